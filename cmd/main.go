@@ -4,6 +4,7 @@ import (
 	"context"
 	"users_service/configs"
 	"users_service/grpc"
+	"users_service/http"
 	"users_service/pkg/logger"
 	"users_service/service"
 	"users_service/storage"
@@ -18,6 +19,11 @@ func main() {
 	log := logger.NewLogger(cfg.ServiceName, cfg.LoggerLevel, cfg.LogPath)
 	defer logger.Cleanup(log)
 
+	if err := cfg.Validate(); err != nil {
+		log.Panic("invalid config in main", logger.Error(err))
+		return
+	}
+
 	storage, err := storage.New(context.Background(), cfg, &log)
 	if err != nil {
 		log.Panic("error while creating storage in main", logger.Error(err))
@@ -25,8 +31,29 @@ func main() {
 	}
 	defer storage.Close()
 
-	services := service.NewServiceManager(storage, log)
-	server := grpc.SetUpServer(services, log)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go service.NewJanitor(storage, cfg, log).Run(ctx)
+
+	services, err := service.NewServiceManager(ctx, storage, cfg, log)
+	if err != nil {
+		log.Panic("error while creating services in main", logger.Error(err))
+		return
+	}
+	server, err := grpc.SetUpServer(services, cfg, log)
+	if err != nil {
+		log.Panic("error while setting up grpc server in main", logger.Error(err))
+		return
+	}
+
+	httpServer := http.SetUpServer(services.Tokens(), cfg, log)
+	go func() {
+		fmt.Printf("User service is serving token keys on %s...\n", httpServer.Addr)
+		if err := httpServer.ListenAndServe(); err != nil {
+			log.Error("error while serving http for user service", logger.Error(err))
+		}
+	}()
 
 	listener, err := net.Listen("tcp",
 		cfg.UserServiceGrpcHost+cfg.UserServiceGrpcPort,
@@ -1,8 +1,11 @@
 package configs
 
 import (
+	"errors"
 	"log"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/spf13/cast"
@@ -14,6 +17,15 @@ type Config struct {
 
 	UserServiceGrpcHost string
 	UserServiceGrpcPort string
+	UserServiceHttpHost string
+	UserServiceHttpPort string
+
+	GrpcTLSCertPath       string
+	GrpcTLSKeyPath        string
+	GrpcTLSClientCAPath   string
+	GrpcTLSReloadInterval time.Duration
+	GrpcAllowlistPath     string
+	GrpcTrustedProxies    []string
 
 	// LearingServiceGrpcHost string
 	// LearingServiceGrpcPort string
@@ -38,8 +50,38 @@ type Config struct {
 	RedisPort     string
 	RedisPassword string
 
-	SigningKeyAccess  string
-	SigningKeyRefresh string
+	AccessTokenTTL       time.Duration
+	RefreshTokenTTL      time.Duration
+	SessionCheckCacheTTL time.Duration
+
+	TokenIssuer              string
+	TokenSigningAlgorithm    string
+	TokenSigningKeyPath      string
+	SigningKeyEncryptionKey  string
+	SigningKeyReloadInterval time.Duration
+
+	RefreshTokenHashKey string
+
+	PasswordHashScheme string
+	PasswordPepper     string
+	BcryptCost         int
+	Argon2Memory       uint32
+	Argon2Iterations   uint32
+	Argon2Parallelism  uint8
+
+	PasswordMinLength     int
+	PasswordMaxLength     int
+	PasswordRequireLower  bool
+	PasswordRequireUpper  bool
+	PasswordRequireDigit  bool
+	PasswordRequireSymbol bool
+	BreachedPasswordsPath string
+
+	PasswordHistoryCount     int
+	PasswordHistoryRetention time.Duration
+
+	TokenCleanupInterval  time.Duration
+	TokenCleanupBatchSize int
 
 	ServiceName string
 	LoggerLevel string
@@ -47,6 +89,56 @@ type Config struct {
 
 	Email    string
 	Password string
+
+	MailerDriver string
+	SmtpHost     string
+	SmtpPort     string
+	MailDir      string
+
+	EmailVerificationTTL time.Duration
+	EmailVerificationURL string
+	RequireVerifiedEmail bool
+
+	PasswordResetTTL time.Duration
+	PasswordResetURL string
+
+	MfaEncryptionKey  string
+	MfaIssuer         string
+	MfaChallengeTTL   time.Duration
+	RecoveryCodeCount int
+
+	LockoutThreshold   int
+	LockoutBaseDelay   time.Duration
+	LockoutMaxDuration time.Duration
+
+	LoginHistoryRetention time.Duration
+
+	PasswordlessTTL          time.Duration
+	PasswordlessURL          string
+	PasswordlessMaxAttempts  int
+	PasswordlessStartLimit   int
+	PasswordlessStartIpLimit int
+	PasswordlessStartWindow  time.Duration
+
+	RoleChangeApproval   bool
+	RoleChangeRequestTTL time.Duration
+
+	ImpersonationTTL       time.Duration
+	ImpersonationMaxTTL    time.Duration
+	ImpersonationRetention time.Duration
+
+	ApiTokenMaxTTL time.Duration
+
+	OidcProviders    []OidcProvider
+	OidcJWKSCacheTTL time.Duration
+}
+
+// OidcProvider is an OpenID Connect identity provider users can log in with
+type OidcProvider struct {
+	Name     string
+	Issuer   string
+	ClientId string
+	JWKSURL  string
 }
 
 func Load() *Config {
@@ -59,8 +151,15 @@ func Load() *Config {
 
 	config.UserServiceGrpcHost = cast.ToString(coalesce("USER_SERVICE_GRPC_HOST", "localhost"))
 	config.UserServiceGrpcPort = cast.ToString(coalesce("USER_SERVICE_GRPC_PORT", ":1111"))
-	// config.UserServiceHttpHost = cast.ToString(coalesce("USER_SERVICE_HTTP_HOST", "localhost"))
-	// config.UserServiceHttpPort = cast.ToString(coalesce("USER_SERVICE_HTTP_PORT", ":2222"))
+	config.UserServiceHttpHost = cast.ToString(coalesce("USER_SERVICE_HTTP_HOST", "localhost"))
+	config.UserServiceHttpPort = cast.ToString(coalesce("USER_SERVICE_HTTP_PORT", ":2222"))
+
+	config.GrpcTLSCertPath = cast.ToString(coalesce("GRPC_TLS_CERT_PATH", ""))
+	config.GrpcTLSKeyPath = cast.ToString(coalesce("GRPC_TLS_KEY_PATH", ""))
+	config.GrpcTLSClientCAPath = cast.ToString(coalesce("GRPC_TLS_CLIENT_CA_PATH", ""))
+	config.GrpcTLSReloadInterval = cast.ToDuration(coalesce("GRPC_TLS_RELOAD_INTERVAL", "30s"))
+	config.GrpcAllowlistPath = cast.ToString(coalesce("GRPC_ALLOWLIST_PATH", ""))
+	config.GrpcTrustedProxies = strings.Split(cast.ToString(coalesce("GRPC_TRUSTED_PROXIES", "")), ",")
 
 	// config.LearingServiceGrpcHost = cast.ToString(coalesce("LEARNING_SERVICE_GRPC_HOST", "localhost"))
 	// config.LearingServiceGrpcPort = cast.ToString(coalesce("LEARNING_SERVICE_GRPC_PORT", ":3333"))
@@ -85,8 +184,38 @@ func Load() *Config {
 	config.RedisPort = cast.ToString(coalesce("REDIS_PORT", "6379"))
 	config.RedisPassword = cast.ToString(coalesce("REDIS_PASSWORD", ""))
 
-	config.SigningKeyAccess = cast.ToString(coalesce("SINGNING_KEY_ACCESS", "SSECCA"))
-	config.SigningKeyRefresh = cast.ToString(coalesce("SINGNING_KEY_REFRESH", "HSERFER"))
+	config.AccessTokenTTL = cast.ToDuration(coalesce("ACCESS_TOKEN_TTL", "1h"))
+	config.RefreshTokenTTL = cast.ToDuration(coalesce("REFRESH_TOKEN_TTL", "720h"))
+	config.SessionCheckCacheTTL = cast.ToDuration(coalesce("SESSION_CHECK_CACHE_TTL", "10s"))
+
+	config.TokenIssuer = cast.ToString(coalesce("TOKEN_ISSUER", "http://localhost:2222"))
+	config.TokenSigningAlgorithm = cast.ToString(coalesce("TOKEN_SIGNING_ALGORITHM", "RS256"))
+	config.TokenSigningKeyPath = cast.ToString(coalesce("TOKEN_SIGNING_KEY_PATH", ""))
+	config.SigningKeyEncryptionKey = cast.ToString(coalesce("SIGNING_KEY_ENCRYPTION_KEY", ""))
+	config.SigningKeyReloadInterval = cast.ToDuration(coalesce("SIGNING_KEY_RELOAD_INTERVAL", "1m"))
+
+	config.RefreshTokenHashKey = cast.ToString(coalesce("REFRESH_TOKEN_HASH_KEY", ""))
+
+	config.PasswordHashScheme = cast.ToString(coalesce("PASSWORD_HASH_SCHEME", "argon2id"))
+	config.PasswordPepper = cast.ToString(coalesce("PASSWORD_PEPPER", ""))
+	config.BcryptCost = cast.ToInt(coalesce("BCRYPT_COST", 10))
+	config.Argon2Memory = cast.ToUint32(coalesce("ARGON2_MEMORY", 65536))
+	config.Argon2Iterations = cast.ToUint32(coalesce("ARGON2_ITERATIONS", 3))
+	config.Argon2Parallelism = cast.ToUint8(coalesce("ARGON2_PARALLELISM", 2))
+
+	config.PasswordMinLength = cast.ToInt(coalesce("PASSWORD_MIN_LENGTH", 8))
+	config.PasswordMaxLength = cast.ToInt(coalesce("PASSWORD_MAX_LENGTH", 128))
+	config.PasswordRequireLower = cast.ToBool(coalesce("PASSWORD_REQUIRE_LOWER", true))
+	config.PasswordRequireUpper = cast.ToBool(coalesce("PASSWORD_REQUIRE_UPPER", true))
+	config.PasswordRequireDigit = cast.ToBool(coalesce("PASSWORD_REQUIRE_DIGIT", true))
+	config.PasswordRequireSymbol = cast.ToBool(coalesce("PASSWORD_REQUIRE_SYMBOL", false))
+	config.BreachedPasswordsPath = cast.ToString(coalesce("BREACHED_PASSWORDS_PATH", ""))
+
+	config.PasswordHistoryCount = cast.ToInt(coalesce("PASSWORD_HISTORY_COUNT", 5))
+	config.PasswordHistoryRetention = cast.ToDuration(coalesce("PASSWORD_HISTORY_RETENTION", "8760h"))
+
+	config.TokenCleanupInterval = cast.ToDuration(coalesce("TOKEN_CLEANUP_INTERVAL", "1h"))
+	config.TokenCleanupBatchSize = cast.ToInt(coalesce("TOKEN_CLEANUP_BATCH_SIZE", 1000))
 
 	config.ServiceName = cast.ToString(coalesce("SERVICE_NAME", "auth_service"))
 	config.LoggerLevel = cast.ToString(coalesce("LOGGER_LEVEL", "debug"))
@@ -95,9 +224,96 @@ func Load() *Config {
 	config.Email = cast.ToString(coalesce("EMAIL", "s@gmail.com"))
 	config.Password = cast.ToString(coalesce("PASSWORD", "nothing"))
 
+	config.MailerDriver = cast.ToString(coalesce("MAILER_DRIVER", "smtp"))
+	config.SmtpHost = cast.ToString(coalesce("SMTP_HOST", "smtp.gmail.com"))
+	config.SmtpPort = cast.ToString(coalesce("SMTP_PORT", "587"))
+	config.MailDir = cast.ToString(coalesce("MAIL_DIR", "mails"))
+
+	config.EmailVerificationTTL = cast.ToDuration(coalesce("EMAIL_VERIFICATION_TTL", "24h"))
+	config.EmailVerificationURL = cast.ToString(coalesce("EMAIL_VERIFICATION_URL", "http://localhost:8080/auth/verify-email"))
+	config.RequireVerifiedEmail = cast.ToBool(coalesce("REQUIRE_VERIFIED_EMAIL", false))
+
+	config.PasswordResetTTL = cast.ToDuration(coalesce("PASSWORD_RESET_TTL", "15m"))
+	config.PasswordResetURL = cast.ToString(coalesce("PASSWORD_RESET_URL", "http://localhost:8080/auth/reset-password"))
+
+	config.MfaEncryptionKey = cast.ToString(coalesce("MFA_ENCRYPTION_KEY", ""))
+	config.MfaIssuer = cast.ToString(coalesce("MFA_ISSUER", "users_service"))
+	config.MfaChallengeTTL = cast.ToDuration(coalesce("MFA_CHALLENGE_TTL", "5m"))
+	config.RecoveryCodeCount = cast.ToInt(coalesce("RECOVERY_CODE_COUNT", 10))
+
+	config.LockoutThreshold = cast.ToInt(coalesce("LOCKOUT_THRESHOLD", 5))
+	config.LockoutBaseDelay = cast.ToDuration(coalesce("LOCKOUT_BASE_DELAY", "1m"))
+	config.LockoutMaxDuration = cast.ToDuration(coalesce("LOCKOUT_MAX_DURATION", "24h"))
+
+	config.LoginHistoryRetention = cast.ToDuration(coalesce("LOGIN_HISTORY_RETENTION", "2160h"))
+
+	config.PasswordlessTTL = cast.ToDuration(coalesce("PASSWORDLESS_TTL", "10m"))
+	config.PasswordlessURL = cast.ToString(coalesce("PASSWORDLESS_URL", "http://localhost:8080/auth/passwordless"))
+	config.PasswordlessMaxAttempts = cast.ToInt(coalesce("PASSWORDLESS_MAX_ATTEMPTS", 5))
+	config.PasswordlessStartLimit = cast.ToInt(coalesce("PASSWORDLESS_START_LIMIT", 5))
+	config.PasswordlessStartIpLimit = cast.ToInt(coalesce("PASSWORDLESS_START_IP_LIMIT", 20))
+	config.PasswordlessStartWindow = cast.ToDuration(coalesce("PASSWORDLESS_START_WINDOW", "1h"))
+
+	config.RoleChangeApproval = cast.ToBool(coalesce("ROLE_CHANGE_APPROVAL", false))
+	config.RoleChangeRequestTTL = cast.ToDuration(coalesce("ROLE_CHANGE_REQUEST_TTL", "72h"))
+
+	config.ImpersonationTTL = cast.ToDuration(coalesce("IMPERSONATION_TTL", "15m"))
+	config.ImpersonationMaxTTL = cast.ToDuration(coalesce("IMPERSONATION_MAX_TTL", "1h"))
+	config.ImpersonationRetention = cast.ToDuration(coalesce("IMPERSONATION_RETENTION", "2160h"))
+
+	config.ApiTokenMaxTTL = cast.ToDuration(coalesce("API_TOKEN_MAX_TTL", "8760h"))
+
+	// every provider in OIDC_PROVIDERS is configured by OIDC_<NAME>_ISSUER, _CLIENT_ID and _JWKS_URL
+	for _, name := range strings.Split(cast.ToString(coalesce("OIDC_PROVIDERS", "")), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		config.OidcProviders = append(config.OidcProviders, OidcProvider{
+			Name:     name,
+			Issuer:   cast.ToString(coalesce(prefix+"ISSUER", "")),
+			ClientId: cast.ToString(coalesce(prefix+"CLIENT_ID", "")),
+			JWKSURL:  cast.ToString(coalesce(prefix+"JWKS_URL", "")),
+		})
+	}
+	config.OidcJWKSCacheTTL = cast.ToDuration(coalesce("OIDC_JWKS_CACHE_TTL", "1h"))
+
 	return &config
 }
 
+// Validate rejects values the service can not run with
+func (c *Config) Validate() error {
+	switch {
+	case c.SessionCheckCacheTTL < 0:
+		return errors.New("SESSION_CHECK_CACHE_TTL must not be negative")
+	case c.TokenCleanupInterval <= 0:
+		return errors.New("TOKEN_CLEANUP_INTERVAL must be positive")
+	case c.TokenCleanupBatchSize <= 0:
+		return errors.New("TOKEN_CLEANUP_BATCH_SIZE must be positive")
+	case c.LockoutThreshold <= 0:
+		return errors.New("LOCKOUT_THRESHOLD must be positive")
+	case c.LockoutBaseDelay <= 0:
+		return errors.New("LOCKOUT_BASE_DELAY must be positive")
+	case c.LockoutMaxDuration <= 0:
+		return errors.New("LOCKOUT_MAX_DURATION must be positive")
+	case c.PasswordlessStartLimit <= 0:
+		return errors.New("PASSWORDLESS_START_LIMIT must be positive")
+	case c.PasswordlessStartIpLimit <= 0:
+		return errors.New("PASSWORDLESS_START_IP_LIMIT must be positive")
+	case c.PasswordlessStartWindow <= 0:
+		return errors.New("PASSWORDLESS_START_WINDOW must be positive")
+	case c.ImpersonationTTL <= 0:
+		return errors.New("IMPERSONATION_TTL must be positive")
+	case c.ImpersonationMaxTTL < c.ImpersonationTTL:
+		return errors.New("IMPERSONATION_MAX_TTL must be at least IMPERSONATION_TTL")
+	case c.ApiTokenMaxTTL <= 0:
+		return errors.New("API_TOKEN_MAX_TTL must be positive")
+	}
+	return nil
+}
+
 func coalesce(key string, defaultValue interface{}) interface{} {
 	if res, exists := os.LookupEnv(key); exists {
 		return res
@@ -0,0 +1,57 @@
+package configs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValidate(t *testing.T) {
+	valid := func() *Config {
+		return &Config{
+			TokenCleanupInterval:     time.Hour,
+			TokenCleanupBatchSize:    1000,
+			LockoutThreshold:         5,
+			LockoutBaseDelay:         time.Minute,
+			LockoutMaxDuration:       24 * time.Hour,
+			PasswordlessStartLimit:   5,
+			PasswordlessStartIpLimit: 20,
+			PasswordlessStartWindow:  time.Hour,
+			ImpersonationTTL:         15 * time.Minute,
+			ImpersonationMaxTTL:      time.Hour,
+			ApiTokenMaxTTL:           8760 * time.Hour,
+		}
+	}
+
+	tests := []struct {
+		name    string
+		modify  func(*Config)
+		wantErr bool
+	}{
+		{"valid", func(*Config) {}, false},
+		{"no session check cache", func(c *Config) { c.SessionCheckCacheTTL = 0 }, false},
+		{"negative session check cache ttl", func(c *Config) { c.SessionCheckCacheTTL = -time.Second }, true},
+		{"no cleanup interval", func(c *Config) { c.TokenCleanupInterval = 0 }, true},
+		{"negative batch size", func(c *Config) { c.TokenCleanupBatchSize = -1 }, true},
+		{"no lockout threshold", func(c *Config) { c.LockoutThreshold = 0 }, true},
+		{"no lockout base delay", func(c *Config) { c.LockoutBaseDelay = 0 }, true},
+		{"negative lockout max duration", func(c *Config) { c.LockoutMaxDuration = -time.Hour }, true},
+		{"no passwordless start limit", func(c *Config) { c.PasswordlessStartLimit = 0 }, true},
+		{"no passwordless start ip limit", func(c *Config) { c.PasswordlessStartIpLimit = 0 }, true},
+		{"negative passwordless start window", func(c *Config) { c.PasswordlessStartWindow = -time.Hour }, true},
+		{"no impersonation ttl", func(c *Config) { c.ImpersonationTTL = 0 }, true},
+		{"impersonation max ttl below ttl", func(c *Config) { c.ImpersonationMaxTTL = time.Minute }, true},
+		{"impersonation max ttl equal to ttl", func(c *Config) { c.ImpersonationMaxTTL = c.ImpersonationTTL }, false},
+		{"no api token max ttl", func(c *Config) { c.ApiTokenMaxTTL = 0 }, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := valid()
+			tt.modify(cfg)
+
+			if err := cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
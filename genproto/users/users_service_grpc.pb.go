@@ -28,6 +28,29 @@ type UsersServiceClient interface {
 	Delete(ctx context.Context, in *PrimaryKey, opts ...grpc.CallOption) (*Void, error)
 	ChangePassword(ctx context.Context, in *ChangePassword, opts ...grpc.CallOption) (*Void, error)
 	ChangeUserRole(ctx context.Context, in *ChangeUserRole, opts ...grpc.CallOption) (*Void, error)
+	VerifyPassword(ctx context.Context, in *VerifyPassword, opts ...grpc.CallOption) (*Void, error)
+	EnrollTotp(ctx context.Context, in *PrimaryKey, opts ...grpc.CallOption) (*TotpEnrollment, error)
+	ConfirmTotp(ctx context.Context, in *TotpCodeRequest, opts ...grpc.CallOption) (*RecoveryCodes, error)
+	DisableTotp(ctx context.Context, in *TotpCodeRequest, opts ...grpc.CallOption) (*Void, error)
+	RegenerateRecoveryCodes(ctx context.Context, in *TotpCodeRequest, opts ...grpc.CallOption) (*RecoveryCodes, error)
+	UnlockUser(ctx context.Context, in *PrimaryKey, opts ...grpc.CallOption) (*Void, error)
+	ListLoginHistory(ctx context.Context, in *LoginHistoryRequest, opts ...grpc.CallOption) (*LoginEvents, error)
+	LinkIdentity(ctx context.Context, in *LinkIdentityRequest, opts ...grpc.CallOption) (*Identity, error)
+	UnlinkIdentity(ctx context.Context, in *UnlinkIdentityRequest, opts ...grpc.CallOption) (*Void, error)
+	ListIdentities(ctx context.Context, in *PrimaryKey, opts ...grpc.CallOption) (*Identities, error)
+	CreateRole(ctx context.Context, in *Role, opts ...grpc.CallOption) (*Role, error)
+	ListRoles(ctx context.Context, in *Void, opts ...grpc.CallOption) (*Roles, error)
+	DeleteRole(ctx context.Context, in *RoleName, opts ...grpc.CallOption) (*Void, error)
+	SetRolePermissions(ctx context.Context, in *Role, opts ...grpc.CallOption) (*Role, error)
+	CreatePermission(ctx context.Context, in *Permission, opts ...grpc.CallOption) (*Permission, error)
+	ListPermissions(ctx context.Context, in *Void, opts ...grpc.CallOption) (*Permissions, error)
+	AssignRole(ctx context.Context, in *UserRoleRequest, opts ...grpc.CallOption) (*Void, error)
+	UnassignRole(ctx context.Context, in *UserRoleRequest, opts ...grpc.CallOption) (*Void, error)
+	ListUserRoles(ctx context.Context, in *PrimaryKey, opts ...grpc.CallOption) (*UserRoles, error)
+	HasPermission(ctx context.Context, in *HasPermissionRequest, opts ...grpc.CallOption) (*HasPermissionResponse, error)
+	RequestRoleChange(ctx context.Context, in *RoleChangeRequest, opts ...grpc.CallOption) (*RoleChange, error)
+	ApproveRoleChange(ctx context.Context, in *PrimaryKey, opts ...grpc.CallOption) (*RoleChange, error)
+	ListRoleChanges(ctx context.Context, in *RoleChangesRequest, opts ...grpc.CallOption) (*RoleChanges, error)
 }
 
 type usersServiceClient struct {
@@ -92,6 +115,213 @@ func (c *usersServiceClient) ChangeUserRole(ctx context.Context, in *ChangeUserR
 	return out, nil
 }
 
+func (c *usersServiceClient) VerifyPassword(ctx context.Context, in *VerifyPassword, opts ...grpc.CallOption) (*Void, error) {
+	out := new(Void)
+	err := c.cc.Invoke(ctx, "/users.UsersService/VerifyPassword", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *usersServiceClient) EnrollTotp(ctx context.Context, in *PrimaryKey, opts ...grpc.CallOption) (*TotpEnrollment, error) {
+	out := new(TotpEnrollment)
+	err := c.cc.Invoke(ctx, "/users.UsersService/EnrollTotp", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *usersServiceClient) ConfirmTotp(ctx context.Context, in *TotpCodeRequest, opts ...grpc.CallOption) (*RecoveryCodes, error) {
+	out := new(RecoveryCodes)
+	err := c.cc.Invoke(ctx, "/users.UsersService/ConfirmTotp", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *usersServiceClient) DisableTotp(ctx context.Context, in *TotpCodeRequest, opts ...grpc.CallOption) (*Void, error) {
+	out := new(Void)
+	err := c.cc.Invoke(ctx, "/users.UsersService/DisableTotp", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *usersServiceClient) RegenerateRecoveryCodes(ctx context.Context, in *TotpCodeRequest, opts ...grpc.CallOption) (*RecoveryCodes, error) {
+	out := new(RecoveryCodes)
+	err := c.cc.Invoke(ctx, "/users.UsersService/RegenerateRecoveryCodes", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *usersServiceClient) UnlockUser(ctx context.Context, in *PrimaryKey, opts ...grpc.CallOption) (*Void, error) {
+	out := new(Void)
+	err := c.cc.Invoke(ctx, "/users.UsersService/UnlockUser", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *usersServiceClient) ListLoginHistory(ctx context.Context, in *LoginHistoryRequest, opts ...grpc.CallOption) (*LoginEvents, error) {
+	out := new(LoginEvents)
+	err := c.cc.Invoke(ctx, "/users.UsersService/ListLoginHistory", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *usersServiceClient) LinkIdentity(ctx context.Context, in *LinkIdentityRequest, opts ...grpc.CallOption) (*Identity, error) {
+	out := new(Identity)
+	err := c.cc.Invoke(ctx, "/users.UsersService/LinkIdentity", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *usersServiceClient) UnlinkIdentity(ctx context.Context, in *UnlinkIdentityRequest, opts ...grpc.CallOption) (*Void, error) {
+	out := new(Void)
+	err := c.cc.Invoke(ctx, "/users.UsersService/UnlinkIdentity", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *usersServiceClient) ListIdentities(ctx context.Context, in *PrimaryKey, opts ...grpc.CallOption) (*Identities, error) {
+	out := new(Identities)
+	err := c.cc.Invoke(ctx, "/users.UsersService/ListIdentities", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *usersServiceClient) CreateRole(ctx context.Context, in *Role, opts ...grpc.CallOption) (*Role, error) {
+	out := new(Role)
+	err := c.cc.Invoke(ctx, "/users.UsersService/CreateRole", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *usersServiceClient) ListRoles(ctx context.Context, in *Void, opts ...grpc.CallOption) (*Roles, error) {
+	out := new(Roles)
+	err := c.cc.Invoke(ctx, "/users.UsersService/ListRoles", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *usersServiceClient) DeleteRole(ctx context.Context, in *RoleName, opts ...grpc.CallOption) (*Void, error) {
+	out := new(Void)
+	err := c.cc.Invoke(ctx, "/users.UsersService/DeleteRole", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *usersServiceClient) SetRolePermissions(ctx context.Context, in *Role, opts ...grpc.CallOption) (*Role, error) {
+	out := new(Role)
+	err := c.cc.Invoke(ctx, "/users.UsersService/SetRolePermissions", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *usersServiceClient) CreatePermission(ctx context.Context, in *Permission, opts ...grpc.CallOption) (*Permission, error) {
+	out := new(Permission)
+	err := c.cc.Invoke(ctx, "/users.UsersService/CreatePermission", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *usersServiceClient) ListPermissions(ctx context.Context, in *Void, opts ...grpc.CallOption) (*Permissions, error) {
+	out := new(Permissions)
+	err := c.cc.Invoke(ctx, "/users.UsersService/ListPermissions", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *usersServiceClient) AssignRole(ctx context.Context, in *UserRoleRequest, opts ...grpc.CallOption) (*Void, error) {
+	out := new(Void)
+	err := c.cc.Invoke(ctx, "/users.UsersService/AssignRole", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *usersServiceClient) UnassignRole(ctx context.Context, in *UserRoleRequest, opts ...grpc.CallOption) (*Void, error) {
+	out := new(Void)
+	err := c.cc.Invoke(ctx, "/users.UsersService/UnassignRole", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *usersServiceClient) ListUserRoles(ctx context.Context, in *PrimaryKey, opts ...grpc.CallOption) (*UserRoles, error) {
+	out := new(UserRoles)
+	err := c.cc.Invoke(ctx, "/users.UsersService/ListUserRoles", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *usersServiceClient) HasPermission(ctx context.Context, in *HasPermissionRequest, opts ...grpc.CallOption) (*HasPermissionResponse, error) {
+	out := new(HasPermissionResponse)
+	err := c.cc.Invoke(ctx, "/users.UsersService/HasPermission", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *usersServiceClient) RequestRoleChange(ctx context.Context, in *RoleChangeRequest, opts ...grpc.CallOption) (*RoleChange, error) {
+	out := new(RoleChange)
+	err := c.cc.Invoke(ctx, "/users.UsersService/RequestRoleChange", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *usersServiceClient) ApproveRoleChange(ctx context.Context, in *PrimaryKey, opts ...grpc.CallOption) (*RoleChange, error) {
+	out := new(RoleChange)
+	err := c.cc.Invoke(ctx, "/users.UsersService/ApproveRoleChange", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *usersServiceClient) ListRoleChanges(ctx context.Context, in *RoleChangesRequest, opts ...grpc.CallOption) (*RoleChanges, error) {
+	out := new(RoleChanges)
+	err := c.cc.Invoke(ctx, "/users.UsersService/ListRoleChanges", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // UsersServiceServer is the server API for UsersService service.
 // All implementations must embed UnimplementedUsersServiceServer
 // for forward compatibility
@@ -102,6 +332,29 @@ type UsersServiceServer interface {
 	Delete(context.Context, *PrimaryKey) (*Void, error)
 	ChangePassword(context.Context, *ChangePassword) (*Void, error)
 	ChangeUserRole(context.Context, *ChangeUserRole) (*Void, error)
+	VerifyPassword(context.Context, *VerifyPassword) (*Void, error)
+	EnrollTotp(context.Context, *PrimaryKey) (*TotpEnrollment, error)
+	ConfirmTotp(context.Context, *TotpCodeRequest) (*RecoveryCodes, error)
+	DisableTotp(context.Context, *TotpCodeRequest) (*Void, error)
+	RegenerateRecoveryCodes(context.Context, *TotpCodeRequest) (*RecoveryCodes, error)
+	UnlockUser(context.Context, *PrimaryKey) (*Void, error)
+	ListLoginHistory(context.Context, *LoginHistoryRequest) (*LoginEvents, error)
+	LinkIdentity(context.Context, *LinkIdentityRequest) (*Identity, error)
+	UnlinkIdentity(context.Context, *UnlinkIdentityRequest) (*Void, error)
+	ListIdentities(context.Context, *PrimaryKey) (*Identities, error)
+	CreateRole(context.Context, *Role) (*Role, error)
+	ListRoles(context.Context, *Void) (*Roles, error)
+	DeleteRole(context.Context, *RoleName) (*Void, error)
+	SetRolePermissions(context.Context, *Role) (*Role, error)
+	CreatePermission(context.Context, *Permission) (*Permission, error)
+	ListPermissions(context.Context, *Void) (*Permissions, error)
+	AssignRole(context.Context, *UserRoleRequest) (*Void, error)
+	UnassignRole(context.Context, *UserRoleRequest) (*Void, error)
+	ListUserRoles(context.Context, *PrimaryKey) (*UserRoles, error)
+	HasPermission(context.Context, *HasPermissionRequest) (*HasPermissionResponse, error)
+	RequestRoleChange(context.Context, *RoleChangeRequest) (*RoleChange, error)
+	ApproveRoleChange(context.Context, *PrimaryKey) (*RoleChange, error)
+	ListRoleChanges(context.Context, *RoleChangesRequest) (*RoleChanges, error)
 	mustEmbedUnimplementedUsersServiceServer()
 }
 
@@ -127,6 +380,75 @@ func (UnimplementedUsersServiceServer) ChangePassword(context.Context, *ChangePa
 func (UnimplementedUsersServiceServer) ChangeUserRole(context.Context, *ChangeUserRole) (*Void, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method ChangeUserRole not implemented")
 }
+func (UnimplementedUsersServiceServer) VerifyPassword(context.Context, *VerifyPassword) (*Void, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method VerifyPassword not implemented")
+}
+func (UnimplementedUsersServiceServer) EnrollTotp(context.Context, *PrimaryKey) (*TotpEnrollment, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method EnrollTotp not implemented")
+}
+func (UnimplementedUsersServiceServer) ConfirmTotp(context.Context, *TotpCodeRequest) (*RecoveryCodes, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ConfirmTotp not implemented")
+}
+func (UnimplementedUsersServiceServer) DisableTotp(context.Context, *TotpCodeRequest) (*Void, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DisableTotp not implemented")
+}
+func (UnimplementedUsersServiceServer) RegenerateRecoveryCodes(context.Context, *TotpCodeRequest) (*RecoveryCodes, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RegenerateRecoveryCodes not implemented")
+}
+func (UnimplementedUsersServiceServer) UnlockUser(context.Context, *PrimaryKey) (*Void, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UnlockUser not implemented")
+}
+func (UnimplementedUsersServiceServer) ListLoginHistory(context.Context, *LoginHistoryRequest) (*LoginEvents, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListLoginHistory not implemented")
+}
+func (UnimplementedUsersServiceServer) LinkIdentity(context.Context, *LinkIdentityRequest) (*Identity, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method LinkIdentity not implemented")
+}
+func (UnimplementedUsersServiceServer) UnlinkIdentity(context.Context, *UnlinkIdentityRequest) (*Void, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UnlinkIdentity not implemented")
+}
+func (UnimplementedUsersServiceServer) ListIdentities(context.Context, *PrimaryKey) (*Identities, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListIdentities not implemented")
+}
+func (UnimplementedUsersServiceServer) CreateRole(context.Context, *Role) (*Role, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateRole not implemented")
+}
+func (UnimplementedUsersServiceServer) ListRoles(context.Context, *Void) (*Roles, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListRoles not implemented")
+}
+func (UnimplementedUsersServiceServer) DeleteRole(context.Context, *RoleName) (*Void, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteRole not implemented")
+}
+func (UnimplementedUsersServiceServer) SetRolePermissions(context.Context, *Role) (*Role, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetRolePermissions not implemented")
+}
+func (UnimplementedUsersServiceServer) CreatePermission(context.Context, *Permission) (*Permission, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreatePermission not implemented")
+}
+func (UnimplementedUsersServiceServer) ListPermissions(context.Context, *Void) (*Permissions, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListPermissions not implemented")
+}
+func (UnimplementedUsersServiceServer) AssignRole(context.Context, *UserRoleRequest) (*Void, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AssignRole not implemented")
+}
+func (UnimplementedUsersServiceServer) UnassignRole(context.Context, *UserRoleRequest) (*Void, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UnassignRole not implemented")
+}
+func (UnimplementedUsersServiceServer) ListUserRoles(context.Context, *PrimaryKey) (*UserRoles, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListUserRoles not implemented")
+}
+func (UnimplementedUsersServiceServer) HasPermission(context.Context, *HasPermissionRequest) (*HasPermissionResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method HasPermission not implemented")
+}
+func (UnimplementedUsersServiceServer) RequestRoleChange(context.Context, *RoleChangeRequest) (*RoleChange, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RequestRoleChange not implemented")
+}
+func (UnimplementedUsersServiceServer) ApproveRoleChange(context.Context, *PrimaryKey) (*RoleChange, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ApproveRoleChange not implemented")
+}
+func (UnimplementedUsersServiceServer) ListRoleChanges(context.Context, *RoleChangesRequest) (*RoleChanges, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListRoleChanges not implemented")
+}
 func (UnimplementedUsersServiceServer) mustEmbedUnimplementedUsersServiceServer() {}
 
 // UnsafeUsersServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -248,36 +570,542 @@ func _UsersService_ChangeUserRole_Handler(srv interface{}, ctx context.Context,
 	return interceptor(ctx, in, info, handler)
 }
 
-// UsersService_ServiceDesc is the grpc.ServiceDesc for UsersService service.
-// It's only intended for direct use with grpc.RegisterService,
-// and not to be introspected or modified (even as a copy)
-var UsersService_ServiceDesc = grpc.ServiceDesc{
-	ServiceName: "users.UsersService",
-	HandlerType: (*UsersServiceServer)(nil),
-	Methods: []grpc.MethodDesc{
-		{
-			MethodName: "GetById",
-			Handler:    _UsersService_GetById_Handler,
-		},
-		{
-			MethodName: "GetAll",
-			Handler:    _UsersService_GetAll_Handler,
-		},
-		{
-			MethodName: "Update",
-			Handler:    _UsersService_Update_Handler,
-		},
-		{
-			MethodName: "Delete",
-			Handler:    _UsersService_Delete_Handler,
-		},
-		{
-			MethodName: "ChangePassword",
-			Handler:    _UsersService_ChangePassword_Handler,
-		},
-		{
-			MethodName: "ChangeUserRole",
-			Handler:    _UsersService_ChangeUserRole_Handler,
+func _UsersService_VerifyPassword_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(VerifyPassword)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UsersServiceServer).VerifyPassword(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/users.UsersService/VerifyPassword",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UsersServiceServer).VerifyPassword(ctx, req.(*VerifyPassword))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UsersService_EnrollTotp_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PrimaryKey)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UsersServiceServer).EnrollTotp(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/users.UsersService/EnrollTotp",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UsersServiceServer).EnrollTotp(ctx, req.(*PrimaryKey))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UsersService_ConfirmTotp_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TotpCodeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UsersServiceServer).ConfirmTotp(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/users.UsersService/ConfirmTotp",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UsersServiceServer).ConfirmTotp(ctx, req.(*TotpCodeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UsersService_DisableTotp_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TotpCodeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UsersServiceServer).DisableTotp(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/users.UsersService/DisableTotp",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UsersServiceServer).DisableTotp(ctx, req.(*TotpCodeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UsersService_RegenerateRecoveryCodes_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TotpCodeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UsersServiceServer).RegenerateRecoveryCodes(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/users.UsersService/RegenerateRecoveryCodes",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UsersServiceServer).RegenerateRecoveryCodes(ctx, req.(*TotpCodeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UsersService_UnlockUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PrimaryKey)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UsersServiceServer).UnlockUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/users.UsersService/UnlockUser",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UsersServiceServer).UnlockUser(ctx, req.(*PrimaryKey))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UsersService_ListLoginHistory_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LoginHistoryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UsersServiceServer).ListLoginHistory(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/users.UsersService/ListLoginHistory",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UsersServiceServer).ListLoginHistory(ctx, req.(*LoginHistoryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UsersService_LinkIdentity_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LinkIdentityRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UsersServiceServer).LinkIdentity(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/users.UsersService/LinkIdentity",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UsersServiceServer).LinkIdentity(ctx, req.(*LinkIdentityRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UsersService_UnlinkIdentity_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UnlinkIdentityRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UsersServiceServer).UnlinkIdentity(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/users.UsersService/UnlinkIdentity",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UsersServiceServer).UnlinkIdentity(ctx, req.(*UnlinkIdentityRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UsersService_ListIdentities_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PrimaryKey)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UsersServiceServer).ListIdentities(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/users.UsersService/ListIdentities",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UsersServiceServer).ListIdentities(ctx, req.(*PrimaryKey))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UsersService_CreateRole_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Role)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UsersServiceServer).CreateRole(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/users.UsersService/CreateRole",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UsersServiceServer).CreateRole(ctx, req.(*Role))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UsersService_ListRoles_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Void)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UsersServiceServer).ListRoles(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/users.UsersService/ListRoles",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UsersServiceServer).ListRoles(ctx, req.(*Void))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UsersService_DeleteRole_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RoleName)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UsersServiceServer).DeleteRole(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/users.UsersService/DeleteRole",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UsersServiceServer).DeleteRole(ctx, req.(*RoleName))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UsersService_SetRolePermissions_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Role)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UsersServiceServer).SetRolePermissions(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/users.UsersService/SetRolePermissions",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UsersServiceServer).SetRolePermissions(ctx, req.(*Role))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UsersService_CreatePermission_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Permission)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UsersServiceServer).CreatePermission(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/users.UsersService/CreatePermission",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UsersServiceServer).CreatePermission(ctx, req.(*Permission))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UsersService_ListPermissions_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Void)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UsersServiceServer).ListPermissions(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/users.UsersService/ListPermissions",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UsersServiceServer).ListPermissions(ctx, req.(*Void))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UsersService_AssignRole_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UserRoleRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UsersServiceServer).AssignRole(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/users.UsersService/AssignRole",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UsersServiceServer).AssignRole(ctx, req.(*UserRoleRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UsersService_UnassignRole_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UserRoleRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UsersServiceServer).UnassignRole(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/users.UsersService/UnassignRole",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UsersServiceServer).UnassignRole(ctx, req.(*UserRoleRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UsersService_ListUserRoles_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PrimaryKey)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UsersServiceServer).ListUserRoles(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/users.UsersService/ListUserRoles",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UsersServiceServer).ListUserRoles(ctx, req.(*PrimaryKey))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UsersService_HasPermission_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HasPermissionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UsersServiceServer).HasPermission(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/users.UsersService/HasPermission",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UsersServiceServer).HasPermission(ctx, req.(*HasPermissionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UsersService_RequestRoleChange_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RoleChangeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UsersServiceServer).RequestRoleChange(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/users.UsersService/RequestRoleChange",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UsersServiceServer).RequestRoleChange(ctx, req.(*RoleChangeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UsersService_ApproveRoleChange_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PrimaryKey)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UsersServiceServer).ApproveRoleChange(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/users.UsersService/ApproveRoleChange",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UsersServiceServer).ApproveRoleChange(ctx, req.(*PrimaryKey))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UsersService_ListRoleChanges_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RoleChangesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UsersServiceServer).ListRoleChanges(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/users.UsersService/ListRoleChanges",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UsersServiceServer).ListRoleChanges(ctx, req.(*RoleChangesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// UsersService_ServiceDesc is the grpc.ServiceDesc for UsersService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var UsersService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "users.UsersService",
+	HandlerType: (*UsersServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetById",
+			Handler:    _UsersService_GetById_Handler,
+		},
+		{
+			MethodName: "GetAll",
+			Handler:    _UsersService_GetAll_Handler,
+		},
+		{
+			MethodName: "Update",
+			Handler:    _UsersService_Update_Handler,
+		},
+		{
+			MethodName: "Delete",
+			Handler:    _UsersService_Delete_Handler,
+		},
+		{
+			MethodName: "ChangePassword",
+			Handler:    _UsersService_ChangePassword_Handler,
+		},
+		{
+			MethodName: "ChangeUserRole",
+			Handler:    _UsersService_ChangeUserRole_Handler,
+		},
+		{
+			MethodName: "VerifyPassword",
+			Handler:    _UsersService_VerifyPassword_Handler,
+		},
+		{
+			MethodName: "EnrollTotp",
+			Handler:    _UsersService_EnrollTotp_Handler,
+		},
+		{
+			MethodName: "ConfirmTotp",
+			Handler:    _UsersService_ConfirmTotp_Handler,
+		},
+		{
+			MethodName: "DisableTotp",
+			Handler:    _UsersService_DisableTotp_Handler,
+		},
+		{
+			MethodName: "RegenerateRecoveryCodes",
+			Handler:    _UsersService_RegenerateRecoveryCodes_Handler,
+		},
+		{
+			MethodName: "UnlockUser",
+			Handler:    _UsersService_UnlockUser_Handler,
+		},
+		{
+			MethodName: "ListLoginHistory",
+			Handler:    _UsersService_ListLoginHistory_Handler,
+		},
+		{
+			MethodName: "LinkIdentity",
+			Handler:    _UsersService_LinkIdentity_Handler,
+		},
+		{
+			MethodName: "UnlinkIdentity",
+			Handler:    _UsersService_UnlinkIdentity_Handler,
+		},
+		{
+			MethodName: "ListIdentities",
+			Handler:    _UsersService_ListIdentities_Handler,
+		},
+		{
+			MethodName: "CreateRole",
+			Handler:    _UsersService_CreateRole_Handler,
+		},
+		{
+			MethodName: "ListRoles",
+			Handler:    _UsersService_ListRoles_Handler,
+		},
+		{
+			MethodName: "DeleteRole",
+			Handler:    _UsersService_DeleteRole_Handler,
+		},
+		{
+			MethodName: "SetRolePermissions",
+			Handler:    _UsersService_SetRolePermissions_Handler,
+		},
+		{
+			MethodName: "CreatePermission",
+			Handler:    _UsersService_CreatePermission_Handler,
+		},
+		{
+			MethodName: "ListPermissions",
+			Handler:    _UsersService_ListPermissions_Handler,
+		},
+		{
+			MethodName: "AssignRole",
+			Handler:    _UsersService_AssignRole_Handler,
+		},
+		{
+			MethodName: "UnassignRole",
+			Handler:    _UsersService_UnassignRole_Handler,
+		},
+		{
+			MethodName: "ListUserRoles",
+			Handler:    _UsersService_ListUserRoles_Handler,
+		},
+		{
+			MethodName: "HasPermission",
+			Handler:    _UsersService_HasPermission_Handler,
+		},
+		{
+			MethodName: "RequestRoleChange",
+			Handler:    _UsersService_RequestRoleChange_Handler,
+		},
+		{
+			MethodName: "ApproveRoleChange",
+			Handler:    _UsersService_ApproveRoleChange_Handler,
+		},
+		{
+			MethodName: "ListRoleChanges",
+			Handler:    _UsersService_ListRoleChanges_Handler,
 		},
 	},
 	Streams:  []grpc.StreamDesc{},
@@ -0,0 +1,134 @@
+package grpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"users_service/pkg/caller"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// allowlist names the services that may call each method. Keys are full method names like
+// /users.UsersService/ChangeUserRole, /users.UsersService/* for all methods of a service or
+// * for every other method. Services are matched by any name of their certificate, * allows
+// every service with a verified certificate.
+type allowlist map[string][]string
+
+// loadAllowlist reads an allowlist from a JSON file, without a path every method is open
+func loadAllowlist(path string) (allowlist, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error while reading grpc allowlist: %w", err)
+	}
+
+	var allow allowlist
+	if err = json.Unmarshal(data, &allow); err != nil {
+		return nil, fmt.Errorf("error while parsing grpc allowlist: %w", err)
+	}
+
+	return allow, nil
+}
+
+// allows reports whether service may call method, methods without a rule are denied
+func (a allowlist) allows(method string, service *caller.Service) bool {
+	rule, ok := a[method]
+	if !ok {
+		rule, ok = a[method[:strings.LastIndex(method, "/")+1]+"*"]
+	}
+	if !ok {
+		rule, ok = a["*"]
+	}
+	if !ok {
+		return false
+	}
+
+	if slices.Contains(rule, "*") {
+		return true
+	}
+
+	for _, name := range service.Names() {
+		if slices.Contains(rule, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func serviceIdentityUnaryInterceptor(allow allowlist) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := authorizeService(ctx, info.FullMethod, allow)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func serviceIdentityStreamInterceptor(allow allowlist) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authorizeService(stream.Context(), info.FullMethod, allow)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &serverStream{ServerStream: stream, ctx: ctx})
+	}
+}
+
+// authorizeService puts the identity of the calling service into ctx and checks it against the allowlist
+func authorizeService(ctx context.Context, method string, allow allowlist) (context.Context, error) {
+	service := peerService(ctx)
+	if service != nil {
+		ctx = caller.WithService(ctx, service)
+	}
+
+	if allow == nil {
+		return ctx, nil
+	}
+
+	if service == nil {
+		return ctx, status.Error(codes.Unauthenticated, "a verified client certificate is required")
+	}
+
+	if !allow.allows(method, service) {
+		return ctx, status.Errorf(codes.PermissionDenied, "service %s may not call %s", service.Name(), method)
+	}
+
+	return ctx, nil
+}
+
+// peerService returns the identity of the peer's verified client certificate
+func peerService(ctx context.Context) *caller.Service {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return nil
+	}
+
+	return caller.NewService(info.State.VerifiedChains[0][0])
+}
+
+// serverStream overrides the context of a stream
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
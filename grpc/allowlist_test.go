@@ -0,0 +1,150 @@
+package grpc
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"users_service/pkg/caller"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func TestAllowlistAllows(t *testing.T) {
+	allow := allowlist{
+		"/users.AuthService/Login":            {"api-gateway"},
+		"/users.AuthService/ValidateApiToken": {"api-gateway", "spiffe://cluster/transactions"},
+		"/users.AuthService/*":                {"admin-console"},
+		"/users.UsersService/HasPermission":   {"*"},
+		"*":                                   {"monitoring"},
+	}
+
+	var (
+		gateway      = &caller.Service{CommonName: "api-gateway"}
+		console      = &caller.Service{DNSNames: []string{"admin-console"}}
+		transactions = &caller.Service{CommonName: "transactions", URIs: []string{"spiffe://cluster/transactions"}}
+		monitoring   = &caller.Service{CommonName: "monitoring"}
+	)
+
+	tests := []struct {
+		name    string
+		method  string
+		service *caller.Service
+		want    bool
+	}{
+		{"exact rule", "/users.AuthService/Login", gateway, true},
+		{"exact rule hides the service rule", "/users.AuthService/Login", console, false},
+		{"matched by uri", "/users.AuthService/ValidateApiToken", transactions, true},
+		{"service wildcard", "/users.AuthService/Refresh", console, true},
+		{"not in the service wildcard", "/users.AuthService/Refresh", gateway, false},
+		{"any verified service", "/users.UsersService/HasPermission", transactions, true},
+		{"catch all rule", "/users.UsersService/GetById", monitoring, true},
+		{"not in the catch all rule", "/users.UsersService/GetById", gateway, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := allow.allows(tt.method, tt.service); got != tt.want {
+				t.Fatalf("allows(%s) = %v, want %v", tt.method, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAllowlistWithoutCatchAll(t *testing.T) {
+	allow := allowlist{"/users.AuthService/Login": {"api-gateway"}}
+
+	if allow.allows("/users.AuthService/Refresh", &caller.Service{CommonName: "api-gateway"}) {
+		t.Fatal("a method without a rule is allowed")
+	}
+}
+
+func TestLoadAllowlist(t *testing.T) {
+	dir := t.TempDir()
+
+	invalid := filepath.Join(dir, "invalid.json")
+	if err := os.WriteFile(invalid, []byte(`{"/users.AuthService/Login": "api-gateway"}`), 0o600); err != nil {
+		t.Fatalf("writing allowlist: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		wantNil bool
+		wantErr bool
+	}{
+		{"no path opens every method", "", true, false},
+		{"example", "../configs/grpc_allowlist.example.json", false, false},
+		{"missing file", filepath.Join(dir, "missing.json"), true, true},
+		{"invalid json", invalid, true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allow, err := loadAllowlist(tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadAllowlist() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if (allow == nil) != tt.wantNil {
+				t.Fatalf("loadAllowlist() = %v, want nil %v", allow, tt.wantNil)
+			}
+		})
+	}
+}
+
+func TestAuthorizeService(t *testing.T) {
+	spiffe, _ := url.Parse("spiffe://cluster/api-gateway")
+
+	withCert := func(cert *x509.Certificate) context.Context {
+		return peer.NewContext(context.Background(), &peer.Peer{
+			AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{
+				VerifiedChains: [][]*x509.Certificate{{cert}},
+			}},
+		})
+	}
+
+	var (
+		gateway   = withCert(&x509.Certificate{Subject: pkix.Name{CommonName: "gateway"}, URIs: []*url.URL{spiffe}})
+		unknown   = withCert(&x509.Certificate{Subject: pkix.Name{CommonName: "unknown"}})
+		anonymous = context.Background()
+		allow     = allowlist{"*": {"spiffe://cluster/api-gateway"}}
+	)
+
+	tests := []struct {
+		name        string
+		ctx         context.Context
+		allow       allowlist
+		wantCode    codes.Code
+		wantService string
+	}{
+		{"no allowlist and no certificate", anonymous, nil, codes.OK, ""},
+		{"no allowlist keeps the service", gateway, nil, codes.OK, "spiffe://cluster/api-gateway"},
+		{"allowed service", gateway, allow, codes.OK, "spiffe://cluster/api-gateway"},
+		{"service not on the allowlist", unknown, allow, codes.PermissionDenied, "unknown"},
+		{"no certificate", anonymous, allow, codes.Unauthenticated, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, err := authorizeService(tt.ctx, "/users.AuthService/Login", tt.allow)
+			if code := status.Code(err); code != tt.wantCode {
+				t.Fatalf("authorizeService() = %v, want %s", err, tt.wantCode)
+			}
+
+			var got string
+			if service := caller.ServiceFrom(ctx); service != nil {
+				got = service.Name()
+			}
+			if got != tt.wantService {
+				t.Fatalf("service = %q, want %q", got, tt.wantService)
+			}
+		})
+	}
+}
@@ -0,0 +1,257 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"users_service/pkg/caller"
+	"users_service/pkg/logger"
+	"users_service/pkg/token"
+	"users_service/service"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// access says who may call a method
+type access int
+
+const (
+	// accessPublic methods can be called anonymously
+	accessPublic access = iota
+	// accessAuthenticated methods need a valid access token
+	accessAuthenticated
+	// accessSelf methods act on the user named by the request's Id or UserId, which has to be
+	// the caller. Admins may act on every user.
+	accessSelf
+	// accessAdmin methods can only be called by admins
+	accessAdmin
+	// accessSupport methods can only be called by support users
+	accessSupport
+)
+
+// methodAccess holds the rule of every method, methods without a rule can not be called
+var methodAccess = map[string]access{
+	"/users.AuthService/Create":                     accessPublic,
+	"/users.AuthService/GetByEmail":                 accessAdmin,
+	"/users.AuthService/DeleteRefreshTokenByUserId": accessSelf,
+	"/users.AuthService/StoreRefreshToken":          accessAdmin,
+	"/users.AuthService/CheckRefreshTokenExists":    accessAdmin,
+	"/users.AuthService/CheckEmailExists":           accessPublic,
+	"/users.AuthService/Login":                      accessPublic,
+	"/users.AuthService/Refresh":                    accessPublic,
+	"/users.AuthService/ListSessions":               accessSelf,
+	"/users.AuthService/RevokeSession":              accessSelf,
+	"/users.AuthService/SendVerificationEmail":      accessPublic,
+	"/users.AuthService/VerifyEmail":                accessPublic,
+	"/users.AuthService/RequestPasswordReset":       accessPublic,
+	"/users.AuthService/ConfirmPasswordReset":       accessPublic,
+	"/users.AuthService/VerifyMfa":                  accessPublic,
+	"/users.AuthService/StartPasswordlessLogin":     accessPublic,
+	"/users.AuthService/CompletePasswordlessLogin":  accessPublic,
+	"/users.AuthService/LoginWithProvider":          accessPublic,
+	"/users.AuthService/ListSigningKeys":            accessAdmin,
+	"/users.AuthService/RotateSigningKeys":          accessAdmin,
+	"/users.AuthService/CreateApiToken":             accessSelf,
+	"/users.AuthService/ListApiTokens":              accessSelf,
+	"/users.AuthService/RevokeApiToken":             accessSelf,
+	// other services resolve api tokens, the allowlist decides which
+	"/users.AuthService/ValidateApiToken": accessPublic,
+	"/users.AuthService/Impersonate":      accessSupport,
+
+	"/users.UsersService/GetById":                 accessSelf,
+	"/users.UsersService/GetAll":                  accessAdmin,
+	"/users.UsersService/Update":                  accessSelf,
+	"/users.UsersService/Delete":                  accessSelf,
+	"/users.UsersService/ChangePassword":          accessSelf,
+	"/users.UsersService/ChangeUserRole":          accessAdmin,
+	"/users.UsersService/VerifyPassword":          accessSelf,
+	"/users.UsersService/EnrollTotp":              accessSelf,
+	"/users.UsersService/ConfirmTotp":             accessSelf,
+	"/users.UsersService/DisableTotp":             accessSelf,
+	"/users.UsersService/RegenerateRecoveryCodes": accessSelf,
+	"/users.UsersService/UnlockUser":              accessAdmin,
+	"/users.UsersService/ListLoginHistory":        accessSelf,
+	"/users.UsersService/LinkIdentity":            accessSelf,
+	"/users.UsersService/UnlinkIdentity":          accessSelf,
+	"/users.UsersService/ListIdentities":          accessSelf,
+	"/users.UsersService/CreateRole":              accessAdmin,
+	"/users.UsersService/ListRoles":               accessAdmin,
+	"/users.UsersService/DeleteRole":              accessAdmin,
+	"/users.UsersService/SetRolePermissions":      accessAdmin,
+	"/users.UsersService/CreatePermission":        accessAdmin,
+	"/users.UsersService/ListPermissions":         accessAdmin,
+	"/users.UsersService/AssignRole":              accessAdmin,
+	"/users.UsersService/UnassignRole":            accessAdmin,
+	"/users.UsersService/ListUserRoles":           accessSelf,
+	"/users.UsersService/HasPermission":           accessSelf,
+	"/users.UsersService/RequestRoleChange":       accessAdmin,
+	"/users.UsersService/ApproveRoleChange":       accessAdmin,
+	"/users.UsersService/ListRoleChanges":         accessAdmin,
+}
+
+// notImpersonable methods can not be called with an impersonation token, the user's
+// credentials and account stay in the user's own hands
+var notImpersonable = map[string]bool{
+	"/users.AuthService/DeleteRefreshTokenByUserId": true,
+	"/users.AuthService/RevokeSession":              true,
+	"/users.AuthService/CreateApiToken":             true,
+	"/users.AuthService/RevokeApiToken":             true,
+	"/users.AuthService/Impersonate":                true,
+
+	"/users.UsersService/Update":                  true,
+	"/users.UsersService/Delete":                  true,
+	"/users.UsersService/ChangePassword":          true,
+	"/users.UsersService/VerifyPassword":          true,
+	"/users.UsersService/EnrollTotp":              true,
+	"/users.UsersService/ConfirmTotp":             true,
+	"/users.UsersService/DisableTotp":             true,
+	"/users.UsersService/RegenerateRecoveryCodes": true,
+	"/users.UsersService/LinkIdentity":            true,
+	"/users.UsersService/UnlinkIdentity":          true,
+}
+
+// authUnaryInterceptor puts the user of the bearer access token into the context and
+// enforces the method's access rule. Tokens of revoked or ended sessions are refused,
+// see service.SessionChecker. Roles are taken from the token, a role change takes
+// effect with the user's next token. Impersonated calls are logged with the support
+// user making them.
+func authUnaryInterceptor(tokens *token.Manager, sessions service.SessionChecker, log logger.ILogger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		rule, ok := methodAccess[info.FullMethod]
+		if !ok {
+			return nil, status.Errorf(codes.PermissionDenied, "%s has no access rule", info.FullMethod)
+		}
+
+		user, err := bearerUser(ctx, tokens)
+		if err == nil {
+			if err = activeSession(ctx, sessions, user, log); err != nil {
+				user = nil
+			}
+		}
+		if user != nil {
+			ctx = caller.WithUser(ctx, user)
+		}
+
+		if user != nil && user.Impersonated() {
+			resp, err := impersonatedCall(ctx, req, info, handler, user, rule)
+			log.Info("impersonated call",
+				logger.String("method", info.FullMethod),
+				logger.String("impersonator_id", user.ImpersonatorId),
+				logger.String("user_id", user.Id),
+				logger.String("session_id", user.SessionId),
+				logger.String("code", status.Code(err).String()),
+			)
+			return resp, err
+		}
+
+		if rule == accessPublic {
+			return handler(ctx, req)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if err = authorizeUser(req, user, rule); err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// impersonatedCall runs a call made with an impersonation token
+func impersonatedCall(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler, user *caller.User, rule access) (interface{}, error) {
+	if notImpersonable[info.FullMethod] {
+		return nil, status.Error(codes.PermissionDenied, "not allowed while impersonating")
+	}
+
+	if err := authorizeUser(req, user, rule); err != nil {
+		return nil, err
+	}
+
+	return handler(ctx, req)
+}
+
+// authorizeUser checks the access rule of a method for an authenticated user
+func authorizeUser(req interface{}, user *caller.User, rule access) error {
+	switch rule {
+	case accessSelf:
+		if !user.IsAdmin() && subjectOf(req) != user.Id {
+			return status.Error(codes.PermissionDenied, "you can only act on your own account")
+		}
+	case accessAdmin:
+		if !user.IsAdmin() {
+			return status.Error(codes.PermissionDenied, "admin role required")
+		}
+	case accessSupport:
+		if !user.HasRole(caller.RoleSupport) {
+			return status.Error(codes.PermissionDenied, "support role required")
+		}
+	}
+	return nil
+}
+
+// bearerUser returns the user of the access token in the authorization metadata
+func bearerUser(ctx context.Context, tokens *token.Manager) (*caller.User, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return nil, status.Error(codes.Unauthenticated, "missing bearer token")
+	}
+
+	scheme, accessToken, ok := strings.Cut(values[0], " ")
+	if !ok || !strings.EqualFold(scheme, "bearer") {
+		return nil, status.Error(codes.Unauthenticated, "authorization must be a bearer token")
+	}
+
+	claims, err := tokens.ParseAccessToken(strings.TrimSpace(accessToken))
+	if errors.Is(err, token.ErrTokenExpired) {
+		return nil, status.Error(codes.Unauthenticated, "access token expired")
+	}
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "invalid access token")
+	}
+
+	user := &caller.User{
+		Id:        claims.UserId,
+		Role:      claims.UserRole,
+		Roles:     claims.Roles,
+		SessionId: claims.SessionId,
+	}
+	if claims.Act != nil {
+		user.ImpersonatorId = claims.Act.Subject
+	}
+
+	return user, nil
+}
+
+// activeSession refuses a user whose token belongs to a revoked or ended session
+func activeSession(ctx context.Context, sessions service.SessionChecker, user *caller.User, log logger.ILogger) error {
+	active, err := sessions.Active(ctx, user.SessionId)
+	if err != nil {
+		log.Error("error while checking session in grpc layer", logger.Error(err))
+		return status.Error(codes.Unavailable, "could not check the session")
+	}
+
+	if !active {
+		return status.Error(codes.Unauthenticated, "session has ended")
+	}
+
+	return nil
+}
+
+// subjectOf returns the user a request acts on
+func subjectOf(req interface{}) string {
+	switch req := req.(type) {
+	case interface{ GetUserId() string }:
+		return req.GetUserId()
+	case interface{ GetId() string }:
+		return req.GetId()
+	}
+	return ""
+}
@@ -0,0 +1,191 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+	pb "users_service/genproto/users"
+	"users_service/pkg/caller"
+	"users_service/pkg/logger"
+	"users_service/pkg/token/tokentest"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestMethodAccessCoversEveryMethod(t *testing.T) {
+	methods := map[string]bool{}
+
+	for _, desc := range []grpc.ServiceDesc{pb.AuthService_ServiceDesc, pb.UsersService_ServiceDesc} {
+		for _, method := range desc.Methods {
+			methods["/"+desc.ServiceName+"/"+method.MethodName] = true
+		}
+	}
+
+	for method := range methods {
+		if _, ok := methodAccess[method]; !ok {
+			t.Errorf("%s has no access rule", method)
+		}
+	}
+	for method := range methodAccess {
+		if !methods[method] {
+			t.Errorf("access rule for unknown method %s", method)
+		}
+	}
+	for method := range notImpersonable {
+		if !methods[method] {
+			t.Errorf("notImpersonable names unknown method %s", method)
+		}
+	}
+}
+
+func TestAuthorizeUser(t *testing.T) {
+	var (
+		user    = &caller.User{Id: "user-1", Role: "user", Roles: []string{"user"}}
+		admin   = &caller.User{Id: "admin-1", Role: "user", Roles: []string{"user", caller.RoleAdmin}}
+		support = &caller.User{Id: "support-1", Role: "user", Roles: []string{"user", caller.RoleSupport}}
+	)
+
+	tests := []struct {
+		name     string
+		rule     access
+		req      interface{}
+		user     *caller.User
+		wantCode codes.Code
+	}{
+		{"authenticated", accessAuthenticated, &pb.Void{}, user, codes.OK},
+		{"self by id", accessSelf, &pb.PrimaryKey{Id: "user-1"}, user, codes.OK},
+		{"self by user id", accessSelf, &pb.ChangePassword{UserId: "user-1"}, user, codes.OK},
+		{"another user", accessSelf, &pb.PrimaryKey{Id: "user-2"}, user, codes.PermissionDenied},
+		{"request without subject", accessSelf, &pb.Void{}, user, codes.PermissionDenied},
+		{"admin acts on another user", accessSelf, &pb.PrimaryKey{Id: "user-2"}, admin, codes.OK},
+		{"admin method as user", accessAdmin, &pb.Void{}, user, codes.PermissionDenied},
+		{"admin method as admin", accessAdmin, &pb.Void{}, admin, codes.OK},
+		{"admin method as support", accessAdmin, &pb.Void{}, support, codes.PermissionDenied},
+		{"support method as support", accessSupport, &pb.Void{}, support, codes.OK},
+		{"support method as admin", accessSupport, &pb.Void{}, admin, codes.PermissionDenied},
+		{"support method as user", accessSupport, &pb.Void{}, user, codes.PermissionDenied},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := status.Code(authorizeUser(tt.req, tt.user, tt.rule)); code != tt.wantCode {
+				t.Fatalf("authorizeUser() = %s, want %s", code, tt.wantCode)
+			}
+		})
+	}
+}
+
+// fakeSessionChecker knows which sessions are active, checking an unknown session fails with err
+type fakeSessionChecker struct {
+	active map[string]bool
+	err    error
+}
+
+func (f fakeSessionChecker) Active(ctx context.Context, sessionId string) (bool, error) {
+	active, ok := f.active[sessionId]
+	if !ok {
+		return false, f.err
+	}
+	return active, nil
+}
+
+func TestAuthUnaryInterceptor(t *testing.T) {
+	tokens := tokentest.NewManager(t)
+	sessions := fakeSessionChecker{
+		active: map[string]bool{"session-1": true, "session-2": true, "session-9": false},
+		err:    errors.New("connection refused"),
+	}
+	interceptor := authUnaryInterceptor(tokens, sessions, logger.NewLogger("test", logger.LevelError, filepath.Join(t.TempDir(), "test.log")))
+
+	bearerOf := func(sessionId, userId string, roles ...string) string {
+		accessToken, _, err := tokens.GenerateAccessToken(userId, "", "user", sessionId, roles)
+		if err != nil {
+			t.Fatalf("GenerateAccessToken: %v", err)
+		}
+		return "Bearer " + accessToken
+	}
+	bearer := func(userId string, roles ...string) string {
+		return bearerOf("session-1", userId, roles...)
+	}
+	impersonating := func(userId, actorId string) string {
+		accessToken, _, err := tokens.GenerateImpersonationToken(userId, "", "user", "session-2", []string{"user"}, actorId, time.Minute)
+		if err != nil {
+			t.Fatalf("GenerateImpersonationToken: %v", err)
+		}
+		return "Bearer " + accessToken
+	}
+
+	type testCase struct {
+		name          string
+		method        string
+		authorization string
+		req           interface{}
+		wantCode      codes.Code
+		wantUser      string
+	}
+
+	tests := []testCase{
+		{"public without token", "/users.AuthService/Login", "", &pb.LoginRequest{}, codes.OK, ""},
+		{"public with invalid token", "/users.AuthService/Login", "Bearer garbage", &pb.LoginRequest{}, codes.OK, ""},
+		{"public with token knows the caller", "/users.AuthService/Login", bearer("user-1", "user"), &pb.LoginRequest{}, codes.OK, "user-1"},
+		{"method without rule", "/users.AuthService/Unknown", bearer("admin-1", "admin"), &pb.Void{}, codes.PermissionDenied, ""},
+		{"missing token", "/users.UsersService/GetById", "", &pb.PrimaryKey{Id: "user-1"}, codes.Unauthenticated, ""},
+		{"not a bearer token", "/users.UsersService/GetById", "Basic dXNlcjpwYXNz", &pb.PrimaryKey{Id: "user-1"}, codes.Unauthenticated, ""},
+		{"invalid token", "/users.UsersService/GetById", "Bearer garbage", &pb.PrimaryKey{Id: "user-1"}, codes.Unauthenticated, ""},
+		{"own account", "/users.UsersService/GetById", bearer("user-1", "user"), &pb.PrimaryKey{Id: "user-1"}, codes.OK, "user-1"},
+		{"lower case scheme", "/users.UsersService/GetById", "bearer" + bearer("user-1", "user")[6:], &pb.PrimaryKey{Id: "user-1"}, codes.OK, "user-1"},
+		{"another account", "/users.UsersService/GetById", bearer("user-1", "user"), &pb.PrimaryKey{Id: "user-2"}, codes.PermissionDenied, ""},
+		{"admin on another account", "/users.UsersService/GetById", bearer("admin-1", "user", "admin"), &pb.PrimaryKey{Id: "user-2"}, codes.OK, "admin-1"},
+		{"admin method as user", "/users.UsersService/GetAll", bearer("user-1", "user"), &pb.GetListRequest{}, codes.PermissionDenied, ""},
+		{"impersonate as support", "/users.AuthService/Impersonate", bearer("support-1", "user", "support"), &pb.ImpersonateRequest{TargetUserId: "user-1"}, codes.OK, "support-1"},
+		{"impersonate as admin", "/users.AuthService/Impersonate", bearer("admin-1", "user", "admin"), &pb.ImpersonateRequest{TargetUserId: "user-1"}, codes.PermissionDenied, ""},
+		{"impersonated read", "/users.UsersService/GetById", impersonating("user-1", "support-1"), &pb.PrimaryKey{Id: "user-1"}, codes.OK, "user-1"},
+		{"impersonated call on another account", "/users.UsersService/GetById", impersonating("user-1", "support-1"), &pb.PrimaryKey{Id: "user-2"}, codes.PermissionDenied, ""},
+		{"impersonated public call", "/users.AuthService/Login", impersonating("user-1", "support-1"), &pb.LoginRequest{}, codes.OK, "user-1"},
+		{"revoked session", "/users.UsersService/GetById", bearerOf("session-9", "user-1", "user"), &pb.PrimaryKey{Id: "user-1"}, codes.Unauthenticated, ""},
+		{"public with revoked session", "/users.AuthService/Login", bearerOf("session-9", "user-1", "user"), &pb.LoginRequest{}, codes.OK, ""},
+		{"session check failing", "/users.UsersService/GetById", bearerOf("session-8", "user-1", "user"), &pb.PrimaryKey{Id: "user-1"}, codes.Unavailable, ""},
+	}
+
+	for method := range notImpersonable {
+		tests = append(tests, testCase{"impersonated " + method, method, impersonating("user-1", "support-1"), &pb.PrimaryKey{Id: "user-1"}, codes.PermissionDenied, ""})
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.authorization != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", tt.authorization))
+			}
+
+			var (
+				called  bool
+				gotUser string
+			)
+
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				called = true
+				if user := caller.UserFrom(ctx); user != nil {
+					gotUser = user.Id
+				}
+				return &pb.Void{}, nil
+			}
+
+			_, err := interceptor(ctx, tt.req, &grpc.UnaryServerInfo{FullMethod: tt.method}, handler)
+			if code := status.Code(err); code != tt.wantCode {
+				t.Fatalf("interceptor() = %v, want %s", err, tt.wantCode)
+			}
+			if called != (tt.wantCode == codes.OK) {
+				t.Fatalf("handler called = %v, want %v", called, tt.wantCode == codes.OK)
+			}
+			if gotUser != tt.wantUser {
+				t.Fatalf("caller = %q, want %q", gotUser, tt.wantUser)
+			}
+		})
+	}
+}
@@ -1,20 +1,71 @@
 package grpc
 
 import (
+	"context"
+	"errors"
+	"net/netip"
+	"users_service/configs"
 	pb "users_service/genproto/users"
+	"users_service/pkg/helper"
 	"users_service/pkg/logger"
 	"users_service/service"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/reflection"
 )
 
-func SetUpServer(services service.IServiceManager, log logger.ILogger) *grpc.Server {
-	grpcServer := grpc.NewServer()
+// SetUpServer serves over TLS when a certificate is configured and requires client certificates
+// when a client CA is configured too. With an allowlist only the services it names can call.
+// Every method is guarded by its access rule, see methodAccess. Client info forwarded in metadata
+// is only trusted from the configured proxies.
+func SetUpServer(services service.IServiceManager, cfg *configs.Config, log logger.ILogger) (*grpc.Server, error) {
+	var options []grpc.ServerOption
+
+	if cfg.GrpcTLSCertPath != "" {
+		reloader, err := newCertReloader(cfg.GrpcTLSCertPath, cfg.GrpcTLSKeyPath, cfg.GrpcTLSClientCAPath, cfg.GrpcTLSReloadInterval, log)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, grpc.Creds(credentials.NewTLS(reloader.TLSConfig())))
+	} else if cfg.GrpcTLSClientCAPath != "" {
+		return nil, errors.New("a grpc client ca needs a server certificate")
+	}
+
+	allow, err := loadAllowlist(cfg.GrpcAllowlistPath)
+	if err != nil {
+		return nil, err
+	}
+	if allow != nil && cfg.GrpcTLSClientCAPath == "" {
+		return nil, errors.New("a grpc allowlist needs client certificates, configure a client ca")
+	}
+
+	proxies, err := helper.ParseTrustedProxies(cfg.GrpcTrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+
+	options = append(options,
+		grpc.ChainUnaryInterceptor(
+			clientInfoUnaryInterceptor(proxies),
+			serviceIdentityUnaryInterceptor(allow),
+			authUnaryInterceptor(services.Tokens(), services.Sessions(), log),
+		),
+		grpc.ChainStreamInterceptor(serviceIdentityStreamInterceptor(allow)),
+	)
+
+	grpcServer := grpc.NewServer(options...)
 
 	pb.RegisterAuthServiceServer(grpcServer, services.AuthService())
 	pb.RegisterUsersServiceServer(grpcServer, services.UsersService())
 
 	reflection.Register(grpcServer)
-	return grpcServer
+	return grpcServer, nil
+}
+
+// clientInfoUnaryInterceptor resolves the caller's address and user agent for the handlers, see helper.ClientInfo
+func clientInfoUnaryInterceptor(proxies []netip.Prefix) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return handler(helper.WithClientInfo(ctx, proxies), req)
+	}
 }
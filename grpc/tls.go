@@ -0,0 +1,145 @@
+package grpc
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+	"users_service/pkg/logger"
+)
+
+// certReloader serves the server certificate and client CAs from disk and picks up
+// replaced files on the first handshake after interval, a broken replacement keeps
+// the previous files in use.
+type certReloader struct {
+	certPath string
+	keyPath  string
+	caPath   string
+	interval time.Duration
+	log      logger.ILogger
+
+	mu        sync.Mutex
+	config    *tls.Config
+	modTimes  []time.Time
+	checkedAt time.Time
+}
+
+func newCertReloader(certPath, keyPath, caPath string, interval time.Duration, log logger.ILogger) (*certReloader, error) {
+	r := &certReloader{
+		certPath: certPath,
+		keyPath:  keyPath,
+		caPath:   caPath,
+		interval: interval,
+		log:      log,
+	}
+
+	modTimes, err := r.modTimesOf()
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := r.load()
+	if err != nil {
+		return nil, err
+	}
+
+	r.config, r.modTimes, r.checkedAt = config, modTimes, time.Now()
+
+	return r, nil
+}
+
+// TLSConfig ...
+func (r *certReloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return r.current(), nil
+		},
+	}
+}
+
+func (r *certReloader) current() *tls.Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.checkedAt) < r.interval {
+		return r.config
+	}
+	r.checkedAt = time.Now()
+
+	modTimes, err := r.modTimesOf()
+	if err != nil {
+		r.log.Error("error while checking tls certificates for changes", logger.Error(err))
+		return r.config
+	}
+
+	if slices.EqualFunc(modTimes, r.modTimes, time.Time.Equal) {
+		return r.config
+	}
+
+	config, err := r.load()
+	if err != nil {
+		r.log.Error("error while reloading tls certificates, keeping the previous ones", logger.Error(err))
+		return r.config
+	}
+
+	r.config, r.modTimes = config, modTimes
+	r.log.Info("reloaded tls certificates")
+
+	return r.config
+}
+
+func (r *certReloader) load() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(r.certPath, r.keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("error while loading tls certificate: %w", err)
+	}
+
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		// the config returned for a client replaces the one grpc added h2 to
+		NextProtos: []string{"h2"},
+	}
+
+	if r.caPath == "" {
+		return config, nil
+	}
+
+	pem, err := os.ReadFile(r.caPath)
+	if err != nil {
+		return nil, fmt.Errorf("error while reading tls client ca: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("tls client ca file contains no certificates")
+	}
+
+	config.ClientCAs = pool
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+
+	return config, nil
+}
+
+func (r *certReloader) modTimesOf() ([]time.Time, error) {
+	var modTimes []time.Time
+
+	for _, path := range []string{r.certPath, r.keyPath, r.caPath} {
+		if path == "" {
+			continue
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("error while reading tls file: %w", err)
+		}
+		modTimes = append(modTimes, info.ModTime())
+	}
+
+	return modTimes, nil
+}
@@ -0,0 +1,56 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+	"users_service/configs"
+	"users_service/pkg/logger"
+	"users_service/pkg/token"
+)
+
+const jwksPath = "/.well-known/jwks.json"
+
+// discovery is the subset of the OpenID provider metadata relevant to verifying tokens
+type discovery struct {
+	Issuer                           string   `json:"issuer"`
+	JwksURI                          string   `json:"jwks_uri"`
+	SubjectTypesSupported            []string `json:"subject_types_supported"`
+	IdTokenSigningAlgValuesSupported []string `json:"id_token_signing_alg_values_supported"`
+	ClaimsSupported                  []string `json:"claims_supported"`
+}
+
+// SetUpServer serves the JWKS and discovery documents downstream services verify access tokens with
+func SetUpServer(tokens *token.Manager, cfg *configs.Config, log logger.ILogger) *http.Server {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc(jwksPath, func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, log, tokens.JWKS())
+	})
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, log, discovery{
+			Issuer:                           tokens.Issuer(),
+			JwksURI:                          strings.TrimSuffix(tokens.Issuer(), "/") + jwksPath,
+			SubjectTypesSupported:            []string{"public"},
+			IdTokenSigningAlgValuesSupported: tokens.Algorithms(),
+			ClaimsSupported:                  []string{"iss", "sub", "iat", "exp", "user_id", "email", "user_role", "roles", "sid", "act"},
+		})
+	})
+
+	return &http.Server{
+		Addr:              cfg.UserServiceHttpHost + cfg.UserServiceHttpPort,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+}
+
+func writeJSON(w http.ResponseWriter, log logger.ILogger, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Error("error while writing http response", logger.Error(err))
+	}
+}
@@ -0,0 +1,8 @@
+drop index if exists refresh_tokens_refresh_token_idx;
+drop index if exists refresh_tokens_family_id_idx;
+
+alter table refresh_tokens
+    drop column if exists revoked_at,
+    drop column if exists consumed_at,
+    drop column if exists parent_id,
+    drop column if exists family_id;
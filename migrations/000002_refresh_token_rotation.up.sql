@@ -0,0 +1,12 @@
+ALTER TABLE refresh_tokens
+    ADD COLUMN family_id UUID,
+    ADD COLUMN parent_id UUID references refresh_tokens(id) ON DELETE SET NULL,
+    ADD COLUMN consumed_at TIMESTAMP,
+    ADD COLUMN revoked_at TIMESTAMP;
+
+UPDATE refresh_tokens SET family_id = id WHERE family_id IS NULL;
+
+ALTER TABLE refresh_tokens ALTER COLUMN family_id SET NOT NULL;
+
+CREATE INDEX refresh_tokens_family_id_idx ON refresh_tokens(family_id);
+CREATE INDEX refresh_tokens_refresh_token_idx ON refresh_tokens(refresh_token);
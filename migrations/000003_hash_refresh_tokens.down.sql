@@ -0,0 +1,8 @@
+delete from refresh_tokens;
+
+drop index if exists refresh_tokens_token_hash_idx;
+
+alter table refresh_tokens alter column token_hash type text;
+alter table refresh_tokens rename column token_hash to refresh_token;
+
+create index refresh_tokens_refresh_token_idx on refresh_tokens(refresh_token);
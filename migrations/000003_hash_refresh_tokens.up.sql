@@ -0,0 +1,10 @@
+-- plaintext refresh tokens can not be rehashed without the server key,
+-- so existing sessions are expired and users have to sign in again
+DELETE FROM refresh_tokens;
+
+DROP INDEX IF EXISTS refresh_tokens_refresh_token_idx;
+
+ALTER TABLE refresh_tokens RENAME COLUMN refresh_token TO token_hash;
+ALTER TABLE refresh_tokens ALTER COLUMN token_hash TYPE VARCHAR(64);
+
+CREATE UNIQUE INDEX refresh_tokens_token_hash_idx ON refresh_tokens(token_hash);
@@ -0,0 +1,4 @@
+drop index if exists refresh_tokens_expires_in_idx;
+
+alter table refresh_tokens
+    alter column expires_in type timestamp using expires_in at time zone 'UTC';
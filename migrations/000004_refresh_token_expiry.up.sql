@@ -0,0 +1,4 @@
+ALTER TABLE refresh_tokens
+    ALTER COLUMN expires_in TYPE TIMESTAMP WITH TIME ZONE USING expires_in AT TIME ZONE 'UTC';
+
+CREATE INDEX refresh_tokens_expires_in_idx ON refresh_tokens(expires_in);
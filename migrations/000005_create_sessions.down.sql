@@ -0,0 +1,3 @@
+alter table refresh_tokens drop constraint if exists refresh_tokens_family_id_fkey;
+
+drop table if exists sessions;
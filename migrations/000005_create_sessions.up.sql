@@ -0,0 +1,22 @@
+CREATE TABLE sessions (
+    id UUID PRIMARY KEY default gen_random_uuid(),
+    user_id UUID references users(id) NOT NULL,
+    device_name VARCHAR(100) default '' NOT NULL,
+    user_agent text default '' NOT NULL,
+    ip_address VARCHAR(64) default '' NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    last_used_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    revoked_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX sessions_user_id_idx ON sessions(user_id);
+
+-- every existing token family becomes a session
+INSERT INTO sessions (id, user_id, created_at, last_used_at)
+SELECT family_id, user_id, min(created_at), max(created_at)
+FROM refresh_tokens
+GROUP BY family_id, user_id;
+
+ALTER TABLE refresh_tokens
+    ADD CONSTRAINT refresh_tokens_family_id_fkey
+    FOREIGN KEY (family_id) REFERENCES sessions(id) ON DELETE CASCADE;
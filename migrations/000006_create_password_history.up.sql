@@ -0,0 +1,12 @@
+CREATE TABLE password_history (
+    id UUID PRIMARY KEY default gen_random_uuid(),
+    user_id UUID references users(id) NOT NULL,
+    password_hash VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX password_history_user_id_idx ON password_history(user_id, created_at DESC);
+
+-- current passwords are the first entries of the history
+INSERT INTO password_history (user_id, password_hash)
+SELECT id, password_hash FROM users WHERE deleted_at IS NULL;
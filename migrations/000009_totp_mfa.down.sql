@@ -0,0 +1,5 @@
+drop table if exists recovery_codes;
+
+drop table if exists user_totp;
+
+alter table users drop column if exists mfa_enabled;
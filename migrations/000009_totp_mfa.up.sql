@@ -0,0 +1,18 @@
+ALTER TABLE users ADD COLUMN mfa_enabled BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE user_totp (
+    user_id UUID PRIMARY KEY references users(id),
+    secret_encrypted TEXT NOT NULL,
+    last_used_step BIGINT,
+    confirmed_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE recovery_codes (
+    id UUID PRIMARY KEY default gen_random_uuid(),
+    user_id UUID references users(id) NOT NULL,
+    code_hash VARCHAR(64) NOT NULL,
+    used_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (user_id, code_hash)
+);
@@ -0,0 +1,3 @@
+alter table users drop column if exists locked_until;
+
+alter table users drop column if exists failed_login_count;
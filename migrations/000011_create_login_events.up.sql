@@ -0,0 +1,15 @@
+CREATE TABLE login_events (
+    id UUID PRIMARY KEY default gen_random_uuid(),
+    user_id UUID references users(id),
+    email VARCHAR(100) NOT NULL,
+    success BOOLEAN NOT NULL,
+    failure_reason VARCHAR(64),
+    method VARCHAR(32) NOT NULL,
+    ip_address VARCHAR(64) default '' NOT NULL,
+    user_agent text default '' NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX login_events_user_id_created_at_idx ON login_events(user_id, created_at DESC);
+
+CREATE INDEX login_events_created_at_idx ON login_events(created_at);
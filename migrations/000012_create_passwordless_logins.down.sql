@@ -0,0 +1 @@
+drop table if exists passwordless_logins;
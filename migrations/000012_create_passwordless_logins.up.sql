@@ -0,0 +1,11 @@
+CREATE TABLE passwordless_logins (
+    id UUID PRIMARY KEY default gen_random_uuid(),
+    user_id UUID references users(id),
+    code_hash VARCHAR(64),
+    attempts INT NOT NULL DEFAULT 0,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    used_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX passwordless_logins_user_id_idx ON passwordless_logins(user_id);
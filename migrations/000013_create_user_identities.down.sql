@@ -0,0 +1,5 @@
+drop table if exists user_identities;
+
+update users set password_hash = '' where password_hash is null;
+
+alter table users alter column password_hash set not null;
@@ -0,0 +1,13 @@
+CREATE TABLE signing_keys (
+    id VARCHAR(64) PRIMARY KEY,
+    algorithm VARCHAR(16) NOT NULL,
+    private_key_encrypted TEXT NOT NULL,
+    state VARCHAR(16) NOT NULL CHECK (state IN ('pending', 'active', 'retired')),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    activated_at TIMESTAMP WITH TIME ZONE,
+    retired_at TIMESTAMP WITH TIME ZONE,
+    expires_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE UNIQUE INDEX signing_keys_active_idx ON signing_keys(state) WHERE state = 'active';
+CREATE UNIQUE INDEX signing_keys_pending_idx ON signing_keys(state) WHERE state = 'pending';
@@ -0,0 +1,5 @@
+alter table users drop constraint if exists users_user_role_fkey;
+drop table if exists user_roles;
+drop table if exists role_permissions;
+drop table if exists permissions;
+drop table if exists roles;
@@ -0,0 +1,47 @@
+CREATE TABLE roles (
+    name VARCHAR(100) PRIMARY KEY,
+    description TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE permissions (
+    name VARCHAR(100) PRIMARY KEY,
+    description TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE role_permissions (
+    role_name VARCHAR(100) references roles(name) ON DELETE CASCADE NOT NULL,
+    permission_name VARCHAR(100) references permissions(name) ON DELETE CASCADE NOT NULL,
+    PRIMARY KEY (role_name, permission_name)
+);
+
+-- roles a user holds next to the primary role in users.user_role
+CREATE TABLE user_roles (
+    user_id UUID references users(id) NOT NULL,
+    role_name VARCHAR(100) references roles(name) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, role_name)
+);
+
+CREATE INDEX user_roles_role_name_idx ON user_roles(role_name);
+
+INSERT INTO roles (name, description) VALUES
+    ('user', 'Every registered user'),
+    ('admin', 'Manages users, roles and signing keys');
+
+INSERT INTO permissions (name, description) VALUES
+    ('users:read', 'Read any user'),
+    ('users:write', 'Update and delete any user'),
+    ('roles:manage', 'Manage roles, permissions and role assignments'),
+    ('signing_keys:manage', 'List and rotate token signing keys');
+
+INSERT INTO role_permissions (role_name, permission_name)
+    SELECT 'admin', name FROM permissions;
+
+-- keep every role already in use
+INSERT INTO roles (name)
+    SELECT DISTINCT user_role FROM users
+    ON CONFLICT (name) DO NOTHING;
+
+ALTER TABLE users ADD CONSTRAINT users_user_role_fkey FOREIGN KEY (user_role) REFERENCES roles(name);
@@ -0,0 +1,17 @@
+-- every applied role change with the admin who made it, and changes waiting for a second admin's approval
+CREATE TABLE role_changes (
+    id UUID PRIMARY KEY default gen_random_uuid(),
+    user_id UUID references users(id) NOT NULL,
+    action VARCHAR(20) NOT NULL CHECK (action IN ('set_primary', 'assign', 'unassign')),
+    role VARCHAR(100) NOT NULL,
+    previous_role VARCHAR(100),
+    actor_id UUID references users(id),
+    approved_by UUID references users(id),
+    state VARCHAR(20) NOT NULL CHECK (state IN ('pending', 'applied')),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    applied_at TIMESTAMP WITH TIME ZONE,
+    expires_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX role_changes_user_id_idx ON role_changes(user_id, created_at);
+CREATE INDEX role_changes_pending_idx ON role_changes(created_at) WHERE state = 'pending';
@@ -0,0 +1,8 @@
+delete from sessions where impersonator_id is not null;
+alter table sessions drop column if exists impersonator_id;
+alter table sessions drop column if exists impersonation_reason;
+alter table sessions drop column if exists expires_at;
+delete from user_roles where role_name = 'support';
+update users set user_role = 'user' where user_role = 'support';
+delete from roles where name = 'support';
+delete from permissions where name = 'users:impersonate';
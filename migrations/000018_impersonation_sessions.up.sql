@@ -0,0 +1,14 @@
+-- sessions a support user opens as another user, they have no refresh token and end at expires_at
+ALTER TABLE sessions
+    ADD COLUMN impersonator_id UUID references users(id),
+    ADD COLUMN impersonation_reason TEXT,
+    ADD COLUMN expires_at TIMESTAMP WITH TIME ZONE;
+
+INSERT INTO roles (name, description) VALUES
+    ('support', 'Impersonates customers to debug their issues');
+
+INSERT INTO permissions (name, description) VALUES
+    ('users:impersonate', 'Open time-boxed sessions as another user');
+
+INSERT INTO role_permissions (role_name, permission_name) VALUES
+    ('support', 'users:impersonate');
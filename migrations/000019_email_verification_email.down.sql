@@ -0,0 +1 @@
+alter table email_verification_tokens drop column if exists email;
@@ -0,0 +1,6 @@
+-- a verification token only verifies the address it was mailed to
+ALTER TABLE email_verification_tokens ADD COLUMN email VARCHAR(100);
+
+UPDATE email_verification_tokens t SET email = u.email FROM users u WHERE u.id = t.user_id;
+
+ALTER TABLE email_verification_tokens ALTER COLUMN email SET NOT NULL;
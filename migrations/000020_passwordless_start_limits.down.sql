@@ -0,0 +1,4 @@
+drop index if exists passwordless_logins_ip_address_idx;
+drop index if exists passwordless_logins_email_hash_idx;
+alter table passwordless_logins drop column if exists ip_address;
+alter table passwordless_logins drop column if exists email_hash;
@@ -0,0 +1,6 @@
+-- starts are limited per email, registered or not, and per client ip
+ALTER TABLE passwordless_logins ADD COLUMN email_hash VARCHAR(64) default '' NOT NULL;
+ALTER TABLE passwordless_logins ADD COLUMN ip_address VARCHAR(64) default '' NOT NULL;
+
+CREATE INDEX passwordless_logins_email_hash_idx ON passwordless_logins(email_hash, created_at);
+CREATE INDEX passwordless_logins_ip_address_idx ON passwordless_logins(ip_address, created_at);
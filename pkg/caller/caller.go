@@ -0,0 +1,102 @@
+package caller
+
+import (
+	"context"
+	"crypto/x509"
+	"slices"
+)
+
+type (
+	serviceKey struct{}
+	userKey    struct{}
+)
+
+// Service is the identity of a calling service as proven by its verified client certificate
+type Service struct {
+	CommonName string
+	DNSNames   []string
+	URIs       []string
+}
+
+// NewService reads the identity from the leaf of a verified certificate chain
+func NewService(cert *x509.Certificate) *Service {
+	service := &Service{
+		CommonName: cert.Subject.CommonName,
+		DNSNames:   cert.DNSNames,
+	}
+	for _, uri := range cert.URIs {
+		service.URIs = append(service.URIs, uri.String())
+	}
+	return service
+}
+
+// Names returns every name the service is known by, SANs first
+func (s *Service) Names() []string {
+	names := slices.Concat(s.URIs, s.DNSNames)
+	if s.CommonName != "" {
+		names = append(names, s.CommonName)
+	}
+	return names
+}
+
+// Name returns the most specific name of the service
+func (s *Service) Name() string {
+	if names := s.Names(); len(names) > 0 {
+		return names[0]
+	}
+	return ""
+}
+
+// WithService returns a copy of ctx carrying the calling service
+func WithService(ctx context.Context, service *Service) context.Context {
+	return context.WithValue(ctx, serviceKey{}, service)
+}
+
+// ServiceFrom returns the calling service, it is nil for calls without a verified client certificate
+func ServiceFrom(ctx context.Context) *Service {
+	service, _ := ctx.Value(serviceKey{}).(*Service)
+	return service
+}
+
+const (
+	// RoleAdmin is the role of users allowed to manage other users
+	RoleAdmin = "admin"
+	// RoleSupport is the role of users allowed to impersonate customers
+	RoleSupport = "support"
+)
+
+// User is the authenticated user a call is made for, Role is the primary one of its Roles.
+// ImpersonatorId is set when a support user makes the call as this user.
+type User struct {
+	Id             string
+	Role           string
+	Roles          []string
+	SessionId      string
+	ImpersonatorId string
+}
+
+// HasRole ...
+func (u *User) HasRole(role string) bool {
+	return u.Role == role || slices.Contains(u.Roles, role)
+}
+
+// IsAdmin ...
+func (u *User) IsAdmin() bool {
+	return u.HasRole(RoleAdmin)
+}
+
+// Impersonated reports whether the call is made by a support user acting as the user
+func (u *User) Impersonated() bool {
+	return u.ImpersonatorId != ""
+}
+
+// WithUser returns a copy of ctx carrying the calling user
+func WithUser(ctx context.Context, user *User) context.Context {
+	return context.WithValue(ctx, userKey{}, user)
+}
+
+// UserFrom returns the calling user, it is nil for calls without a valid bearer token
+func UserFrom(ctx context.Context) *User {
+	user, _ := ctx.Value(userKey{}).(*User)
+	return user
+}
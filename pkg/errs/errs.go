@@ -0,0 +1,77 @@
+package errs
+
+import "errors"
+
+var (
+	// ErrRefreshTokenNotFound ...
+	ErrRefreshTokenNotFound = errors.New("refresh token not found")
+	// ErrRefreshTokenExpired ...
+	ErrRefreshTokenExpired = errors.New("refresh token expired")
+	// ErrRefreshTokenRevoked ...
+	ErrRefreshTokenRevoked = errors.New("refresh token revoked")
+	// ErrRefreshTokenReused is returned when an already rotated refresh token is presented again
+	ErrRefreshTokenReused = errors.New("refresh token reused")
+	// ErrRefreshTokenExpiryInvalid is returned when a refresh token to store does not expire at a future RFC3339 time
+	ErrRefreshTokenExpiryInvalid = errors.New("invalid refresh token expiry")
+	// ErrSessionNotFound ...
+	ErrSessionNotFound = errors.New("session not found")
+	// ErrVerificationTokenNotFound is returned for unknown and already used email verification tokens
+	ErrVerificationTokenNotFound = errors.New("verification token not found")
+	// ErrVerificationTokenExpired ...
+	ErrVerificationTokenExpired = errors.New("verification token expired")
+	// ErrResetTokenNotFound is returned for unknown and already used password reset tokens
+	ErrResetTokenNotFound = errors.New("password reset token not found")
+	// ErrResetTokenExpired ...
+	ErrResetTokenExpired = errors.New("password reset token expired")
+	// ErrMfaNotEnrolled is returned when the user has no TOTP secret to confirm or verify against
+	ErrMfaNotEnrolled = errors.New("mfa not enrolled")
+	// ErrPasswordlessNotFound is returned for unknown, used and burnt passwordless challenges
+	ErrPasswordlessNotFound = errors.New("passwordless login not found")
+	// ErrPasswordlessExpired ...
+	ErrPasswordlessExpired = errors.New("passwordless login expired")
+	// ErrPasswordlessCodeInvalid ...
+	ErrPasswordlessCodeInvalid = errors.New("invalid passwordless login code")
+	// ErrPasswordlessAttemptsExceeded is returned by the wrong code that burns the challenge
+	ErrPasswordlessAttemptsExceeded = errors.New("too many passwordless login attempts")
+	// ErrPasswordlessRateLimited is returned when too many passwordless logins were started lately
+	// for an email or from a client ip
+	ErrPasswordlessRateLimited = errors.New("too many passwordless logins started")
+	// ErrIdentityNotFound ...
+	ErrIdentityNotFound = errors.New("identity not found")
+	// ErrIdentityAlreadyLinked is returned when the provider subject or the user's provider slot is taken
+	ErrIdentityAlreadyLinked = errors.New("identity already linked")
+	// ErrLastLoginMethod is returned when unlinking would leave a user without any way to log in
+	ErrLastLoginMethod = errors.New("cannot remove the last login method")
+	// ErrEmailTaken ...
+	ErrEmailTaken = errors.New("email already registered")
+	// ErrNoPendingSigningKey is returned when there is no pending key to rotate to
+	ErrNoPendingSigningKey = errors.New("no pending signing key")
+	// ErrApiTokenNotFound is returned for unknown and revoked api tokens
+	ErrApiTokenNotFound = errors.New("api token not found")
+	// ErrApiTokenExpired ...
+	ErrApiTokenExpired = errors.New("api token expired")
+	// ErrRoleNotFound ...
+	ErrRoleNotFound = errors.New("role not found")
+	// ErrRoleExists ...
+	ErrRoleExists = errors.New("role already exists")
+	// ErrRoleInUse is returned when deleting a role users still hold
+	ErrRoleInUse = errors.New("role is still assigned to users")
+	// ErrRoleNotAssigned ...
+	ErrRoleNotAssigned = errors.New("role not assigned to user")
+	// ErrPermissionNotFound ...
+	ErrPermissionNotFound = errors.New("permission not found")
+	// ErrPermissionExists ...
+	ErrPermissionExists = errors.New("permission already exists")
+	// ErrUserNotFound ...
+	ErrUserNotFound = errors.New("user not found")
+	// ErrLastAdmin is returned when a role change would leave no admin
+	ErrLastAdmin = errors.New("can not remove the last admin")
+	// ErrRoleChangeNotFound ...
+	ErrRoleChangeNotFound = errors.New("role change not found")
+	// ErrRoleChangeNotPending is returned when approving a role change that was already applied
+	ErrRoleChangeNotPending = errors.New("role change is not pending")
+	// ErrRoleChangeExpired ...
+	ErrRoleChangeExpired = errors.New("role change request expired")
+	// ErrSameApprover is returned when an admin approves its own role change request
+	ErrSameApprover = errors.New("role change must be approved by another admin")
+)
@@ -1,6 +1,7 @@
 package helper
 
 import (
+	"net/url"
 	"strconv"
 	"strings"
 )
@@ -23,3 +24,17 @@ func ReplaceQueryParams(namedQuery string, params map[string]interface{}) (strin
 
 	return namedQuery, args
 }
+
+// TokenLink appends token as the token query parameter of base
+func TokenLink(base, token string) (string, error) {
+	link, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+
+	return link.String(), nil
+}
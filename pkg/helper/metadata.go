@@ -0,0 +1,167 @@
+package helper
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"unicode/utf8"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// userAgentMaxLength caps stored user agents, longer ones are cut
+const userAgentMaxLength = 512
+
+type clientInfoKey struct{}
+
+type clientInfo struct {
+	ip        string
+	userAgent string
+}
+
+// ParseTrustedProxies parses ip addresses and CIDR ranges of the proxies allowed to forward client info
+func ParseTrustedProxies(values []string) ([]netip.Prefix, error) {
+	var proxies []netip.Prefix
+
+	for _, value := range values {
+		if value = strings.TrimSpace(value); value == "" {
+			continue
+		}
+
+		if strings.Contains(value, "/") {
+			prefix, err := netip.ParsePrefix(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %w", value, err)
+			}
+			proxies = append(proxies, prefix.Masked())
+			continue
+		}
+
+		addr, err := netip.ParseAddr(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", value, err)
+		}
+		addr = addr.Unmap()
+		proxies = append(proxies, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+
+	return proxies, nil
+}
+
+// WithClientInfo resolves the caller's ip address and user agent once and puts them into ctx.
+// Forwarded metadata is only honored when the gRPC peer is one of the trusted proxies.
+func WithClientInfo(ctx context.Context, trustedProxies []netip.Prefix) context.Context {
+	ip, userAgent := resolveClientInfo(ctx, trustedProxies)
+	return context.WithValue(ctx, clientInfoKey{}, clientInfo{ip: ip, userAgent: userAgent})
+}
+
+// ClientInfo returns the caller's ip address and user agent. Without WithClientInfo no proxy
+// is trusted and the gRPC peer itself is the caller.
+func ClientInfo(ctx context.Context) (ip, userAgent string) {
+	if info, ok := ctx.Value(clientInfoKey{}).(clientInfo); ok {
+		return info.ip, info.userAgent
+	}
+	return resolveClientInfo(ctx, nil)
+}
+
+func resolveClientInfo(ctx context.Context, trustedProxies []netip.Prefix) (ip, userAgent string) {
+
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	peerAddr, ok := peerAddress(ctx)
+	if ok {
+		ip = peerAddr.String()
+	}
+
+	userAgent = firstValue(md, "user-agent")
+
+	if ok && trusted(peerAddr, trustedProxies) {
+		if addr, found := forwardedAddress(md, trustedProxies); found {
+			ip = addr.String()
+		}
+		if forwarded := firstValue(md, "x-user-agent"); forwarded != "" {
+			userAgent = forwarded
+		}
+	}
+
+	return ip, truncate(userAgent, userAgentMaxLength)
+}
+
+// forwardedAddress walks x-forwarded-for from the closest hop back and returns the first
+// address that is not a trusted proxy, falling back to x-real-ip. Malformed values are ignored.
+func forwardedAddress(md metadata.MD, trustedProxies []netip.Prefix) (netip.Addr, bool) {
+	var hops []string
+	for _, value := range md.Get("x-forwarded-for") {
+		hops = append(hops, strings.Split(value, ",")...)
+	}
+
+	var client netip.Addr
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		client = addr.Unmap().WithZone("")
+		if !trusted(client, trustedProxies) {
+			return client, true
+		}
+	}
+	if client.IsValid() {
+		return client, true
+	}
+
+	if addr, err := netip.ParseAddr(strings.TrimSpace(firstValue(md, "x-real-ip"))); err == nil {
+		return addr.Unmap().WithZone(""), true
+	}
+
+	return netip.Addr{}, false
+}
+
+func peerAddress(ctx context.Context) (netip.Addr, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return netip.Addr{}, false
+	}
+
+	host := p.Addr.String()
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap().WithZone(""), true
+}
+
+func trusted(addr netip.Addr, trustedProxies []netip.Prefix) bool {
+	for _, proxy := range trustedProxies {
+		if proxy.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// truncate drops invalid UTF-8 from s and cuts it to at most limit bytes without splitting a character
+func truncate(s string, limit int) string {
+	s = strings.ToValidUTF8(s, "")
+	if len(s) <= limit {
+		return s
+	}
+	for limit > 0 && !utf8.RuneStart(s[limit]) {
+		limit--
+	}
+	return s[:limit]
+}
+
+func firstValue(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
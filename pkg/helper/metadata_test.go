@@ -0,0 +1,74 @@
+package helper
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+func TestClientInfo(t *testing.T) {
+	proxies, err := ParseTrustedProxies([]string{"10.0.0.0/8", " 192.168.1.1 ", ""})
+	if err != nil {
+		t.Fatalf("ParseTrustedProxies: %v", err)
+	}
+
+	tests := []struct {
+		name          string
+		peer          string
+		md            metadata.MD
+		wantIp        string
+		wantUserAgent string
+	}{
+		{"direct", "203.0.113.7", metadata.Pairs("user-agent", "curl"), "203.0.113.7", "curl"},
+		{"forwarded by untrusted peer", "203.0.113.7", metadata.Pairs("x-forwarded-for", "198.51.100.1", "x-user-agent", "browser", "user-agent", "curl"), "203.0.113.7", "curl"},
+		{"forwarded by trusted proxy", "10.1.2.3", metadata.Pairs("x-forwarded-for", "198.51.100.1", "x-user-agent", "browser"), "198.51.100.1", "browser"},
+		{"spoofed first hop", "10.1.2.3", metadata.Pairs("x-forwarded-for", "1.1.1.1, 198.51.100.1, 192.168.1.1"), "198.51.100.1", ""},
+		{"only proxies", "10.1.2.3", metadata.Pairs("x-forwarded-for", "10.0.0.5"), "10.0.0.5", ""},
+		{"real ip", "192.168.1.1", metadata.Pairs("x-real-ip", "198.51.100.2"), "198.51.100.2", ""},
+		{"malformed", "10.1.2.3", metadata.Pairs("x-forwarded-for", "not an ip"), "10.1.2.3", ""},
+		{"long user agent", "203.0.113.7", metadata.Pairs("user-agent", strings.Repeat("a", userAgentMaxLength+10)), "203.0.113.7", strings.Repeat("a", userAgentMaxLength)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(tt.peer), Port: 4242}})
+			ctx = WithClientInfo(metadata.NewIncomingContext(ctx, tt.md), proxies)
+
+			ip, userAgent := ClientInfo(ctx)
+			if ip != tt.wantIp || userAgent != tt.wantUserAgent {
+				t.Fatalf("ClientInfo() = %q, %q, want %q, %q", ip, userAgent, tt.wantIp, tt.wantUserAgent)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxiesInvalid(t *testing.T) {
+	for _, value := range []string{"10.0.0.0/33", "gateway"} {
+		if _, err := ParseTrustedProxies([]string{value}); err == nil {
+			t.Fatalf("ParseTrustedProxies(%q) succeeded, want an error", value)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		s     string
+		limit int
+		want  string
+	}{
+		{"laptop", 10, "laptop"},
+		{"laptop", 3, "lap"},
+		{"héllo", 2, "h"},
+		{"a\xffb", 10, "ab"},
+	}
+
+	for _, tt := range tests {
+		if got := truncate(tt.s, tt.limit); got != tt.want {
+			t.Fatalf("truncate(%q, %d) = %q, want %q", tt.s, tt.limit, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type fileMailer struct {
+	dir  string
+	from string
+}
+
+// NewFileMailer writes every message as an .eml file into dir, for local development
+func NewFileMailer(dir, from string) (Mailer, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("error while creating mail directory: %w", err)
+	}
+
+	return &fileMailer{
+		dir:  dir,
+		from: from,
+	}, nil
+}
+
+func (f *fileMailer) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s-%s.eml",
+		time.Now().Format("20060102T150405.000000000"),
+		strings.NewReplacer("@", "_at_", "/", "_").Replace(msg.To),
+	)
+
+	return os.WriteFile(filepath.Join(f.dir, name), compose(f.from, msg), 0644)
+}
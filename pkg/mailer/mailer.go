@@ -0,0 +1,42 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"users_service/configs"
+)
+
+const (
+	// DriverSMTP ...
+	DriverSMTP = "smtp"
+	// DriverFile ...
+	DriverFile = "file"
+	// DriverMemory ...
+	DriverMemory = "memory"
+)
+
+// Message ...
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers transactional emails like verification links
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// New returns the mailer selected by MAILER_DRIVER
+func New(cfg *configs.Config) (Mailer, error) {
+	switch cfg.MailerDriver {
+	case DriverSMTP:
+		return NewSMTPMailer(cfg.SmtpHost, cfg.SmtpPort, cfg.Email, cfg.Password), nil
+	case DriverFile:
+		return NewFileMailer(cfg.MailDir, cfg.Email)
+	case DriverMemory:
+		return NewMemoryMailer(), nil
+	default:
+		return nil, fmt.Errorf("unknown mailer driver %q", cfg.MailerDriver)
+	}
+}
@@ -0,0 +1,55 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+)
+
+type smtpMailer struct {
+	addr     string
+	host     string
+	from     string
+	password string
+}
+
+// NewSMTPMailer sends mail through an SMTP server authenticating as the from account
+func NewSMTPMailer(host, port, from, password string) Mailer {
+	return &smtpMailer{
+		addr:     net.JoinHostPort(host, port),
+		host:     host,
+		from:     from,
+		password: password,
+	}
+}
+
+func (s *smtpMailer) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	auth := smtp.PlainAuth("", s.from, s.password, s.host)
+
+	if err := smtp.SendMail(s.addr, auth, s.from, []string{msg.To}, compose(s.from, msg)); err != nil {
+		return fmt.Errorf("error while sending mail to %s: %w", msg.To, err)
+	}
+
+	return nil
+}
+
+// compose renders msg as a plain text RFC 5322 message
+func compose(from string, msg Message) []byte {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=\"utf-8\"\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(msg.Body)
+
+	return []byte(b.String())
+}
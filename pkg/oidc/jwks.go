@@ -0,0 +1,205 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// minRefreshInterval keeps tokens with unknown key ids from hammering the provider
+const minRefreshInterval = time.Minute
+
+type idTokenClaims struct {
+	Email         string       `json:"email"`
+	EmailVerified flexibleBool `json:"email_verified"`
+	jwt.RegisteredClaims
+}
+
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type jwkSet struct {
+	Keys []jwk `json:"keys"`
+}
+
+// jwksVerifier verifies RS256 and ES256 ID tokens against keys fetched from the provider's JWKS url.
+// Keys are cached for ttl and refetched early when a token names an unknown key id.
+type jwksVerifier struct {
+	provider string
+	issuer   string
+	clientId string
+	url      string
+	ttl      time.Duration
+	client   *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]interface{}
+	fetchedAt time.Time
+}
+
+// NewJWKSVerifier ...
+func NewJWKSVerifier(provider, issuer, clientId, url string, ttl time.Duration) Verifier {
+	return &jwksVerifier{
+		provider: provider,
+		issuer:   issuer,
+		clientId: clientId,
+		url:      url,
+		ttl:      ttl,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (v *jwksVerifier) Verify(ctx context.Context, idToken string) (*Identity, error) {
+	claims := &idTokenClaims{}
+
+	_, err := jwt.ParseWithClaims(idToken, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return v.key(ctx, kid)
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodES256.Alg()}),
+		jwt.WithIssuer(v.issuer),
+		jwt.WithAudience(v.clientId),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: missing subject", ErrInvalidIDToken)
+	}
+
+	return &Identity{
+		Provider:      v.provider,
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: bool(claims.EmailVerified),
+	}, nil
+}
+
+// key returns the public key with the given id, refreshing the cached set when needed
+func (v *jwksVerifier) key(ctx context.Context, kid string) (interface{}, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	key, ok := v.keys[kid]
+	stale := time.Since(v.fetchedAt) > v.ttl
+	if ok && !stale {
+		return key, nil
+	}
+
+	if !stale && time.Since(v.fetchedAt) < minRefreshInterval {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+
+	keys, err := v.fetch(ctx)
+	if err != nil {
+		// keep serving the cached keys while the provider is unreachable
+		if ok {
+			return key, nil
+		}
+		return nil, err
+	}
+	v.keys = keys
+	v.fetchedAt = time.Now()
+
+	if key, ok = v.keys[kid]; !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+
+	return key, nil
+}
+
+func (v *jwksVerifier) fetch(ctx context.Context) (map[string]interface{}, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error while fetching jwks of %s: %w", v.provider, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error while fetching jwks of %s: status %d", v.provider, resp.StatusCode)
+	}
+
+	var set jwkSet
+	if err = json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("error while decoding jwks of %s: %w", v.provider, err)
+	}
+
+	return parseKeySet(set)
+}
+
+// parseKeySet converts the signing keys of a JWKS, keys of unsupported types are skipped
+func parseKeySet(set jwkSet) (map[string]interface{}, error) {
+	keys := make(map[string]interface{}, len(set.Keys))
+
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		switch k.Kty {
+		case "RSA":
+			n, err := decodeBigInt(k.N)
+			if err != nil {
+				return nil, err
+			}
+			e, err := decodeBigInt(k.E)
+			if err != nil {
+				return nil, err
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: n, E: int(e.Int64())}
+		case "EC":
+			if k.Crv != "P-256" {
+				continue
+			}
+			x, err := decodeBigInt(k.X)
+			if err != nil {
+				return nil, err
+			}
+			y, err := decodeBigInt(k.Y)
+			if err != nil {
+				return nil, err
+			}
+			keys[k.Kid] = &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("jwks has no usable signing keys")
+	}
+
+	return keys, nil
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("error while decoding jwk: %w", err)
+	}
+	return new(big.Int).SetBytes(raw), nil
+}
@@ -0,0 +1,86 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"users_service/configs"
+)
+
+var (
+	// ErrUnknownProvider ...
+	ErrUnknownProvider = errors.New("unknown identity provider")
+	// ErrInvalidIDToken is returned for ID tokens that fail signature or claim validation
+	ErrInvalidIDToken = errors.New("invalid id token")
+)
+
+// Identity is the verified subject of an ID token
+type Identity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+}
+
+// Verifier checks ID tokens issued by one identity provider
+type Verifier interface {
+	Verify(ctx context.Context, idToken string) (*Identity, error)
+}
+
+// Registry holds the verifiers of every configured provider by name
+type Registry struct {
+	verifiers map[string]Verifier
+}
+
+// NewRegistry builds a JWKS verifier for every provider in OIDC_PROVIDERS
+func NewRegistry(cfg *configs.Config) (*Registry, error) {
+	registry := &Registry{verifiers: map[string]Verifier{}}
+
+	for _, provider := range cfg.OidcProviders {
+		if provider.Issuer == "" || provider.ClientId == "" || provider.JWKSURL == "" {
+			return nil, fmt.Errorf("identity provider %q needs an issuer, a client id and a jwks url", provider.Name)
+		}
+
+		registry.Register(provider.Name, NewJWKSVerifier(provider.Name, provider.Issuer, provider.ClientId, provider.JWKSURL, cfg.OidcJWKSCacheTTL))
+	}
+
+	return registry, nil
+}
+
+// Register adds or replaces the verifier of a provider, tests use it to plug in a FakeProvider
+func (r *Registry) Register(provider string, verifier Verifier) {
+	r.verifiers[strings.ToLower(provider)] = verifier
+}
+
+// Verify checks idToken with the verifier of provider
+func (r *Registry) Verify(ctx context.Context, provider, idToken string) (*Identity, error) {
+	verifier, ok := r.verifiers[strings.ToLower(provider)]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+
+	return verifier.Verify(ctx, idToken)
+}
+
+// flexibleBool accepts both true and "true", some providers send email_verified as a string
+type flexibleBool bool
+
+func (b *flexibleBool) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	switch v := value.(type) {
+	case bool:
+		*b = flexibleBool(v)
+	case string:
+		*b = flexibleBool(v == "true")
+	default:
+		*b = false
+	}
+
+	return nil
+}
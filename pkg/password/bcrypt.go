@@ -0,0 +1,57 @@
+package password
+
+import (
+	"errors"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// bcryptMaxBytes is the longest password bcrypt hashes
+const bcryptMaxBytes = 72
+
+type bcryptScheme struct {
+	cost int
+}
+
+func newBcrypt(cost int) *bcryptScheme {
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		cost = bcrypt.DefaultCost
+	}
+	return &bcryptScheme{cost: cost}
+}
+
+func (b *bcryptScheme) name() string {
+	return SchemeBcrypt
+}
+
+func (b *bcryptScheme) recognises(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") ||
+		strings.HasPrefix(hash, "$2b$") ||
+		strings.HasPrefix(hash, "$2y$")
+}
+
+func (b *bcryptScheme) hash(password []byte) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword(password, b.cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func (b *bcryptScheme) verify(hash string, password []byte) (bool, bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), password)
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, err
+	}
+
+	cost, err := bcrypt.Cost([]byte(hash))
+	if err != nil {
+		return false, false, err
+	}
+
+	return true, cost != b.cost, nil
+}
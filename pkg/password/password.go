@@ -0,0 +1,124 @@
+package password
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"users_service/configs"
+)
+
+const (
+	// SchemeArgon2id ...
+	SchemeArgon2id = "argon2id"
+	// SchemeBcrypt ...
+	SchemeBcrypt = "bcrypt"
+)
+
+// Hasher hashes plaintext passwords and checks them against stored hashes.
+// Every credential-setting path goes through it so plaintext never reaches storage.
+type Hasher interface {
+	Hash(password string) (string, error)
+	// Verify reports whether password matches hash and whether hash should be
+	// replaced because it was made with an outdated scheme or parameters.
+	// An empty hash never matches.
+	Verify(hash, password string) (match bool, needsRehash bool, err error)
+	// VerifyNone spends as long as Verify for a user that has no hash, so response
+	// times do not reveal whether the user exists. It never matches.
+	VerifyNone(password string)
+}
+
+// scheme is a single hashing algorithm recognised by the prefix of its encoded hash
+type scheme interface {
+	name() string
+	recognises(hash string) bool
+	hash(password []byte) (string, error)
+	verify(hash string, password []byte) (match bool, outdated bool, err error)
+}
+
+type hasher struct {
+	current   scheme
+	schemes   []scheme
+	pepper    []byte
+	dummyHash string
+}
+
+// NewHasher hashes new passwords with the configured scheme and verifies hashes of every known scheme
+func NewHasher(cfg *configs.Config) (Hasher, error) {
+	schemes := []scheme{
+		newArgon2id(Argon2idParams{
+			Memory:      cfg.Argon2Memory,
+			Iterations:  cfg.Argon2Iterations,
+			Parallelism: cfg.Argon2Parallelism,
+			SaltLength:  16,
+			KeyLength:   32,
+		}),
+		newBcrypt(cfg.BcryptCost),
+	}
+
+	h := &hasher{
+		schemes: schemes,
+		pepper:  []byte(cfg.PasswordPepper),
+	}
+
+	for _, s := range schemes {
+		if s.name() == cfg.PasswordHashScheme {
+			h.current = s
+		}
+	}
+
+	if h.current == nil {
+		return nil, fmt.Errorf("unknown password hash scheme %q", cfg.PasswordHashScheme)
+	}
+
+	dummyHash, err := h.Hash("dummy password")
+	if err != nil {
+		return nil, err
+	}
+	h.dummyHash = dummyHash
+
+	return h, nil
+}
+
+func (h *hasher) Hash(password string) (string, error) {
+	return h.current.hash(h.peppered(h.current, password))
+}
+
+func (h *hasher) Verify(hash, password string) (bool, bool, error) {
+	// accounts created through an identity provider have no password
+	if hash == "" {
+		h.VerifyNone(password)
+		return false, false, nil
+	}
+
+	for _, s := range h.schemes {
+		if !s.recognises(hash) {
+			continue
+		}
+
+		match, outdated, err := s.verify(hash, h.peppered(s, password))
+		if err != nil || !match {
+			return false, false, err
+		}
+
+		return true, outdated || s != h.current, nil
+	}
+
+	return false, false, errors.New("unrecognised password hash format")
+}
+
+func (h *hasher) VerifyNone(password string) {
+	_, _, _ = h.Verify(h.dummyHash, password)
+}
+
+// peppered mixes the server-side pepper into the password. Bcrypt hashes predate the
+// pepper, so it only applies to argon2id.
+func (h *hasher) peppered(s scheme, password string) []byte {
+	if len(h.pepper) == 0 || s.name() != SchemeArgon2id {
+		return []byte(password)
+	}
+
+	mac := hmac.New(sha256.New, h.pepper)
+	mac.Write([]byte(password))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,148 @@
+package password
+
+import (
+	"strings"
+	"testing"
+	"users_service/configs"
+)
+
+// testConfig keeps the hashing costs low, the tests check behaviour and not strength
+func testConfig(scheme, pepper string) *configs.Config {
+	return &configs.Config{
+		PasswordHashScheme: scheme,
+		PasswordPepper:     pepper,
+		BcryptCost:         4,
+		Argon2Memory:       1024,
+		Argon2Iterations:   1,
+		Argon2Parallelism:  1,
+	}
+}
+
+func newTestHasher(t *testing.T, scheme, pepper string) Hasher {
+	t.Helper()
+
+	hasher, err := NewHasher(testConfig(scheme, pepper))
+	if err != nil {
+		t.Fatalf("NewHasher(%s): %v", scheme, err)
+	}
+	return hasher
+}
+
+func TestNewHasherUnknownScheme(t *testing.T) {
+	if _, err := NewHasher(testConfig("md5", "")); err == nil {
+		t.Fatal("NewHasher accepted an unknown scheme")
+	}
+}
+
+func TestHashAndVerify(t *testing.T) {
+	tests := []struct {
+		name       string
+		scheme     string
+		pepper     string
+		wantPrefix string
+	}{
+		{"argon2id", SchemeArgon2id, "", "$argon2id$"},
+		{"argon2id with pepper", SchemeArgon2id, "pepper", "$argon2id$"},
+		{"bcrypt", SchemeBcrypt, "", "$2a$"},
+		{"bcrypt ignores pepper", SchemeBcrypt, "pepper", "$2a$"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hasher := newTestHasher(t, tt.scheme, tt.pepper)
+
+			hash, err := hasher.Hash("correct horse")
+			if err != nil {
+				t.Fatalf("Hash: %v", err)
+			}
+			if !strings.HasPrefix(hash, tt.wantPrefix) {
+				t.Fatalf("hash %q does not start with %q", hash, tt.wantPrefix)
+			}
+
+			match, needsRehash, err := hasher.Verify(hash, "correct horse")
+			if err != nil || !match || needsRehash {
+				t.Fatalf("Verify(right password) = %v, %v, %v, want true, false, nil", match, needsRehash, err)
+			}
+
+			match, _, err = hasher.Verify(hash, "wrong horse")
+			if err != nil || match {
+				t.Fatalf("Verify(wrong password) = %v, %v, want false, nil", match, err)
+			}
+		})
+	}
+}
+
+func TestVerifyEdgeCases(t *testing.T) {
+	hasher := newTestHasher(t, SchemeArgon2id, "")
+
+	tests := []struct {
+		name    string
+		hash    string
+		wantErr bool
+	}{
+		{"empty hash never matches", "", false},
+		{"unrecognised format", "plaintext", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match, needsRehash, err := hasher.Verify(tt.hash, "password")
+			if match || needsRehash {
+				t.Fatalf("Verify() = %v, %v, want false, false", match, needsRehash)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Verify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// Hashes of another scheme, other parameters or made without the pepper still verify but ask for a rehash
+func TestNeedsRehash(t *testing.T) {
+	bcryptHash, err := newTestHasher(t, SchemeBcrypt, "").Hash("password")
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+
+	cheaper := testConfig(SchemeArgon2id, "")
+	cheaper.Argon2Memory = 512
+	cheaperHasher, err := NewHasher(cheaper)
+	if err != nil {
+		t.Fatalf("NewHasher: %v", err)
+	}
+	cheaperHash, err := cheaperHasher.Hash("password")
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+
+	argon2 := newTestHasher(t, SchemeArgon2id, "")
+
+	tests := []struct {
+		name string
+		hash string
+	}{
+		{"bcrypt hash with argon2id current", bcryptHash},
+		{"argon2id hash with older parameters", cheaperHash},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match, needsRehash, err := argon2.Verify(tt.hash, "password")
+			if err != nil || !match || !needsRehash {
+				t.Fatalf("Verify() = %v, %v, %v, want true, true, nil", match, needsRehash, err)
+			}
+		})
+	}
+}
+
+func TestPepperIsRequired(t *testing.T) {
+	hash, err := newTestHasher(t, SchemeArgon2id, "pepper").Hash("password")
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+
+	match, _, err := newTestHasher(t, SchemeArgon2id, "other pepper").Verify(hash, "password")
+	if err != nil || match {
+		t.Fatalf("Verify with another pepper = %v, %v, want false, nil", match, err)
+	}
+}
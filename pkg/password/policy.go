@@ -0,0 +1,170 @@
+package password
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+	"users_service/configs"
+)
+
+// Rules reported in policy violations
+const (
+	RuleMinLength    = "min_length"
+	RuleMaxLength    = "max_length"
+	RuleLowercase    = "lowercase"
+	RuleUppercase    = "uppercase"
+	RuleDigit        = "digit"
+	RuleSymbol       = "symbol"
+	RulePersonalInfo = "personal_info"
+	RuleBreached     = "breached"
+	RuleHistory      = "history"
+)
+
+// Violation is a single policy rule the password failed
+type Violation struct {
+	Rule        string
+	Description string
+}
+
+// Policy validates new passwords against configurable rules
+type Policy struct {
+	minLength     int
+	maxLength     int
+	maxBytes      int
+	requireLower  bool
+	requireUpper  bool
+	requireDigit  bool
+	requireSymbol bool
+	breached      map[string]struct{}
+}
+
+// NewPolicy builds the policy from config and loads the breached password list when one is configured
+func NewPolicy(cfg *configs.Config) (*Policy, error) {
+	policy := &Policy{
+		minLength:     cfg.PasswordMinLength,
+		maxLength:     cfg.PasswordMaxLength,
+		requireLower:  cfg.PasswordRequireLower,
+		requireUpper:  cfg.PasswordRequireUpper,
+		requireDigit:  cfg.PasswordRequireDigit,
+		requireSymbol: cfg.PasswordRequireSymbol,
+		breached:      map[string]struct{}{},
+	}
+
+	// an empty password is never acceptable
+	if policy.minLength < 1 {
+		policy.minLength = 1
+	}
+
+	// bcrypt refuses longer passwords, so they must not pass the policy
+	if cfg.PasswordHashScheme == SchemeBcrypt {
+		policy.maxBytes = bcryptMaxBytes
+	}
+
+	if cfg.BreachedPasswordsPath == "" {
+		return policy, nil
+	}
+
+	file, err := os.Open(cfg.BreachedPasswordsPath)
+	if err != nil {
+		return nil, fmt.Errorf("error while opening breached password list: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		policy.breached[strings.ToLower(line)] = struct{}{}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error while reading breached password list: %w", err)
+	}
+
+	return policy, nil
+}
+
+// Validate returns every rule the password violates. Personal values like the user's
+// email and full name must not be part of the password.
+func (p *Policy) Validate(password string, personal ...string) []Violation {
+	var (
+		violations = []Violation{}
+		length     = utf8.RuneCountInString(password)
+		hasLower   bool
+		hasUpper   bool
+		hasDigit   bool
+		hasSymbol  bool
+	)
+
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			hasSymbol = true
+		}
+	}
+
+	if length < p.minLength {
+		violations = append(violations, Violation{RuleMinLength, fmt.Sprintf("password must be at least %d characters long", p.minLength)})
+	}
+	if p.maxLength > 0 && length > p.maxLength {
+		violations = append(violations, Violation{RuleMaxLength, fmt.Sprintf("password must be at most %d characters long", p.maxLength)})
+	} else if p.maxBytes > 0 && len(password) > p.maxBytes {
+		violations = append(violations, Violation{RuleMaxLength, fmt.Sprintf("password must be at most %d bytes long", p.maxBytes)})
+	}
+	if p.requireLower && !hasLower {
+		violations = append(violations, Violation{RuleLowercase, "password must contain a lowercase letter"})
+	}
+	if p.requireUpper && !hasUpper {
+		violations = append(violations, Violation{RuleUppercase, "password must contain an uppercase letter"})
+	}
+	if p.requireDigit && !hasDigit {
+		violations = append(violations, Violation{RuleDigit, "password must contain a digit"})
+	}
+	if p.requireSymbol && !hasSymbol {
+		violations = append(violations, Violation{RuleSymbol, "password must contain a symbol"})
+	}
+	if containsPersonalInfo(password, personal) {
+		violations = append(violations, Violation{RulePersonalInfo, "password must not contain your email or name"})
+	}
+	if _, ok := p.breached[strings.ToLower(password)]; ok {
+		violations = append(violations, Violation{RuleBreached, "password appears in a list of breached passwords"})
+	}
+
+	return violations
+}
+
+func containsPersonalInfo(password string, personal []string) bool {
+	lowered := strings.ToLower(password)
+
+	for _, value := range personal {
+		value = strings.ToLower(strings.TrimSpace(value))
+		if value == "" {
+			continue
+		}
+
+		parts := []string{value}
+		if local, _, ok := strings.Cut(value, "@"); ok {
+			parts = append(parts, local)
+		}
+		parts = append(parts, strings.Fields(value)...)
+
+		for _, part := range parts {
+			// very short fragments like initials would reject too many passwords
+			if utf8.RuneCountInString(part) >= 3 && strings.Contains(lowered, part) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
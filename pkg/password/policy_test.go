@@ -0,0 +1,113 @@
+package password
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"users_service/configs"
+)
+
+func newTestPolicy(t *testing.T, cfg *configs.Config) *Policy {
+	t.Helper()
+
+	policy, err := NewPolicy(cfg)
+	if err != nil {
+		t.Fatalf("NewPolicy: %v", err)
+	}
+	return policy
+}
+
+func rules(violations []Violation) []string {
+	names := make([]string, 0, len(violations))
+	for _, violation := range violations {
+		names = append(names, violation.Rule)
+	}
+	return names
+}
+
+func TestPolicyValidate(t *testing.T) {
+	breached := filepath.Join(t.TempDir(), "breached.txt")
+	if err := os.WriteFile(breached, []byte("# common passwords\nPassword1!\n\n"), 0o600); err != nil {
+		t.Fatalf("writing breached list: %v", err)
+	}
+
+	policy := newTestPolicy(t, &configs.Config{
+		PasswordHashScheme:    SchemeArgon2id,
+		PasswordMinLength:     8,
+		PasswordMaxLength:     20,
+		PasswordRequireLower:  true,
+		PasswordRequireUpper:  true,
+		PasswordRequireDigit:  true,
+		PasswordRequireSymbol: true,
+		BreachedPasswordsPath: breached,
+	})
+
+	tests := []struct {
+		name      string
+		password  string
+		personal  []string
+		wantRules []string
+	}{
+		{"valid", "Tr0ub4dor&3", nil, []string{}},
+		{"too short", "Ab1!", nil, []string{RuleMinLength}},
+		{"too long", "Abcdefghijklmnopqr1!xyz", nil, []string{RuleMaxLength}},
+		{"length counts characters not bytes", "Ünïcödé-Pässwörd1ßß", nil, []string{}},
+		{"no lowercase", "TR0UB4DOR&3", nil, []string{RuleLowercase}},
+		{"no uppercase", "tr0ub4dor&3", nil, []string{RuleUppercase}},
+		{"no digit", "Troubador&x", nil, []string{RuleDigit}},
+		{"no symbol", "Tr0ub4dor33", nil, []string{RuleSymbol}},
+		{"empty", "", nil, []string{RuleMinLength, RuleLowercase, RuleUppercase, RuleDigit, RuleSymbol}},
+		{"contains email local part", "Xjohnny.b1!", []string{"johnny.b@example.com"}, []string{RulePersonalInfo}},
+		{"contains name", "Smith-2024!a", []string{"Anna Smith"}, []string{RulePersonalInfo}},
+		{"short name fragments are allowed", "Al-2024!bcd", []string{"Al Bo"}, []string{}},
+		{"breached ignoring case", "password1!", nil, []string{RuleUppercase, RuleBreached}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := rules(policy.Validate(tt.password, tt.personal...))
+			if !slices.Equal(got, tt.wantRules) {
+				t.Fatalf("Validate(%q) = %v, want %v", tt.password, got, tt.wantRules)
+			}
+		})
+	}
+}
+
+// bcrypt only hashes 72 bytes, longer passwords are rejected even below the character limit
+func TestPolicyBcryptByteLimit(t *testing.T) {
+	tests := []struct {
+		name      string
+		scheme    string
+		password  string
+		wantRules []string
+	}{
+		{"72 bytes with bcrypt", SchemeBcrypt, strings.Repeat("a", 72), []string{}},
+		{"73 bytes with bcrypt", SchemeBcrypt, strings.Repeat("a", 73), []string{RuleMaxLength}},
+		{"multibyte characters over 72 bytes with bcrypt", SchemeBcrypt, strings.Repeat("ä", 40), []string{RuleMaxLength}},
+		{"over 72 bytes with argon2id", SchemeArgon2id, strings.Repeat("a", 100), []string{}},
+		{"over the character limit with bcrypt", SchemeBcrypt, strings.Repeat("a", 129), []string{RuleMaxLength}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := newTestPolicy(t, &configs.Config{
+				PasswordHashScheme: tt.scheme,
+				PasswordMinLength:  8,
+				PasswordMaxLength:  128,
+			})
+
+			got := rules(policy.Validate(tt.password))
+			if !slices.Equal(got, tt.wantRules) {
+				t.Fatalf("Validate() = %v, want %v", got, tt.wantRules)
+			}
+		})
+	}
+}
+
+func TestNewPolicyMissingBreachedList(t *testing.T) {
+	if _, err := NewPolicy(&configs.Config{BreachedPasswordsPath: filepath.Join(t.TempDir(), "missing.txt")}); err == nil {
+		t.Fatal("NewPolicy succeeded without the breached password list")
+	}
+}
@@ -0,0 +1,247 @@
+package token
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	AlgorithmRS256 = "RS256"
+	AlgorithmEdDSA = "EdDSA"
+
+	// KeyStatePending keys are published but not used for signing yet
+	KeyStatePending = "pending"
+	// KeyStateActive is the one key new tokens are signed with
+	KeyStateActive = "active"
+	// KeyStateRetired keys only verify tokens signed before they were retired
+	KeyStateRetired = "retired"
+
+	rsaKeyBits = 2048
+)
+
+var (
+	// ErrUnknownKey is returned for tokens signed with a key id the manager does not know
+	ErrUnknownKey = errors.New("unknown signing key")
+	// ErrNoActiveKey ...
+	ErrNoActiveKey = errors.New("no active signing key")
+)
+
+// SigningKey is an asymmetric key access tokens are signed with, its id is the RFC 7638
+// thumbprint of the public key
+type SigningKey struct {
+	Id        string
+	Algorithm string
+	State     string
+	private   crypto.Signer
+	// secret is the key material the HMAC keys of the other tokens are derived from
+	secret []byte
+}
+
+// JWK is the public part of a signing key as published in the JWKS document
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKSet ...
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// GenerateSigningKey creates a new key for the RS256 or EdDSA algorithm
+func GenerateSigningKey(algorithm string) (*SigningKey, error) {
+	var (
+		private crypto.Signer
+		err     error
+	)
+
+	switch algorithm {
+	case AlgorithmRS256:
+		private, err = rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	case AlgorithmEdDSA:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported token signing algorithm %q", algorithm)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error while generating %s signing key: %w", algorithm, err)
+	}
+
+	return newSigningKey(private)
+}
+
+// ParseSigningKey reads a PEM encoded PKCS #8 or PKCS #1 private key, the algorithm follows from the key type
+func ParseSigningKey(data []byte) (*SigningKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("signing key is not PEM encoded")
+	}
+
+	if block.Type == "RSA PRIVATE KEY" {
+		private, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("error while parsing signing key: %w", err)
+		}
+		return newSigningKey(private)
+	}
+
+	private, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("error while parsing signing key: %w", err)
+	}
+
+	signer, ok := private.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("signing key can not sign")
+	}
+	return newSigningKey(signer)
+}
+
+// LoadSigningKey reads a PEM encoded private key file and checks it is a key for algorithm
+func LoadSigningKey(path, algorithm string) (*SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error while reading token signing key: %w", err)
+	}
+
+	key, err := ParseSigningKey(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if key.Algorithm != algorithm {
+		return nil, fmt.Errorf("token signing key is a %s key but %s is configured", key.Algorithm, algorithm)
+	}
+
+	return key, nil
+}
+
+// MarshalPEM encodes the private key as PKCS #8
+func (k *SigningKey) MarshalPEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: k.secret})
+}
+
+// Public ...
+func (k *SigningKey) Public() crypto.PublicKey {
+	return k.private.Public()
+}
+
+// JWK ...
+func (k *SigningKey) JWK() JWK {
+	jwk := publicJWK(k.Public())
+	jwk.Use = "sig"
+	jwk.Alg = k.Algorithm
+	jwk.Kid = k.Id
+	return jwk
+}
+
+// hmacKey derives a purpose bound key so tokens of one kind never verify as another
+func (k *SigningKey) hmacKey(purpose string) []byte {
+	mac := hmac.New(sha256.New, k.secret)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+func (k *SigningKey) method() jwt.SigningMethod {
+	if k.Algorithm == AlgorithmEdDSA {
+		return jwt.SigningMethodEdDSA
+	}
+	return jwt.SigningMethodRS256
+}
+
+func newSigningKey(private crypto.Signer) (*SigningKey, error) {
+	secret, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, fmt.Errorf("error while encoding signing key: %w", err)
+	}
+
+	key := &SigningKey{private: private, secret: secret}
+
+	switch public := private.Public().(type) {
+	case *rsa.PublicKey:
+		if public.N.BitLen() < rsaKeyBits {
+			return nil, fmt.Errorf("rsa signing key must have at least %d bits", rsaKeyBits)
+		}
+		key.Algorithm = AlgorithmRS256
+	case ed25519.PublicKey:
+		key.Algorithm = AlgorithmEdDSA
+	default:
+		return nil, fmt.Errorf("unsupported signing key type %T", public)
+	}
+
+	thumbprint, err := jwkThumbprint(publicJWK(private.Public()))
+	if err != nil {
+		return nil, err
+	}
+	key.Id = thumbprint
+
+	return key, nil
+}
+
+func publicJWK(public crypto.PublicKey) JWK {
+	switch public := public.(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			Kty: "RSA",
+			N:   base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+		}
+	case ed25519.PublicKey:
+		return JWK{
+			Kty: "OKP",
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(public),
+		}
+	}
+	return JWK{}
+}
+
+// jwkThumbprint hashes the required members of the key in lexicographic order as RFC 7638 defines
+func jwkThumbprint(jwk JWK) (string, error) {
+	var members interface{}
+
+	switch jwk.Kty {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.Kty, jwk.N}
+	case "OKP":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Crv, jwk.Kty, jwk.X}
+	default:
+		return "", fmt.Errorf("unsupported key type %q", jwk.Kty)
+	}
+
+	data, err := json.Marshal(members)
+	if err != nil {
+		return "", fmt.Errorf("error while computing key thumbprint: %w", err)
+	}
+
+	sum := sha256.Sum256(data)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,354 @@
+package token
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"sync"
+	"time"
+	"users_service/configs"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ErrTokenExpired ...
+var ErrTokenExpired = errors.New("token expired")
+
+// Claims ...
+type Claims struct {
+	UserId     string   `json:"user_id"`
+	Email      string   `json:"email,omitempty"`
+	UserRole   string   `json:"user_role,omitempty"`
+	Roles      []string `json:"roles,omitempty"`
+	SessionId  string   `json:"sid,omitempty"`
+	DeviceName string   `json:"device_name,omitempty"`
+	// Act names the user acting as UserId in an impersonation token
+	Act *Actor `json:"act,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// Actor is the act claim of RFC 8693
+type Actor struct {
+	Subject string `json:"sub"`
+}
+
+const (
+	purposeAccess       = "access"
+	purposeRefresh      = "refresh"
+	purposeMfa          = "mfa"
+	purposePasswordless = "passwordless"
+)
+
+// Manager signs and parses tokens with the keys of its key ring. Access tokens are signed
+// with the active asymmetric key so other services can verify them with the published JWKS
+// alone, the tokens only this service reads with HMAC keys derived from it. Tokens name
+// their key in the kid header and verify for as long as that key stays in the ring.
+type Manager struct {
+	mu     sync.RWMutex
+	active *SigningKey
+	keys   []*SigningKey
+
+	issuer     string
+	hashKey    []byte
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+	mfaTTL     time.Duration
+	linkTTL    time.Duration
+}
+
+// NewManager returns a manager without keys, it signs nothing until SetKeys is called.
+// It fails without a hash key, the key digests refresh and api tokens.
+func NewManager(cfg *configs.Config) (*Manager, error) {
+	if cfg.RefreshTokenHashKey == "" {
+		return nil, errors.New("refresh token hash key is empty")
+	}
+
+	return &Manager{
+		issuer:     cfg.TokenIssuer,
+		hashKey:    []byte(cfg.RefreshTokenHashKey),
+		accessTTL:  cfg.AccessTokenTTL,
+		refreshTTL: cfg.RefreshTokenTTL,
+		mfaTTL:     cfg.MfaChallengeTTL,
+		linkTTL:    cfg.PasswordlessTTL,
+	}, nil
+}
+
+// SetKeys replaces the key ring, exactly one of the keys has to be active
+func (m *Manager) SetKeys(keys []*SigningKey) error {
+	var active *SigningKey
+
+	for _, key := range keys {
+		if key.State != KeyStateActive {
+			continue
+		}
+		if active != nil {
+			return errors.New("more than one active signing key")
+		}
+		active = key
+	}
+
+	if active == nil {
+		return ErrNoActiveKey
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.active = active
+	m.keys = keys
+
+	return nil
+}
+
+// GenerateAccessToken ...
+func (m *Manager) GenerateAccessToken(userId, email, userRole, sessionId string, roles []string) (string, time.Time, error) {
+	return m.generateAccessToken(userId, email, userRole, sessionId, roles, nil, m.accessTTL)
+}
+
+// GenerateImpersonationToken returns an access token for userId that carries actorId as its actor
+func (m *Manager) GenerateImpersonationToken(userId, email, userRole, sessionId string, roles []string, actorId string, ttl time.Duration) (string, time.Time, error) {
+	return m.generateAccessToken(userId, email, userRole, sessionId, roles, &Actor{Subject: actorId}, ttl)
+}
+
+func (m *Manager) generateAccessToken(userId, email, userRole, sessionId string, roles []string, act *Actor, ttl time.Duration) (string, time.Time, error) {
+	expiresAt := time.Now().Add(ttl)
+
+	token, err := m.sign(purposeAccess, &Claims{
+		UserId:    userId,
+		Email:     email,
+		UserRole:  userRole,
+		Roles:     roles,
+		SessionId: sessionId,
+		Act:       act,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    m.issuer,
+			Subject:   userId,
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	})
+
+	return token, expiresAt, err
+}
+
+// GenerateRefreshToken ...
+func (m *Manager) GenerateRefreshToken(userId string) (string, time.Time, error) {
+	expiresAt := time.Now().Add(m.refreshTTL)
+
+	jti, err := randomId()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	token, err := m.sign(purposeRefresh, &Claims{
+		UserId: userId,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			Subject:   userId,
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	})
+
+	return token, expiresAt, err
+}
+
+// GenerateMfaToken returns a short lived token proving the password step of a login
+// that still has to be completed with a second factor
+func (m *Manager) GenerateMfaToken(userId, deviceName string) (string, error) {
+	return m.sign(purposeMfa, &Claims{
+		UserId:     userId,
+		DeviceName: deviceName,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   userId,
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(m.mfaTTL)),
+		},
+	})
+}
+
+// GenerateLoginLinkToken returns the signed token of a passwordless login link, its id
+// is the challenge that makes the link single use
+func (m *Manager) GenerateLoginLinkToken(userId, challengeId string) (string, error) {
+	return m.sign(purposePasswordless, &Claims{
+		UserId: userId,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        challengeId,
+			Subject:   userId,
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(m.linkTTL)),
+		},
+	})
+}
+
+// ParseAccessToken ...
+func (m *Manager) ParseAccessToken(token string) (*Claims, error) {
+	return m.parse(purposeAccess, token)
+}
+
+// ParseRefreshToken ...
+func (m *Manager) ParseRefreshToken(token string) (*Claims, error) {
+	return m.parse(purposeRefresh, token)
+}
+
+// ParseMfaToken ...
+func (m *Manager) ParseMfaToken(token string) (*Claims, error) {
+	return m.parse(purposeMfa, token)
+}
+
+// ParseLoginLinkToken ...
+func (m *Manager) ParseLoginLinkToken(token string) (*Claims, error) {
+	return m.parse(purposePasswordless, token)
+}
+
+// Issuer ...
+func (m *Manager) Issuer() string {
+	return m.issuer
+}
+
+// Algorithms returns the algorithms of the keys in the ring
+func (m *Manager) Algorithms() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var algorithms []string
+	for _, key := range m.keys {
+		if !slices.Contains(algorithms, key.Algorithm) {
+			algorithms = append(algorithms, key.Algorithm)
+		}
+	}
+	return algorithms
+}
+
+// JWKS returns the public keys access tokens can be verified with, pending keys are published
+// ahead of their activation so verifiers already know them once tokens are signed with them
+func (m *Manager) JWKS() JWKSet {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	set := JWKSet{Keys: []JWK{}}
+	for _, key := range m.keys {
+		set.Keys = append(set.Keys, key.JWK())
+	}
+	return set
+}
+
+// Digest returns the keyed digest under which a token is stored, so a database dump does not leak live tokens
+func (m *Manager) Digest(token string) string {
+	mac := hmac.New(sha256.New, m.hashKey)
+	mac.Write([]byte(token))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// RandomToken returns an opaque url safe token for single use links like email verification
+func RandomToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("error while generating token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// RandomCode returns a random numeric code of the given length for codes typed in by users
+func RandomCode(digits int) (string, error) {
+	max := big.NewInt(1)
+	for i := 0; i < digits; i++ {
+		max.Mul(max, big.NewInt(10))
+	}
+
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", fmt.Errorf("error while generating code: %w", err)
+	}
+	return fmt.Sprintf("%0*d", digits, n), nil
+}
+
+// sign signs claims with the active key, asymmetrically for access tokens and with
+// a purpose bound HMAC key for everything else
+func (m *Manager) sign(purpose string, claims *Claims) (string, error) {
+	m.mu.RLock()
+	key := m.active
+	m.mu.RUnlock()
+
+	if key == nil {
+		return "", ErrNoActiveKey
+	}
+
+	if purpose == purposeAccess {
+		token := jwt.NewWithClaims(key.method(), claims)
+		token.Header["kid"] = key.Id
+		return token.SignedString(key.private)
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token.Header["kid"] = key.Id
+	return token.SignedString(key.hmacKey(purpose))
+}
+
+func (m *Manager) parse(purpose, token string) (*Claims, error) {
+	keyFunc := func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+
+		key := m.key(kid)
+		if key == nil {
+			return nil, ErrUnknownKey
+		}
+
+		if purpose != purposeAccess {
+			return key.hmacKey(purpose), nil
+		}
+		if t.Method.Alg() != key.Algorithm {
+			return nil, fmt.Errorf("token is signed with %s but key %s is a %s key", t.Method.Alg(), kid, key.Algorithm)
+		}
+		return key.Public(), nil
+	}
+
+	if purpose == purposeAccess {
+		return m.parseWith(token, keyFunc, jwt.WithValidMethods([]string{AlgorithmRS256, AlgorithmEdDSA}), jwt.WithIssuer(m.issuer))
+	}
+	return m.parseWith(token, keyFunc, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+}
+
+func (m *Manager) key(kid string) *SigningKey {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, key := range m.keys {
+		if key.Id == kid {
+			return key
+		}
+	}
+	return nil
+}
+
+func (m *Manager) parseWith(token string, keyFunc jwt.Keyfunc, options ...jwt.ParserOption) (*Claims, error) {
+	claims := &Claims{}
+
+	parsed, err := jwt.ParseWithClaims(token, claims, keyFunc, options...)
+	if errors.Is(err, jwt.ErrTokenExpired) {
+		return nil, ErrTokenExpired
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !parsed.Valid {
+		return nil, errors.New("invalid token")
+	}
+
+	return claims, nil
+}
+
+func randomId() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("error while generating token id: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
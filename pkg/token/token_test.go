@@ -0,0 +1,382 @@
+package token
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+	"users_service/configs"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// mustNewManager returns NewManager(cfg) and fails the test on an error
+func mustNewManager(t *testing.T, cfg *configs.Config) *Manager {
+	t.Helper()
+
+	manager, err := NewManager(cfg)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	return manager
+}
+
+func newTestManager(t *testing.T, algorithm string) (*Manager, *SigningKey) {
+	t.Helper()
+
+	manager := mustNewManager(t, &configs.Config{
+		TokenIssuer:         "https://issuer.test",
+		RefreshTokenHashKey: "test hash key",
+		AccessTokenTTL:      time.Hour,
+		RefreshTokenTTL:     24 * time.Hour,
+		MfaChallengeTTL:     5 * time.Minute,
+		PasswordlessTTL:     10 * time.Minute,
+	})
+
+	key, err := GenerateSigningKey(algorithm)
+	if err != nil {
+		t.Fatalf("GenerateSigningKey(%s): %v", algorithm, err)
+	}
+	key.State = KeyStateActive
+
+	if err = manager.SetKeys([]*SigningKey{key}); err != nil {
+		t.Fatalf("SetKeys: %v", err)
+	}
+
+	return manager, key
+}
+
+func TestSetKeys(t *testing.T) {
+	manager, _ := newTestManager(t, AlgorithmEdDSA)
+
+	newKey := func(state string) *SigningKey {
+		key, err := GenerateSigningKey(AlgorithmEdDSA)
+		if err != nil {
+			t.Fatalf("GenerateSigningKey: %v", err)
+		}
+		key.State = state
+		return key
+	}
+
+	tests := []struct {
+		name    string
+		keys    []*SigningKey
+		wantErr bool
+	}{
+		{"one active", []*SigningKey{newKey(KeyStateActive)}, false},
+		{"active with pending and retired", []*SigningKey{newKey(KeyStatePending), newKey(KeyStateActive), newKey(KeyStateRetired)}, false},
+		{"no keys", nil, true},
+		{"no active key", []*SigningKey{newKey(KeyStatePending), newKey(KeyStateRetired)}, true},
+		{"two active keys", []*SigningKey{newKey(KeyStateActive), newKey(KeyStateActive)}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := manager.SetKeys(tt.keys); (err != nil) != tt.wantErr {
+				t.Fatalf("SetKeys() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAccessTokenRoundTrip(t *testing.T) {
+	for _, algorithm := range []string{AlgorithmRS256, AlgorithmEdDSA} {
+		t.Run(algorithm, func(t *testing.T) {
+			manager, key := newTestManager(t, algorithm)
+
+			accessToken, expiresAt, err := manager.GenerateAccessToken("user-1", "user@example.com", "user", "session-1", []string{"admin", "user"})
+			if err != nil {
+				t.Fatalf("GenerateAccessToken: %v", err)
+			}
+			if time.Until(expiresAt) <= 0 {
+				t.Fatalf("access token expires at %s, in the past", expiresAt)
+			}
+
+			claims, err := manager.ParseAccessToken(accessToken)
+			if err != nil {
+				t.Fatalf("ParseAccessToken: %v", err)
+			}
+			if claims.UserId != "user-1" || claims.Email != "user@example.com" || claims.UserRole != "user" ||
+				claims.SessionId != "session-1" || claims.Issuer != "https://issuer.test" || strings.Join(claims.Roles, " ") != "admin user" {
+				t.Fatalf("unexpected claims %+v", claims)
+			}
+			if manager.Algorithms()[0] != key.Algorithm {
+				t.Fatalf("Algorithms() = %v, want [%s]", manager.Algorithms(), key.Algorithm)
+			}
+		})
+	}
+}
+
+func TestImpersonationToken(t *testing.T) {
+	manager, _ := newTestManager(t, AlgorithmEdDSA)
+
+	accessToken, expiresAt, err := manager.GenerateImpersonationToken("user-1", "user@example.com", "user", "session-1", nil, "support-1", 10*time.Minute)
+	if err != nil {
+		t.Fatalf("GenerateImpersonationToken: %v", err)
+	}
+	if time.Until(expiresAt) > 10*time.Minute {
+		t.Fatalf("impersonation token outlives its ttl, expires at %s", expiresAt)
+	}
+
+	claims, err := manager.ParseAccessToken(accessToken)
+	if err != nil {
+		t.Fatalf("ParseAccessToken: %v", err)
+	}
+	if claims.Act == nil || claims.Act.Subject != "support-1" {
+		t.Fatalf("act = %+v, want support-1", claims.Act)
+	}
+}
+
+// The published JWKS names the key access tokens carry in their kid header, and pending
+// keys ahead of their activation
+func TestJWKS(t *testing.T) {
+	manager, active := newTestManager(t, AlgorithmEdDSA)
+
+	pending, err := GenerateSigningKey(AlgorithmRS256)
+	if err != nil {
+		t.Fatalf("GenerateSigningKey: %v", err)
+	}
+	pending.State = KeyStatePending
+	if err = manager.SetKeys([]*SigningKey{active, pending}); err != nil {
+		t.Fatalf("SetKeys: %v", err)
+	}
+
+	accessToken, _, err := manager.GenerateAccessToken("user-1", "", "user", "", nil)
+	if err != nil {
+		t.Fatalf("GenerateAccessToken: %v", err)
+	}
+	parsed, _, err := jwt.NewParser().ParseUnverified(accessToken, &Claims{})
+	if err != nil {
+		t.Fatalf("ParseUnverified: %v", err)
+	}
+	if parsed.Header["kid"] != active.Id {
+		t.Fatalf("token signed with key %v, want the active key %s", parsed.Header["kid"], active.Id)
+	}
+
+	keys := manager.JWKS().Keys
+	if len(keys) != 2 || keys[0].Kid != active.Id || keys[0].Alg != AlgorithmEdDSA || keys[1].Kid != pending.Id || keys[1].Alg != AlgorithmRS256 {
+		t.Fatalf("JWKS() = %+v, want the active and the pending key", keys)
+	}
+}
+
+func TestRefreshTokenRoundTrip(t *testing.T) {
+	manager, _ := newTestManager(t, AlgorithmEdDSA)
+
+	refreshToken, _, err := manager.GenerateRefreshToken("user-1")
+	if err != nil {
+		t.Fatalf("GenerateRefreshToken: %v", err)
+	}
+	other, _, err := manager.GenerateRefreshToken("user-1")
+	if err != nil {
+		t.Fatalf("GenerateRefreshToken: %v", err)
+	}
+	if refreshToken == other {
+		t.Fatal("two refresh tokens of the same user are equal")
+	}
+
+	claims, err := manager.ParseRefreshToken(refreshToken)
+	if err != nil {
+		t.Fatalf("ParseRefreshToken: %v", err)
+	}
+	if claims.UserId != "user-1" || claims.ID == "" {
+		t.Fatalf("unexpected claims %+v", claims)
+	}
+}
+
+// Every kind of token is signed with its own key, one never parses as another
+func TestTokenPurposes(t *testing.T) {
+	manager, _ := newTestManager(t, AlgorithmEdDSA)
+
+	accessToken, _, err := manager.GenerateAccessToken("user-1", "", "user", "session-1", nil)
+	if err != nil {
+		t.Fatalf("GenerateAccessToken: %v", err)
+	}
+	refreshToken, _, err := manager.GenerateRefreshToken("user-1")
+	if err != nil {
+		t.Fatalf("GenerateRefreshToken: %v", err)
+	}
+	mfaToken, err := manager.GenerateMfaToken("user-1", "laptop")
+	if err != nil {
+		t.Fatalf("GenerateMfaToken: %v", err)
+	}
+	linkToken, err := manager.GenerateLoginLinkToken("user-1", "challenge-1")
+	if err != nil {
+		t.Fatalf("GenerateLoginLinkToken: %v", err)
+	}
+
+	tokens := map[string]string{
+		"access":  accessToken,
+		"refresh": refreshToken,
+		"mfa":     mfaToken,
+		"link":    linkToken,
+	}
+	parsers := map[string]func(string) (*Claims, error){
+		"access":  manager.ParseAccessToken,
+		"refresh": manager.ParseRefreshToken,
+		"mfa":     manager.ParseMfaToken,
+		"link":    manager.ParseLoginLinkToken,
+	}
+
+	for tokenPurpose, token := range tokens {
+		for parserPurpose, parse := range parsers {
+			t.Run(tokenPurpose+" as "+parserPurpose, func(t *testing.T) {
+				claims, err := parse(token)
+				if tokenPurpose == parserPurpose {
+					if err != nil {
+						t.Fatalf("parse: %v", err)
+					}
+					if claims.UserId != "user-1" {
+						t.Fatalf("user id = %q, want user-1", claims.UserId)
+					}
+					return
+				}
+				if err == nil {
+					t.Fatal("token parsed for another purpose")
+				}
+			})
+		}
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	manager, key := newTestManager(t, AlgorithmRS256)
+	other, _ := newTestManager(t, AlgorithmRS256)
+
+	expired := mustNewManager(t, &configs.Config{TokenIssuer: "https://issuer.test", RefreshTokenHashKey: "test hash key", AccessTokenTTL: -time.Minute})
+	otherIssuer := mustNewManager(t, &configs.Config{TokenIssuer: "https://other.test", RefreshTokenHashKey: "test hash key", AccessTokenTTL: time.Hour})
+	for _, m := range []*Manager{expired, otherIssuer} {
+		if err := m.SetKeys([]*SigningKey{key}); err != nil {
+			t.Fatalf("SetKeys: %v", err)
+		}
+	}
+
+	expiredToken, _, err := expired.GenerateAccessToken("user-1", "", "user", "", nil)
+	if err != nil {
+		t.Fatalf("GenerateAccessToken: %v", err)
+	}
+	otherIssuerToken, _, err := otherIssuer.GenerateAccessToken("user-1", "", "user", "", nil)
+	if err != nil {
+		t.Fatalf("GenerateAccessToken: %v", err)
+	}
+	foreignToken, _, err := other.GenerateAccessToken("user-1", "", "user", "", nil)
+	if err != nil {
+		t.Fatalf("GenerateAccessToken: %v", err)
+	}
+	validToken, _, err := manager.GenerateAccessToken("user-1", "", "user", "", nil)
+	if err != nil {
+		t.Fatalf("GenerateAccessToken: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{"expired", expiredToken, ErrTokenExpired},
+		{"unknown key", foreignToken, ErrUnknownKey},
+		{"another issuer", otherIssuerToken, nil},
+		{"tampered", validToken[:len(validToken)-4] + "AAAA", nil},
+		{"garbage", "not a token", nil},
+		{"empty", "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := manager.ParseAccessToken(tt.token)
+			if err == nil {
+				t.Fatal("ParseAccessToken succeeded")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ParseAccessToken() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// A retired key keeps verifying the tokens it signed after a rotation
+func TestRetiredKeyVerifies(t *testing.T) {
+	manager, oldKey := newTestManager(t, AlgorithmEdDSA)
+
+	accessToken, _, err := manager.GenerateAccessToken("user-1", "", "user", "", nil)
+	if err != nil {
+		t.Fatalf("GenerateAccessToken: %v", err)
+	}
+	mfaToken, err := manager.GenerateMfaToken("user-1", "laptop")
+	if err != nil {
+		t.Fatalf("GenerateMfaToken: %v", err)
+	}
+
+	newKey, err := GenerateSigningKey(AlgorithmEdDSA)
+	if err != nil {
+		t.Fatalf("GenerateSigningKey: %v", err)
+	}
+	newKey.State = KeyStateActive
+	oldKey.State = KeyStateRetired
+
+	if err = manager.SetKeys([]*SigningKey{newKey, oldKey}); err != nil {
+		t.Fatalf("SetKeys: %v", err)
+	}
+	if _, err = manager.ParseAccessToken(accessToken); err != nil {
+		t.Fatalf("access token of the retired key: %v", err)
+	}
+	if _, err = manager.ParseMfaToken(mfaToken); err != nil {
+		t.Fatalf("mfa token of the retired key: %v", err)
+	}
+
+	if err = manager.SetKeys([]*SigningKey{newKey}); err != nil {
+		t.Fatalf("SetKeys: %v", err)
+	}
+	if _, err = manager.ParseAccessToken(accessToken); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("token of a removed key: error = %v, want %v", err, ErrUnknownKey)
+	}
+}
+
+// Nothing is signed before the first SetKeys
+func TestNoActiveKey(t *testing.T) {
+	manager := mustNewManager(t, &configs.Config{RefreshTokenHashKey: "test hash key", AccessTokenTTL: time.Hour})
+
+	if _, _, err := manager.GenerateAccessToken("user-1", "", "user", "", nil); !errors.Is(err, ErrNoActiveKey) {
+		t.Fatalf("GenerateAccessToken() error = %v, want %v", err, ErrNoActiveKey)
+	}
+}
+
+func TestDigest(t *testing.T) {
+	manager, _ := newTestManager(t, AlgorithmEdDSA)
+	other := mustNewManager(t, &configs.Config{RefreshTokenHashKey: "another key"})
+
+	if manager.Digest("token") != manager.Digest("token") {
+		t.Fatal("Digest is not deterministic")
+	}
+	if manager.Digest("token") == manager.Digest("token2") {
+		t.Fatal("different tokens share a digest")
+	}
+	if manager.Digest("token") == other.Digest("token") {
+		t.Fatal("Digest does not depend on the hash key")
+	}
+	if manager.Digest("token") == "token" {
+		t.Fatal("Digest returned the token")
+	}
+}
+
+func TestNewManagerRequiresHashKey(t *testing.T) {
+	if _, err := NewManager(&configs.Config{AccessTokenTTL: time.Hour}); err == nil {
+		t.Fatal("NewManager() without a hash key succeeded")
+	}
+}
+
+func TestRandomCode(t *testing.T) {
+	for _, digits := range []int{4, 6, 8} {
+		code, err := RandomCode(digits)
+		if err != nil {
+			t.Fatalf("RandomCode(%d): %v", digits, err)
+		}
+		if len(code) != digits {
+			t.Fatalf("RandomCode(%d) = %q", digits, code)
+		}
+		for _, r := range code {
+			if r < '0' || r > '9' {
+				t.Fatalf("RandomCode(%d) = %q, not numeric", digits, code)
+			}
+		}
+	}
+}
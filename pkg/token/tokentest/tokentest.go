@@ -0,0 +1,39 @@
+// Package tokentest provides a token manager for tests of the packages issuing and checking tokens
+package tokentest
+
+import (
+	"testing"
+	"time"
+	"users_service/configs"
+	"users_service/pkg/token"
+)
+
+// NewManager returns a manager whose key ring holds a single fresh EdDSA key, access
+// tokens it issues are valid for an hour
+func NewManager(t testing.TB) *token.Manager {
+	t.Helper()
+
+	manager, err := token.NewManager(&configs.Config{
+		TokenIssuer:         "https://issuer.test",
+		RefreshTokenHashKey: "test hash key",
+		AccessTokenTTL:      time.Hour,
+		RefreshTokenTTL:     24 * time.Hour,
+		MfaChallengeTTL:     5 * time.Minute,
+		PasswordlessTTL:     10 * time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+
+	key, err := token.GenerateSigningKey(token.AlgorithmEdDSA)
+	if err != nil {
+		t.Fatalf("GenerateSigningKey: %v", err)
+	}
+	key.State = token.KeyStateActive
+
+	if err = manager.SetKeys([]*token.SigningKey{key}); err != nil {
+		t.Fatalf("SetKeys: %v", err)
+	}
+
+	return manager
+}
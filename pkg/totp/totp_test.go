@@ -0,0 +1,121 @@
+package totp
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 key of the RFC 6238 test vectors, "12345678901234567890" base32 encoded
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// The RFC 6238 SHA1 test vectors, cut to the last six digits
+func TestValidateRFC6238(t *testing.T) {
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			at := time.Unix(tt.unix, 0)
+
+			step, ok := Validate(rfcSecret, tt.code, at)
+			if !ok {
+				t.Fatalf("Validate(%s) at %d failed", tt.code, tt.unix)
+			}
+			if want := tt.unix / int64(Period.Seconds()); step != want {
+				t.Fatalf("step = %d, want %d", step, want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	at := time.Unix(1111111111, 0)
+
+	tests := []struct {
+		name   string
+		secret string
+		code   string
+		at     time.Time
+		want   bool
+	}{
+		{"current step", rfcSecret, "050471", at, true},
+		{"lower case secret with padding", strings.ToLower(rfcSecret) + "====", "050471", at, true},
+		{"one step late", rfcSecret, "050471", at.Add(Period), true},
+		{"one step early", rfcSecret, "050471", at.Add(-Period), true},
+		{"two steps late", rfcSecret, "050471", at.Add(2 * Period), false},
+		{"wrong code", rfcSecret, "050472", at, false},
+		{"too short", rfcSecret, "05047", at, false},
+		{"too long", rfcSecret, "0504710", at, false},
+		{"empty code", rfcSecret, "", at, false},
+		{"invalid secret", "not base32!", "050471", at, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := Validate(tt.secret, tt.code, tt.at); ok != tt.want {
+				t.Fatalf("Validate() = %v, want %v", ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatalf("GenerateSecret: %v", err)
+	}
+
+	if _, err = encoding.DecodeString(secret); err != nil {
+		t.Fatalf("secret %q is not base32: %v", secret, err)
+	}
+
+	other, err := GenerateSecret()
+	if err != nil {
+		t.Fatalf("GenerateSecret: %v", err)
+	}
+	if secret == other {
+		t.Fatal("GenerateSecret returned the same secret twice")
+	}
+
+	key, _ := encoding.DecodeString(secret)
+	now := time.Now()
+	code := generate(key, now.Unix()/int64(Period.Seconds()))
+	if _, ok := Validate(secret, code, now); !ok {
+		t.Fatal("a code generated for a new secret does not validate")
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri, err := url.Parse(URI("users_service", "user@example.com", rfcSecret))
+	if err != nil {
+		t.Fatalf("URI is not a url: %v", err)
+	}
+
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || uri.Path != "/users_service:user@example.com" {
+		t.Fatalf("unexpected uri %s", uri)
+	}
+
+	query := uri.Query()
+	for key, want := range map[string]string{
+		"secret":    rfcSecret,
+		"issuer":    "users_service",
+		"algorithm": "SHA1",
+		"digits":    "6",
+		"period":    "30",
+	} {
+		if got := query.Get(key); got != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"users_service/pkg/token"
+)
+
+const (
+	// apiTokenPrefix marks api tokens so they are recognizable in scripts and secret scanners
+	apiTokenPrefix = "pft_"
+	// apiTokenShownLength characters of a token are kept to tell tokens apart after creation
+	apiTokenShownLength = len(apiTokenPrefix) + 8
+
+	apiTokenMaxNameLength = 100
+)
+
+// apiTokenScope is a resource and an action like transactions:read
+var apiTokenScope = regexp.MustCompile(`^[a-z][a-z_]*:[a-z][a-z_]*$`)
+
+// newApiToken returns a new api token and the prefix it is shown by
+func newApiToken() (string, string, error) {
+	random, err := token.RandomToken()
+	if err != nil {
+		return "", "", err
+	}
+
+	apiToken := apiTokenPrefix + random
+	return apiToken, apiToken[:apiTokenShownLength], nil
+}
+
+// apiTokenScopes validates scopes and returns them sorted without duplicates
+func apiTokenScopes(scopes []string) ([]string, error) {
+	if len(scopes) == 0 {
+		return nil, errors.New("at least one scope is required")
+	}
+
+	for _, scope := range scopes {
+		if !apiTokenScope.MatchString(scope) {
+			return nil, fmt.Errorf("invalid scope %q, scopes look like resource:action", scope)
+		}
+	}
+
+	scopes = slices.Clone(scopes)
+	slices.Sort(scopes)
+	return slices.Compact(scopes), nil
+}
@@ -0,0 +1,168 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	pb "users_service/genproto/users"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestApiTokenScopes(t *testing.T) {
+	tests := []struct {
+		name    string
+		scopes  []string
+		want    []string
+		wantErr bool
+	}{
+		{"sorted without duplicates", []string{"users:write", "users:read", "users:write"}, []string{"users:read", "users:write"}, false},
+		{"underscores", []string{"login_history:read"}, []string{"login_history:read"}, false},
+		{"none", nil, nil, true},
+		{"no action", []string{"users"}, nil, true},
+		{"upper case", []string{"Users:read"}, nil, true},
+		{"wildcard", []string{"users:*"}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := apiTokenScopes(tt.scopes)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("apiTokenScopes() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Fatalf("apiTokenScopes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApiTokenLifecycle(t *testing.T) {
+	var (
+		ctx  = context.Background()
+		strg = newFakeStorage(t)
+		a    = newTestAuthService(t, strg)
+	)
+
+	created, err := a.CreateApiToken(ctx, &pb.CreateApiTokenRequest{UserId: "user-1", Name: " deploy ", Scopes: []string{"users:read"}, ExpiresIn: 3600})
+	if err != nil {
+		t.Fatalf("CreateApiToken: %v", err)
+	}
+	apiToken := created.GetToken()
+	if !strings.HasPrefix(apiToken, apiTokenPrefix) || !strings.HasPrefix(apiToken, created.GetApiToken().GetPrefix()) {
+		t.Fatalf("token %q does not start with %q", apiToken, created.GetApiToken().GetPrefix())
+	}
+	if created.GetApiToken().GetName() != "deploy" {
+		t.Fatalf("name = %q, want the trimmed name", created.GetApiToken().GetName())
+	}
+	if strg.apiTokens[0].tokenHash == apiToken {
+		t.Fatal("the api token was stored in plain")
+	}
+
+	identity, err := a.ValidateApiToken(ctx, &pb.ValidateApiTokenRequest{Token: apiToken})
+	if err != nil {
+		t.Fatalf("ValidateApiToken: %v", err)
+	}
+	if identity.GetUserId() != "user-1" || len(identity.GetScopes()) != 1 || identity.GetScopes()[0] != "users:read" {
+		t.Fatalf("ValidateApiToken() = %v, want user-1 with users:read", identity)
+	}
+
+	if _, err = a.RevokeApiToken(ctx, &pb.RevokeApiTokenRequest{UserId: "user-2", TokenId: created.GetApiToken().GetId()}); status.Code(err) != codes.NotFound {
+		t.Fatalf("RevokeApiToken() of another user = %v, want %s", err, codes.NotFound)
+	}
+	if _, err = a.RevokeApiToken(ctx, &pb.RevokeApiTokenRequest{UserId: "user-1", TokenId: created.GetApiToken().GetId()}); err != nil {
+		t.Fatalf("RevokeApiToken: %v", err)
+	}
+	if _, err = a.ValidateApiToken(ctx, &pb.ValidateApiTokenRequest{Token: apiToken}); errorReason(err) != "API_TOKEN_INVALID" {
+		t.Fatalf("ValidateApiToken() of a revoked token = %v, want API_TOKEN_INVALID", err)
+	}
+
+	tokens, err := a.ListApiTokens(ctx, &pb.PrimaryKey{Id: "user-1"})
+	if err != nil {
+		t.Fatalf("ListApiTokens: %v", err)
+	}
+	if len(tokens.GetApiTokens()) != 0 {
+		t.Fatalf("ListApiTokens() = %v, want no tokens after the revoke", tokens)
+	}
+}
+
+func TestValidateApiTokenRejects(t *testing.T) {
+	var (
+		ctx  = context.Background()
+		strg = newFakeStorage(t)
+		a    = newTestAuthService(t, strg)
+	)
+
+	created, err := a.CreateApiToken(ctx, &pb.CreateApiTokenRequest{UserId: "user-1", Name: "old", Scopes: []string{"users:read"}, ExpiresIn: 60})
+	if err != nil {
+		t.Fatalf("CreateApiToken: %v", err)
+	}
+	strg.apiTokens[0].expiresAt = time.Now().Add(-time.Second)
+
+	tests := []struct {
+		name       string
+		token      string
+		wantReason string
+	}{
+		{"expired", created.GetToken(), "API_TOKEN_EXPIRED"},
+		{"unknown", apiTokenPrefix + "unknown", "API_TOKEN_INVALID"},
+		{"without prefix", "not an api token", "API_TOKEN_INVALID"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := a.ValidateApiToken(ctx, &pb.ValidateApiTokenRequest{Token: tt.token})
+			if status.Code(err) != codes.Unauthenticated || errorReason(err) != tt.wantReason {
+				t.Fatalf("ValidateApiToken() = %v, want %s", err, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestCreateApiTokenRejects(t *testing.T) {
+	a := newTestAuthService(t, newFakeStorage(t))
+
+	tests := []struct {
+		name    string
+		request *pb.CreateApiTokenRequest
+	}{
+		{"no name", &pb.CreateApiTokenRequest{UserId: "user-1", Name: "  ", Scopes: []string{"users:read"}, ExpiresIn: 3600}},
+		{"long name", &pb.CreateApiTokenRequest{UserId: "user-1", Name: strings.Repeat("a", apiTokenMaxNameLength+1), Scopes: []string{"users:read"}, ExpiresIn: 3600}},
+		{"negative expiry", &pb.CreateApiTokenRequest{UserId: "user-1", Name: "ci", Scopes: []string{"users:read"}, ExpiresIn: -1}},
+		{"no expiry", &pb.CreateApiTokenRequest{UserId: "user-1", Name: "ci", Scopes: []string{"users:read"}}},
+		{"expiry over the maximum", &pb.CreateApiTokenRequest{UserId: "user-1", Name: "ci", Scopes: []string{"users:read"}, ExpiresIn: int64(a.apiTokenMaxTTL/time.Second) + 1}},
+		{"invalid scope", &pb.CreateApiTokenRequest{UserId: "user-1", Name: "ci", Scopes: []string{"everything"}, ExpiresIn: 3600}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := a.CreateApiToken(context.Background(), tt.request); status.Code(err) != codes.InvalidArgument {
+				t.Fatalf("CreateApiToken() = %v, want %s", err, codes.InvalidArgument)
+			}
+		})
+	}
+}
+
+// A token can only carry scopes its user is granted
+func TestCreateApiTokenScopePermissions(t *testing.T) {
+	var (
+		ctx  = context.Background()
+		strg = newAdminStorage(t)
+		a    = newTestAuthService(t, strg)
+	)
+
+	_, err := a.CreateApiToken(ctx, &pb.CreateApiTokenRequest{UserId: "user-1", Name: "ci", Scopes: []string{"users:read", "users:write"}, ExpiresIn: 3600})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("CreateApiToken(users:write) = %v, want %s", err, codes.PermissionDenied)
+	}
+	if len(strg.apiTokens) != 0 {
+		t.Fatal("api token created with a scope the user is not granted")
+	}
+
+	if _, err = a.CreateApiToken(ctx, &pb.CreateApiTokenRequest{UserId: "admin-1", Name: "ci", Scopes: []string{"users:read", "users:write"}, ExpiresIn: 3600}); err != nil {
+		t.Fatalf("CreateApiToken(admin): %v", err)
+	}
+}
@@ -2,34 +2,95 @@ package service
 
 import (
 	"context"
+	"errors"
+	"strings"
+	"time"
+	"users_service/configs"
+	"users_service/pkg/errs"
+	"users_service/pkg/helper"
 	"users_service/pkg/logger"
+	"users_service/pkg/oidc"
+	"users_service/pkg/password"
+	"users_service/pkg/token"
 	"users_service/storage"
 
 	pb "users_service/genproto/users"
 
+	"github.com/jackc/pgx/v5"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
+// sessionMaxDeviceNameLength is the longest device name a session stores
+const sessionMaxDeviceNameLength = 100
+
 type authService struct {
 	storage storage.IStorage
+	tokens  *token.Manager
+	hasher  password.Hasher
+	policy  *password.Policy
+	history *passwordHistory
+	emails  *emailVerifier
+	resets  *passwordResetter
+	mfa     *mfa
+	lockout *lockout
+	links   *passwordless
+	idps    *oidc.Registry
+	keys    *keyRing
+	support *impersonation
 	log     logger.ILogger
+
+	requireVerifiedEmail bool
+	apiTokenMaxTTL       time.Duration
 	pb.UnimplementedAuthServiceServer
 }
 
-func NewAuthService(storage storage.IStorage, log logger.ILogger) *authService {
+func NewAuthService(storage storage.IStorage, tokens *token.Manager, hasher password.Hasher, policy *password.Policy, history *passwordHistory, emails *emailVerifier, resets *passwordResetter, mfa *mfa, lockout *lockout, links *passwordless, idps *oidc.Registry, keys *keyRing, support *impersonation, cfg *configs.Config, log logger.ILogger) *authService {
 	return &authService{
 		storage: storage,
+		tokens:  tokens,
+		hasher:  hasher,
+		policy:  policy,
+		history: history,
+		emails:  emails,
+		resets:  resets,
+		mfa:     mfa,
+		lockout: lockout,
+		links:   links,
+		idps:    idps,
+		keys:    keys,
+		support: support,
 		log:     log,
+
+		requireVerifiedEmail: cfg.RequireVerifiedEmail,
+		apiTokenMaxTTL:       cfg.ApiTokenMaxTTL,
 	}
 }
 
 func (a *authService) Create(ctx context.Context, request *pb.CreateUser) (*pb.User, error) {
 
+	if violations := a.policy.Validate(request.GetPassword(), request.GetEmail(), request.GetFullName()); len(violations) > 0 {
+		return &pb.User{}, passwordPolicyError("password", violations)
+	}
+
+	hashedPassword, err := a.hasher.Hash(request.GetPassword())
+	if err != nil {
+		a.log.Error("error while hashing password in service layer", logger.Error(err))
+		return &pb.User{}, err
+	}
+	request.Password = hashedPassword
+
 	resp, err := a.storage.Auth().Create(ctx, request)
 	if err != nil {
 		a.log.Error("error while creating user info in service layer", logger.Error(err))
 		return &pb.User{}, err
 	}
 
+	// the account exists either way, the user can ask for another email with SendVerificationEmail
+	if err = a.emails.send(ctx, resp.GetId(), resp.GetEmail()); err != nil {
+		a.log.Error("error while sending verification email in service layer", logger.Error(err))
+	}
+
 	return resp, nil
 }
 
@@ -41,6 +102,9 @@ func (a *authService) GetByEmail(ctx context.Context, request *pb.Email) (*pb.Us
 		return &pb.UserByEmail{}, err
 	}
 
+	// password hash never leaves the service, use Login to check credentials
+	resp.Password = ""
+
 	return resp, nil
 }
 
@@ -57,8 +121,35 @@ func (a *authService) DeleteRefreshTokenByUserId(ctx context.Context, request *p
 
 func (a *authService) StoreRefreshToken(ctx context.Context, request *pb.RefreshToken) (*pb.Void, error) {
 
-	resp, err := a.storage.Auth().StoreRefreshToken(ctx, request)
+	refreshToken := &pb.RefreshToken{
+		UserId:       request.GetUserId(),
+		RefreshToken: a.tokens.Digest(request.GetRefreshToken()),
+		ExpiresIn:    request.GetExpiresIn(),
+		FamilyId:     request.GetFamilyId(),
+	}
+
+	// a token without a family starts a new session
+	if refreshToken.FamilyId == "" {
+		ip, userAgent := helper.ClientInfo(ctx)
+		if _, err := a.storage.Sessions().Create(ctx, &pb.Session{
+			UserId:    request.GetUserId(),
+			UserAgent: userAgent,
+			IpAddress: ip,
+		}, refreshToken); err != nil {
+			if errors.Is(err, errs.ErrRefreshTokenExpiryInvalid) {
+				return &pb.Void{}, status.Error(codes.InvalidArgument, "expires_in must be a future RFC3339 time")
+			}
+			a.log.Error("error while creating session in service layer", logger.Error(err))
+			return &pb.Void{}, err
+		}
+		return &pb.Void{}, nil
+	}
+
+	resp, err := a.storage.Auth().StoreRefreshToken(ctx, refreshToken)
 	if err != nil {
+		if errors.Is(err, errs.ErrRefreshTokenExpiryInvalid) {
+			return &pb.Void{}, status.Error(codes.InvalidArgument, "expires_in must be a future RFC3339 time")
+		}
 		a.log.Error("error while storing refresh token in service layer", logger.Error(err))
 		return &pb.Void{}, err
 	}
@@ -68,8 +159,13 @@ func (a *authService) StoreRefreshToken(ctx context.Context, request *pb.Refresh
 
 func (a *authService) CheckRefreshTokenExists(ctx context.Context, request *pb.RequestRefreshToken) (*pb.Void, error) {
 
-	resp, err := a.storage.Auth().CheckRefreshTokenExists(ctx, request)
-	if err != nil {
+	resp, err := a.storage.Auth().CheckRefreshTokenExists(ctx, &pb.RequestRefreshToken{
+		RefreshToken: a.tokens.Digest(request.GetRefreshToken()),
+	})
+	switch {
+	case errors.Is(err, errs.ErrRefreshTokenNotFound), errors.Is(err, errs.ErrRefreshTokenExpired):
+		return &pb.Void{}, refreshTokenError(err)
+	case err != nil:
 		a.log.Error("error while cheking refresh token is existing in service layer", logger.Error(err))
 		return &pb.Void{}, err
 	}
@@ -88,13 +184,789 @@ func (a *authService) CheckEmailExists(ctx context.Context, request *pb.Email) (
 	return resp, nil
 }
 
-func (a *authService) ResetPassword(ctx context.Context, request *pb.ResetPassword) (*pb.Void, error) {
+// RequestPasswordReset mails a reset link. It answers the same way for unknown emails
+// so it cannot be used to find out which emails are registered.
+func (a *authService) RequestPasswordReset(ctx context.Context, request *pb.Email) (*pb.Void, error) {
+
+	user, err := a.storage.Auth().GetByEmail(ctx, request)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return &pb.Void{}, nil
+		}
+		a.log.Error("error while getting user to request password reset in service layer", logger.Error(err))
+		return &pb.Void{}, err
+	}
+
+	if err = a.resets.send(ctx, user.GetId(), user.GetEmail()); err != nil {
+		a.log.Error("error while sending password reset email in service layer", logger.Error(err))
+		return &pb.Void{}, err
+	}
+
+	return &pb.Void{}, nil
+}
+
+func (a *authService) ConfirmPasswordReset(ctx context.Context, request *pb.ConfirmPasswordResetRequest) (*pb.Void, error) {
+
+	userId, err := a.resets.userId(ctx, request.GetToken())
+	if err != nil {
+		return &pb.Void{}, a.resetTokenError(err)
+	}
+
+	user, err := a.storage.Users().GetById(ctx, &pb.PrimaryKey{Id: userId})
+	if err != nil {
+		a.log.Error("error while getting user to reset password in service layer", logger.Error(err))
+		return &pb.Void{}, err
+	}
+
+	if violations := a.policy.Validate(request.GetNewPassword(), user.GetEmail(), user.GetFullName()); len(violations) > 0 {
+		return &pb.Void{}, passwordPolicyError("new_password", violations)
+	}
+
+	violations, err := a.history.check(ctx, userId, request.GetNewPassword())
+	if err != nil {
+		a.log.Error("error while checking password history in service layer", logger.Error(err))
+		return &pb.Void{}, err
+	}
+	if len(violations) > 0 {
+		return &pb.Void{}, passwordPolicyError("new_password", violations)
+	}
+
+	hashedPassword, err := a.hasher.Hash(request.GetNewPassword())
+	if err != nil {
+		a.log.Error("error while hashing password in service layer", logger.Error(err))
+		return &pb.Void{}, err
+	}
+
+	if _, err = a.resets.consume(ctx, request.GetToken(), hashedPassword); err != nil {
+		return &pb.Void{}, a.resetTokenError(err)
+	}
+
+	return &pb.Void{}, nil
+}
+
+func (a *authService) Login(ctx context.Context, request *pb.LoginRequest) (*pb.Tokens, error) {
+
+	if err := checkDeviceName(request.GetDeviceName()); err != nil {
+		return &pb.Tokens{}, err
+	}
+
+	attempt := loginAttempt{email: request.GetEmail(), method: loginMethodPassword}
+
+	user, err := a.storage.Auth().GetByEmail(ctx, &pb.Email{Email: request.GetEmail()})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			a.hasher.VerifyNone(request.GetPassword())
+			a.recordLogin(ctx, attempt, loginFailureUnknownEmail)
+			return &pb.Tokens{}, status.Error(codes.Unauthenticated, "invalid email or password")
+		}
+		a.log.Error("error while getting user by email to login in service layer", logger.Error(err))
+		return &pb.Tokens{}, err
+	}
+	attempt.userId = user.GetId()
+
+	if err = a.checkLockout(ctx, attempt); err != nil {
+		return &pb.Tokens{}, err
+	}
+
+	match, needsRehash, err := a.hasher.Verify(user.GetPassword(), request.GetPassword())
+	if err != nil {
+		a.log.Error("error while verifying password to login in service layer", logger.Error(err))
+		return &pb.Tokens{}, err
+	}
+
+	if !match {
+		return &pb.Tokens{}, a.loginFailed(ctx, attempt, loginFailureInvalidPassword, status.Error(codes.Unauthenticated, "invalid email or password"))
+	}
+
+	if a.requireVerifiedEmail && !user.GetEmailVerified() {
+		a.recordLogin(ctx, attempt, loginFailureEmailNotVerified)
+		return &pb.Tokens{}, statusWithReason(codes.FailedPrecondition, "EMAIL_NOT_VERIFIED", "email is not verified")
+	}
+
+	if needsRehash {
+		a.rehashPassword(ctx, user.GetId(), request.GetPassword())
+	}
+
+	return a.completeLogin(ctx, attempt, &pb.User{
+		Id:         user.GetId(),
+		Email:      user.GetEmail(),
+		UserRole:   user.GetUserRole(),
+		MfaEnabled: user.GetMfaEnabled(),
+	}, request.GetDeviceName())
+}
+
+// VerifyMfa completes a login that returned an mfa token with a TOTP or recovery code
+func (a *authService) VerifyMfa(ctx context.Context, request *pb.VerifyMfaRequest) (*pb.Tokens, error) {
+
+	claims, err := a.tokens.ParseMfaToken(request.GetMfaToken())
+	if err != nil {
+		if errors.Is(err, token.ErrTokenExpired) {
+			return &pb.Tokens{}, statusWithReason(codes.Unauthenticated, "MFA_TOKEN_EXPIRED", "mfa token expired")
+		}
+		return &pb.Tokens{}, statusWithReason(codes.Unauthenticated, "MFA_TOKEN_INVALID", "invalid mfa token")
+	}
+
+	user, err := a.storage.Users().GetById(ctx, &pb.PrimaryKey{Id: claims.UserId})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return &pb.Tokens{}, statusWithReason(codes.Unauthenticated, "MFA_TOKEN_INVALID", "invalid mfa token")
+		}
+		a.log.Error("error while getting user to verify mfa in service layer", logger.Error(err))
+		return &pb.Tokens{}, err
+	}
+
+	attempt := loginAttempt{userId: user.GetId(), email: user.GetEmail(), method: loginMethodMfa}
+
+	if err = a.checkLockout(ctx, attempt); err != nil {
+		return &pb.Tokens{}, err
+	}
+
+	match, err := a.mfa.verify(ctx, user.GetId(), request.GetCode())
+	if err != nil {
+		if statusErr := mfaError(err); statusErr != nil {
+			return &pb.Tokens{}, statusErr
+		}
+		a.log.Error("error while verifying mfa code in service layer", logger.Error(err))
+		return &pb.Tokens{}, err
+	}
+
+	if !match {
+		return &pb.Tokens{}, a.loginFailed(ctx, attempt, loginFailureInvalidMfaCode, statusWithReason(codes.Unauthenticated, "MFA_CODE_INVALID", "invalid two factor code"))
+	}
+
+	return a.issueLoginTokens(ctx, attempt, user, claims.DeviceName)
+}
+
+// StartPasswordlessLogin mails a sign in link or code. It answers the same way for unknown
+// emails so it cannot be used to find out which emails are registered.
+func (a *authService) StartPasswordlessLogin(ctx context.Context, request *pb.StartPasswordlessLoginRequest) (*pb.PasswordlessChallenge, error) {
+
+	method := request.GetMethod()
+	if method == "" {
+		method = passwordlessMethodLink
+	}
+	if method != passwordlessMethodLink && method != passwordlessMethodCode {
+		return &pb.PasswordlessChallenge{}, status.Error(codes.InvalidArgument, "method must be link or code")
+	}
+
+	var userId string
+
+	user, err := a.storage.Auth().GetByEmail(ctx, &pb.Email{Email: request.GetEmail()})
+	switch {
+	case err == nil:
+		userId = user.GetId()
+	case !errors.Is(err, pgx.ErrNoRows):
+		a.log.Error("error while getting user to start passwordless login in service layer", logger.Error(err))
+		return &pb.PasswordlessChallenge{}, err
+	}
+
+	challengeId, err := a.links.start(ctx, userId, request.GetEmail(), method)
+	if errors.Is(err, errs.ErrPasswordlessRateLimited) {
+		ip, _ := helper.ClientInfo(ctx)
+		a.log.Warn("security event: passwordless login start limit reached",
+			logger.String("event", "passwordless_rate_limited"),
+			logger.String("user_id", userId),
+			logger.String("ip_address", ip),
+		)
+		// unknown emails are limited the same way, so the refusal does not tell that the email is registered
+		return &pb.PasswordlessChallenge{}, statusWithReason(codes.ResourceExhausted, "PASSWORDLESS_RATE_LIMITED", "too many sign ins started, try again later")
+	}
+	if err != nil {
+		a.log.Error("error while starting passwordless login in service layer", logger.Error(err))
+		return &pb.PasswordlessChallenge{}, err
+	}
+
+	return &pb.PasswordlessChallenge{
+		ChallengeId: challengeId,
+		ExpiresIn:   int64(a.links.ttl.Seconds()),
+	}, nil
+}
+
+// CompletePasswordlessLogin exchanges a sign in link token, or a challenge id and code, for a session.
+// Wrong codes count as failed logins of the challenge's user, like wrong passwords.
+func (a *authService) CompletePasswordlessLogin(ctx context.Context, request *pb.CompletePasswordlessLoginRequest) (*pb.Tokens, error) {
+
+	if err := checkDeviceName(request.GetDeviceName()); err != nil {
+		return &pb.Tokens{}, err
+	}
+
+	var (
+		userId     string
+		method     string
+		consumeErr error
+	)
+
+	if request.GetToken() != "" {
+		method = loginMethodLink
+		userId, consumeErr = a.links.completeLink(ctx, request.GetToken())
+	} else {
+		method = loginMethodCode
+		userId, consumeErr = a.links.completeCode(ctx, request.GetChallengeId(), request.GetCode())
+	}
+	if consumeErr != nil && userId == "" {
+		if statusErr := passwordlessError(consumeErr); statusErr != nil {
+			return &pb.Tokens{}, statusErr
+		}
+		a.log.Error("error while completing passwordless login in service layer", logger.Error(consumeErr))
+		return &pb.Tokens{}, consumeErr
+	}
+
+	user, err := a.storage.Users().GetById(ctx, &pb.PrimaryKey{Id: userId})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return &pb.Tokens{}, statusWithReason(codes.Unauthenticated, "PASSWORDLESS_INVALID", "invalid sign in link or code")
+		}
+		a.log.Error("error while getting user to complete passwordless login in service layer", logger.Error(err))
+		return &pb.Tokens{}, err
+	}
+
+	attempt := loginAttempt{userId: user.GetId(), email: user.GetEmail(), method: method}
+
+	if err = a.checkLockout(ctx, attempt); err != nil {
+		return &pb.Tokens{}, err
+	}
+
+	if consumeErr != nil {
+		return &pb.Tokens{}, a.loginFailed(ctx, attempt, loginFailureInvalidCode, passwordlessError(consumeErr))
+	}
+
+	return a.completeLogin(ctx, attempt, user, request.GetDeviceName())
+}
+
+// LoginWithProvider logs in with an identity provider's ID token. An unknown identity signs
+// up a new account when the provider verified an email no account uses yet. Existing accounts
+// are never linked implicitly, their owner has to link the provider with LinkIdentity.
+func (a *authService) LoginWithProvider(ctx context.Context, request *pb.ProviderLoginRequest) (*pb.Tokens, error) {
+
+	if err := checkDeviceName(request.GetDeviceName()); err != nil {
+		return &pb.Tokens{}, err
+	}
+
+	identity, err := a.idps.Verify(ctx, request.GetProvider(), request.GetIdToken())
+	if err != nil {
+		if statusErr := identityError(err); statusErr != nil {
+			return &pb.Tokens{}, statusErr
+		}
+		a.log.Error("error while verifying id token in service layer", logger.Error(err))
+		return &pb.Tokens{}, err
+	}
+
+	var user *pb.User
+
+	linked, err := a.storage.Identities().GetBySubject(ctx, identity.Provider, identity.Subject)
+	switch {
+	case err == nil:
+		user, err = a.storage.Users().GetById(ctx, &pb.PrimaryKey{Id: linked.GetUserId()})
+		if err != nil {
+			a.log.Error("error while getting user of identity in service layer", logger.Error(err))
+			return &pb.Tokens{}, err
+		}
+	case errors.Is(err, errs.ErrIdentityNotFound):
+		user, err = a.signUpWithProvider(ctx, identity)
+		if err != nil {
+			return &pb.Tokens{}, err
+		}
+	default:
+		a.log.Error("error while getting identity in service layer", logger.Error(err))
+		return &pb.Tokens{}, err
+	}
+
+	attempt := loginAttempt{userId: user.GetId(), email: user.GetEmail(), method: loginMethodProvider + identity.Provider}
+
+	if err = a.checkLockout(ctx, attempt); err != nil {
+		return &pb.Tokens{}, err
+	}
+
+	return a.completeLogin(ctx, attempt, user, request.GetDeviceName())
+}
+
+func (a *authService) Refresh(ctx context.Context, request *pb.RequestRefreshToken) (*pb.Tokens, error) {
+
+	claims, err := a.tokens.ParseRefreshToken(request.GetRefreshToken())
+	if err != nil {
+		return &pb.Tokens{}, refreshTokenError(err)
+	}
+
+	user, err := a.storage.Users().GetById(ctx, &pb.PrimaryKey{Id: claims.UserId})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return &pb.Tokens{}, status.Error(codes.Unauthenticated, "user not found")
+		}
+		a.log.Error("error while getting user to refresh token in service layer", logger.Error(err))
+		return &pb.Tokens{}, err
+	}
+
+	refreshToken, refreshExpiresAt, err := a.tokens.GenerateRefreshToken(user.GetId())
+	if err != nil {
+		a.log.Error("error while generating refresh token in service layer", logger.Error(err))
+		return &pb.Tokens{}, err
+	}
+
+	current, err := a.storage.Auth().RotateRefreshToken(ctx, &pb.RequestRefreshToken{
+		RefreshToken: a.tokens.Digest(request.GetRefreshToken()),
+	}, &pb.RefreshToken{
+		RefreshToken: a.tokens.Digest(refreshToken),
+		ExpiresIn:    refreshExpiresAt.Format(time.RFC3339),
+	})
+	switch {
+	case errors.Is(err, errs.ErrRefreshTokenReused):
+		a.log.Warn("security event: refresh token reuse detected, token family revoked",
+			logger.String("event", "refresh_token_reuse"),
+			logger.String("user_id", current.GetUserId()),
+			logger.String("family_id", current.GetFamilyId()),
+		)
+		return &pb.Tokens{}, refreshTokenError(err)
+	case errors.Is(err, errs.ErrRefreshTokenNotFound),
+		errors.Is(err, errs.ErrRefreshTokenRevoked),
+		errors.Is(err, errs.ErrRefreshTokenExpired):
+		return &pb.Tokens{}, refreshTokenError(err)
+	case err != nil:
+		a.log.Error("error while rotating refresh token in service layer", logger.Error(err))
+		return &pb.Tokens{}, err
+	}
+
+	accessToken, accessExpiresAt, err := a.accessToken(ctx, user, current.GetFamilyId())
+	if err != nil {
+		a.log.Error("error while generating access token in service layer", logger.Error(err))
+		return &pb.Tokens{}, err
+	}
+
+	return &pb.Tokens{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(time.Until(accessExpiresAt).Seconds()),
+	}, nil
+}
+
+func (a *authService) ListSessions(ctx context.Context, request *pb.PrimaryKey) (*pb.Sessions, error) {
+
+	resp, err := a.storage.Sessions().GetAll(ctx, request)
+	if err != nil {
+		a.log.Error("error while getting sessions in service layer", logger.Error(err))
+		return &pb.Sessions{}, err
+	}
+
+	return resp, nil
+}
+
+func (a *authService) RevokeSession(ctx context.Context, request *pb.RevokeSessionRequest) (*pb.Void, error) {
 
-	resp, err := a.storage.Auth().ResetPassword(ctx, request)
+	resp, err := a.storage.Sessions().Revoke(ctx, request)
 	if err != nil {
-		a.log.Error("error while reseting password in service layer", logger.Error(err))
+		if errors.Is(err, errs.ErrSessionNotFound) {
+			return &pb.Void{}, status.Error(codes.NotFound, "session not found")
+		}
+		a.log.Error("error while revoking session in service layer", logger.Error(err))
 		return &pb.Void{}, err
 	}
 
 	return resp, nil
 }
+
+// SendVerificationEmail mails a new verification link. It answers the same way for unknown and
+// already verified emails so it cannot be used to find out which emails are registered.
+func (a *authService) SendVerificationEmail(ctx context.Context, request *pb.Email) (*pb.Void, error) {
+
+	user, err := a.storage.Auth().GetByEmail(ctx, request)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return &pb.Void{}, nil
+		}
+		a.log.Error("error while getting user to send verification email in service layer", logger.Error(err))
+		return &pb.Void{}, err
+	}
+
+	if user.GetEmailVerified() {
+		return &pb.Void{}, nil
+	}
+
+	if err = a.emails.send(ctx, user.GetId(), user.GetEmail()); err != nil {
+		a.log.Error("error while sending verification email in service layer", logger.Error(err))
+		return &pb.Void{}, err
+	}
+
+	return &pb.Void{}, nil
+}
+
+func (a *authService) VerifyEmail(ctx context.Context, request *pb.VerifyEmailRequest) (*pb.Void, error) {
+
+	if _, err := a.emails.verify(ctx, request.GetToken()); err != nil {
+		switch {
+		case errors.Is(err, errs.ErrVerificationTokenExpired):
+			return &pb.Void{}, statusWithReason(codes.InvalidArgument, "VERIFICATION_TOKEN_EXPIRED", "verification token expired")
+		case errors.Is(err, errs.ErrVerificationTokenNotFound):
+			return &pb.Void{}, statusWithReason(codes.InvalidArgument, "VERIFICATION_TOKEN_INVALID", "invalid verification token")
+		}
+		a.log.Error("error while verifying email in service layer", logger.Error(err))
+		return &pb.Void{}, err
+	}
+
+	return &pb.Void{}, nil
+}
+
+// resetTokenError maps an invalid or expired reset token to a status, other errors are logged and returned as is
+func (a *authService) resetTokenError(err error) error {
+	switch {
+	case errors.Is(err, errs.ErrResetTokenExpired):
+		return statusWithReason(codes.InvalidArgument, "RESET_TOKEN_EXPIRED", "password reset token expired")
+	case errors.Is(err, errs.ErrResetTokenNotFound):
+		return statusWithReason(codes.InvalidArgument, "RESET_TOKEN_INVALID", "invalid password reset token")
+	}
+	a.log.Error("error while resetting password in service layer", logger.Error(err))
+	return err
+}
+
+// signUpWithProvider creates a passwordless account for an identity not linked to anyone yet
+func (a *authService) signUpWithProvider(ctx context.Context, identity *oidc.Identity) (*pb.User, error) {
+
+	if identity.Email == "" || !identity.EmailVerified {
+		return nil, statusWithReason(codes.FailedPrecondition, "IDENTITY_NOT_LINKED", "identity is not linked and the provider did not verify an email to sign up with")
+	}
+
+	_, err := a.storage.Auth().GetByEmail(ctx, &pb.Email{Email: identity.Email})
+	switch {
+	case err == nil:
+		return nil, identityError(errs.ErrEmailTaken)
+	case !errors.Is(err, pgx.ErrNoRows):
+		a.log.Error("error while checking email to sign up with provider in service layer", logger.Error(err))
+		return nil, err
+	}
+
+	user, err := a.storage.Identities().CreateWithUser(ctx, &pb.CreateUser{Email: identity.Email}, &pb.Identity{
+		Provider: identity.Provider,
+		Subject:  identity.Subject,
+		Email:    identity.Email,
+	}, identity.EmailVerified)
+	if err != nil {
+		if statusErr := identityError(err); statusErr != nil {
+			return nil, statusErr
+		}
+		a.log.Error("error while signing up with provider in service layer", logger.Error(err))
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// completeLogin finishes a login whose first factor succeeded. Users with mfa get an mfa
+// token to redeem with VerifyMfa, everyone else gets a new session.
+func (a *authService) completeLogin(ctx context.Context, attempt loginAttempt, user *pb.User, deviceName string) (*pb.Tokens, error) {
+
+	if user.GetMfaEnabled() {
+		mfaToken, err := a.tokens.GenerateMfaToken(user.GetId(), deviceName)
+		if err != nil {
+			a.log.Error("error while generating mfa token in service layer", logger.Error(err))
+			return &pb.Tokens{}, err
+		}
+
+		return &pb.Tokens{
+			MfaRequired: true,
+			MfaToken:    mfaToken,
+		}, nil
+	}
+
+	return a.issueLoginTokens(ctx, attempt, user, deviceName)
+}
+
+// issueLoginTokens opens the session of a successful login. The login only counts as
+// succeeded once the session exists, otherwise it is recorded as failed.
+func (a *authService) issueLoginTokens(ctx context.Context, attempt loginAttempt, user *pb.User, deviceName string) (*pb.Tokens, error) {
+
+	tokens, err := a.issueTokens(ctx, user, deviceName)
+	if err != nil {
+		a.recordLogin(ctx, attempt, loginFailureInternalError)
+		return &pb.Tokens{}, err
+	}
+
+	a.loginSucceeded(ctx, attempt)
+
+	return tokens, nil
+}
+
+// checkLockout returns an account locked status while the user is locked out
+func (a *authService) checkLockout(ctx context.Context, attempt loginAttempt) error {
+
+	lockedUntil, err := a.lockout.lockedUntil(ctx, attempt.userId)
+	if err != nil {
+		a.log.Error("error while checking account lockout in service layer", logger.Error(err))
+		return err
+	}
+
+	if !lockedUntil.IsZero() {
+		a.recordLogin(ctx, attempt, loginFailureAccountLocked)
+		return accountLockedError(lockedUntil)
+	}
+
+	return nil
+}
+
+// loginFailed records a failed login attempt and returns failure, or an account locked
+// status when the attempt locked the user out
+func (a *authService) loginFailed(ctx context.Context, attempt loginAttempt, reason string, failure error) error {
+
+	a.recordLogin(ctx, attempt, reason)
+
+	lockedUntil, err := a.lockout.fail(ctx, attempt.userId)
+	if err != nil {
+		a.log.Error("error while recording failed login in service layer", logger.Error(err))
+		return failure
+	}
+
+	if !lockedUntil.IsZero() {
+		a.log.Warn("security event: account locked after failed logins",
+			logger.String("event", "account_locked"),
+			logger.String("user_id", attempt.userId),
+		)
+		return accountLockedError(lockedUntil)
+	}
+
+	return failure
+}
+
+// loginSucceeded records the login and clears the user's failed attempts, failing to
+// do so must not fail the login
+func (a *authService) loginSucceeded(ctx context.Context, attempt loginAttempt) {
+
+	a.recordLogin(ctx, attempt, "")
+
+	if err := a.lockout.clear(ctx, attempt.userId); err != nil {
+		a.log.Error("error while clearing failed logins in service layer", logger.Error(err))
+	}
+}
+
+// rehashPassword upgrades the stored hash to the current scheme and parameters.
+// Failing to do so must not fail the login, it is retried on the next one.
+func (a *authService) rehashPassword(ctx context.Context, userId, password string) {
+
+	hashedPassword, err := a.hasher.Hash(password)
+	if err != nil {
+		a.log.Error("error while rehashing password in service layer", logger.Error(err))
+		return
+	}
+
+	if _, err = a.storage.Users().UpdatePasswordHash(ctx, &pb.ChangePassword{
+		UserId:      userId,
+		NewPassword: hashedPassword,
+	}); err != nil {
+		a.log.Error("error while storing rehashed password in service layer", logger.Error(err))
+	}
+}
+
+// accessToken signs an access token carrying every role the user holds
+func (a *authService) accessToken(ctx context.Context, user *pb.User, sessionId string) (string, time.Time, error) {
+	roles, err := a.storage.Roles().GetUserRoles(ctx, &pb.PrimaryKey{Id: user.GetId()})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return a.tokens.GenerateAccessToken(user.GetId(), user.GetEmail(), user.GetUserRole(), sessionId, roles.GetRoles())
+}
+
+// checkDeviceName rejects device names longer than a session stores, before any login work is done
+func checkDeviceName(deviceName string) error {
+	if len(deviceName) > sessionMaxDeviceNameLength {
+		return status.Errorf(codes.InvalidArgument, "device_name must be at most %d characters", sessionMaxDeviceNameLength)
+	}
+	return nil
+}
+
+// issueTokens opens a new session for the user and returns its first access and refresh tokens
+func (a *authService) issueTokens(ctx context.Context, user *pb.User, deviceName string) (*pb.Tokens, error) {
+
+	refreshToken, refreshExpiresAt, err := a.tokens.GenerateRefreshToken(user.GetId())
+	if err != nil {
+		a.log.Error("error while generating refresh token in service layer", logger.Error(err))
+		return &pb.Tokens{}, err
+	}
+
+	ip, userAgent := helper.ClientInfo(ctx)
+
+	session, err := a.storage.Sessions().Create(ctx, &pb.Session{
+		UserId:     user.GetId(),
+		DeviceName: deviceName,
+		UserAgent:  userAgent,
+		IpAddress:  ip,
+	}, &pb.RefreshToken{
+		RefreshToken: a.tokens.Digest(refreshToken),
+		ExpiresIn:    refreshExpiresAt.Format(time.RFC3339),
+	})
+	if err != nil {
+		a.log.Error("error while creating session in service layer", logger.Error(err))
+		return &pb.Tokens{}, err
+	}
+
+	accessToken, accessExpiresAt, err := a.accessToken(ctx, user, session.GetId())
+	if err != nil {
+		a.log.Error("error while generating access token in service layer", logger.Error(err))
+		return &pb.Tokens{}, err
+	}
+
+	return &pb.Tokens{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(time.Until(accessExpiresAt).Seconds()),
+	}, nil
+}
+
+// ListSigningKeys returns the pending, active and retired keys tokens are verified with
+func (a *authService) ListSigningKeys(ctx context.Context, request *pb.Void) (*pb.SigningKeys, error) {
+
+	resp, _, err := a.storage.SigningKeys().GetAll(ctx)
+	if err != nil {
+		a.log.Error("error while getting signing keys in service layer", logger.Error(err))
+		return &pb.SigningKeys{}, err
+	}
+
+	return resp, nil
+}
+
+// RotateSigningKeys activates the pending key. The active key is retired but keeps verifying
+// the tokens it signed until they expire, and a new pending key is published for the next rotation.
+func (a *authService) RotateSigningKeys(ctx context.Context, request *pb.Void) (*pb.SigningKeys, error) {
+
+	resp, err := a.keys.rotate(ctx)
+	if err != nil {
+		if errors.Is(err, errs.ErrNoPendingSigningKey) {
+			return &pb.SigningKeys{}, status.Error(codes.FailedPrecondition, "there is no pending signing key to rotate to")
+		}
+		a.log.Error("error while rotating signing keys in service layer", logger.Error(err))
+		return &pb.SigningKeys{}, err
+	}
+
+	a.log.Info("rotated signing keys")
+
+	return resp, nil
+}
+
+// CreateApiToken creates a scoped token for scripts, the token is only ever returned here.
+// Its scopes are limited to the permissions of the user it acts for.
+func (a *authService) CreateApiToken(ctx context.Context, request *pb.CreateApiTokenRequest) (*pb.CreatedApiToken, error) {
+
+	name := strings.TrimSpace(request.GetName())
+	if name == "" || len(name) > apiTokenMaxNameLength {
+		return &pb.CreatedApiToken{}, status.Errorf(codes.InvalidArgument, "name must be 1 to %d characters", apiTokenMaxNameLength)
+	}
+
+	maxExpiresIn := int64(a.apiTokenMaxTTL / time.Second)
+	if request.GetExpiresIn() <= 0 || request.GetExpiresIn() > maxExpiresIn {
+		return &pb.CreatedApiToken{}, status.Errorf(codes.InvalidArgument, "expires_in must be 1 to %d seconds", maxExpiresIn)
+	}
+
+	scopes, err := apiTokenScopes(request.GetScopes())
+	if err != nil {
+		return &pb.CreatedApiToken{}, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	for _, scope := range scopes {
+		allowed, err := a.storage.Roles().HasPermission(ctx, &pb.HasPermissionRequest{UserId: request.GetUserId(), Permission: scope})
+		if err != nil {
+			a.log.Error("error while checking api token scope in service layer", logger.Error(err))
+			return &pb.CreatedApiToken{}, err
+		}
+		if !allowed {
+			return &pb.CreatedApiToken{}, status.Errorf(codes.PermissionDenied, "scope %s is not granted to the user", scope)
+		}
+	}
+
+	apiToken, prefix, err := newApiToken()
+	if err != nil {
+		a.log.Error("error while generating api token in service layer", logger.Error(err))
+		return &pb.CreatedApiToken{}, err
+	}
+
+	resp, err := a.storage.ApiTokens().Create(ctx, &pb.CreateApiTokenRequest{
+		UserId: request.GetUserId(),
+		Name:   name,
+		Scopes: scopes,
+	}, prefix, a.tokens.Digest(apiToken), time.Now().Add(time.Duration(request.GetExpiresIn())*time.Second))
+	if err != nil {
+		a.log.Error("error while creating api token in service layer", logger.Error(err))
+		return &pb.CreatedApiToken{}, err
+	}
+
+	return &pb.CreatedApiToken{
+		Token:    apiToken,
+		ApiToken: resp,
+	}, nil
+}
+
+func (a *authService) ListApiTokens(ctx context.Context, request *pb.PrimaryKey) (*pb.ApiTokens, error) {
+
+	resp, err := a.storage.ApiTokens().GetAll(ctx, request)
+	if err != nil {
+		a.log.Error("error while getting api tokens in service layer", logger.Error(err))
+		return &pb.ApiTokens{}, err
+	}
+
+	return resp, nil
+}
+
+func (a *authService) RevokeApiToken(ctx context.Context, request *pb.RevokeApiTokenRequest) (*pb.Void, error) {
+
+	resp, err := a.storage.ApiTokens().Revoke(ctx, request)
+	if err != nil {
+		if errors.Is(err, errs.ErrApiTokenNotFound) {
+			return &pb.Void{}, status.Error(codes.NotFound, "api token not found")
+		}
+		a.log.Error("error while revoking api token in service layer", logger.Error(err))
+		return &pb.Void{}, err
+	}
+
+	return resp, nil
+}
+
+// ValidateApiToken resolves an api token to its user and scopes for other services
+func (a *authService) ValidateApiToken(ctx context.Context, request *pb.ValidateApiTokenRequest) (*pb.ApiTokenIdentity, error) {
+
+	if !strings.HasPrefix(request.GetToken(), apiTokenPrefix) {
+		return &pb.ApiTokenIdentity{}, statusWithReason(codes.Unauthenticated, "API_TOKEN_INVALID", "invalid api token")
+	}
+
+	resp, err := a.storage.ApiTokens().Validate(ctx, a.tokens.Digest(request.GetToken()))
+	switch {
+	case errors.Is(err, errs.ErrApiTokenExpired):
+		return &pb.ApiTokenIdentity{}, statusWithReason(codes.Unauthenticated, "API_TOKEN_EXPIRED", "api token expired")
+	case errors.Is(err, errs.ErrApiTokenNotFound):
+		return &pb.ApiTokenIdentity{}, statusWithReason(codes.Unauthenticated, "API_TOKEN_INVALID", "invalid api token")
+	case err != nil:
+		a.log.Error("error while validating api token in service layer", logger.Error(err))
+		return &pb.ApiTokenIdentity{}, err
+	}
+
+	return resp, nil
+}
+
+// Impersonate gives the calling support user a short lived access token of the target user. The
+// session it opens shows up in the target's session list and every call made with it is logged.
+func (a *authService) Impersonate(ctx context.Context, request *pb.ImpersonateRequest) (*pb.Tokens, error) {
+
+	ttl, err := a.support.duration(request.GetDuration())
+	if err != nil {
+		return &pb.Tokens{}, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	session, accessToken, expiresAt, err := a.support.start(ctx, request, ttl)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return &pb.Tokens{}, status.Error(codes.NotFound, "user not found")
+		}
+		if statusErr := impersonationError(err); statusErr != nil {
+			return &pb.Tokens{}, statusErr
+		}
+		a.log.Error("error while impersonating user in service layer", logger.Error(err))
+		return &pb.Tokens{}, err
+	}
+
+	a.log.Info("impersonation started",
+		logger.String("impersonator_id", session.GetImpersonatorId()),
+		logger.String("user_id", session.GetUserId()),
+		logger.String("session_id", session.GetId()),
+		logger.String("reason", session.GetImpersonationReason()),
+		logger.String("expires_at", expiresAt.Format(time.RFC3339)),
+	)
+
+	return &pb.Tokens{
+		AccessToken: accessToken,
+		ExpiresIn:   int64(time.Until(expiresAt).Seconds()),
+	}, nil
+}
@@ -0,0 +1,382 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+	"users_service/pkg/mailer"
+	"users_service/pkg/password"
+	"users_service/pkg/token/tokentest"
+
+	pb "users_service/genproto/users"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func newTestAuthService(t *testing.T, strg *fakeStorage) *authService {
+	var (
+		cfg    = newTestConfig()
+		hasher = newTestHasher(t, password.SchemeArgon2id)
+		tokens = tokentest.NewManager(t)
+		mail   = mailer.NewMemoryMailer()
+	)
+
+	return &authService{
+		storage: strg,
+		tokens:  tokens,
+		hasher:  hasher,
+		policy:  newTestPolicy(t),
+		history: newPasswordHistory(strg, hasher, cfg),
+		emails:  newEmailVerifier(strg, tokens, mail, cfg),
+		resets:  newPasswordResetter(strg, tokens, mail, cfg),
+		mfa:     newTestMfa(t, strg, tokens),
+		lockout: newLockout(strg, cfg),
+		links:   newPasswordless(strg, tokens, mail, cfg),
+		support: newImpersonation(strg, tokens, cfg),
+		log:     newTestLogger(t),
+
+		apiTokenMaxTTL: cfg.ApiTokenMaxTTL,
+	}
+}
+
+// errorReason returns the ErrorInfo reason of a status error, empty when it has none
+func errorReason(err error) string {
+	for _, detail := range status.Convert(err).Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok {
+			return info.GetReason()
+		}
+	}
+	return ""
+}
+
+// Passwords are hashed before they reach storage
+func TestCreateHashesPassword(t *testing.T) {
+	strg := newFakeStorage(t)
+	a := newTestAuthService(t, strg)
+
+	user, err := a.Create(context.Background(), &pb.CreateUser{Email: "bob@example.com", Password: "battery staple", FullName: "Bob"})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	stored := strg.passwords[user.GetId()]
+	if stored == "battery staple" {
+		t.Fatal("the password was stored in plain")
+	}
+	if match, _, err := a.hasher.Verify(stored, "battery staple"); err != nil || !match {
+		t.Fatalf("Verify(stored hash) = %v, %v, want true", match, err)
+	}
+
+	if _, err = a.Login(context.Background(), &pb.LoginRequest{Email: "bob@example.com", Password: "battery staple"}); err != nil {
+		t.Fatalf("Login of the new user: %v", err)
+	}
+}
+
+func TestCreatePasswordPolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		wantCode codes.Code
+	}{
+		{"acceptable", "battery staple", codes.OK},
+		{"too short", "battery", codes.InvalidArgument},
+		{"contains the name", "little tables", codes.InvalidArgument},
+		{"contains the email local part", "bob-staple", codes.InvalidArgument},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			strg := newFakeStorage(t)
+			a := newTestAuthService(t, strg)
+
+			_, err := a.Create(context.Background(), &pb.CreateUser{Email: "bob@example.com", Password: tt.password, FullName: "Bobby Tables"})
+			if code := status.Code(err); code != tt.wantCode {
+				t.Fatalf("Create() = %v, want %s", err, tt.wantCode)
+			}
+			if tt.wantCode == codes.OK {
+				return
+			}
+
+			if reason := errorReason(err); reason != "PASSWORD_POLICY_VIOLATION" {
+				t.Fatalf("reason = %q, want PASSWORD_POLICY_VIOLATION", reason)
+			}
+			if len(strg.users) != 1 {
+				t.Fatal("a user with a rejected password was created")
+			}
+		})
+	}
+}
+
+func TestLogin(t *testing.T) {
+	tests := []struct {
+		name     string
+		email    string
+		password string
+		wantCode codes.Code
+	}{
+		{"right password", "anna@example.com", testPassword, codes.OK},
+		{"wrong password", "anna@example.com", "wrong horse", codes.Unauthenticated},
+		{"unknown email", "bob@example.com", testPassword, codes.Unauthenticated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			strg := newFakeStorage(t)
+			a := newTestAuthService(t, strg)
+
+			resp, err := a.Login(context.Background(), &pb.LoginRequest{Email: tt.email, Password: tt.password, DeviceName: "laptop"})
+			if code := status.Code(err); code != tt.wantCode {
+				t.Fatalf("Login() = %v, want %s", err, tt.wantCode)
+			}
+			if tt.wantCode != codes.OK {
+				return
+			}
+
+			claims, err := a.tokens.ParseAccessToken(resp.GetAccessToken())
+			if err != nil || claims.UserId != "user-1" {
+				t.Fatalf("ParseAccessToken() = %+v, %v, want user-1", claims, err)
+			}
+			if len(strg.sessions) != 1 || strg.sessions[0].session.DeviceName != "laptop" || claims.SessionId != strg.sessions[0].session.Id {
+				t.Fatalf("access token of session %q, want the one session of the laptop", claims.SessionId)
+			}
+			if _, ok := strg.refreshTokens[a.tokens.Digest(resp.GetRefreshToken())]; !ok {
+				t.Fatal("the digest of the refresh token was not stored")
+			}
+			if _, ok := strg.refreshTokens[resp.GetRefreshToken()]; ok {
+				t.Fatal("the refresh token was stored in plain")
+			}
+		})
+	}
+}
+
+// A bcrypt hash is replaced by an argon2id hash on the first login with the right password
+// Device names longer than the sessions table stores are refused before the password is checked
+func TestLoginDeviceNameLength(t *testing.T) {
+	var (
+		ctx  = context.Background()
+		strg = newFakeStorage(t)
+		a    = newTestAuthService(t, strg)
+	)
+
+	login := &pb.LoginRequest{Email: "anna@example.com", Password: "wrong horse", DeviceName: strings.Repeat("a", sessionMaxDeviceNameLength+1)}
+	if _, err := a.Login(ctx, login); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("Login() = %v, want %s", err, codes.InvalidArgument)
+	}
+	if strg.failedLogins["user-1"] != 0 || len(strg.sessions) != 0 {
+		t.Fatal("a refused device name counted as a login attempt")
+	}
+
+	if _, err := a.CompletePasswordlessLogin(ctx, &pb.CompletePasswordlessLoginRequest{Token: "token", DeviceName: login.DeviceName}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("CompletePasswordlessLogin() = %v, want %s", err, codes.InvalidArgument)
+	}
+
+	login.Password = testPassword
+	login.DeviceName = strings.Repeat("a", sessionMaxDeviceNameLength)
+	if _, err := a.Login(ctx, login); err != nil {
+		t.Fatalf("Login() with the longest device name: %v", err)
+	}
+}
+
+func TestLoginUpgradesBcryptHash(t *testing.T) {
+	bcryptHash, err := newTestHasher(t, password.SchemeBcrypt).Hash(testPassword)
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		password    string
+		wantUpgrade bool
+	}{
+		{"right password", testPassword, true},
+		{"wrong password", "wrong horse", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			strg := newFakeStorage(t)
+			strg.passwords["user-1"] = bcryptHash
+			a := newTestAuthService(t, strg)
+
+			_, _ = a.Login(context.Background(), &pb.LoginRequest{Email: "anna@example.com", Password: tt.password})
+
+			stored := strg.passwords["user-1"]
+			if upgraded := strings.HasPrefix(stored, "$argon2id$"); upgraded != tt.wantUpgrade {
+				t.Fatalf("stored hash %q upgraded = %v, want %v", stored, upgraded, tt.wantUpgrade)
+			}
+			if match, needsRehash, err := a.hasher.Verify(stored, testPassword); err != nil || !match || needsRehash != !tt.wantUpgrade {
+				t.Fatalf("Verify(stored hash) = %v, %v, %v", match, needsRehash, err)
+			}
+		})
+	}
+}
+
+// Every refresh consumes the presented token, presenting it again revokes the whole family
+func TestRefreshRotation(t *testing.T) {
+	var (
+		ctx  = context.Background()
+		strg = newFakeStorage(t)
+		a    = newTestAuthService(t, strg)
+	)
+
+	login, err := a.Login(ctx, &pb.LoginRequest{Email: "anna@example.com", Password: testPassword})
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+
+	rotated, err := a.Refresh(ctx, &pb.RequestRefreshToken{RefreshToken: login.GetRefreshToken()})
+	if err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if rotated.GetRefreshToken() == login.GetRefreshToken() {
+		t.Fatal("Refresh returned the presented refresh token")
+	}
+	claims, err := a.tokens.ParseAccessToken(rotated.GetAccessToken())
+	if err != nil {
+		t.Fatalf("ParseAccessToken: %v", err)
+	}
+	if claims.SessionId != strg.sessions[0].session.Id {
+		t.Fatalf("rotated access token of session %q, want %q", claims.SessionId, strg.sessions[0].session.Id)
+	}
+
+	_, err = a.Refresh(ctx, &pb.RequestRefreshToken{RefreshToken: login.GetRefreshToken()})
+	if code, reason := status.Code(err), errorReason(err); code != codes.Unauthenticated || reason != "REFRESH_TOKEN_REUSED" {
+		t.Fatalf("Refresh(reused token) = %v, want %s REFRESH_TOKEN_REUSED", err, codes.Unauthenticated)
+	}
+
+	_, err = a.Refresh(ctx, &pb.RequestRefreshToken{RefreshToken: rotated.GetRefreshToken()})
+	if code, reason := status.Code(err), errorReason(err); code != codes.Unauthenticated || reason != "REFRESH_TOKEN_INVALID" {
+		t.Fatalf("Refresh(token of a revoked family) = %v, want %s REFRESH_TOKEN_INVALID", err, codes.Unauthenticated)
+	}
+
+	for refreshToken, stored := range strg.refreshTokens {
+		if !stored.revoked {
+			t.Fatalf("refresh token %s of the reused family is not revoked", refreshToken)
+		}
+	}
+}
+
+func TestRefreshRejects(t *testing.T) {
+	var (
+		strg = newFakeStorage(t)
+		a    = newTestAuthService(t, strg)
+	)
+
+	unknown, _, err := a.tokens.GenerateRefreshToken("user-1")
+	if err != nil {
+		t.Fatalf("GenerateRefreshToken: %v", err)
+	}
+	deleted, _, err := a.tokens.GenerateRefreshToken("user-9")
+	if err != nil {
+		t.Fatalf("GenerateRefreshToken: %v", err)
+	}
+	access, _, err := a.tokens.GenerateAccessToken("user-1", "", "user", "", nil)
+	if err != nil {
+		t.Fatalf("GenerateAccessToken: %v", err)
+	}
+
+	// the jwt is still valid but the stored token ran out, the database has the last word
+	expired, _, err := a.tokens.GenerateRefreshToken("user-1")
+	if err != nil {
+		t.Fatalf("GenerateRefreshToken: %v", err)
+	}
+	strg.refreshTokens[a.tokens.Digest(expired)] = &fakeRefreshToken{
+		userId:    "user-1",
+		familyId:  "family-9",
+		expiresAt: time.Now().Add(-time.Minute),
+	}
+
+	tests := []struct {
+		name         string
+		refreshToken string
+		wantReason   string
+	}{
+		{"garbage", "not a token", "REFRESH_TOKEN_INVALID"},
+		{"access token", access, "REFRESH_TOKEN_INVALID"},
+		{"not stored", unknown, "REFRESH_TOKEN_INVALID"},
+		{"expired in storage", expired, "REFRESH_TOKEN_EXPIRED"},
+		{"deleted user", deleted, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := a.Refresh(context.Background(), &pb.RequestRefreshToken{RefreshToken: tt.refreshToken})
+			if code := status.Code(err); code != codes.Unauthenticated {
+				t.Fatalf("Refresh() = %v, want %s", err, codes.Unauthenticated)
+			}
+			if reason := errorReason(err); reason != tt.wantReason {
+				t.Fatalf("reason = %q, want %q", reason, tt.wantReason)
+			}
+		})
+	}
+}
+
+// Revoking a session ends its refresh token family, other sessions keep working
+func TestRevokeSession(t *testing.T) {
+	var (
+		ctx  = context.Background()
+		strg = newFakeStorage(t)
+		a    = newTestAuthService(t, strg)
+	)
+
+	laptop, err := a.Login(ctx, &pb.LoginRequest{Email: "anna@example.com", Password: testPassword, DeviceName: "laptop"})
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	phone, err := a.Login(ctx, &pb.LoginRequest{Email: "anna@example.com", Password: testPassword, DeviceName: "phone"})
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	laptopSession := strg.sessions[0].session.Id
+
+	_, err = a.RevokeSession(ctx, &pb.RevokeSessionRequest{UserId: "user-2", SessionId: laptopSession})
+	if code := status.Code(err); code != codes.NotFound {
+		t.Fatalf("RevokeSession(session of another user) = %v, want %s", err, codes.NotFound)
+	}
+
+	if _, err = a.RevokeSession(ctx, &pb.RevokeSessionRequest{UserId: "user-1", SessionId: laptopSession}); err != nil {
+		t.Fatalf("RevokeSession: %v", err)
+	}
+
+	_, err = a.RevokeSession(ctx, &pb.RevokeSessionRequest{UserId: "user-1", SessionId: laptopSession})
+	if code := status.Code(err); code != codes.NotFound {
+		t.Fatalf("RevokeSession(revoked session) = %v, want %s", err, codes.NotFound)
+	}
+
+	_, err = a.Refresh(ctx, &pb.RequestRefreshToken{RefreshToken: laptop.GetRefreshToken()})
+	if code := status.Code(err); code != codes.Unauthenticated {
+		t.Fatalf("Refresh(token of a revoked session) = %v, want %s", err, codes.Unauthenticated)
+	}
+	if _, err = a.Refresh(ctx, &pb.RequestRefreshToken{RefreshToken: phone.GetRefreshToken()}); err != nil {
+		t.Fatalf("Refresh(token of another session): %v", err)
+	}
+}
+
+func TestStoreRefreshTokenExpiry(t *testing.T) {
+	tests := []struct {
+		name      string
+		expiresIn string
+		familyId  string
+		wantCode  codes.Code
+	}{
+		{"new session", time.Now().Add(time.Hour).Format(time.RFC3339), "", codes.OK},
+		{"existing session", time.Now().Add(time.Hour).Format(time.RFC3339), "family-1", codes.OK},
+		{"not RFC3339", "tomorrow", "", codes.InvalidArgument},
+		{"in the past", time.Now().Add(-time.Hour).Format(time.RFC3339), "family-1", codes.InvalidArgument},
+		{"empty", "", "family-1", codes.InvalidArgument},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestAuthService(t, newFakeStorage(t))
+
+			_, err := a.StoreRefreshToken(context.Background(), &pb.RefreshToken{UserId: "user-1", RefreshToken: "token", ExpiresIn: tt.expiresIn, FamilyId: tt.familyId})
+			if code := status.Code(err); code != tt.wantCode {
+				t.Fatalf("StoreRefreshToken() = %v, want %s", err, tt.wantCode)
+			}
+		})
+	}
+}
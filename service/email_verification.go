@@ -0,0 +1,60 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+	"users_service/configs"
+	"users_service/pkg/helper"
+	"users_service/pkg/mailer"
+	"users_service/pkg/token"
+	"users_service/storage"
+)
+
+// emailVerifier issues single use verification tokens and mails them as links
+type emailVerifier struct {
+	storage storage.IStorage
+	tokens  *token.Manager
+	mailer  mailer.Mailer
+	ttl     time.Duration
+	url     string
+}
+
+func newEmailVerifier(storage storage.IStorage, tokens *token.Manager, mailer mailer.Mailer, cfg *configs.Config) *emailVerifier {
+	return &emailVerifier{
+		storage: storage,
+		tokens:  tokens,
+		mailer:  mailer,
+		ttl:     cfg.EmailVerificationTTL,
+		url:     cfg.EmailVerificationURL,
+	}
+}
+
+// send stores a new token for the user and mails the verification link to email
+func (e *emailVerifier) send(ctx context.Context, userId, email string) error {
+	verificationToken, err := token.RandomToken()
+	if err != nil {
+		return err
+	}
+
+	if err = e.storage.EmailVerification().Create(ctx, userId, email, e.tokens.Digest(verificationToken), time.Now().Add(e.ttl)); err != nil {
+		return err
+	}
+
+	link, err := helper.TokenLink(e.url, verificationToken)
+	if err != nil {
+		return fmt.Errorf("error while building email verification link: %w", err)
+	}
+
+	return e.mailer.Send(ctx, mailer.Message{
+		To:      email,
+		Subject: "Verify your email",
+		Body: fmt.Sprintf("Confirm your email address by opening the link below:\n\n%s\n\nThe link expires in %s. If you did not create an account, ignore this email.\n",
+			link, e.ttl),
+	})
+}
+
+// verify consumes the token and returns the id of the user whose email it verified
+func (e *emailVerifier) verify(ctx context.Context, verificationToken string) (string, error) {
+	return e.storage.EmailVerification().Consume(ctx, e.tokens.Digest(verificationToken))
+}
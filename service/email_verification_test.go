@@ -0,0 +1,112 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+	"users_service/pkg/mailer"
+
+	pb "users_service/genproto/users"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestEmailVerification(t *testing.T) {
+	var (
+		ctx  = context.Background()
+		strg = newFakeStorage(t)
+		a    = newTestAuthService(t, strg)
+	)
+	a.requireVerifiedEmail = true
+
+	user, err := a.Create(ctx, &pb.CreateUser{Email: "bob@example.com", Password: "battery staple", FullName: "Bob"})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	login := &pb.LoginRequest{Email: "bob@example.com", Password: "battery staple"}
+
+	if _, err = a.Login(ctx, login); errorReason(err) != "EMAIL_NOT_VERIFIED" {
+		t.Fatalf("Login before verification = %v, want EMAIL_NOT_VERIFIED", err)
+	}
+
+	verificationToken := lastMailedToken(t, a)
+	if _, ok := strg.verifications[verificationToken]; ok {
+		t.Fatal("the verification token was stored in plain")
+	}
+
+	if _, err = a.VerifyEmail(ctx, &pb.VerifyEmailRequest{Token: verificationToken}); err != nil {
+		t.Fatalf("VerifyEmail: %v", err)
+	}
+	if !strg.users[user.GetId()].GetEmailVerified() {
+		t.Fatal("email is not verified")
+	}
+
+	if _, err = a.VerifyEmail(ctx, &pb.VerifyEmailRequest{Token: verificationToken}); errorReason(err) != "VERIFICATION_TOKEN_INVALID" {
+		t.Fatalf("reused VerifyEmail = %v, want VERIFICATION_TOKEN_INVALID", err)
+	}
+
+	if _, err = a.Login(ctx, login); err != nil {
+		t.Fatalf("Login after verification: %v", err)
+	}
+}
+
+func TestVerifyEmailExpired(t *testing.T) {
+	var (
+		ctx  = context.Background()
+		strg = newFakeStorage(t)
+		a    = newTestAuthService(t, strg)
+	)
+
+	if _, err := a.SendVerificationEmail(ctx, &pb.Email{Email: "anna@example.com"}); err != nil {
+		t.Fatalf("SendVerificationEmail: %v", err)
+	}
+	for _, verification := range strg.verifications {
+		verification.expiresAt = time.Now().Add(-time.Minute)
+	}
+
+	_, err := a.VerifyEmail(ctx, &pb.VerifyEmailRequest{Token: lastMailedToken(t, a)})
+	if status.Code(err) != codes.InvalidArgument || errorReason(err) != "VERIFICATION_TOKEN_EXPIRED" {
+		t.Fatalf("VerifyEmail() = %v, want VERIFICATION_TOKEN_EXPIRED", err)
+	}
+}
+
+// Unknown and already verified emails get the same answer and no email
+func TestSendVerificationEmailQuiet(t *testing.T) {
+	var (
+		ctx  = context.Background()
+		strg = newFakeStorage(t)
+		a    = newTestAuthService(t, strg)
+	)
+	strg.users["user-1"].EmailVerified = true
+
+	for _, email := range []string{"nobody@example.com", "anna@example.com"} {
+		if _, err := a.SendVerificationEmail(ctx, &pb.Email{Email: email}); err != nil {
+			t.Fatalf("SendVerificationEmail(%q): %v", email, err)
+		}
+	}
+	if messages := a.emails.mailer.(*mailer.MemoryMailer).Messages(); len(messages) != 0 {
+		t.Fatalf("%d emails sent, want none", len(messages))
+	}
+}
+
+// A token only verifies the address it was mailed to, not one the user changed to since
+func TestVerifyEmailChangedAddress(t *testing.T) {
+	var (
+		ctx  = context.Background()
+		strg = newFakeStorage(t)
+		a    = newTestAuthService(t, strg)
+	)
+
+	if _, err := a.SendVerificationEmail(ctx, &pb.Email{Email: "anna@example.com"}); err != nil {
+		t.Fatalf("SendVerificationEmail: %v", err)
+	}
+	strg.users["user-1"].Email = "anna@example.org"
+
+	if _, err := a.VerifyEmail(ctx, &pb.VerifyEmailRequest{Token: lastMailedToken(t, a)}); errorReason(err) != "VERIFICATION_TOKEN_INVALID" {
+		t.Fatalf("VerifyEmail() = %v, want VERIFICATION_TOKEN_INVALID", err)
+	}
+	if strg.users["user-1"].GetEmailVerified() {
+		t.Fatal("the new address was verified by a token of the old one")
+	}
+}
@@ -0,0 +1,186 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"time"
+	"users_service/pkg/errs"
+	"users_service/pkg/oidc"
+	"users_service/pkg/password"
+	"users_service/pkg/token"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+const errorDomain = "users_service"
+
+// statusWithReason builds a status error carrying a machine readable reason clients can switch on
+func statusWithReason(code codes.Code, reason, msg string) error {
+	st, err := status.New(code, msg).WithDetails(&errdetails.ErrorInfo{
+		Reason: reason,
+		Domain: errorDomain,
+	})
+	if err != nil {
+		return status.Error(code, msg)
+	}
+	return st.Err()
+}
+
+// refreshTokenError maps storage and token errors of a presented refresh token to a gRPC status
+func refreshTokenError(err error) error {
+	switch {
+	case errors.Is(err, errs.ErrRefreshTokenExpired), errors.Is(err, token.ErrTokenExpired):
+		return statusWithReason(codes.Unauthenticated, "REFRESH_TOKEN_EXPIRED", "refresh token expired")
+	case errors.Is(err, errs.ErrRefreshTokenReused):
+		return statusWithReason(codes.Unauthenticated, "REFRESH_TOKEN_REUSED", "invalid refresh token")
+	default:
+		return statusWithReason(codes.Unauthenticated, "REFRESH_TOKEN_INVALID", "invalid refresh token")
+	}
+}
+
+// passwordPolicyError lists every failed password rule as a field violation of field
+func passwordPolicyError(field string, violations []password.Violation) error {
+	var (
+		badRequest = &errdetails.BadRequest{}
+		rules      = make([]string, 0, len(violations))
+	)
+
+	for _, violation := range violations {
+		badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       field,
+			Description: violation.Description,
+		})
+		rules = append(rules, violation.Rule)
+	}
+
+	st, err := status.New(codes.InvalidArgument, "password does not satisfy the password policy").WithDetails(
+		&errdetails.ErrorInfo{
+			Reason:   "PASSWORD_POLICY_VIOLATION",
+			Domain:   errorDomain,
+			Metadata: map[string]string{"rules": strings.Join(rules, ",")},
+		},
+		badRequest,
+	)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, "password does not satisfy the password policy")
+	}
+	return st.Err()
+}
+
+// mfaError maps mfa state errors to a status and returns nil for any other error
+func mfaError(err error) error {
+	switch {
+	case errors.Is(err, errs.ErrMfaNotEnrolled):
+		return statusWithReason(codes.FailedPrecondition, "MFA_NOT_ENROLLED", "two factor authentication is not enrolled")
+	case errors.Is(err, errMfaAlreadyEnabled):
+		return statusWithReason(codes.FailedPrecondition, "MFA_ALREADY_ENABLED", "two factor authentication is already enabled")
+	case errors.Is(err, errMfaCodeInvalid):
+		return statusWithReason(codes.InvalidArgument, "MFA_CODE_INVALID", "invalid two factor code")
+	}
+	return nil
+}
+
+// accountLockedError tells the client when it may retry a login of a locked account
+func accountLockedError(lockedUntil time.Time) error {
+	msg := "account is temporarily locked after too many failed attempts"
+
+	st, err := status.New(codes.ResourceExhausted, msg).WithDetails(
+		&errdetails.ErrorInfo{
+			Reason:   "ACCOUNT_LOCKED",
+			Domain:   errorDomain,
+			Metadata: map[string]string{"locked_until": lockedUntil.UTC().Format(time.RFC3339)},
+		},
+		&errdetails.RetryInfo{
+			RetryDelay: durationpb.New(time.Until(lockedUntil).Round(time.Second)),
+		},
+	)
+	if err != nil {
+		return status.Error(codes.ResourceExhausted, msg)
+	}
+	return st.Err()
+}
+
+// passwordlessError maps an unusable passwordless link or code to a status and returns nil for any other error
+func passwordlessError(err error) error {
+	switch {
+	case errors.Is(err, errs.ErrPasswordlessExpired):
+		return statusWithReason(codes.Unauthenticated, "PASSWORDLESS_EXPIRED", "sign in link or code expired")
+	case errors.Is(err, errs.ErrPasswordlessAttemptsExceeded):
+		return statusWithReason(codes.Unauthenticated, "PASSWORDLESS_ATTEMPTS_EXCEEDED", "too many wrong codes, start a new sign in")
+	case errors.Is(err, errs.ErrPasswordlessNotFound), errors.Is(err, errs.ErrPasswordlessCodeInvalid):
+		return statusWithReason(codes.Unauthenticated, "PASSWORDLESS_INVALID", "invalid sign in link or code")
+	}
+	return nil
+}
+
+// identityError maps identity provider and linking errors to a status and returns nil for any other error
+func identityError(err error) error {
+	switch {
+	case errors.Is(err, oidc.ErrUnknownProvider):
+		return status.Error(codes.InvalidArgument, "unknown identity provider")
+	case errors.Is(err, oidc.ErrInvalidIDToken):
+		return statusWithReason(codes.Unauthenticated, "ID_TOKEN_INVALID", "invalid id token")
+	case errors.Is(err, errs.ErrIdentityNotFound):
+		return status.Error(codes.NotFound, "identity not found")
+	case errors.Is(err, errs.ErrIdentityAlreadyLinked):
+		return statusWithReason(codes.AlreadyExists, "IDENTITY_ALREADY_LINKED", "identity is already linked to an account")
+	case errors.Is(err, errs.ErrLastLoginMethod):
+		return statusWithReason(codes.FailedPrecondition, "LAST_LOGIN_METHOD", "set a password or link another provider first")
+	case errors.Is(err, errs.ErrEmailTaken):
+		return statusWithReason(codes.FailedPrecondition, "ACCOUNT_EXISTS", "an account with this email exists, log in and link the provider to it")
+	}
+	return nil
+}
+
+// roleError maps role and permission errors to a status and returns nil for any other error
+func roleError(err error) error {
+	switch {
+	case errors.Is(err, errs.ErrRoleNotFound):
+		return status.Error(codes.NotFound, "role not found")
+	case errors.Is(err, errs.ErrPermissionNotFound):
+		return status.Error(codes.InvalidArgument, "unknown permission")
+	case errors.Is(err, errs.ErrRoleExists):
+		return status.Error(codes.AlreadyExists, "role already exists")
+	case errors.Is(err, errs.ErrPermissionExists):
+		return status.Error(codes.AlreadyExists, "permission already exists")
+	case errors.Is(err, errs.ErrRoleInUse):
+		return statusWithReason(codes.FailedPrecondition, "ROLE_IN_USE", "role is still assigned to users")
+	case errors.Is(err, errs.ErrRoleNotAssigned):
+		return status.Error(codes.NotFound, "role is not assigned to the user")
+	case errors.Is(err, errs.ErrUserNotFound):
+		return status.Error(codes.NotFound, "user not found")
+	case errors.Is(err, errs.ErrLastAdmin):
+		return statusWithReason(codes.FailedPrecondition, "LAST_ADMIN", "the last admin can not lose the admin role")
+	case errors.Is(err, errApprovalRequired):
+		return statusWithReason(codes.FailedPrecondition, "APPROVAL_REQUIRED", "granting admin needs another admin's approval, use RequestRoleChange")
+	case errors.Is(err, errNoActor):
+		return status.Error(codes.Unauthenticated, "role changes need an authenticated admin")
+	case errors.Is(err, errs.ErrRoleChangeNotFound):
+		return status.Error(codes.NotFound, "role change not found")
+	case errors.Is(err, errs.ErrRoleChangeNotPending):
+		return statusWithReason(codes.FailedPrecondition, "ROLE_CHANGE_NOT_PENDING", "role change was already applied")
+	case errors.Is(err, errs.ErrRoleChangeExpired):
+		return statusWithReason(codes.FailedPrecondition, "ROLE_CHANGE_EXPIRED", "role change request expired")
+	case errors.Is(err, errs.ErrSameApprover):
+		return statusWithReason(codes.PermissionDenied, "SAME_APPROVER", "role change must be approved by another admin")
+	}
+	return nil
+}
+
+// impersonationError maps impersonation errors to a status and returns nil for any other error
+func impersonationError(err error) error {
+	switch {
+	case errors.Is(err, errImpersonationReason), errors.Is(err, errImpersonateSelf):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, errNotImpersonable):
+		return statusWithReason(codes.PermissionDenied, "IMPERSONATION_NOT_ALLOWED", err.Error())
+	case errors.Is(err, errNoActor):
+		return status.Error(codes.Unauthenticated, "impersonation needs an authenticated support user")
+	case errors.Is(err, errs.ErrUserNotFound):
+		return status.Error(codes.NotFound, "user not found")
+	}
+	return nil
+}
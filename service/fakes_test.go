@@ -0,0 +1,1141 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+	"users_service/configs"
+	"users_service/pkg/caller"
+	"users_service/pkg/errs"
+	"users_service/pkg/logger"
+	"users_service/pkg/mailer"
+	"users_service/pkg/password"
+	"users_service/pkg/token"
+	"users_service/storage"
+
+	pb "users_service/genproto/users"
+
+	"github.com/jackc/pgx/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testPassword is the password of every user of newFakeStorage
+const testPassword = "correct horse"
+
+// fakeStorage keeps users and refresh tokens in memory, the repos a test does not
+// touch are left nil
+type fakeStorage struct {
+	storage.IStorage
+
+	users         map[string]*pb.User
+	passwords     map[string]string
+	history       map[string][]string
+	refreshTokens map[string]*fakeRefreshToken
+	families      int
+	sessions      []*fakeSession
+	verifications map[string]*fakeVerification
+	resets        map[string]*fakeVerification
+	totp          map[string]*fakeTotp
+	recoveryCodes map[string]map[string]bool
+	failedLogins  map[string]int
+	lockedUntil   map[string]time.Time
+	loginEvents   []*pb.LoginEvent
+	challenges    map[string]*fakeChallenge
+	identities    []*pb.Identity
+	signingKeys   []*fakeSigningKey
+	apiTokens     []*fakeApiToken
+	roles         map[string][]string
+	permissions   map[string]bool
+	userRoles     map[string][]string
+	roleChanges   []*fakeRoleChange
+
+	// sessionsErr makes creating sessions fail when set
+	sessionsErr error
+	// sessionChecks counts the IsActive lookups
+	sessionChecks int
+}
+
+type fakeSession struct {
+	session *pb.Session
+	revoked bool
+}
+
+type fakeTotp struct {
+	secret    string
+	confirmed bool
+	lastStep  int64
+}
+
+type fakeChallenge struct {
+	userId    string
+	codeHash  string
+	emailHash string
+	ipAddress string
+	attempts  int
+	createdAt time.Time
+	expiresAt time.Time
+	used      bool
+}
+
+type fakeSigningKey struct {
+	key        *pb.SigningKey
+	privateKey string
+}
+
+type fakeRoleChange struct {
+	change    *pb.RoleChange
+	expiresAt time.Time
+}
+
+type fakeApiToken struct {
+	token     *pb.ApiToken
+	tokenHash string
+	expiresAt time.Time
+	revoked   bool
+}
+
+// fakeVerification is a single use token of the email verification and password reset repos
+type fakeVerification struct {
+	userId    string
+	email     string
+	expiresAt time.Time
+	used      bool
+}
+
+type fakeRefreshToken struct {
+	userId    string
+	familyId  string
+	expiresAt time.Time
+	consumed  bool
+	revoked   bool
+}
+
+func newFakeStorage(t *testing.T) *fakeStorage {
+	t.Helper()
+
+	hash, err := newTestHasher(t, password.SchemeArgon2id).Hash(testPassword)
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+
+	return &fakeStorage{
+		users: map[string]*pb.User{
+			"user-1": {Id: "user-1", Email: "anna@example.com", UserRole: "user"},
+		},
+		passwords:     map[string]string{"user-1": hash},
+		history:       map[string][]string{"user-1": {hash}},
+		refreshTokens: map[string]*fakeRefreshToken{},
+		verifications: map[string]*fakeVerification{},
+		resets:        map[string]*fakeVerification{},
+		totp:          map[string]*fakeTotp{},
+		recoveryCodes: map[string]map[string]bool{},
+		failedLogins:  map[string]int{},
+		lockedUntil:   map[string]time.Time{},
+		challenges:    map[string]*fakeChallenge{},
+		roles: map[string][]string{
+			"user":           {"users:read"},
+			caller.RoleAdmin: {"users:read", "users:write"},
+		},
+		permissions: map[string]bool{"users:read": true, "users:write": true},
+		userRoles:   map[string][]string{},
+	}
+}
+
+// newTestConfig holds the settings the services under test read at construction
+func newTestConfig() *configs.Config {
+	return &configs.Config{
+		PasswordHistoryCount:     3,
+		EmailVerificationTTL:     time.Hour,
+		EmailVerificationURL:     "https://example.com/verify",
+		PasswordResetTTL:         time.Hour,
+		PasswordResetURL:         "https://example.com/reset",
+		MfaEncryptionKey:         "test mfa key",
+		MfaIssuer:                "users_service",
+		RecoveryCodeCount:        4,
+		LockoutThreshold:         3,
+		LockoutBaseDelay:         time.Minute,
+		LockoutMaxDuration:       10 * time.Minute,
+		PasswordlessTTL:          10 * time.Minute,
+		PasswordlessURL:          "https://example.com/passwordless",
+		RoleChangeRequestTTL:     time.Hour,
+		ImpersonationTTL:         15 * time.Minute,
+		ImpersonationMaxTTL:      time.Hour,
+		ApiTokenMaxTTL:           24 * time.Hour,
+		PasswordlessMaxAttempts:  3,
+		PasswordlessStartLimit:   3,
+		PasswordlessStartIpLimit: 5,
+		PasswordlessStartWindow:  time.Hour,
+	}
+}
+
+// newTestHasher keeps the hashing costs low, the tests check behaviour and not strength
+func newTestHasher(t *testing.T, scheme string) password.Hasher {
+	t.Helper()
+
+	hasher, err := password.NewHasher(&configs.Config{
+		PasswordHashScheme: scheme,
+		BcryptCost:         bcrypt.MinCost,
+		Argon2Memory:       1024,
+		Argon2Iterations:   1,
+		Argon2Parallelism:  1,
+	})
+	if err != nil {
+		t.Fatalf("NewHasher: %v", err)
+	}
+	return hasher
+}
+
+func newTestPolicy(t *testing.T) *password.Policy {
+	t.Helper()
+
+	policy, err := password.NewPolicy(&configs.Config{PasswordMinLength: 8, PasswordMaxLength: 128})
+	if err != nil {
+		t.Fatalf("NewPolicy: %v", err)
+	}
+	return policy
+}
+
+func newTestMfa(t *testing.T, strg *fakeStorage, tokens *token.Manager) *mfa {
+	t.Helper()
+
+	m, err := newMfa(strg, tokens, newTestConfig())
+	if err != nil {
+		t.Fatalf("newMfa: %v", err)
+	}
+	return m
+}
+
+func newTestLogger(t *testing.T) logger.ILogger {
+	return logger.NewLogger("test", logger.LevelError, filepath.Join(t.TempDir(), "test.log"))
+}
+
+// lastMailedToken returns the token of the link in the last email sent by a
+func lastMailedToken(t *testing.T, a *authService) string {
+	t.Helper()
+
+	messages := a.emails.mailer.(*mailer.MemoryMailer).Messages()
+	if len(messages) == 0 {
+		t.Fatal("no email was sent")
+	}
+
+	for _, field := range strings.Fields(messages[len(messages)-1].Body) {
+		if !strings.HasPrefix(field, "https://example.com/") {
+			continue
+		}
+		link, err := url.Parse(field)
+		if err != nil {
+			t.Fatalf("parsing link: %v", err)
+		}
+		return link.Query().Get("token")
+	}
+
+	t.Fatal("the email has no link")
+	return ""
+}
+
+func (s *fakeStorage) Auth() storage.IAuthStorage         { return fakeAuth{s: s} }
+func (s *fakeStorage) Users() storage.IUsersStorage       { return fakeUsers{s: s} }
+func (s *fakeStorage) Sessions() storage.ISessionsStorage { return fakeSessions{s: s} }
+func (s *fakeStorage) EmailVerification() storage.IEmailVerificationStorage {
+	return fakeEmailVerification{s: s}
+}
+func (s *fakeStorage) PasswordReset() storage.IPasswordResetStorage { return fakePasswordReset{s: s} }
+func (s *fakeStorage) Mfa() storage.IMfaStorage                     { return fakeMfa{s: s} }
+func (s *fakeStorage) Passwordless() storage.IPasswordlessStorage   { return fakePasswordless{s: s} }
+func (s *fakeStorage) Identities() storage.IIdentitiesStorage       { return fakeIdentities{s: s} }
+func (s *fakeStorage) SigningKeys() storage.ISigningKeysStorage     { return fakeSigningKeys{s: s} }
+func (s *fakeStorage) Roles() storage.IRolesStorage                 { return fakeRoles{s: s} }
+func (s *fakeStorage) RoleChanges() storage.IRoleChangesStorage     { return fakeRoleChanges{s: s} }
+func (s *fakeStorage) ApiTokens() storage.IApiTokensStorage         { return fakeApiTokens{s: s} }
+func (s *fakeStorage) LoginEvents() storage.ILoginEventsStorage     { return fakeLoginEvents{s: s} }
+
+type fakeAuth struct {
+	storage.IAuthStorage
+	s *fakeStorage
+}
+
+func (f fakeAuth) Create(ctx context.Context, request *pb.CreateUser) (*pb.User, error) {
+	user := &pb.User{
+		Id:       fmt.Sprintf("user-%d", len(f.s.users)+1),
+		Email:    request.GetEmail(),
+		FullName: request.GetFullName(),
+		UserRole: "user",
+	}
+	f.s.users[user.Id] = user
+	f.s.passwords[user.Id] = request.GetPassword()
+	return user, nil
+}
+
+func (f fakeAuth) GetByEmail(ctx context.Context, request *pb.Email) (*pb.UserByEmail, error) {
+	for _, user := range f.s.users {
+		if user.Email == request.GetEmail() {
+			return &pb.UserByEmail{
+				Id:            user.Id,
+				Email:         user.Email,
+				UserRole:      user.UserRole,
+				Password:      f.s.passwords[user.Id],
+				EmailVerified: user.EmailVerified,
+				MfaEnabled:    user.MfaEnabled,
+			}, nil
+		}
+	}
+	return nil, pgx.ErrNoRows
+}
+
+func (f fakeAuth) StoreRefreshToken(ctx context.Context, request *pb.RefreshToken) (*pb.Void, error) {
+	familyId := request.GetFamilyId()
+	if familyId == "" {
+		f.s.families++
+		familyId = fmt.Sprintf("family-%d", f.s.families)
+	}
+
+	expiresAt, err := time.Parse(time.RFC3339, request.GetExpiresIn())
+	if err != nil || !expiresAt.After(time.Now()) {
+		return nil, errs.ErrRefreshTokenExpiryInvalid
+	}
+
+	f.s.refreshTokens[request.GetRefreshToken()] = &fakeRefreshToken{userId: request.GetUserId(), familyId: familyId, expiresAt: expiresAt}
+	return &pb.Void{}, nil
+}
+
+// RotateRefreshToken follows the postgres repo: reusing a consumed token revokes its family,
+// expired tokens are refused
+func (f fakeAuth) RotateRefreshToken(ctx context.Context, request *pb.RequestRefreshToken, next *pb.RefreshToken) (*pb.RefreshToken, error) {
+	current, ok := f.s.refreshTokens[request.GetRefreshToken()]
+	if !ok {
+		return nil, errs.ErrRefreshTokenNotFound
+	}
+
+	owner := &pb.RefreshToken{UserId: current.userId, FamilyId: current.familyId}
+
+	switch {
+	case current.revoked:
+		return owner, errs.ErrRefreshTokenRevoked
+	case current.consumed:
+		for _, token := range f.s.refreshTokens {
+			if token.familyId == current.familyId {
+				token.revoked = true
+			}
+		}
+		return owner, errs.ErrRefreshTokenReused
+	case !current.expiresAt.After(time.Now()):
+		return owner, errs.ErrRefreshTokenExpired
+	}
+
+	expiresAt, err := time.Parse(time.RFC3339, next.GetExpiresIn())
+	if err != nil {
+		return nil, err
+	}
+
+	current.consumed = true
+	f.s.refreshTokens[next.GetRefreshToken()] = &fakeRefreshToken{userId: current.userId, familyId: current.familyId, expiresAt: expiresAt}
+
+	return &pb.RefreshToken{
+		UserId:       current.userId,
+		RefreshToken: next.GetRefreshToken(),
+		ExpiresIn:    next.GetExpiresIn(),
+		FamilyId:     current.familyId,
+	}, nil
+}
+
+func (f fakeAuth) GetLockedUntil(ctx context.Context, userId string) (time.Time, error) {
+	return f.s.lockedUntil[userId], nil
+}
+
+func (f fakeAuth) RecordFailedLogin(ctx context.Context, userId string) (int, error) {
+	f.s.failedLogins[userId]++
+	return f.s.failedLogins[userId], nil
+}
+
+func (f fakeAuth) LockUser(ctx context.Context, userId string, until time.Time) error {
+	f.s.lockedUntil[userId] = until
+	return nil
+}
+
+func (f fakeAuth) ResetFailedLogins(ctx context.Context, userId string) (*pb.Void, error) {
+	delete(f.s.failedLogins, userId)
+	delete(f.s.lockedUntil, userId)
+	return &pb.Void{}, nil
+}
+
+type fakeUsers struct {
+	storage.IUsersStorage
+	s *fakeStorage
+}
+
+func (f fakeUsers) GetById(ctx context.Context, request *pb.PrimaryKey) (*pb.User, error) {
+	user, ok := f.s.users[request.GetId()]
+	if !ok {
+		return nil, pgx.ErrNoRows
+	}
+	return user, nil
+}
+
+// Delete follows the postgres repo, it refuses to delete the last admin
+func (f fakeUsers) Delete(ctx context.Context, request *pb.PrimaryKey) (*pb.Void, error) {
+	user, ok := f.s.users[request.GetId()]
+	if !ok {
+		return &pb.Void{}, nil
+	}
+
+	delete(f.s.users, user.GetId())
+	if !f.s.adminLeft() {
+		f.s.users[user.GetId()] = user
+		return nil, errs.ErrLastAdmin
+	}
+	return &pb.Void{}, nil
+}
+
+func (f fakeUsers) GetPasswordHash(ctx context.Context, request *pb.PrimaryKey) (string, error) {
+	if _, ok := f.s.users[request.GetId()]; !ok {
+		return "", pgx.ErrNoRows
+	}
+	return f.s.passwords[request.GetId()], nil
+}
+
+// ChangePassword sets a new password and adds it to the history, newest first
+// Update follows the postgres repo: a new password hash is recorded in the history
+func (f fakeUsers) Update(ctx context.Context, request *pb.UpdateUser) (*pb.UpdatedUser, error) {
+	user, ok := f.s.users[request.GetId()]
+	if !ok {
+		return nil, pgx.ErrNoRows
+	}
+	if request.GetFullName() != "" {
+		user.FullName = request.GetFullName()
+	}
+	if request.GetEmail() != "" {
+		user.Email = request.GetEmail()
+	}
+	if request.GetPasswordHash() != "" {
+		f.s.passwords[user.Id] = request.GetPasswordHash()
+		f.s.history[user.Id] = append([]string{request.GetPasswordHash()}, f.s.history[user.Id]...)
+	}
+	return &pb.UpdatedUser{
+		Id:       user.Id,
+		Email:    user.Email,
+		Password: f.s.passwords[user.Id],
+		FullName: user.FullName,
+		UserRole: user.UserRole,
+	}, nil
+}
+
+func (f fakeUsers) ChangePassword(ctx context.Context, request *pb.ChangePassword) (*pb.Void, error) {
+	if _, err := f.UpdatePasswordHash(ctx, request); err != nil {
+		return nil, err
+	}
+	f.s.history[request.GetUserId()] = append([]string{request.GetNewPassword()}, f.s.history[request.GetUserId()]...)
+	return &pb.Void{}, nil
+}
+
+func (f fakeUsers) UpdatePasswordHash(ctx context.Context, request *pb.ChangePassword) (*pb.Void, error) {
+	if _, ok := f.s.users[request.GetUserId()]; !ok {
+		return nil, pgx.ErrNoRows
+	}
+	f.s.passwords[request.GetUserId()] = request.GetNewPassword()
+	return &pb.Void{}, nil
+}
+
+func (f fakeUsers) GetPasswordHistory(ctx context.Context, request *pb.PrimaryKey, limit int, since time.Time) ([]string, error) {
+	hashes := f.s.history[request.GetId()]
+	if len(hashes) > limit {
+		hashes = hashes[:limit]
+	}
+	return hashes, nil
+}
+
+type fakeSessions struct {
+	storage.ISessionsStorage
+	s *fakeStorage
+}
+
+// Create opens a session, its id is the family of its refresh tokens
+func (f fakeSessions) CreateImpersonation(ctx context.Context, request *pb.Session, expiresAt time.Time) (*pb.Session, error) {
+	session := &pb.Session{
+		Id:                  fmt.Sprintf("session-%d", len(f.s.sessions)+1),
+		UserId:              request.GetUserId(),
+		DeviceName:          request.GetDeviceName(),
+		ImpersonatorId:      request.GetImpersonatorId(),
+		ImpersonationReason: request.GetImpersonationReason(),
+	}
+	f.s.sessions = append(f.s.sessions, &fakeSession{session: session})
+	return session, nil
+}
+
+func (f fakeSessions) Create(ctx context.Context, request *pb.Session, refreshToken *pb.RefreshToken) (*pb.Session, error) {
+	if f.s.sessionsErr != nil {
+		return nil, f.s.sessionsErr
+	}
+
+	session := &pb.Session{
+		Id:         fmt.Sprintf("session-%d", len(f.s.sessions)+1),
+		UserId:     request.GetUserId(),
+		DeviceName: request.GetDeviceName(),
+		UserAgent:  request.GetUserAgent(),
+		IpAddress:  request.GetIpAddress(),
+	}
+	f.s.sessions = append(f.s.sessions, &fakeSession{session: session})
+
+	if _, err := (fakeAuth{s: f.s}).StoreRefreshToken(ctx, &pb.RefreshToken{
+		UserId:       session.UserId,
+		RefreshToken: refreshToken.GetRefreshToken(),
+		ExpiresIn:    refreshToken.GetExpiresIn(),
+		FamilyId:     session.Id,
+	}); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+func (f fakeSessions) Revoke(ctx context.Context, request *pb.RevokeSessionRequest) (*pb.Void, error) {
+	for _, stored := range f.s.sessions {
+		if stored.session.Id != request.GetSessionId() || stored.session.UserId != request.GetUserId() || stored.revoked {
+			continue
+		}
+
+		stored.revoked = true
+		for _, token := range f.s.refreshTokens {
+			if token.familyId == stored.session.Id {
+				token.revoked = true
+			}
+		}
+		return &pb.Void{}, nil
+	}
+	return nil, errs.ErrSessionNotFound
+}
+
+func (f fakeSessions) IsActive(ctx context.Context, id string) (bool, error) {
+	f.s.sessionChecks++
+	for _, stored := range f.s.sessions {
+		if stored.session.Id == id {
+			return !stored.revoked, nil
+		}
+	}
+	return false, nil
+}
+
+type fakeEmailVerification struct {
+	storage.IEmailVerificationStorage
+	s *fakeStorage
+}
+
+func (f fakeEmailVerification) Create(ctx context.Context, userId, email, tokenHash string, expiresAt time.Time) error {
+	f.s.verifications[tokenHash] = &fakeVerification{userId: userId, email: email, expiresAt: expiresAt}
+	return nil
+}
+
+// Consume follows the postgres repo: it uses up every open token of the owner and
+// marks the owner's email verified
+func (f fakeEmailVerification) Consume(ctx context.Context, tokenHash string) (string, error) {
+	current, ok := f.s.verifications[tokenHash]
+	if !ok || current.used || current.email != f.s.users[current.userId].GetEmail() {
+		return "", errs.ErrVerificationTokenNotFound
+	}
+	if !current.expiresAt.After(time.Now()) {
+		return "", errs.ErrVerificationTokenExpired
+	}
+
+	for _, verification := range f.s.verifications {
+		if verification.userId == current.userId {
+			verification.used = true
+		}
+	}
+	f.s.users[current.userId].EmailVerified = true
+	return current.userId, nil
+}
+
+type fakePasswordReset struct {
+	storage.IPasswordResetStorage
+	s *fakeStorage
+}
+
+func (f fakePasswordReset) Create(ctx context.Context, userId, tokenHash string, expiresAt time.Time) error {
+	f.s.resets[tokenHash] = &fakeVerification{userId: userId, expiresAt: expiresAt}
+	return nil
+}
+
+func (f fakePasswordReset) GetUserId(ctx context.Context, tokenHash string) (string, error) {
+	current, ok := f.s.resets[tokenHash]
+	if !ok || current.used {
+		return "", errs.ErrResetTokenNotFound
+	}
+	if !current.expiresAt.After(time.Now()) {
+		return "", errs.ErrResetTokenExpired
+	}
+	return current.userId, nil
+}
+
+// Consume follows the postgres repo: it uses up every open token of the owner, changes the
+// password and revokes the owner's sessions and refresh tokens
+func (f fakePasswordReset) Consume(ctx context.Context, tokenHash, passwordHash string) (string, error) {
+	userId, err := f.GetUserId(ctx, tokenHash)
+	if err != nil {
+		return "", err
+	}
+
+	for _, reset := range f.s.resets {
+		if reset.userId == userId {
+			reset.used = true
+		}
+	}
+	if _, err = (fakeUsers{s: f.s}).ChangePassword(ctx, &pb.ChangePassword{UserId: userId, NewPassword: passwordHash}); err != nil {
+		return "", err
+	}
+	for _, token := range f.s.refreshTokens {
+		if token.userId == userId {
+			token.revoked = true
+		}
+	}
+	for _, stored := range f.s.sessions {
+		if stored.session.UserId == userId {
+			stored.revoked = true
+		}
+	}
+	return userId, nil
+}
+
+// fakeMfa follows the postgres repo, recovery codes map to whether they were used
+type fakeMfa struct {
+	storage.IMfaStorage
+	s *fakeStorage
+}
+
+func (f fakeMfa) SaveSecret(ctx context.Context, userId, encryptedSecret string) error {
+	f.s.totp[userId] = &fakeTotp{secret: encryptedSecret}
+	return nil
+}
+
+func (f fakeMfa) GetSecret(ctx context.Context, userId string) (string, bool, error) {
+	stored, ok := f.s.totp[userId]
+	if !ok {
+		return "", false, errs.ErrMfaNotEnrolled
+	}
+	return stored.secret, stored.confirmed, nil
+}
+
+func (f fakeMfa) UseStep(ctx context.Context, userId string, step int64) (bool, error) {
+	stored, ok := f.s.totp[userId]
+	if !ok || stored.lastStep >= step {
+		return false, nil
+	}
+	stored.lastStep = step
+	return true, nil
+}
+
+func (f fakeMfa) Enable(ctx context.Context, userId string, step int64, codeHashes []string) error {
+	stored, ok := f.s.totp[userId]
+	if !ok || stored.confirmed {
+		return errs.ErrMfaNotEnrolled
+	}
+	stored.confirmed, stored.lastStep = true, step
+	f.s.users[userId].MfaEnabled = true
+	return f.ReplaceRecoveryCodes(ctx, userId, codeHashes)
+}
+
+func (f fakeMfa) ReplaceRecoveryCodes(ctx context.Context, userId string, codeHashes []string) error {
+	f.s.recoveryCodes[userId] = map[string]bool{}
+	for _, codeHash := range codeHashes {
+		f.s.recoveryCodes[userId][codeHash] = false
+	}
+	return nil
+}
+
+func (f fakeMfa) UseRecoveryCode(ctx context.Context, userId, codeHash string) (bool, error) {
+	used, ok := f.s.recoveryCodes[userId][codeHash]
+	if !ok || used {
+		return false, nil
+	}
+	f.s.recoveryCodes[userId][codeHash] = true
+	return true, nil
+}
+
+func (f fakeMfa) Disable(ctx context.Context, userId string) error {
+	delete(f.s.totp, userId)
+	delete(f.s.recoveryCodes, userId)
+	f.s.users[userId].MfaEnabled = false
+	return nil
+}
+
+type fakeLoginEvents struct {
+	storage.ILoginEventsStorage
+	s *fakeStorage
+}
+
+func (f fakeLoginEvents) Create(ctx context.Context, event *pb.LoginEvent) error {
+	f.s.loginEvents = append(f.s.loginEvents, event)
+	return nil
+}
+
+// GetAll pages through the user's events newest first
+func (f fakeLoginEvents) GetAll(ctx context.Context, request *pb.LoginHistoryRequest) (*pb.LoginEvents, error) {
+	var events []*pb.LoginEvent
+	for i := len(f.s.loginEvents) - 1; i >= 0; i-- {
+		if f.s.loginEvents[i].GetUserId() == request.GetUserId() {
+			events = append(events, f.s.loginEvents[i])
+		}
+	}
+
+	resp := &pb.LoginEvents{Page: request.GetPage(), Limit: request.GetLimit(), Count: int32(len(events))}
+	for i := int64(request.GetPage()-1) * request.GetLimit(); i < int64(len(events)) && int64(len(resp.Events)) < request.GetLimit(); i++ {
+		resp.Events = append(resp.Events, events[i])
+	}
+	return resp, nil
+}
+
+type fakePasswordless struct {
+	storage.IPasswordlessStorage
+	s *fakeStorage
+}
+
+// Create follows the postgres repo: a new challenge replaces the user's open ones, the user
+// can open limit challenges since since
+func (f fakePasswordless) Create(ctx context.Context, userId, codeHash string, expiresAt time.Time, emailHash, ipAddress string, since time.Time, emailLimit, ipLimit int) (string, error) {
+	var emailStarted, ipStarted int
+	for _, challenge := range f.s.challenges {
+		if challenge.createdAt.Before(since) {
+			continue
+		}
+		if challenge.emailHash == emailHash {
+			emailStarted++
+		}
+		if challenge.ipAddress == ipAddress {
+			ipStarted++
+		}
+	}
+	if emailStarted >= emailLimit || (ipAddress != "" && ipStarted >= ipLimit) {
+		return "", errs.ErrPasswordlessRateLimited
+	}
+
+	if userId != "" {
+		for _, challenge := range f.s.challenges {
+			if challenge.userId == userId {
+				challenge.used = true
+			}
+		}
+	}
+
+	id := fmt.Sprintf("challenge-%d", len(f.s.challenges)+1)
+	f.s.challenges[id] = &fakeChallenge{
+		userId:    userId,
+		codeHash:  codeHash,
+		emailHash: emailHash,
+		ipAddress: ipAddress,
+		createdAt: time.Now(),
+		expiresAt: expiresAt,
+	}
+	return id, nil
+}
+
+func (f fakePasswordless) ConsumeLink(ctx context.Context, id string) (string, error) {
+	challenge, err := f.get(id)
+	if err != nil {
+		return "", err
+	}
+	if challenge.userId == "" || challenge.codeHash != "" {
+		return "", errs.ErrPasswordlessNotFound
+	}
+	challenge.used = true
+	return challenge.userId, nil
+}
+
+// ConsumeCode follows the postgres repo: every mismatch counts, the challenge is burnt after maxAttempts
+func (f fakePasswordless) ConsumeCode(ctx context.Context, id, codeHash string, maxAttempts int) (string, error) {
+	challenge, err := f.get(id)
+	if err != nil {
+		return "", err
+	}
+	if challenge.codeHash == "" {
+		return "", errs.ErrPasswordlessNotFound
+	}
+	if challenge.userId == "" || challenge.codeHash != codeHash {
+		challenge.attempts++
+		if challenge.attempts >= maxAttempts {
+			challenge.used = true
+			return challenge.userId, errs.ErrPasswordlessAttemptsExceeded
+		}
+		return challenge.userId, errs.ErrPasswordlessCodeInvalid
+	}
+	challenge.used = true
+	return challenge.userId, nil
+}
+
+func (f fakePasswordless) get(id string) (*fakeChallenge, error) {
+	challenge, ok := f.s.challenges[id]
+	if !ok || challenge.used {
+		return nil, errs.ErrPasswordlessNotFound
+	}
+	if !challenge.expiresAt.After(time.Now()) {
+		return nil, errs.ErrPasswordlessExpired
+	}
+	return challenge, nil
+}
+
+type fakeIdentities struct {
+	storage.IIdentitiesStorage
+	s *fakeStorage
+}
+
+func (f fakeIdentities) Create(ctx context.Context, request *pb.Identity) (*pb.Identity, error) {
+	if _, err := f.GetBySubject(ctx, request.GetProvider(), request.GetSubject()); err == nil {
+		return nil, errs.ErrIdentityAlreadyLinked
+	}
+
+	request.Id = fmt.Sprintf("identity-%d", len(f.s.identities)+1)
+	f.s.identities = append(f.s.identities, request)
+	return request, nil
+}
+
+func (f fakeIdentities) CreateWithUser(ctx context.Context, request *pb.CreateUser, identity *pb.Identity, emailVerified bool) (*pb.User, error) {
+	user := &pb.User{
+		Id:            fmt.Sprintf("user-%d", len(f.s.users)+1),
+		Email:         request.GetEmail(),
+		UserRole:      "user",
+		EmailVerified: emailVerified,
+	}
+	f.s.users[user.Id] = user
+
+	identity.UserId = user.Id
+	if _, err := f.Create(ctx, identity); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (f fakeIdentities) GetBySubject(ctx context.Context, provider, subject string) (*pb.Identity, error) {
+	for _, identity := range f.s.identities {
+		if identity.Provider == provider && identity.Subject == subject {
+			return identity, nil
+		}
+	}
+	return nil, errs.ErrIdentityNotFound
+}
+
+// Delete follows the postgres repo: the last identity of a user without a password stays
+func (f fakeIdentities) Delete(ctx context.Context, request *pb.UnlinkIdentityRequest) (*pb.Void, error) {
+	var (
+		index  = -1
+		others int
+	)
+
+	for i, identity := range f.s.identities {
+		if identity.UserId != request.GetUserId() {
+			continue
+		}
+		if identity.Provider == request.GetProvider() {
+			index = i
+		} else {
+			others++
+		}
+	}
+
+	if index < 0 {
+		return nil, errs.ErrIdentityNotFound
+	}
+	if others == 0 && f.s.passwords[request.GetUserId()] == "" {
+		return nil, errs.ErrLastLoginMethod
+	}
+
+	f.s.identities = append(f.s.identities[:index], f.s.identities[index+1:]...)
+	return &pb.Void{}, nil
+}
+
+type fakeSigningKeys struct {
+	storage.ISigningKeysStorage
+	s *fakeStorage
+}
+
+func (f fakeSigningKeys) GetAll(ctx context.Context) (*pb.SigningKeys, map[string]string, error) {
+	var (
+		keys        = &pb.SigningKeys{}
+		privateKeys = map[string]string{}
+	)
+	for _, stored := range f.s.signingKeys {
+		keys.Keys = append(keys.Keys, stored.key)
+		privateKeys[stored.key.Id] = stored.privateKey
+	}
+	return keys, privateKeys, nil
+}
+
+// Bootstrap follows the postgres repo: it only creates keys while there is no active one
+func (f fakeSigningKeys) Bootstrap(ctx context.Context, active, pending *pb.SigningKey, activePrivateKey, pendingPrivateKey string) (bool, error) {
+	for _, stored := range f.s.signingKeys {
+		if stored.key.State == token.KeyStateActive {
+			return false, nil
+		}
+	}
+
+	active.State, pending.State = token.KeyStateActive, token.KeyStatePending
+	f.s.signingKeys = append(f.s.signingKeys,
+		&fakeSigningKey{key: active, privateKey: activePrivateKey},
+		&fakeSigningKey{key: pending, privateKey: pendingPrivateKey},
+	)
+	return true, nil
+}
+
+func (f fakeSigningKeys) Rotate(ctx context.Context, next *pb.SigningKey, nextPrivateKey string, retiredFor time.Duration) error {
+	var pending *fakeSigningKey
+	for _, stored := range f.s.signingKeys {
+		if stored.key.State == token.KeyStatePending {
+			pending = stored
+		}
+	}
+	if pending == nil {
+		return errs.ErrNoPendingSigningKey
+	}
+
+	for _, stored := range f.s.signingKeys {
+		if stored.key.State == token.KeyStateActive {
+			stored.key.State = token.KeyStateRetired
+			stored.key.ExpiresAt = time.Now().Add(retiredFor).Format(time.RFC3339)
+		}
+	}
+	pending.key.State = token.KeyStateActive
+
+	next.State = token.KeyStatePending
+	f.s.signingKeys = append(f.s.signingKeys, &fakeSigningKey{key: next, privateKey: nextPrivateKey})
+	return nil
+}
+
+type fakeApiTokens struct {
+	storage.IApiTokensStorage
+	s *fakeStorage
+}
+
+func (f fakeApiTokens) Create(ctx context.Context, request *pb.CreateApiTokenRequest, prefix, tokenHash string, expiresAt time.Time) (*pb.ApiToken, error) {
+	apiToken := &pb.ApiToken{
+		Id:     fmt.Sprintf("api-token-%d", len(f.s.apiTokens)+1),
+		UserId: request.GetUserId(),
+		Name:   request.GetName(),
+		Prefix: prefix,
+		Scopes: request.GetScopes(),
+	}
+	if !expiresAt.IsZero() {
+		apiToken.ExpiresAt = expiresAt.Format(time.RFC3339)
+	}
+
+	f.s.apiTokens = append(f.s.apiTokens, &fakeApiToken{token: apiToken, tokenHash: tokenHash, expiresAt: expiresAt})
+	return apiToken, nil
+}
+
+func (f fakeApiTokens) GetAll(ctx context.Context, request *pb.PrimaryKey) (*pb.ApiTokens, error) {
+	resp := &pb.ApiTokens{}
+	for _, stored := range f.s.apiTokens {
+		if stored.token.UserId == request.GetId() && !stored.revoked {
+			resp.ApiTokens = append(resp.ApiTokens, stored.token)
+		}
+	}
+	return resp, nil
+}
+
+func (f fakeApiTokens) Revoke(ctx context.Context, request *pb.RevokeApiTokenRequest) (*pb.Void, error) {
+	for _, stored := range f.s.apiTokens {
+		if stored.token.Id == request.GetTokenId() && stored.token.UserId == request.GetUserId() && !stored.revoked {
+			stored.revoked = true
+			return &pb.Void{}, nil
+		}
+	}
+	return nil, errs.ErrApiTokenNotFound
+}
+
+func (f fakeApiTokens) Validate(ctx context.Context, tokenHash string) (*pb.ApiTokenIdentity, error) {
+	for _, stored := range f.s.apiTokens {
+		if stored.tokenHash != tokenHash || stored.revoked {
+			continue
+		}
+		if !stored.expiresAt.IsZero() && !stored.expiresAt.After(time.Now()) {
+			return nil, errs.ErrApiTokenExpired
+		}
+		return &pb.ApiTokenIdentity{TokenId: stored.token.Id, UserId: stored.token.UserId, Scopes: stored.token.Scopes}, nil
+	}
+	return nil, errs.ErrApiTokenNotFound
+}
+
+type fakeRoles struct {
+	storage.IRolesStorage
+	s *fakeStorage
+}
+
+func (f fakeRoles) Create(ctx context.Context, request *pb.Role) (*pb.Role, error) {
+	if _, ok := f.s.roles[request.GetName()]; ok {
+		return nil, errs.ErrRoleExists
+	}
+	for _, permission := range request.GetPermissions() {
+		if !f.s.permissions[permission] {
+			return nil, errs.ErrPermissionNotFound
+		}
+	}
+
+	f.s.roles[request.GetName()] = request.GetPermissions()
+	return request, nil
+}
+
+func (f fakeRoles) Delete(ctx context.Context, request *pb.RoleName) (*pb.Void, error) {
+	if _, ok := f.s.roles[request.GetName()]; !ok {
+		return nil, errs.ErrRoleNotFound
+	}
+	for userId, roles := range f.s.userRoles {
+		if slices.Contains(roles, request.GetName()) || f.s.users[userId].GetUserRole() == request.GetName() {
+			return nil, errs.ErrRoleInUse
+		}
+	}
+
+	delete(f.s.roles, request.GetName())
+	return &pb.Void{}, nil
+}
+
+func (f fakeRoles) GetUserRoles(ctx context.Context, request *pb.PrimaryKey) (*pb.UserRoles, error) {
+	user, ok := f.s.users[request.GetId()]
+	if !ok {
+		return nil, errs.ErrUserNotFound
+	}
+
+	roles := append([]string{user.GetUserRole()}, f.s.userRoles[user.GetId()]...)
+	slices.Sort(roles)
+	return &pb.UserRoles{PrimaryRole: user.GetUserRole(), Roles: slices.Compact(roles)}, nil
+}
+
+func (f fakeRoles) HasPermission(ctx context.Context, request *pb.HasPermissionRequest) (bool, error) {
+	roles, err := f.GetUserRoles(ctx, &pb.PrimaryKey{Id: request.GetUserId()})
+	if err != nil {
+		return false, nil
+	}
+
+	for _, role := range roles.GetRoles() {
+		if slices.Contains(f.s.roles[role], request.GetPermission()) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+type fakeRoleChanges struct {
+	storage.IRoleChangesStorage
+	s *fakeStorage
+}
+
+func (f fakeRoleChanges) Apply(ctx context.Context, request *pb.RoleChange) (*pb.RoleChange, error) {
+	previousRole, err := f.apply(request)
+	if err != nil {
+		return nil, err
+	}
+
+	change := &pb.RoleChange{
+		Id:           fmt.Sprintf("role-change-%d", len(f.s.roleChanges)+1),
+		UserId:       request.GetUserId(),
+		Action:       request.GetAction(),
+		Role:         request.GetRole(),
+		PreviousRole: previousRole,
+		ActorId:      request.GetActorId(),
+		State:        "applied",
+	}
+	f.s.roleChanges = append(f.s.roleChanges, &fakeRoleChange{change: change})
+	return change, nil
+}
+
+func (f fakeRoleChanges) Request(ctx context.Context, request *pb.RoleChange, expiresAt time.Time) (*pb.RoleChange, error) {
+	if _, ok := f.s.roles[request.GetRole()]; !ok {
+		return nil, errs.ErrRoleNotFound
+	}
+	if _, ok := f.s.users[request.GetUserId()]; !ok {
+		return nil, errs.ErrUserNotFound
+	}
+
+	change := &pb.RoleChange{
+		Id:      fmt.Sprintf("role-change-%d", len(f.s.roleChanges)+1),
+		UserId:  request.GetUserId(),
+		Action:  request.GetAction(),
+		Role:    request.GetRole(),
+		ActorId: request.GetActorId(),
+		State:   "pending",
+	}
+	f.s.roleChanges = append(f.s.roleChanges, &fakeRoleChange{change: change, expiresAt: expiresAt})
+	return change, nil
+}
+
+func (f fakeRoleChanges) Approve(ctx context.Context, request *pb.PrimaryKey, approverId string) (*pb.RoleChange, error) {
+	for _, stored := range f.s.roleChanges {
+		if stored.change.Id != request.GetId() {
+			continue
+		}
+
+		switch {
+		case stored.change.State == "pending" && stored.expiresAt.Before(time.Now()):
+			return nil, errs.ErrRoleChangeExpired
+		case stored.change.State != "pending":
+			return nil, errs.ErrRoleChangeNotPending
+		case stored.change.ActorId == approverId:
+			return nil, errs.ErrSameApprover
+		}
+
+		previousRole, err := f.apply(stored.change)
+		if err != nil {
+			return nil, err
+		}
+
+		stored.change.State = "applied"
+		stored.change.PreviousRole = previousRole
+		stored.change.ApprovedBy = approverId
+		return stored.change, nil
+	}
+	return nil, errs.ErrRoleChangeNotFound
+}
+
+// apply makes a role change like the postgres repo, including its last admin guard
+func (f fakeRoleChanges) apply(change *pb.RoleChange) (string, error) {
+	user, ok := f.s.users[change.GetUserId()]
+	if !ok {
+		return "", errs.ErrUserNotFound
+	}
+	if _, ok = f.s.roles[change.GetRole()]; !ok && change.GetAction() != roleChangeUnassign {
+		return "", errs.ErrRoleNotFound
+	}
+
+	var (
+		previousRole = user.GetUserRole()
+		assigned     = f.s.userRoles[user.GetId()]
+	)
+
+	switch change.GetAction() {
+	case roleChangeSetPrimary:
+		user.UserRole = change.GetRole()
+	case roleChangeAssign:
+		if !slices.Contains(assigned, change.GetRole()) {
+			f.s.userRoles[user.GetId()] = append(assigned, change.GetRole())
+		}
+	case roleChangeUnassign:
+		i := slices.Index(assigned, change.GetRole())
+		if i < 0 {
+			return "", errs.ErrRoleNotAssigned
+		}
+		f.s.userRoles[user.GetId()] = slices.Delete(slices.Clone(assigned), i, i+1)
+	}
+
+	removesAdmin := (change.GetAction() == roleChangeSetPrimary && previousRole == caller.RoleAdmin) ||
+		(change.GetAction() == roleChangeUnassign && change.GetRole() == caller.RoleAdmin)
+	if !removesAdmin {
+		return previousRole, nil
+	}
+
+	if f.s.adminLeft() {
+		return previousRole, nil
+	}
+
+	// no admin is left, undo the change
+	user.UserRole = previousRole
+	f.s.userRoles[user.GetId()] = assigned
+	return "", errs.ErrLastAdmin
+}
+
+// adminLeft reports whether any user still holds the admin role
+func (s *fakeStorage) adminLeft() bool {
+	for id, user := range s.users {
+		if user.GetUserRole() == caller.RoleAdmin || slices.Contains(s.userRoles[id], caller.RoleAdmin) {
+			return true
+		}
+	}
+	return false
+}
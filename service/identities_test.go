@@ -0,0 +1,261 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"users_service/configs"
+	"users_service/pkg/oidc"
+
+	pb "users_service/genproto/users"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// newIdentityStorage links an identity of the fake provider to each of three users: user-1
+// has no password, user-2 uses mfa and user-3 also has a password
+func newIdentityStorage(t *testing.T) *fakeStorage {
+	t.Helper()
+
+	strg := newFakeStorage(t)
+	strg.users["user-2"] = &pb.User{Id: "user-2", Email: "bob@example.com", UserRole: "user", MfaEnabled: true}
+	strg.users["user-3"] = &pb.User{Id: "user-3", Email: "carl@example.com", UserRole: "user"}
+	strg.passwords["user-3"] = strg.passwords["user-1"]
+	delete(strg.passwords, "user-1")
+
+	strg.identities = []*pb.Identity{
+		{Id: "identity-1", UserId: "user-1", Provider: "fake", Subject: "subject-1"},
+		{Id: "identity-2", UserId: "user-2", Provider: "fake", Subject: "subject-2"},
+		{Id: "identity-3", UserId: "user-3", Provider: "fake", Subject: "subject-3"},
+	}
+	return strg
+}
+
+// newTestProvider returns a registry serving the fake provider and the provider to issue ID tokens with
+func newTestProvider(t *testing.T) (*oidc.Registry, *oidc.FakeProvider) {
+	t.Helper()
+
+	provider, err := oidc.NewFakeProvider("fake", "https://idp.test", "users_service")
+	if err != nil {
+		t.Fatalf("NewFakeProvider: %v", err)
+	}
+
+	idps, err := oidc.NewRegistry(&configs.Config{})
+	if err != nil {
+		t.Fatalf("NewRegistry: %v", err)
+	}
+	idps.Register(provider.Name, provider)
+
+	return idps, provider
+}
+
+func issueIDToken(t *testing.T, provider *oidc.FakeProvider, subject, email string, emailVerified bool) string {
+	t.Helper()
+
+	idToken, err := provider.IssueIDToken(subject, email, emailVerified)
+	if err != nil {
+		t.Fatalf("IssueIDToken: %v", err)
+	}
+	return idToken
+}
+
+func TestLoginWithProvider(t *testing.T) {
+	idps, provider := newTestProvider(t)
+
+	// an identically configured provider with another key, its tokens fail verification
+	impostor, err := oidc.NewFakeProvider("fake", provider.Issuer, provider.ClientId)
+	if err != nil {
+		t.Fatalf("NewFakeProvider: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		provider   string
+		idToken    string
+		wantCode   codes.Code
+		wantReason string
+		wantUser   string
+		wantMfa    bool
+		wantSignUp bool
+	}{
+		{"unknown provider", "other", issueIDToken(t, provider, "subject-1", "", false), codes.InvalidArgument, "", "", false, false},
+		{"token of another key", "fake", issueIDToken(t, impostor, "subject-1", "", false), codes.Unauthenticated, "ID_TOKEN_INVALID", "", false, false},
+		{"garbage token", "fake", "garbage", codes.Unauthenticated, "ID_TOKEN_INVALID", "", false, false},
+		{"linked identity", "fake", issueIDToken(t, provider, "subject-1", "", false), codes.OK, "", "user-1", false, false},
+		{"provider name ignores case", "FAKE", issueIDToken(t, provider, "subject-1", "", false), codes.OK, "", "user-1", false, false},
+		{"linked identity with mfa", "fake", issueIDToken(t, provider, "subject-2", "", false), codes.OK, "", "user-2", true, false},
+		{"sign up with verified email", "fake", issueIDToken(t, provider, "subject-9", "dana@example.com", true), codes.OK, "", "user-4", false, true},
+		{"unverified email does not sign up", "fake", issueIDToken(t, provider, "subject-9", "dana@example.com", false), codes.FailedPrecondition, "IDENTITY_NOT_LINKED", "", false, false},
+		{"no email does not sign up", "fake", issueIDToken(t, provider, "subject-9", "", true), codes.FailedPrecondition, "IDENTITY_NOT_LINKED", "", false, false},
+		{"email of an existing account", "fake", issueIDToken(t, provider, "subject-9", "anna@example.com", true), codes.FailedPrecondition, "ACCOUNT_EXISTS", "", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			strg := newIdentityStorage(t)
+			a := newTestAuthService(t, strg)
+			a.idps = idps
+
+			resp, err := a.LoginWithProvider(context.Background(), &pb.ProviderLoginRequest{
+				Provider:   tt.provider,
+				IdToken:    tt.idToken,
+				DeviceName: "test",
+			})
+			if code := status.Code(err); code != tt.wantCode {
+				t.Fatalf("LoginWithProvider() = %v, want %s", err, tt.wantCode)
+			}
+			if reason := errorReason(err); reason != tt.wantReason {
+				t.Fatalf("reason = %q, want %q", reason, tt.wantReason)
+			}
+			if tt.wantCode != codes.OK {
+				if len(strg.users) != 3 {
+					t.Fatal("a failed provider login created a user")
+				}
+				return
+			}
+
+			if tt.wantMfa {
+				claims, err := a.tokens.ParseMfaToken(resp.GetMfaToken())
+				if !resp.GetMfaRequired() || err != nil || claims.UserId != tt.wantUser {
+					t.Fatalf("LoginWithProvider() = %v, want an mfa token for %s", resp, tt.wantUser)
+				}
+				if len(strg.loginEvents) != 0 {
+					t.Fatal("a login waiting for mfa was recorded")
+				}
+				return
+			}
+
+			claims, err := a.tokens.ParseAccessToken(resp.GetAccessToken())
+			if err != nil {
+				t.Fatalf("ParseAccessToken: %v", err)
+			}
+			if claims.UserId != tt.wantUser {
+				t.Fatalf("logged in as %q, want %q", claims.UserId, tt.wantUser)
+			}
+			if resp.GetRefreshToken() == "" {
+				t.Fatal("no refresh token issued")
+			}
+
+			events := strg.loginEvents
+			if len(events) != 1 || !events[0].Success || events[0].Method != loginMethodProvider+"fake" {
+				t.Fatalf("login events = %v, want one successful %sfake login", events, loginMethodProvider)
+			}
+
+			if signedUp := len(strg.users) == 4; signedUp != tt.wantSignUp {
+				t.Fatalf("signed up = %v, want %v", signedUp, tt.wantSignUp)
+			}
+			if tt.wantSignUp {
+				if !strg.users[tt.wantUser].EmailVerified {
+					t.Fatal("the provider verified email was not marked verified")
+				}
+				if _, err := (fakeIdentities{s: strg}).GetBySubject(context.Background(), "fake", "subject-9"); err != nil {
+					t.Fatal("the identity was not linked to the new user")
+				}
+			}
+		})
+	}
+}
+
+// Accounts created through a provider have no password, password logins never match them
+func TestLoginWithoutPassword(t *testing.T) {
+	a := newTestAuthService(t, newIdentityStorage(t))
+
+	_, err := a.Login(context.Background(), &pb.LoginRequest{Email: "anna@example.com", Password: ""})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("Login() = %v, want %s", err, codes.Unauthenticated)
+	}
+}
+
+func TestLinkIdentity(t *testing.T) {
+	idps, provider := newTestProvider(t)
+
+	tests := []struct {
+		name       string
+		provider   string
+		idToken    string
+		wantCode   codes.Code
+		wantReason string
+	}{
+		{"new identity", "fake", issueIDToken(t, provider, "subject-9", "anna@work.example.com", true), codes.OK, ""},
+		{"linked to this user", "fake", issueIDToken(t, provider, "subject-1", "", false), codes.AlreadyExists, "IDENTITY_ALREADY_LINKED"},
+		{"linked to another user", "fake", issueIDToken(t, provider, "subject-2", "", false), codes.AlreadyExists, "IDENTITY_ALREADY_LINKED"},
+		{"unknown provider", "other", issueIDToken(t, provider, "subject-9", "", false), codes.InvalidArgument, ""},
+		{"invalid token", "fake", "garbage", codes.Unauthenticated, "ID_TOKEN_INVALID"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			strg := newIdentityStorage(t)
+			u := newTestUserService(t, strg)
+			u.idps = idps
+
+			resp, err := u.LinkIdentity(context.Background(), &pb.LinkIdentityRequest{
+				UserId:   "user-1",
+				Provider: tt.provider,
+				IdToken:  tt.idToken,
+			})
+			if code := status.Code(err); code != tt.wantCode {
+				t.Fatalf("LinkIdentity() = %v, want %s", err, tt.wantCode)
+			}
+			if reason := errorReason(err); reason != tt.wantReason {
+				t.Fatalf("reason = %q, want %q", reason, tt.wantReason)
+			}
+
+			if tt.wantCode == codes.OK {
+				if resp.GetUserId() != "user-1" || resp.GetProvider() != "fake" || resp.GetSubject() != "subject-9" || resp.GetEmail() != "anna@work.example.com" {
+					t.Fatalf("LinkIdentity() = %v, want subject-9 of fake linked to user-1", resp)
+				}
+			} else if len(strg.identities) != 3 {
+				t.Fatal("a failed link created an identity")
+			}
+		})
+	}
+}
+
+func TestUnlinkIdentity(t *testing.T) {
+	tests := []struct {
+		name       string
+		userId     string
+		provider   string
+		extra      *pb.Identity
+		wantCode   codes.Code
+		wantReason string
+	}{
+		{"user with a password", "user-3", "fake", nil, codes.OK, ""},
+		{"user with another identity", "user-1", "fake", &pb.Identity{UserId: "user-1", Provider: "other", Subject: "subject-8"}, codes.OK, ""},
+		{"last login method", "user-1", "fake", nil, codes.FailedPrecondition, "LAST_LOGIN_METHOD"},
+		{"provider not linked", "user-3", "other", nil, codes.NotFound, ""},
+		{"identity of another user", "user-4", "fake", nil, codes.NotFound, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			strg := newIdentityStorage(t)
+			if tt.extra != nil {
+				strg.identities = append(strg.identities, tt.extra)
+			}
+			linked := len(strg.identities)
+
+			u := newTestUserService(t, strg)
+
+			_, err := u.UnlinkIdentity(context.Background(), &pb.UnlinkIdentityRequest{
+				UserId:   tt.userId,
+				Provider: tt.provider,
+			})
+			if code := status.Code(err); code != tt.wantCode {
+				t.Fatalf("UnlinkIdentity() = %v, want %s", err, tt.wantCode)
+			}
+			if reason := errorReason(err); reason != tt.wantReason {
+				t.Fatalf("reason = %q, want %q", reason, tt.wantReason)
+			}
+
+			want := linked
+			if tt.wantCode == codes.OK {
+				want--
+			}
+			if len(strg.identities) != want {
+				t.Fatalf("%d identities left, want %d", len(strg.identities), want)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"users_service/configs"
+	"users_service/pkg/caller"
+	"users_service/pkg/helper"
+	"users_service/pkg/token"
+	"users_service/storage"
+
+	pb "users_service/genproto/users"
+)
+
+const (
+	impersonationDeviceName      = "impersonation"
+	impersonationMaxReasonLength = 500
+)
+
+var (
+	errImpersonationReason = errors.New("a reason is required to impersonate a user")
+	errImpersonateSelf     = errors.New("you can not impersonate yourself")
+	errNotImpersonable     = errors.New("admins and support users can not be impersonated")
+)
+
+// impersonation lets support users act as a customer for a short time. Every impersonation
+// opens a session the customer sees in its session list, its token names the support user
+// as the actor.
+type impersonation struct {
+	storage storage.IStorage
+	tokens  *token.Manager
+	ttl     time.Duration
+	maxTTL  time.Duration
+}
+
+func newImpersonation(storage storage.IStorage, tokens *token.Manager, cfg *configs.Config) *impersonation {
+	return &impersonation{
+		storage: storage,
+		tokens:  tokens,
+		ttl:     cfg.ImpersonationTTL,
+		maxTTL:  cfg.ImpersonationMaxTTL,
+	}
+}
+
+// duration returns how long a requested impersonation lasts, zero seconds asks for the default
+func (i *impersonation) duration(seconds int64) (time.Duration, error) {
+	if seconds == 0 {
+		return i.ttl, nil
+	}
+
+	ttl := time.Duration(seconds) * time.Second
+	if ttl < 0 || ttl > i.maxTTL {
+		return 0, fmt.Errorf("duration must be between 1 second and %s", i.maxTTL)
+	}
+	return ttl, nil
+}
+
+// start opens an impersonation session of the calling support user as the target user
+func (i *impersonation) start(ctx context.Context, request *pb.ImpersonateRequest, ttl time.Duration) (*pb.Session, string, time.Time, error) {
+	actor := caller.UserFrom(ctx)
+	if actor == nil {
+		return nil, "", time.Time{}, errNoActor
+	}
+
+	reason := strings.TrimSpace(request.GetReason())
+	if reason == "" || len(reason) > impersonationMaxReasonLength {
+		return nil, "", time.Time{}, errImpersonationReason
+	}
+
+	if request.GetTargetUserId() == actor.Id {
+		return nil, "", time.Time{}, errImpersonateSelf
+	}
+
+	user, err := i.storage.Users().GetById(ctx, &pb.PrimaryKey{Id: request.GetTargetUserId()})
+	if err != nil {
+		return nil, "", time.Time{}, err
+	}
+
+	roles, err := i.storage.Roles().GetUserRoles(ctx, &pb.PrimaryKey{Id: user.GetId()})
+	if err != nil {
+		return nil, "", time.Time{}, err
+	}
+
+	target := &caller.User{Id: user.GetId(), Role: roles.GetPrimaryRole(), Roles: roles.GetRoles()}
+	if target.IsAdmin() || target.HasRole(caller.RoleSupport) {
+		return nil, "", time.Time{}, errNotImpersonable
+	}
+
+	ip, userAgent := helper.ClientInfo(ctx)
+
+	session, err := i.storage.Sessions().CreateImpersonation(ctx, &pb.Session{
+		UserId:              user.GetId(),
+		DeviceName:          impersonationDeviceName,
+		UserAgent:           userAgent,
+		IpAddress:           ip,
+		ImpersonatorId:      actor.Id,
+		ImpersonationReason: reason,
+	}, time.Now().Add(ttl))
+	if err != nil {
+		return nil, "", time.Time{}, err
+	}
+
+	accessToken, expiresAt, err := i.tokens.GenerateImpersonationToken(user.GetId(), user.GetEmail(), user.GetUserRole(), session.GetId(), roles.GetRoles(), actor.Id, ttl)
+	if err != nil {
+		return nil, "", time.Time{}, err
+	}
+
+	return session, accessToken, expiresAt, nil
+}
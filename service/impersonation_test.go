@@ -0,0 +1,110 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+	"users_service/pkg/caller"
+
+	pb "users_service/genproto/users"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestImpersonate(t *testing.T) {
+	var (
+		strg = newAdminStorage(t)
+		a    = newTestAuthService(t, strg)
+		ctx  = caller.WithUser(context.Background(), &caller.User{Id: "support-1", Role: "user", Roles: []string{caller.RoleSupport, "user"}})
+	)
+
+	resp, err := a.Impersonate(ctx, &pb.ImpersonateRequest{TargetUserId: "user-1", Reason: " ticket 42 ", Duration: 600})
+	if err != nil {
+		t.Fatalf("Impersonate: %v", err)
+	}
+	if resp.GetRefreshToken() != "" {
+		t.Fatal("impersonation returned a refresh token")
+	}
+	if resp.GetExpiresIn() > 600 || resp.GetExpiresIn() < 590 {
+		t.Fatalf("impersonation expires in %ds, want the requested 600s", resp.GetExpiresIn())
+	}
+
+	claims, err := a.tokens.ParseAccessToken(resp.GetAccessToken())
+	if err != nil {
+		t.Fatalf("ParseAccessToken: %v", err)
+	}
+	if claims.UserId != "user-1" || claims.Act == nil || claims.Act.Subject != "support-1" {
+		t.Fatalf("token of %q acted by %+v, want user-1 acted by support-1", claims.UserId, claims.Act)
+	}
+
+	session := strg.sessions[len(strg.sessions)-1].session
+	if session.GetId() != claims.SessionId || session.GetImpersonatorId() != "support-1" || session.GetImpersonationReason() != "ticket 42" {
+		t.Fatalf("impersonation session %v, want the token's session of support-1 with the trimmed reason", session)
+	}
+}
+
+func TestImpersonateRejects(t *testing.T) {
+	support := &caller.User{Id: "support-1", Role: "user", Roles: []string{caller.RoleSupport, "user"}}
+
+	tests := []struct {
+		name       string
+		caller     *caller.User
+		request    *pb.ImpersonateRequest
+		wantCode   codes.Code
+		wantReason string
+	}{
+		{"no caller", nil, &pb.ImpersonateRequest{TargetUserId: "user-1", Reason: "ticket 42"}, codes.Unauthenticated, ""},
+		{"no reason", support, &pb.ImpersonateRequest{TargetUserId: "user-1", Reason: "  "}, codes.InvalidArgument, ""},
+		{"too long", support, &pb.ImpersonateRequest{TargetUserId: "user-1", Reason: "ticket 42", Duration: 7200}, codes.InvalidArgument, ""},
+		{"negative duration", support, &pb.ImpersonateRequest{TargetUserId: "user-1", Reason: "ticket 42", Duration: -1}, codes.InvalidArgument, ""},
+		{"self", support, &pb.ImpersonateRequest{TargetUserId: "support-1", Reason: "ticket 42"}, codes.InvalidArgument, ""},
+		{"unknown user", support, &pb.ImpersonateRequest{TargetUserId: "user-9", Reason: "ticket 42"}, codes.NotFound, ""},
+		{"admin", support, &pb.ImpersonateRequest{TargetUserId: "admin-1", Reason: "ticket 42"}, codes.PermissionDenied, "IMPERSONATION_NOT_ALLOWED"},
+		{"assigned admin", support, &pb.ImpersonateRequest{TargetUserId: "admin-2", Reason: "ticket 42"}, codes.PermissionDenied, "IMPERSONATION_NOT_ALLOWED"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				strg = newAdminStorage(t)
+				a    = newTestAuthService(t, strg)
+				ctx  = context.Background()
+			)
+			if tt.caller != nil {
+				ctx = caller.WithUser(ctx, tt.caller)
+			}
+
+			_, err := a.Impersonate(ctx, tt.request)
+			if status.Code(err) != tt.wantCode || errorReason(err) != tt.wantReason {
+				t.Fatalf("Impersonate() = %v, want %s %s", err, tt.wantCode, tt.wantReason)
+			}
+			if len(strg.sessions) != 0 {
+				t.Fatal("a refused impersonation opened a session")
+			}
+		})
+	}
+}
+
+func TestImpersonationDuration(t *testing.T) {
+	i := newImpersonation(nil, nil, newTestConfig())
+
+	tests := []struct {
+		seconds int64
+		want    time.Duration
+		wantErr bool
+	}{
+		{0, 15 * time.Minute, false},
+		{60, time.Minute, false},
+		{3600, time.Hour, false},
+		{3601, 0, true},
+		{-60, 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := i.duration(tt.seconds)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Fatalf("duration(%d) = %s, %v, want %s", tt.seconds, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package service
+
+import (
+	"context"
+	"time"
+	"users_service/configs"
+	"users_service/pkg/logger"
+	"users_service/storage"
+)
+
+// Janitor periodically purges expired and revoked data in batches
+type Janitor struct {
+	storage   storage.IStorage
+	log       logger.ILogger
+	interval  time.Duration
+	batchSize int
+
+	passwordHistoryCount     int
+	passwordHistoryRetention time.Duration
+	loginHistoryRetention    time.Duration
+	impersonationRetention   time.Duration
+	passwordlessStartWindow  time.Duration
+}
+
+func NewJanitor(storage storage.IStorage, cfg *configs.Config, log logger.ILogger) *Janitor {
+	return &Janitor{
+		storage:   storage,
+		log:       log,
+		interval:  cfg.TokenCleanupInterval,
+		batchSize: cfg.TokenCleanupBatchSize,
+
+		passwordHistoryCount:     cfg.PasswordHistoryCount,
+		passwordHistoryRetention: cfg.PasswordHistoryRetention,
+		loginHistoryRetention:    cfg.LoginHistoryRetention,
+		impersonationRetention:   cfg.ImpersonationRetention,
+		passwordlessStartWindow:  cfg.PasswordlessStartWindow,
+	}
+}
+
+// Run blocks until ctx is cancelled, cleaning up on every tick
+func (j *Janitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		j.cleanup(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (j *Janitor) cleanup(ctx context.Context) {
+	j.purge(ctx, "expired refresh tokens", j.storage.Auth().DeleteExpiredRefreshTokens)
+	j.purge(ctx, "expired sessions", func(ctx context.Context, limit int) (int64, error) {
+		// zero retention keeps impersonation sessions forever
+		var impersonationsBefore time.Time
+		if j.impersonationRetention > 0 {
+			impersonationsBefore = time.Now().Add(-j.impersonationRetention)
+		}
+		return j.storage.Sessions().DeleteExpired(ctx, impersonationsBefore, limit)
+	})
+	j.purge(ctx, "expired email verification tokens", j.storage.EmailVerification().DeleteExpired)
+	j.purge(ctx, "expired password reset tokens", j.storage.PasswordReset().DeleteExpired)
+	j.purge(ctx, "expired passwordless logins", func(ctx context.Context, limit int) (int64, error) {
+		return j.storage.Passwordless().DeleteExpired(ctx, time.Now().Add(-j.passwordlessStartWindow), limit)
+	})
+	j.purge(ctx, "expired signing keys", j.storage.SigningKeys().DeleteExpired)
+	j.purge(ctx, "expired api tokens", j.storage.ApiTokens().DeleteExpired)
+	j.purge(ctx, "expired password history", func(ctx context.Context, limit int) (int64, error) {
+		var olderThan time.Time
+		if j.passwordHistoryRetention > 0 {
+			olderThan = time.Now().Add(-j.passwordHistoryRetention)
+		}
+		return j.storage.Users().DeleteExpiredPasswordHistory(ctx, j.passwordHistoryCount, olderThan, limit)
+	})
+	// zero retention keeps the login history forever
+	if j.loginHistoryRetention > 0 {
+		j.purge(ctx, "expired login history", func(ctx context.Context, limit int) (int64, error) {
+			return j.storage.LoginEvents().DeleteExpired(ctx, time.Now().Add(-j.loginHistoryRetention), limit)
+		})
+	}
+}
+
+// purge calls deleteBatch until it removes less than a full batch
+func (j *Janitor) purge(ctx context.Context, name string, deleteBatch func(context.Context, int) (int64, error)) {
+	var total int64
+
+	for ctx.Err() == nil {
+		deleted, err := deleteBatch(ctx, j.batchSize)
+		if err != nil {
+			j.log.Error("error while purging "+name, logger.Error(err))
+			return
+		}
+
+		total += deleted
+		if deleted < int64(j.batchSize) {
+			break
+		}
+	}
+
+	if total > 0 {
+		j.log.Info("purged "+name, logger.Any("count", total))
+	}
+}
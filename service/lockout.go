@@ -0,0 +1,86 @@
+package service
+
+import (
+	"context"
+	"time"
+	"users_service/configs"
+	"users_service/storage"
+)
+
+// lockout locks accounts after repeated failed logins. Every failure past the threshold
+// doubles the lock duration, up to the configured maximum.
+type lockout struct {
+	storage   storage.IStorage
+	threshold int
+	baseDelay time.Duration
+	maxDelay  time.Duration
+}
+
+func newLockout(storage storage.IStorage, cfg *configs.Config) *lockout {
+	return &lockout{
+		storage:   storage,
+		threshold: cfg.LockoutThreshold,
+		baseDelay: cfg.LockoutBaseDelay,
+		maxDelay:  cfg.LockoutMaxDuration,
+	}
+}
+
+// lockedUntil returns until when the user is locked out, zero time when the user may log in
+func (l *lockout) lockedUntil(ctx context.Context, userId string) (time.Time, error) {
+	if l.threshold <= 0 {
+		return time.Time{}, nil
+	}
+
+	lockedUntil, err := l.storage.Auth().GetLockedUntil(ctx, userId)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if !lockedUntil.After(time.Now()) {
+		return time.Time{}, nil
+	}
+
+	return lockedUntil, nil
+}
+
+// fail records a failed attempt. When it locks the user it returns until when.
+func (l *lockout) fail(ctx context.Context, userId string) (time.Time, error) {
+	if l.threshold <= 0 {
+		return time.Time{}, nil
+	}
+
+	count, err := l.storage.Auth().RecordFailedLogin(ctx, userId)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if count < l.threshold {
+		return time.Time{}, nil
+	}
+
+	lockedUntil := time.Now().Add(l.duration(count))
+	if err = l.storage.Auth().LockUser(ctx, userId, lockedUntil); err != nil {
+		return time.Time{}, err
+	}
+
+	return lockedUntil, nil
+}
+
+// clear resets the user's failed attempts after a successful login
+func (l *lockout) clear(ctx context.Context, userId string) error {
+	_, err := l.storage.Auth().ResetFailedLogins(ctx, userId)
+	return err
+}
+
+// duration is the lock duration after count consecutive failures
+func (l *lockout) duration(count int) time.Duration {
+	delay := l.baseDelay
+	for i := l.threshold; i < count && delay < l.maxDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > l.maxDelay {
+		return l.maxDelay
+	}
+	return delay
+}
@@ -0,0 +1,102 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+	"users_service/configs"
+
+	pb "users_service/genproto/users"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestLockoutDuration(t *testing.T) {
+	l := newLockout(nil, &configs.Config{
+		LockoutThreshold:   3,
+		LockoutBaseDelay:   time.Minute,
+		LockoutMaxDuration: 10 * time.Minute,
+	})
+
+	tests := []struct {
+		count int
+		want  time.Duration
+	}{
+		{3, time.Minute},
+		{4, 2 * time.Minute},
+		{5, 4 * time.Minute},
+		{6, 8 * time.Minute},
+		{7, 10 * time.Minute},
+		{50, 10 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		if got := l.duration(tt.count); got != tt.want {
+			t.Errorf("duration(%d) = %s, want %s", tt.count, got, tt.want)
+		}
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	var (
+		ctx   = context.Background()
+		strg  = newFakeStorage(t)
+		a     = newTestAuthService(t, strg)
+		wrong = &pb.LoginRequest{Email: "anna@example.com", Password: "wrong password"}
+		right = &pb.LoginRequest{Email: "anna@example.com", Password: testPassword}
+	)
+
+	for i := 1; i < 3; i++ {
+		if _, err := a.Login(ctx, wrong); status.Code(err) != codes.Unauthenticated {
+			t.Fatalf("failed login %d = %v, want %s", i, err, codes.Unauthenticated)
+		}
+	}
+
+	_, err := a.Login(ctx, wrong)
+	if status.Code(err) != codes.ResourceExhausted || errorReason(err) != "ACCOUNT_LOCKED" {
+		t.Fatalf("third failed login = %v, want ACCOUNT_LOCKED", err)
+	}
+	if until := time.Until(strg.lockedUntil["user-1"]); until <= 0 || until > time.Minute {
+		t.Fatalf("locked for %s, want up to a minute", until)
+	}
+
+	if _, err = a.Login(ctx, right); errorReason(err) != "ACCOUNT_LOCKED" {
+		t.Fatalf("login of a locked account = %v, want ACCOUNT_LOCKED", err)
+	}
+
+	strg.lockedUntil["user-1"] = time.Now().Add(-time.Second)
+	if _, err = a.Login(ctx, wrong); errorReason(err) != "ACCOUNT_LOCKED" {
+		t.Fatalf("failed login after the lock expired = %v, want ACCOUNT_LOCKED", err)
+	}
+	if until := time.Until(strg.lockedUntil["user-1"]); until <= time.Minute {
+		t.Fatalf("locked for %s after another failure, want longer than a minute", until)
+	}
+
+	if _, err = newTestUserService(t, strg).UnlockUser(ctx, &pb.PrimaryKey{Id: "user-1"}); err != nil {
+		t.Fatalf("UnlockUser: %v", err)
+	}
+	if _, err = a.Login(ctx, right); err != nil {
+		t.Fatalf("Login after unlock: %v", err)
+	}
+}
+
+// A successful login starts the count of failed attempts over
+func TestLoginClearsFailedAttempts(t *testing.T) {
+	var (
+		ctx   = context.Background()
+		strg  = newFakeStorage(t)
+		a     = newTestAuthService(t, strg)
+		wrong = &pb.LoginRequest{Email: "anna@example.com", Password: "wrong password"}
+	)
+
+	for i := 0; i < 2; i++ {
+		_, _ = a.Login(ctx, wrong)
+	}
+	if _, err := a.Login(ctx, &pb.LoginRequest{Email: "anna@example.com", Password: testPassword}); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	if _, err := a.Login(ctx, wrong); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("failed login after a successful one = %v, want %s", err, codes.Unauthenticated)
+	}
+}
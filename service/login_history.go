@@ -0,0 +1,52 @@
+package service
+
+import (
+	"context"
+	"users_service/pkg/helper"
+	"users_service/pkg/logger"
+
+	pb "users_service/genproto/users"
+)
+
+const (
+	loginMethodPassword = "password"
+	loginMethodMfa      = "mfa"
+	loginMethodLink     = "passwordless_link"
+	loginMethodCode     = "passwordless_code"
+	// loginMethodProvider is followed by the provider name
+	loginMethodProvider = "oidc_"
+
+	loginFailureUnknownEmail     = "unknown_email"
+	loginFailureInvalidPassword  = "invalid_password"
+	loginFailureAccountLocked    = "account_locked"
+	loginFailureEmailNotVerified = "email_not_verified"
+	loginFailureInvalidMfaCode   = "invalid_mfa_code"
+	loginFailureInvalidCode      = "invalid_passwordless_code"
+	loginFailureInternalError    = "internal_error"
+)
+
+// loginAttempt identifies a login attempt in the login history
+type loginAttempt struct {
+	userId string
+	email  string
+	method string
+}
+
+// recordLogin stores the outcome of attempt, an empty reason means it succeeded.
+// Failing to record must not fail the login itself.
+func (a *authService) recordLogin(ctx context.Context, attempt loginAttempt, reason string) {
+
+	ip, userAgent := helper.ClientInfo(ctx)
+
+	if err := a.storage.LoginEvents().Create(ctx, &pb.LoginEvent{
+		UserId:        attempt.userId,
+		Email:         attempt.email,
+		Success:       reason == "",
+		FailureReason: reason,
+		Method:        attempt.method,
+		IpAddress:     ip,
+		UserAgent:     userAgent,
+	}); err != nil {
+		a.log.Error("error while recording login event in service layer", logger.Error(err))
+	}
+}
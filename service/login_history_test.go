@@ -0,0 +1,80 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	pb "users_service/genproto/users"
+)
+
+func TestLoginHistory(t *testing.T) {
+	var (
+		ctx  = context.Background()
+		strg = newFakeStorage(t)
+		a    = newTestAuthService(t, strg)
+	)
+
+	attempts := []*pb.LoginRequest{
+		{Email: "nobody@example.com", Password: testPassword},
+		{Email: "anna@example.com", Password: "wrong password"},
+		{Email: "anna@example.com", Password: testPassword},
+	}
+	for _, attempt := range attempts {
+		_, _ = a.Login(ctx, attempt)
+	}
+
+	want := []struct {
+		userId  string
+		email   string
+		success bool
+		reason  string
+	}{
+		{"", "nobody@example.com", false, loginFailureUnknownEmail},
+		{"user-1", "anna@example.com", false, loginFailureInvalidPassword},
+		{"user-1", "anna@example.com", true, ""},
+	}
+	if len(strg.loginEvents) != len(want) {
+		t.Fatalf("%d login events, want %d", len(strg.loginEvents), len(want))
+	}
+	for i, event := range strg.loginEvents {
+		w := want[i]
+		if event.GetUserId() != w.userId || event.GetEmail() != w.email || event.GetSuccess() != w.success ||
+			event.GetFailureReason() != w.reason || event.GetMethod() != loginMethodPassword {
+			t.Errorf("event %d = %+v, want %+v", i, event, w)
+		}
+	}
+
+	history, err := newTestUserService(t, strg).ListLoginHistory(ctx, &pb.LoginHistoryRequest{UserId: "user-1", Limit: 1})
+	if err != nil {
+		t.Fatalf("ListLoginHistory: %v", err)
+	}
+	if history.GetPage() != 1 || history.GetCount() != 2 || len(history.GetEvents()) != 1 || !history.GetEvents()[0].GetSuccess() {
+		t.Fatalf("ListLoginHistory() = %+v, want the newest of two events on page 1", history)
+	}
+}
+
+// A login whose session can not be created is recorded as failed and keeps the failed attempts
+func TestLoginSessionFailure(t *testing.T) {
+	var (
+		ctx  = context.Background()
+		strg = newFakeStorage(t)
+		a    = newTestAuthService(t, strg)
+	)
+	strg.failedLogins["user-1"] = 2
+	strg.sessionsErr = errors.New("connection refused")
+
+	if _, err := a.Login(ctx, &pb.LoginRequest{Email: "anna@example.com", Password: testPassword}); err == nil {
+		t.Fatal("Login succeeded without a session")
+	}
+
+	if len(strg.loginEvents) != 1 {
+		t.Fatalf("%d login events, want 1", len(strg.loginEvents))
+	}
+	if event := strg.loginEvents[0]; event.GetSuccess() || event.GetFailureReason() != loginFailureInternalError {
+		t.Fatalf("event = %+v, want failed with %s", event, loginFailureInternalError)
+	}
+	if strg.failedLogins["user-1"] != 2 {
+		t.Fatalf("failed logins = %d, want 2", strg.failedLogins["user-1"])
+	}
+}
@@ -0,0 +1,171 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"users_service/configs"
+	"users_service/pkg/errs"
+	"users_service/pkg/secret"
+	"users_service/pkg/token"
+	"users_service/pkg/totp"
+	"users_service/storage"
+
+	pb "users_service/genproto/users"
+)
+
+// recoveryCodeLength characters of base32 carry 50 random bits
+const recoveryCodeLength = 10
+
+var (
+	errMfaCodeInvalid    = errors.New("invalid mfa code")
+	errMfaAlreadyEnabled = errors.New("mfa already enabled")
+
+	recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+	recoveryCodeReplacer = strings.NewReplacer("-", "", " ", "")
+)
+
+// mfa enrolls TOTP secrets and checks second factor codes. Secrets are stored sealed
+// with the configured key, recovery codes only as digests.
+type mfa struct {
+	storage           storage.IStorage
+	tokens            *token.Manager
+	box               *secret.Box
+	issuer            string
+	recoveryCodeCount int
+}
+
+func newMfa(storage storage.IStorage, tokens *token.Manager, cfg *configs.Config) (*mfa, error) {
+	box, err := secret.NewBox(cfg.MfaEncryptionKey)
+	if err != nil {
+		return nil, fmt.Errorf("error while creating mfa secret box: %w", err)
+	}
+
+	return &mfa{
+		storage:           storage,
+		tokens:            tokens,
+		box:               box,
+		issuer:            cfg.MfaIssuer,
+		recoveryCodeCount: cfg.RecoveryCodeCount,
+	}, nil
+}
+
+// enroll stores a new unconfirmed secret for the user, it takes effect once confirmed with a code
+func (m *mfa) enroll(ctx context.Context, user *pb.User) (*pb.TotpEnrollment, error) {
+	totpSecret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, err := m.box.Seal(totpSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = m.storage.Mfa().SaveSecret(ctx, user.GetId(), sealed); err != nil {
+		return nil, err
+	}
+
+	return &pb.TotpEnrollment{
+		Secret:     totpSecret,
+		OtpauthUri: totp.URI(m.issuer, user.GetEmail(), totpSecret),
+	}, nil
+}
+
+// confirm enables mfa when code matches the enrolled secret and returns the first recovery codes
+func (m *mfa) confirm(ctx context.Context, userId, code string) ([]string, error) {
+	sealed, confirmed, err := m.storage.Mfa().GetSecret(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	if confirmed {
+		return nil, errMfaAlreadyEnabled
+	}
+
+	totpSecret, err := m.box.Open(sealed)
+	if err != nil {
+		return nil, err
+	}
+
+	step, ok := totp.Validate(totpSecret, code, time.Now())
+	if !ok {
+		return nil, errMfaCodeInvalid
+	}
+
+	codes, hashes, err := m.newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	if err = m.storage.Mfa().Enable(ctx, userId, step, hashes); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// verify reports whether code is a current TOTP code or an unused recovery code of the user.
+// Either is accepted only once.
+func (m *mfa) verify(ctx context.Context, userId, code string) (bool, error) {
+	sealed, confirmed, err := m.storage.Mfa().GetSecret(ctx, userId)
+	if err != nil {
+		return false, err
+	}
+	if !confirmed {
+		return false, errs.ErrMfaNotEnrolled
+	}
+
+	totpSecret, err := m.box.Open(sealed)
+	if err != nil {
+		return false, err
+	}
+
+	if step, ok := totp.Validate(totpSecret, code, time.Now()); ok {
+		return m.storage.Mfa().UseStep(ctx, userId, step)
+	}
+
+	return m.storage.Mfa().UseRecoveryCode(ctx, userId, m.tokens.Digest(normalizeRecoveryCode(code)))
+}
+
+// regenerate replaces every recovery code of the user with a fresh set
+func (m *mfa) regenerate(ctx context.Context, userId string) ([]string, error) {
+	codes, hashes, err := m.newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	if err = m.storage.Mfa().ReplaceRecoveryCodes(ctx, userId, hashes); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// newRecoveryCodes returns codes formatted for the user together with the digests to store
+func (m *mfa) newRecoveryCodes() ([]string, []string, error) {
+	var (
+		codes  = make([]string, 0, m.recoveryCodeCount)
+		hashes = make([]string, 0, m.recoveryCodeCount)
+	)
+
+	for i := 0; i < m.recoveryCodeCount; i++ {
+		buf := make([]byte, 8)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, nil, fmt.Errorf("error while generating recovery code: %w", err)
+		}
+
+		code := strings.ToLower(recoveryCodeEncoding.EncodeToString(buf))[:recoveryCodeLength]
+		codes = append(codes, code[:recoveryCodeLength/2]+"-"+code[recoveryCodeLength/2:])
+		hashes = append(hashes, m.tokens.Digest(code))
+	}
+
+	return codes, hashes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(recoveryCodeReplacer.Replace(code))
+}
@@ -0,0 +1,259 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+	"users_service/pkg/totp"
+
+	pb "users_service/genproto/users"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// totpCode computes the current RFC 6238 code of secret the way an authenticator app does
+func totpCode(t *testing.T, secret string) string {
+	t.Helper()
+
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		t.Fatalf("decoding totp secret: %v", err)
+	}
+
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(time.Now().Unix()/int64(totp.Period.Seconds())))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%06d", value%1000000)
+}
+
+// enableTotp enrolls and confirms TOTP for user-1 and returns the code it confirmed with
+// and the recovery codes
+func enableTotp(t *testing.T, u *userService) (string, []string) {
+	t.Helper()
+	ctx := context.Background()
+
+	enrollment, err := u.EnrollTotp(ctx, &pb.PrimaryKey{Id: "user-1"})
+	if err != nil {
+		t.Fatalf("EnrollTotp: %v", err)
+	}
+
+	code := totpCode(t, enrollment.GetSecret())
+	recoveryCodes, err := u.ConfirmTotp(ctx, &pb.TotpCodeRequest{UserId: "user-1", Code: code})
+	if err != nil {
+		t.Fatalf("ConfirmTotp: %v", err)
+	}
+
+	return code, recoveryCodes.GetCodes()
+}
+
+func TestEnableTotp(t *testing.T) {
+	var (
+		ctx  = context.Background()
+		strg = newFakeStorage(t)
+		u    = newTestUserService(t, strg)
+	)
+
+	enrollment, err := u.EnrollTotp(ctx, &pb.PrimaryKey{Id: "user-1"})
+	if err != nil {
+		t.Fatalf("EnrollTotp: %v", err)
+	}
+	if strg.totp["user-1"].secret == enrollment.GetSecret() {
+		t.Fatal("the totp secret was stored in plain")
+	}
+
+	_, err = u.ConfirmTotp(ctx, &pb.TotpCodeRequest{UserId: "user-1", Code: "000000"})
+	if status.Code(err) != codes.InvalidArgument || errorReason(err) != "MFA_CODE_INVALID" {
+		t.Fatalf("ConfirmTotp(wrong code) = %v, want MFA_CODE_INVALID", err)
+	}
+	if strg.users["user-1"].GetMfaEnabled() {
+		t.Fatal("mfa enabled by a wrong code")
+	}
+
+	recoveryCodes, err := u.ConfirmTotp(ctx, &pb.TotpCodeRequest{UserId: "user-1", Code: totpCode(t, enrollment.GetSecret())})
+	if err != nil {
+		t.Fatalf("ConfirmTotp: %v", err)
+	}
+	if len(recoveryCodes.GetCodes()) != 4 {
+		t.Fatalf("%d recovery codes, want 4", len(recoveryCodes.GetCodes()))
+	}
+	if !strg.users["user-1"].GetMfaEnabled() {
+		t.Fatal("mfa is not enabled")
+	}
+
+	if _, err = u.EnrollTotp(ctx, &pb.PrimaryKey{Id: "user-1"}); errorReason(err) != "MFA_ALREADY_ENABLED" {
+		t.Fatalf("EnrollTotp() again = %v, want MFA_ALREADY_ENABLED", err)
+	}
+}
+
+func TestLoginWithMfa(t *testing.T) {
+	var (
+		ctx  = context.Background()
+		strg = newFakeStorage(t)
+		a    = newTestAuthService(t, strg)
+	)
+	usedCode, recoveryCodes := enableTotp(t, newTestUserService(t, strg))
+
+	tokens, err := a.Login(ctx, &pb.LoginRequest{Email: "anna@example.com", Password: testPassword, DeviceName: "laptop"})
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	if !tokens.GetMfaRequired() || tokens.GetMfaToken() == "" || tokens.GetAccessToken() != "" || tokens.GetRefreshToken() != "" {
+		t.Fatalf("Login returned %+v, want only an mfa token", tokens)
+	}
+
+	tests := []struct {
+		name       string
+		mfaToken   string
+		code       string
+		wantReason string
+	}{
+		{"invalid mfa token", "not a token", recoveryCodes[0], "MFA_TOKEN_INVALID"},
+		{"wrong code", tokens.GetMfaToken(), "000000", "MFA_CODE_INVALID"},
+		{"replayed totp code", tokens.GetMfaToken(), usedCode, "MFA_CODE_INVALID"},
+		{"recovery code", tokens.GetMfaToken(), recoveryCodes[0], ""},
+		{"used recovery code", tokens.GetMfaToken(), recoveryCodes[0], "MFA_CODE_INVALID"},
+		{"recovery code typed in capitals without dash", tokens.GetMfaToken(), strings.ToUpper(strings.ReplaceAll(recoveryCodes[1], "-", "")), ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := a.VerifyMfa(ctx, &pb.VerifyMfaRequest{MfaToken: tt.mfaToken, Code: tt.code})
+			if tt.wantReason != "" {
+				if errorReason(err) != tt.wantReason {
+					t.Fatalf("VerifyMfa() = %v, want %s", err, tt.wantReason)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("VerifyMfa: %v", err)
+			}
+
+			claims, err := a.tokens.ParseAccessToken(resp.GetAccessToken())
+			if err != nil {
+				t.Fatalf("ParseAccessToken: %v", err)
+			}
+			if claims.UserId != "user-1" {
+				t.Fatalf("access token of %q, want user-1", claims.UserId)
+			}
+		})
+	}
+}
+
+func TestDisableTotp(t *testing.T) {
+	var (
+		ctx  = context.Background()
+		strg = newFakeStorage(t)
+		u    = newTestUserService(t, strg)
+	)
+	_, recoveryCodes := enableTotp(t, u)
+
+	_, err := u.DisableTotp(ctx, &pb.TotpCodeRequest{UserId: "user-1", Code: "000000"})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("DisableTotp(wrong code) = %v, want %s", err, codes.PermissionDenied)
+	}
+	if !strg.users["user-1"].GetMfaEnabled() {
+		t.Fatal("mfa disabled by a wrong code")
+	}
+
+	if _, err = u.DisableTotp(ctx, &pb.TotpCodeRequest{UserId: "user-1", Code: recoveryCodes[0]}); err != nil {
+		t.Fatalf("DisableTotp: %v", err)
+	}
+	if strg.users["user-1"].GetMfaEnabled() {
+		t.Fatal("mfa is still enabled")
+	}
+
+	tokens, err := newTestAuthService(t, strg).Login(ctx, &pb.LoginRequest{Email: "anna@example.com", Password: testPassword})
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	if tokens.GetMfaRequired() {
+		t.Fatal("login still asks for a second factor")
+	}
+}
+
+// Wrong two factor codes, TOTP or recovery, lock the account like failed logins do
+func TestMfaCodeLockout(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("confirm totp", func(t *testing.T) {
+		var (
+			strg = newFakeStorage(t)
+			u    = newTestUserService(t, strg)
+		)
+
+		enrollment, err := u.EnrollTotp(ctx, &pb.PrimaryKey{Id: "user-1"})
+		if err != nil {
+			t.Fatalf("EnrollTotp: %v", err)
+		}
+		code := totpCode(t, enrollment.GetSecret())
+
+		for i := 1; i < u.lockout.threshold; i++ {
+			_, err = u.ConfirmTotp(ctx, &pb.TotpCodeRequest{UserId: "user-1", Code: wrongTotpCode(code)})
+			if status.Code(err) != codes.InvalidArgument {
+				t.Fatalf("attempt %d: ConfirmTotp(wrong code) = %v, want %s", i, err, codes.InvalidArgument)
+			}
+		}
+		_, err = u.ConfirmTotp(ctx, &pb.TotpCodeRequest{UserId: "user-1", Code: wrongTotpCode(code)})
+		if errorReason(err) != "ACCOUNT_LOCKED" {
+			t.Fatalf("last wrong code = %v, want ACCOUNT_LOCKED", err)
+		}
+
+		_, err = u.ConfirmTotp(ctx, &pb.TotpCodeRequest{UserId: "user-1", Code: code})
+		if errorReason(err) != "ACCOUNT_LOCKED" {
+			t.Fatalf("right code while locked = %v, want ACCOUNT_LOCKED", err)
+		}
+		if strg.users["user-1"].GetMfaEnabled() {
+			t.Fatal("mfa enabled while the account is locked")
+		}
+	})
+
+	t.Run("disable totp", func(t *testing.T) {
+		var (
+			strg = newFakeStorage(t)
+			u    = newTestUserService(t, strg)
+		)
+		code, recoveryCodes := enableTotp(t, u)
+
+		wrongCodes := []string{wrongTotpCode(code), "wrong-recovery-code", wrongTotpCode(code)}
+		for i, wrong := range wrongCodes[:len(wrongCodes)-1] {
+			_, err := u.DisableTotp(ctx, &pb.TotpCodeRequest{UserId: "user-1", Code: wrong})
+			if status.Code(err) != codes.PermissionDenied {
+				t.Fatalf("attempt %d: DisableTotp(%q) = %v, want %s", i+1, wrong, err, codes.PermissionDenied)
+			}
+		}
+		_, err := u.DisableTotp(ctx, &pb.TotpCodeRequest{UserId: "user-1", Code: wrongCodes[len(wrongCodes)-1]})
+		if errorReason(err) != "ACCOUNT_LOCKED" {
+			t.Fatalf("last wrong code = %v, want ACCOUNT_LOCKED", err)
+		}
+
+		_, err = u.DisableTotp(ctx, &pb.TotpCodeRequest{UserId: "user-1", Code: recoveryCodes[0]})
+		if errorReason(err) != "ACCOUNT_LOCKED" {
+			t.Fatalf("recovery code while locked = %v, want ACCOUNT_LOCKED", err)
+		}
+		if !strg.users["user-1"].GetMfaEnabled() {
+			t.Fatal("mfa disabled while the account is locked")
+		}
+	})
+}
+
+// wrongTotpCode returns a six digit code that differs from code
+func wrongTotpCode(code string) string {
+	if code == "000000" {
+		return "111111"
+	}
+	return "000000"
+}
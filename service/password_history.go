@@ -0,0 +1,63 @@
+package service
+
+import (
+	"context"
+	"time"
+	"users_service/configs"
+	"users_service/pkg/password"
+	"users_service/storage"
+
+	pb "users_service/genproto/users"
+)
+
+// passwordHistory rejects new passwords that match one of the user's recent passwords
+type passwordHistory struct {
+	storage   storage.IStorage
+	hasher    password.Hasher
+	count     int
+	retention time.Duration
+}
+
+func newPasswordHistory(storage storage.IStorage, hasher password.Hasher, cfg *configs.Config) *passwordHistory {
+	return &passwordHistory{
+		storage:   storage,
+		hasher:    hasher,
+		count:     cfg.PasswordHistoryCount,
+		retention: cfg.PasswordHistoryRetention,
+	}
+}
+
+// check returns a history violation when newPassword was used within the last count passwords
+func (p *passwordHistory) check(ctx context.Context, userId, newPassword string) ([]password.Violation, error) {
+	if p.count <= 0 {
+		return nil, nil
+	}
+
+	hashes, err := p.storage.Users().GetPasswordHistory(ctx, &pb.PrimaryKey{Id: userId}, p.count, p.since())
+	if err != nil {
+		return nil, err
+	}
+
+	for _, hash := range hashes {
+		match, _, err := p.hasher.Verify(hash, newPassword)
+		if err != nil {
+			return nil, err
+		}
+		if match {
+			return []password.Violation{{
+				Rule:        password.RuleHistory,
+				Description: "password must differ from your recent passwords",
+			}}, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// since is the oldest time an entry is still retained, zero retention keeps entries forever
+func (p *passwordHistory) since() time.Time {
+	if p.retention <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(-p.retention)
+}
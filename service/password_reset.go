@@ -0,0 +1,65 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+	"users_service/configs"
+	"users_service/pkg/helper"
+	"users_service/pkg/mailer"
+	"users_service/pkg/token"
+	"users_service/storage"
+)
+
+// passwordResetter issues single use reset tokens and mails them as links
+type passwordResetter struct {
+	storage storage.IStorage
+	tokens  *token.Manager
+	mailer  mailer.Mailer
+	ttl     time.Duration
+	url     string
+}
+
+func newPasswordResetter(storage storage.IStorage, tokens *token.Manager, mailer mailer.Mailer, cfg *configs.Config) *passwordResetter {
+	return &passwordResetter{
+		storage: storage,
+		tokens:  tokens,
+		mailer:  mailer,
+		ttl:     cfg.PasswordResetTTL,
+		url:     cfg.PasswordResetURL,
+	}
+}
+
+// send stores a new reset token for the user and mails the reset link to email
+func (p *passwordResetter) send(ctx context.Context, userId, email string) error {
+	resetToken, err := token.RandomToken()
+	if err != nil {
+		return err
+	}
+
+	if err = p.storage.PasswordReset().Create(ctx, userId, p.tokens.Digest(resetToken), time.Now().Add(p.ttl)); err != nil {
+		return err
+	}
+
+	link, err := helper.TokenLink(p.url, resetToken)
+	if err != nil {
+		return fmt.Errorf("error while building password reset link: %w", err)
+	}
+
+	return p.mailer.Send(ctx, mailer.Message{
+		To:      email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Choose a new password by opening the link below:\n\n%s\n\nThe link expires in %s and can be used once. If you did not ask to reset your password, ignore this email.\n",
+			link, p.ttl),
+	})
+}
+
+// userId returns the owner of a still usable reset token
+func (p *passwordResetter) userId(ctx context.Context, resetToken string) (string, error) {
+	return p.storage.PasswordReset().GetUserId(ctx, p.tokens.Digest(resetToken))
+}
+
+// consume sets passwordHash as the password of the token's owner and signs the owner out everywhere
+func (p *passwordResetter) consume(ctx context.Context, resetToken, passwordHash string) (string, error) {
+	return p.storage.PasswordReset().Consume(ctx, p.tokens.Digest(resetToken), passwordHash)
+}
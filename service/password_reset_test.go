@@ -0,0 +1,90 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+	"users_service/pkg/mailer"
+
+	pb "users_service/genproto/users"
+)
+
+func TestPasswordReset(t *testing.T) {
+	var (
+		ctx  = context.Background()
+		strg = newFakeStorage(t)
+		a    = newTestAuthService(t, strg)
+	)
+
+	tokens, err := a.Login(ctx, &pb.LoginRequest{Email: "anna@example.com", Password: testPassword})
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+
+	if _, err = a.RequestPasswordReset(ctx, &pb.Email{Email: "anna@example.com"}); err != nil {
+		t.Fatalf("RequestPasswordReset: %v", err)
+	}
+	resetToken := lastMailedToken(t, a)
+
+	rejected := []struct {
+		name     string
+		password string
+	}{
+		{"too short", "short"},
+		{"recently used", testPassword},
+	}
+	for _, tt := range rejected {
+		_, err = a.ConfirmPasswordReset(ctx, &pb.ConfirmPasswordResetRequest{Token: resetToken, NewPassword: tt.password})
+		if errorReason(err) != "PASSWORD_POLICY_VIOLATION" {
+			t.Fatalf("%s: ConfirmPasswordReset() = %v, want PASSWORD_POLICY_VIOLATION", tt.name, err)
+		}
+	}
+
+	if _, err = a.ConfirmPasswordReset(ctx, &pb.ConfirmPasswordResetRequest{Token: resetToken, NewPassword: "brand new password"}); err != nil {
+		t.Fatalf("ConfirmPasswordReset: %v", err)
+	}
+
+	if _, err = a.ConfirmPasswordReset(ctx, &pb.ConfirmPasswordResetRequest{Token: resetToken, NewPassword: "another new password"}); errorReason(err) != "RESET_TOKEN_INVALID" {
+		t.Fatalf("reused ConfirmPasswordReset() = %v, want RESET_TOKEN_INVALID", err)
+	}
+	if _, err = a.Refresh(ctx, &pb.RequestRefreshToken{RefreshToken: tokens.GetRefreshToken()}); err == nil {
+		t.Fatal("refresh token survived the password reset")
+	}
+	if _, err = a.Login(ctx, &pb.LoginRequest{Email: "anna@example.com", Password: testPassword}); err == nil {
+		t.Fatal("login with the old password succeeded")
+	}
+	if _, err = a.Login(ctx, &pb.LoginRequest{Email: "anna@example.com", Password: "brand new password"}); err != nil {
+		t.Fatalf("Login with the new password: %v", err)
+	}
+}
+
+func TestConfirmPasswordResetExpired(t *testing.T) {
+	var (
+		ctx  = context.Background()
+		strg = newFakeStorage(t)
+		a    = newTestAuthService(t, strg)
+	)
+
+	if _, err := a.RequestPasswordReset(ctx, &pb.Email{Email: "anna@example.com"}); err != nil {
+		t.Fatalf("RequestPasswordReset: %v", err)
+	}
+	for _, reset := range strg.resets {
+		reset.expiresAt = time.Now().Add(-time.Minute)
+	}
+
+	_, err := a.ConfirmPasswordReset(ctx, &pb.ConfirmPasswordResetRequest{Token: lastMailedToken(t, a), NewPassword: "brand new password"})
+	if errorReason(err) != "RESET_TOKEN_EXPIRED" {
+		t.Fatalf("ConfirmPasswordReset() = %v, want RESET_TOKEN_EXPIRED", err)
+	}
+}
+
+func TestRequestPasswordResetUnknownEmail(t *testing.T) {
+	a := newTestAuthService(t, newFakeStorage(t))
+
+	if _, err := a.RequestPasswordReset(context.Background(), &pb.Email{Email: "nobody@example.com"}); err != nil {
+		t.Fatalf("RequestPasswordReset: %v", err)
+	}
+	if messages := a.resets.mailer.(*mailer.MemoryMailer).Messages(); len(messages) != 0 {
+		t.Fatalf("%d emails sent, want none", len(messages))
+	}
+}
@@ -0,0 +1,121 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"users_service/configs"
+	"users_service/pkg/errs"
+	"users_service/pkg/helper"
+	"users_service/pkg/mailer"
+	"users_service/pkg/token"
+	"users_service/storage"
+)
+
+const (
+	passwordlessMethodLink = "link"
+	passwordlessMethodCode = "code"
+
+	passwordlessCodeDigits = 6
+)
+
+// passwordless opens single use login challenges and mails them as a link or a code
+type passwordless struct {
+	storage     storage.IStorage
+	tokens      *token.Manager
+	mailer      mailer.Mailer
+	ttl         time.Duration
+	url         string
+	maxAttempts int
+	startLimit  int
+	ipLimit     int
+	startWindow time.Duration
+}
+
+func newPasswordless(storage storage.IStorage, tokens *token.Manager, mailer mailer.Mailer, cfg *configs.Config) *passwordless {
+	return &passwordless{
+		storage:     storage,
+		tokens:      tokens,
+		mailer:      mailer,
+		ttl:         cfg.PasswordlessTTL,
+		url:         cfg.PasswordlessURL,
+		maxAttempts: cfg.PasswordlessMaxAttempts,
+		startLimit:  cfg.PasswordlessStartLimit,
+		ipLimit:     cfg.PasswordlessStartIpLimit,
+		startWindow: cfg.PasswordlessStartWindow,
+	}
+}
+
+// start opens a challenge and mails it to email, it returns the challenge id. Unknown emails
+// get a challenge too, one that is never mailed and can never be completed. The challenge
+// replaces the user's open ones. Starting more than startLimit for the email, registered or
+// not, or more than ipLimit from the client ip in startWindow fails with ErrPasswordlessRateLimited.
+func (p *passwordless) start(ctx context.Context, userId, email, method string) (string, error) {
+	var (
+		expiresAt = time.Now().Add(p.ttl)
+		since     = time.Now().Add(-p.startWindow)
+		emailHash = p.tokens.Digest(strings.ToLower(strings.TrimSpace(email)))
+		ip, _     = helper.ClientInfo(ctx)
+	)
+
+	if method == passwordlessMethodCode {
+		code, err := token.RandomCode(passwordlessCodeDigits)
+		if err != nil {
+			return "", err
+		}
+
+		challengeId, err := p.storage.Passwordless().Create(ctx, userId, p.tokens.Digest(code), expiresAt, emailHash, ip, since, p.startLimit, p.ipLimit)
+		if err != nil || userId == "" {
+			return challengeId, err
+		}
+
+		return challengeId, p.mailer.Send(ctx, mailer.Message{
+			To:      email,
+			Subject: "Your sign in code",
+			Body: fmt.Sprintf("Your sign in code is %s\n\nIt expires in %s. If you did not try to sign in, ignore this email.\n",
+				code, p.ttl),
+		})
+	}
+
+	challengeId, err := p.storage.Passwordless().Create(ctx, userId, "", expiresAt, emailHash, ip, since, p.startLimit, p.ipLimit)
+	if err != nil || userId == "" {
+		return challengeId, err
+	}
+
+	linkToken, err := p.tokens.GenerateLoginLinkToken(userId, challengeId)
+	if err != nil {
+		return "", err
+	}
+
+	link, err := helper.TokenLink(p.url, linkToken)
+	if err != nil {
+		return "", fmt.Errorf("error while building passwordless login link: %w", err)
+	}
+
+	return challengeId, p.mailer.Send(ctx, mailer.Message{
+		To:      email,
+		Subject: "Sign in link",
+		Body: fmt.Sprintf("Sign in by opening the link below:\n\n%s\n\nThe link expires in %s and can be used once. If you did not try to sign in, ignore this email.\n",
+			link, p.ttl),
+	})
+}
+
+// completeLink consumes the challenge of a link token and returns its user
+func (p *passwordless) completeLink(ctx context.Context, linkToken string) (string, error) {
+	claims, err := p.tokens.ParseLoginLinkToken(linkToken)
+	if errors.Is(err, token.ErrTokenExpired) {
+		return "", errs.ErrPasswordlessExpired
+	}
+	if err != nil {
+		return "", errs.ErrPasswordlessNotFound
+	}
+
+	return p.storage.Passwordless().ConsumeLink(ctx, claims.ID)
+}
+
+// completeCode consumes a code challenge and returns its user, a wrong code returns it too
+func (p *passwordless) completeCode(ctx context.Context, challengeId, code string) (string, error) {
+	return p.storage.Passwordless().ConsumeCode(ctx, challengeId, p.tokens.Digest(code), p.maxAttempts)
+}
@@ -0,0 +1,276 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+	"users_service/pkg/helper"
+	"users_service/pkg/mailer"
+
+	pb "users_service/genproto/users"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// lastMailedCode returns the sign in code of the last email sent by a
+func lastMailedCode(t *testing.T, a *authService) string {
+	t.Helper()
+
+	messages := a.links.mailer.(*mailer.MemoryMailer).Messages()
+	if len(messages) == 0 {
+		t.Fatal("no email was sent")
+	}
+
+	body := messages[len(messages)-1].Body
+	_, rest, ok := strings.Cut(body, "Your sign in code is ")
+	if !ok || len(rest) < passwordlessCodeDigits {
+		t.Fatalf("the email has no sign in code: %q", body)
+	}
+	return rest[:passwordlessCodeDigits]
+}
+
+func TestPasswordlessLink(t *testing.T) {
+	var (
+		ctx = context.Background()
+		a   = newTestAuthService(t, newFakeStorage(t))
+	)
+
+	if _, err := a.StartPasswordlessLogin(ctx, &pb.StartPasswordlessLoginRequest{Email: "anna@example.com"}); err != nil {
+		t.Fatalf("StartPasswordlessLogin: %v", err)
+	}
+	linkToken := lastMailedToken(t, a)
+
+	tokens, err := a.CompletePasswordlessLogin(ctx, &pb.CompletePasswordlessLoginRequest{Token: linkToken, DeviceName: "phone"})
+	if err != nil {
+		t.Fatalf("CompletePasswordlessLogin: %v", err)
+	}
+	claims, err := a.tokens.ParseAccessToken(tokens.GetAccessToken())
+	if err != nil {
+		t.Fatalf("ParseAccessToken: %v", err)
+	}
+	if claims.UserId != "user-1" {
+		t.Fatalf("access token of %q, want user-1", claims.UserId)
+	}
+
+	_, err = a.CompletePasswordlessLogin(ctx, &pb.CompletePasswordlessLoginRequest{Token: linkToken})
+	if errorReason(err) != "PASSWORDLESS_INVALID" {
+		t.Fatalf("reused link = %v, want PASSWORDLESS_INVALID", err)
+	}
+}
+
+func TestPasswordlessCode(t *testing.T) {
+	var (
+		ctx = context.Background()
+		a   = newTestAuthService(t, newFakeStorage(t))
+	)
+
+	challenge, err := a.StartPasswordlessLogin(ctx, &pb.StartPasswordlessLoginRequest{Email: "anna@example.com", Method: passwordlessMethodCode})
+	if err != nil {
+		t.Fatalf("StartPasswordlessLogin: %v", err)
+	}
+	if challenge.GetExpiresIn() != int64((10 * time.Minute).Seconds()) {
+		t.Fatalf("challenge expires in %ds, want 600", challenge.GetExpiresIn())
+	}
+	code := lastMailedCode(t, a)
+
+	if _, err = a.CompletePasswordlessLogin(ctx, &pb.CompletePasswordlessLoginRequest{ChallengeId: challenge.GetChallengeId(), Code: "wrong"}); errorReason(err) != "PASSWORDLESS_INVALID" {
+		t.Fatalf("wrong code = %v, want PASSWORDLESS_INVALID", err)
+	}
+	if _, err = a.CompletePasswordlessLogin(ctx, &pb.CompletePasswordlessLoginRequest{ChallengeId: challenge.GetChallengeId(), Code: code}); err != nil {
+		t.Fatalf("CompletePasswordlessLogin: %v", err)
+	}
+	if _, err = a.CompletePasswordlessLogin(ctx, &pb.CompletePasswordlessLoginRequest{ChallengeId: challenge.GetChallengeId(), Code: code}); errorReason(err) != "PASSWORDLESS_INVALID" {
+		t.Fatalf("reused code = %v, want PASSWORDLESS_INVALID", err)
+	}
+}
+
+// The challenge is burnt after too many wrong codes, the right one no longer works either
+func TestPasswordlessCodeAttempts(t *testing.T) {
+	var (
+		ctx = context.Background()
+		a   = newTestAuthService(t, newFakeStorage(t))
+	)
+	a.lockout.threshold = 10
+
+	challenge, err := a.StartPasswordlessLogin(ctx, &pb.StartPasswordlessLoginRequest{Email: "anna@example.com", Method: passwordlessMethodCode})
+	if err != nil {
+		t.Fatalf("StartPasswordlessLogin: %v", err)
+	}
+	code := lastMailedCode(t, a)
+
+	wantReasons := []string{"PASSWORDLESS_INVALID", "PASSWORDLESS_INVALID", "PASSWORDLESS_ATTEMPTS_EXCEEDED"}
+	for i, want := range wantReasons {
+		_, err = a.CompletePasswordlessLogin(ctx, &pb.CompletePasswordlessLoginRequest{ChallengeId: challenge.GetChallengeId(), Code: "wrong"})
+		if errorReason(err) != want {
+			t.Fatalf("wrong code %d = %v, want %s", i+1, err, want)
+		}
+	}
+
+	if _, err = a.CompletePasswordlessLogin(ctx, &pb.CompletePasswordlessLoginRequest{ChallengeId: challenge.GetChallengeId(), Code: code}); errorReason(err) != "PASSWORDLESS_INVALID" {
+		t.Fatalf("right code after the challenge was burnt = %v, want PASSWORDLESS_INVALID", err)
+	}
+}
+
+// Wrong codes count as failed logins of the challenge's user and lock the account like wrong passwords
+func TestPasswordlessCodeLockout(t *testing.T) {
+	var (
+		ctx  = context.Background()
+		strg = newFakeStorage(t)
+		a    = newTestAuthService(t, strg)
+	)
+	a.links.maxAttempts = 10
+
+	challenge, err := a.StartPasswordlessLogin(ctx, &pb.StartPasswordlessLoginRequest{Email: "anna@example.com", Method: passwordlessMethodCode})
+	if err != nil {
+		t.Fatalf("StartPasswordlessLogin: %v", err)
+	}
+
+	for i := 1; i <= a.lockout.threshold; i++ {
+		_, err = a.CompletePasswordlessLogin(ctx, &pb.CompletePasswordlessLoginRequest{ChallengeId: challenge.GetChallengeId(), Code: "wrong"})
+		if i < a.lockout.threshold && errorReason(err) != "PASSWORDLESS_INVALID" {
+			t.Fatalf("wrong code %d = %v, want PASSWORDLESS_INVALID", i, err)
+		}
+	}
+	if errorReason(err) != "ACCOUNT_LOCKED" {
+		t.Fatalf("wrong code %d = %v, want ACCOUNT_LOCKED", a.lockout.threshold, err)
+	}
+	if strg.lockedUntil["user-1"].IsZero() {
+		t.Fatal("the account is not locked")
+	}
+	if events := strg.loginEvents; len(events) == 0 || events[len(events)-1].GetFailureReason() != loginFailureInvalidCode {
+		t.Fatalf("login events %v, want the last one failed with %s", events, loginFailureInvalidCode)
+	}
+}
+
+// Starts past the limit are refused without storing a challenge, a new start replaces the open challenge
+func TestPasswordlessStartLimit(t *testing.T) {
+	var (
+		ctx    = context.Background()
+		strg   = newFakeStorage(t)
+		a      = newTestAuthService(t, strg)
+		mailed = func() int { return len(a.links.mailer.(*mailer.MemoryMailer).Messages()) }
+		start  = &pb.StartPasswordlessLoginRequest{Email: "anna@example.com", Method: passwordlessMethodCode}
+	)
+
+	first, err := a.StartPasswordlessLogin(ctx, start)
+	if err != nil {
+		t.Fatalf("StartPasswordlessLogin: %v", err)
+	}
+	code := lastMailedCode(t, a)
+
+	for i := 2; i <= a.links.startLimit; i++ {
+		if _, err = a.StartPasswordlessLogin(ctx, start); err != nil {
+			t.Fatalf("StartPasswordlessLogin %d: %v", i, err)
+		}
+	}
+	if mailed() != a.links.startLimit {
+		t.Fatalf("%d emails sent, want %d", mailed(), a.links.startLimit)
+	}
+
+	_, err = a.CompletePasswordlessLogin(ctx, &pb.CompletePasswordlessLoginRequest{ChallengeId: first.GetChallengeId(), Code: code})
+	if errorReason(err) != "PASSWORDLESS_INVALID" {
+		t.Fatalf("code of a replaced challenge = %v, want PASSWORDLESS_INVALID", err)
+	}
+
+	_, err = a.StartPasswordlessLogin(ctx, start)
+	if code := status.Code(err); code != codes.ResourceExhausted || errorReason(err) != "PASSWORDLESS_RATE_LIMITED" {
+		t.Fatalf("StartPasswordlessLogin past the limit = %v, want PASSWORDLESS_RATE_LIMITED", err)
+	}
+	if len(strg.challenges) != a.links.startLimit {
+		t.Fatalf("%d challenges stored, want none past the limit", len(strg.challenges)-a.links.startLimit)
+	}
+	if mailed() != a.links.startLimit {
+		t.Fatalf("%d emails sent, want none past the limit", mailed()-a.links.startLimit)
+	}
+}
+
+// Unknown emails are limited like registered ones, so the limit does not tell them apart
+func TestPasswordlessStartLimitUnknownEmail(t *testing.T) {
+	var (
+		ctx   = context.Background()
+		strg  = newFakeStorage(t)
+		a     = newTestAuthService(t, strg)
+		start = &pb.StartPasswordlessLoginRequest{Email: "nobody@example.com", Method: passwordlessMethodCode}
+	)
+
+	for i := 1; i <= a.links.startLimit; i++ {
+		if _, err := a.StartPasswordlessLogin(ctx, start); err != nil {
+			t.Fatalf("StartPasswordlessLogin %d: %v", i, err)
+		}
+	}
+
+	_, err := a.StartPasswordlessLogin(ctx, &pb.StartPasswordlessLoginRequest{Email: " Nobody@Example.com ", Method: passwordlessMethodCode})
+	if errorReason(err) != "PASSWORDLESS_RATE_LIMITED" {
+		t.Fatalf("StartPasswordlessLogin past the limit = %v, want PASSWORDLESS_RATE_LIMITED", err)
+	}
+	if len(strg.challenges) != a.links.startLimit {
+		t.Fatalf("%d challenges stored, want %d", len(strg.challenges), a.links.startLimit)
+	}
+}
+
+// A client ip can only start ipLimit logins, whatever emails it starts them for
+func TestPasswordlessStartIpLimit(t *testing.T) {
+	var (
+		strg = newFakeStorage(t)
+		a    = newTestAuthService(t, strg)
+		from = func(ip string) context.Context {
+			ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 4242}})
+			return helper.WithClientInfo(ctx, nil)
+		}
+		start = func(ctx context.Context, i int) error {
+			_, err := a.StartPasswordlessLogin(ctx, &pb.StartPasswordlessLoginRequest{Email: fmt.Sprintf("nobody%d@example.com", i)})
+			return err
+		}
+	)
+
+	for i := 1; i <= a.links.ipLimit; i++ {
+		if err := start(from("203.0.113.7"), i); err != nil {
+			t.Fatalf("StartPasswordlessLogin %d: %v", i, err)
+		}
+	}
+
+	if err := start(from("203.0.113.7"), a.links.ipLimit+1); errorReason(err) != "PASSWORDLESS_RATE_LIMITED" {
+		t.Fatalf("StartPasswordlessLogin past the ip limit = %v, want PASSWORDLESS_RATE_LIMITED", err)
+	}
+	if err := start(from("198.51.100.1"), a.links.ipLimit+1); err != nil {
+		t.Fatalf("StartPasswordlessLogin from another ip: %v", err)
+	}
+}
+
+// Unknown emails get a challenge like everyone else, but no email and no way to complete it
+func TestPasswordlessUnknownEmail(t *testing.T) {
+	var (
+		ctx = context.Background()
+		a   = newTestAuthService(t, newFakeStorage(t))
+	)
+
+	challenge, err := a.StartPasswordlessLogin(ctx, &pb.StartPasswordlessLoginRequest{Email: "nobody@example.com", Method: passwordlessMethodCode})
+	if err != nil {
+		t.Fatalf("StartPasswordlessLogin: %v", err)
+	}
+	if challenge.GetChallengeId() == "" {
+		t.Fatal("no challenge id for an unknown email")
+	}
+	if messages := a.links.mailer.(*mailer.MemoryMailer).Messages(); len(messages) != 0 {
+		t.Fatalf("%d emails sent, want none", len(messages))
+	}
+
+	_, err = a.CompletePasswordlessLogin(ctx, &pb.CompletePasswordlessLoginRequest{ChallengeId: challenge.GetChallengeId(), Code: "000000"})
+	if errorReason(err) != "PASSWORDLESS_INVALID" {
+		t.Fatalf("CompletePasswordlessLogin() = %v, want PASSWORDLESS_INVALID", err)
+	}
+}
+
+func TestStartPasswordlessLoginMethod(t *testing.T) {
+	a := newTestAuthService(t, newFakeStorage(t))
+
+	_, err := a.StartPasswordlessLogin(context.Background(), &pb.StartPasswordlessLoginRequest{Email: "anna@example.com", Method: "sms"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("StartPasswordlessLogin() = %v, want %s", err, codes.InvalidArgument)
+	}
+}
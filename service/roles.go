@@ -0,0 +1,134 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"time"
+	"users_service/configs"
+	"users_service/pkg/caller"
+	"users_service/storage"
+
+	pb "users_service/genproto/users"
+)
+
+// builtinRoles are seeded by the migrations and relied on by the code, they can not be deleted
+var builtinRoles = []string{"user", caller.RoleAdmin, caller.RoleSupport}
+
+var (
+	roleName       = regexp.MustCompile(`^[a-z][a-z0-9_-]*$`)
+	permissionName = regexp.MustCompile(`^[a-z][a-z_]*:[a-z][a-z_]*$`)
+)
+
+const roleMaxNameLength = 100
+
+// validRoleName ...
+func validRoleName(name string) error {
+	if len(name) > roleMaxNameLength || !roleName.MatchString(name) {
+		return fmt.Errorf("invalid role name %q, use lower case letters, digits, - and _", name)
+	}
+	return nil
+}
+
+// rolePermissions validates permissions and returns them sorted without duplicates
+func rolePermissions(permissions []string) ([]string, error) {
+	for _, permission := range permissions {
+		if err := validPermissionName(permission); err != nil {
+			return nil, err
+		}
+	}
+
+	permissions = slices.Clone(permissions)
+	slices.Sort(permissions)
+	return slices.Compact(permissions), nil
+}
+
+// validPermissionName ...
+func validPermissionName(name string) error {
+	if len(name) > roleMaxNameLength || !permissionName.MatchString(name) {
+		return fmt.Errorf("invalid permission %q, permissions look like resource:action", name)
+	}
+	return nil
+}
+
+const (
+	roleChangeSetPrimary = "set_primary"
+	roleChangeAssign     = "assign"
+	roleChangeUnassign   = "unassign"
+)
+
+var (
+	errApprovalRequired = errors.New("granting admin needs another admin's approval")
+	// errNoActor is returned by actions that are recorded with the user calling them
+	errNoActor = errors.New("no authenticated caller")
+)
+
+// roleChanges makes role changes on behalf of the calling admin. With approval on, granting
+// admin waits for a second admin instead of being applied right away.
+type roleChanges struct {
+	storage    storage.IStorage
+	approval   bool
+	requestTTL time.Duration
+}
+
+func newRoleChanges(storage storage.IStorage, cfg *configs.Config) *roleChanges {
+	return &roleChanges{
+		storage:    storage,
+		approval:   cfg.RoleChangeApproval,
+		requestTTL: cfg.RoleChangeRequestTTL,
+	}
+}
+
+// apply applies a role change right away
+func (r *roleChanges) apply(ctx context.Context, action, userId, role string) (*pb.RoleChange, error) {
+	if r.approval && action != roleChangeUnassign && role == caller.RoleAdmin {
+		return nil, errApprovalRequired
+	}
+
+	return r.storage.RoleChanges().Apply(ctx, &pb.RoleChange{
+		UserId:  userId,
+		Action:  action,
+		Role:    role,
+		ActorId: actorOf(ctx),
+	})
+}
+
+// request records a role change that another admin has to approve
+func (r *roleChanges) request(ctx context.Context, request *pb.RoleChangeRequest) (*pb.RoleChange, error) {
+	actor := actorOf(ctx)
+	if actor == "" {
+		return nil, errNoActor
+	}
+
+	action := roleChangeSetPrimary
+	if request.GetAssign() {
+		action = roleChangeAssign
+	}
+
+	return r.storage.RoleChanges().Request(ctx, &pb.RoleChange{
+		UserId:  request.GetUserId(),
+		Action:  action,
+		Role:    request.GetRole(),
+		ActorId: actor,
+	}, time.Now().Add(r.requestTTL))
+}
+
+// approve applies a pending role change requested by another admin
+func (r *roleChanges) approve(ctx context.Context, request *pb.PrimaryKey) (*pb.RoleChange, error) {
+	approver := actorOf(ctx)
+	if approver == "" {
+		return nil, errNoActor
+	}
+
+	return r.storage.RoleChanges().Approve(ctx, request, approver)
+}
+
+// actorOf returns the id of the calling user
+func actorOf(ctx context.Context) string {
+	if user := caller.UserFrom(ctx); user != nil {
+		return user.Id
+	}
+	return ""
+}
@@ -0,0 +1,294 @@
+package service
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+	"users_service/pkg/caller"
+
+	pb "users_service/genproto/users"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRolePermissions(t *testing.T) {
+	tests := []struct {
+		name        string
+		permissions []string
+		want        []string
+		wantErr     bool
+	}{
+		{"sorted without duplicates", []string{"users:write", "users:read", "users:write"}, []string{"users:read", "users:write"}, false},
+		{"none", nil, nil, false},
+		{"no action", []string{"users"}, nil, true},
+		{"digits", []string{"users2:read"}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := rolePermissions(tt.permissions)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("rolePermissions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Fatalf("rolePermissions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateRole(t *testing.T) {
+	tests := []struct {
+		name     string
+		role     *pb.Role
+		wantCode codes.Code
+	}{
+		{"new role", &pb.Role{Name: "auditor", Permissions: []string{"users:read"}}, codes.OK},
+		{"existing role", &pb.Role{Name: "user"}, codes.AlreadyExists},
+		{"unknown permission", &pb.Role{Name: "auditor", Permissions: []string{"logs:read"}}, codes.InvalidArgument},
+		{"upper case name", &pb.Role{Name: "Auditor"}, codes.InvalidArgument},
+		{"long name", &pb.Role{Name: strings.Repeat("a", roleMaxNameLength+1)}, codes.InvalidArgument},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := newTestUserService(t, newFakeStorage(t))
+
+			if _, err := u.CreateRole(context.Background(), tt.role); status.Code(err) != tt.wantCode {
+				t.Fatalf("CreateRole() = %v, want %s", err, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestDeleteRole(t *testing.T) {
+	var (
+		ctx  = context.Background()
+		strg = newFakeStorage(t)
+		u    = newTestUserService(t, strg)
+	)
+
+	for _, name := range []string{"user", caller.RoleAdmin, caller.RoleSupport} {
+		if _, err := u.DeleteRole(ctx, &pb.RoleName{Name: name}); errorReason(err) != "BUILTIN_ROLE" {
+			t.Fatalf("DeleteRole(%s) = %v, want BUILTIN_ROLE", name, err)
+		}
+	}
+
+	if _, err := u.CreateRole(ctx, &pb.Role{Name: "auditor"}); err != nil {
+		t.Fatalf("CreateRole: %v", err)
+	}
+	if _, err := u.AssignRole(ctx, &pb.UserRoleRequest{UserId: "user-1", Role: "auditor"}); err != nil {
+		t.Fatalf("AssignRole: %v", err)
+	}
+	if _, err := u.DeleteRole(ctx, &pb.RoleName{Name: "auditor"}); errorReason(err) != "ROLE_IN_USE" {
+		t.Fatalf("DeleteRole() of a held role = %v, want ROLE_IN_USE", err)
+	}
+
+	if _, err := u.UnassignRole(ctx, &pb.UserRoleRequest{UserId: "user-1", Role: "auditor"}); err != nil {
+		t.Fatalf("UnassignRole: %v", err)
+	}
+	if _, err := u.DeleteRole(ctx, &pb.RoleName{Name: "auditor"}); err != nil {
+		t.Fatalf("DeleteRole: %v", err)
+	}
+	if _, err := u.DeleteRole(ctx, &pb.RoleName{Name: "auditor"}); status.Code(err) != codes.NotFound {
+		t.Fatalf("DeleteRole() of a deleted role = %v, want %s", err, codes.NotFound)
+	}
+}
+
+func TestAssignRole(t *testing.T) {
+	var (
+		ctx  = context.Background()
+		strg = newFakeStorage(t)
+		u    = newTestUserService(t, strg)
+	)
+
+	allowed := func() bool {
+		t.Helper()
+		resp, err := u.HasPermission(ctx, &pb.HasPermissionRequest{UserId: "user-1", Permission: "users:write"})
+		if err != nil {
+			t.Fatalf("HasPermission: %v", err)
+		}
+		return resp.GetAllowed()
+	}
+
+	if allowed() {
+		t.Fatal("a user may write users before holding the admin role")
+	}
+	if _, err := u.AssignRole(ctx, &pb.UserRoleRequest{UserId: "user-1", Role: caller.RoleAdmin}); err != nil {
+		t.Fatalf("AssignRole: %v", err)
+	}
+	if !allowed() {
+		t.Fatal("the assigned admin role does not grant users:write")
+	}
+
+	roles, err := u.ListUserRoles(ctx, &pb.PrimaryKey{Id: "user-1"})
+	if err != nil {
+		t.Fatalf("ListUserRoles: %v", err)
+	}
+	if roles.GetPrimaryRole() != "user" || strings.Join(roles.GetRoles(), " ") != "admin user" {
+		t.Fatalf("ListUserRoles() = %v, want user as primary role of admin and user", roles)
+	}
+
+	if _, err = u.AssignRole(ctx, &pb.UserRoleRequest{UserId: "user-1", Role: "auditor"}); status.Code(err) != codes.NotFound {
+		t.Fatalf("AssignRole() of an unknown role = %v, want %s", err, codes.NotFound)
+	}
+	if _, err = u.UnassignRole(ctx, &pb.UserRoleRequest{UserId: "user-1", Role: "user"}); status.Code(err) != codes.NotFound {
+		t.Fatalf("UnassignRole() of the primary role = %v, want %s", err, codes.NotFound)
+	}
+}
+
+func TestChangeUserRoleUnknownRole(t *testing.T) {
+	u := newTestUserService(t, newFakeStorage(t))
+
+	_, err := u.ChangeUserRole(context.Background(), &pb.ChangeUserRole{Id: "user-1", NewUserRole: "auditor"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("ChangeUserRole() = %v, want %s", err, codes.InvalidArgument)
+	}
+}
+
+func TestLoginCarriesRoles(t *testing.T) {
+	var (
+		ctx  = context.Background()
+		strg = newFakeStorage(t)
+		a    = newTestAuthService(t, strg)
+	)
+	strg.userRoles["user-1"] = []string{caller.RoleAdmin}
+
+	resp, err := a.Login(ctx, &pb.LoginRequest{Email: "anna@example.com", Password: testPassword})
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+
+	claims, err := a.tokens.ParseAccessToken(resp.GetAccessToken())
+	if err != nil {
+		t.Fatalf("ParseAccessToken: %v", err)
+	}
+	if claims.UserRole != "user" || strings.Join(claims.Roles, " ") != "admin user" {
+		t.Fatalf("access token of role %q with roles %v, want user with admin and user", claims.UserRole, claims.Roles)
+	}
+}
+
+// newAdminStorage adds the admins admin-1, holding admin as primary role, and admin-2, holding it next to user
+func newAdminStorage(t *testing.T) *fakeStorage {
+	t.Helper()
+
+	strg := newFakeStorage(t)
+	strg.users["admin-1"] = &pb.User{Id: "admin-1", Email: "admin1@example.com", UserRole: caller.RoleAdmin}
+	strg.users["admin-2"] = &pb.User{Id: "admin-2", Email: "admin2@example.com", UserRole: "user"}
+	strg.userRoles["admin-2"] = []string{caller.RoleAdmin}
+
+	return strg
+}
+
+func TestLastAdmin(t *testing.T) {
+	var (
+		ctx  = caller.WithUser(context.Background(), &caller.User{Id: "admin-1", Role: caller.RoleAdmin})
+		strg = newAdminStorage(t)
+		u    = newTestUserService(t, strg)
+	)
+
+	if _, err := u.UnassignRole(ctx, &pb.UserRoleRequest{UserId: "admin-2", Role: caller.RoleAdmin}); err != nil {
+		t.Fatalf("UnassignRole() of one of two admins: %v", err)
+	}
+
+	if _, err := u.ChangeUserRole(ctx, &pb.ChangeUserRole{Id: "admin-1", NewUserRole: "user"}); errorReason(err) != "LAST_ADMIN" {
+		t.Fatalf("ChangeUserRole() of the last admin = %v, want LAST_ADMIN", err)
+	}
+	if strg.users["admin-1"].GetUserRole() != caller.RoleAdmin {
+		t.Fatalf("the last admin has role %q after the refused change", strg.users["admin-1"].GetUserRole())
+	}
+
+	if _, err := u.AssignRole(ctx, &pb.UserRoleRequest{UserId: "user-1", Role: caller.RoleAdmin}); err != nil {
+		t.Fatalf("AssignRole: %v", err)
+	}
+	if _, err := u.ChangeUserRole(ctx, &pb.ChangeUserRole{Id: "admin-1", NewUserRole: "user"}); err != nil {
+		t.Fatalf("ChangeUserRole() with another admin left: %v", err)
+	}
+	if _, err := u.UnassignRole(ctx, &pb.UserRoleRequest{UserId: "user-1", Role: caller.RoleAdmin}); errorReason(err) != "LAST_ADMIN" {
+		t.Fatalf("UnassignRole() of the last admin = %v, want LAST_ADMIN", err)
+	}
+}
+
+func TestDeleteLastAdmin(t *testing.T) {
+	var (
+		ctx  = context.Background()
+		strg = newAdminStorage(t)
+		u    = newTestUserService(t, strg)
+	)
+
+	if _, err := u.Delete(ctx, &pb.PrimaryKey{Id: "admin-2"}); err != nil {
+		t.Fatalf("Delete() of one of two admins: %v", err)
+	}
+	if _, err := u.Delete(ctx, &pb.PrimaryKey{Id: "admin-1"}); errorReason(err) != "LAST_ADMIN" {
+		t.Fatalf("Delete() of the last admin = %v, want LAST_ADMIN", err)
+	}
+	if _, ok := strg.users["admin-1"]; !ok {
+		t.Fatal("the last admin was deleted")
+	}
+	if _, err := u.Delete(ctx, &pb.PrimaryKey{Id: "user-1"}); err != nil {
+		t.Fatalf("Delete() of a user: %v", err)
+	}
+}
+
+func TestRoleChangeApproval(t *testing.T) {
+	var (
+		strg   = newAdminStorage(t)
+		u      = newTestUserService(t, strg)
+		first  = caller.WithUser(context.Background(), &caller.User{Id: "admin-1", Role: caller.RoleAdmin})
+		second = caller.WithUser(context.Background(), &caller.User{Id: "admin-2", Role: "user", Roles: []string{caller.RoleAdmin, "user"}})
+	)
+	u.roles.approval = true
+
+	if _, err := u.AssignRole(first, &pb.UserRoleRequest{UserId: "user-1", Role: caller.RoleAdmin}); errorReason(err) != "APPROVAL_REQUIRED" {
+		t.Fatalf("AssignRole() of admin = %v, want APPROVAL_REQUIRED", err)
+	}
+	if _, err := u.ChangeUserRole(first, &pb.ChangeUserRole{Id: "user-1", NewUserRole: caller.RoleAdmin}); errorReason(err) != "APPROVAL_REQUIRED" {
+		t.Fatalf("ChangeUserRole() to admin = %v, want APPROVAL_REQUIRED", err)
+	}
+	if _, err := u.RequestRoleChange(context.Background(), &pb.RoleChangeRequest{UserId: "user-1", Role: caller.RoleAdmin}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("RequestRoleChange() without a caller = %v, want %s", err, codes.Unauthenticated)
+	}
+
+	change, err := u.RequestRoleChange(first, &pb.RoleChangeRequest{UserId: "user-1", Role: caller.RoleAdmin, Assign: true})
+	if err != nil {
+		t.Fatalf("RequestRoleChange: %v", err)
+	}
+	if change.GetState() != "pending" || change.GetActorId() != "admin-1" {
+		t.Fatalf("RequestRoleChange() = %v, want a pending change of admin-1", change)
+	}
+
+	if _, err = u.ApproveRoleChange(first, &pb.PrimaryKey{Id: change.GetId()}); errorReason(err) != "SAME_APPROVER" {
+		t.Fatalf("ApproveRoleChange() by the requester = %v, want SAME_APPROVER", err)
+	}
+
+	approved, err := u.ApproveRoleChange(second, &pb.PrimaryKey{Id: change.GetId()})
+	if err != nil {
+		t.Fatalf("ApproveRoleChange: %v", err)
+	}
+	if approved.GetState() != "applied" || approved.GetApprovedBy() != "admin-2" {
+		t.Fatalf("ApproveRoleChange() = %v, want a change applied by admin-2", approved)
+	}
+	if !slices.Contains(strg.userRoles["user-1"], caller.RoleAdmin) {
+		t.Fatal("the approved change did not grant admin")
+	}
+
+	if _, err = u.ApproveRoleChange(second, &pb.PrimaryKey{Id: change.GetId()}); errorReason(err) != "ROLE_CHANGE_NOT_PENDING" {
+		t.Fatalf("ApproveRoleChange() twice = %v, want ROLE_CHANGE_NOT_PENDING", err)
+	}
+	if _, err = u.ApproveRoleChange(second, &pb.PrimaryKey{Id: "role-change-9"}); status.Code(err) != codes.NotFound {
+		t.Fatalf("ApproveRoleChange() of an unknown change = %v, want %s", err, codes.NotFound)
+	}
+
+	expired, err := u.RequestRoleChange(first, &pb.RoleChangeRequest{UserId: "user-1", Role: caller.RoleAdmin})
+	if err != nil {
+		t.Fatalf("RequestRoleChange: %v", err)
+	}
+	strg.roleChanges[len(strg.roleChanges)-1].expiresAt = time.Now().Add(-time.Second)
+
+	if _, err = u.ApproveRoleChange(second, &pb.PrimaryKey{Id: expired.GetId()}); errorReason(err) != "ROLE_CHANGE_EXPIRED" {
+		t.Fatalf("ApproveRoleChange() of an expired change = %v, want ROLE_CHANGE_EXPIRED", err)
+	}
+}
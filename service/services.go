@@ -1,32 +1,119 @@
 package service
 
 import (
+	"context"
+	"users_service/configs"
 	pb "users_service/genproto/users"
 	"users_service/pkg/logger"
+	"users_service/pkg/mailer"
+	"users_service/pkg/oidc"
+	"users_service/pkg/password"
+	"users_service/pkg/token"
 	"users_service/storage"
 )
 
 type IServiceManager interface {
 	AuthService() pb.AuthServiceServer
 	UsersService() pb.UsersServiceServer
+	Tokens() *token.Manager
+	Sessions() SessionChecker
 }
 
 type ServiceManager struct {
 	storage storage.IStorage
+	tokens  *token.Manager
+	hasher  password.Hasher
+	policy  *password.Policy
+	history *passwordHistory
+	emails  *emailVerifier
+	resets  *passwordResetter
+	mfa     *mfa
+	lockout *lockout
+	links   *passwordless
+	idps    *oidc.Registry
+	keys    *keyRing
+	roles   *roleChanges
+	support *impersonation
+	active  *sessionCache
+	cfg     *configs.Config
 	log     logger.ILogger
 }
 
-func NewServiceManager(storage storage.IStorage, log logger.ILogger) IServiceManager {
+// NewServiceManager loads the token signing keys and keeps reloading them until ctx is cancelled
+func NewServiceManager(ctx context.Context, storage storage.IStorage, cfg *configs.Config, log logger.ILogger) (IServiceManager, error) {
+	hasher, err := password.NewHasher(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	policy, err := password.NewPolicy(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	mail, err := mailer.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, err := token.NewManager(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := newKeyRing(storage, tokens, cfg, log)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = keys.load(ctx); err != nil {
+		return nil, err
+	}
+	go keys.watch(ctx)
+
+	mfa, err := newMfa(storage, tokens, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	idps, err := oidc.NewRegistry(cfg)
+	if err != nil {
+		return nil, err
+	}
+
 	return &ServiceManager{
 		storage: storage,
+		tokens:  tokens,
+		hasher:  hasher,
+		policy:  policy,
+		history: newPasswordHistory(storage, hasher, cfg),
+		emails:  newEmailVerifier(storage, tokens, mail, cfg),
+		resets:  newPasswordResetter(storage, tokens, mail, cfg),
+		mfa:     mfa,
+		lockout: newLockout(storage, cfg),
+		links:   newPasswordless(storage, tokens, mail, cfg),
+		idps:    idps,
+		keys:    keys,
+		roles:   newRoleChanges(storage, cfg),
+		support: newImpersonation(storage, tokens, cfg),
+		active:  newSessionCache(storage, cfg),
+		cfg:     cfg,
 		log:     log,
-	}
+	}, nil
 }
 
 func (s *ServiceManager) AuthService() pb.AuthServiceServer {
-	return NewAuthService(s.storage, s.log)
+	return NewAuthService(s.storage, s.tokens, s.hasher, s.policy, s.history, s.emails, s.resets, s.mfa, s.lockout, s.links, s.idps, s.keys, s.support, s.cfg, s.log)
 }
 
 func (s *ServiceManager) UsersService() pb.UsersServiceServer {
-	return NewUsersService(s.storage, s.log)
+	return NewUsersService(s.storage, s.hasher, s.policy, s.history, s.mfa, s.lockout, s.idps, s.roles, s.log)
+}
+
+func (s *ServiceManager) Tokens() *token.Manager {
+	return s.tokens
+}
+
+func (s *ServiceManager) Sessions() SessionChecker {
+	return s.active
 }
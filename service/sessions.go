@@ -0,0 +1,85 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+	"users_service/configs"
+	"users_service/storage"
+)
+
+// sessionCacheMaxEntries bounds the session cache, stale entries are dropped once it is full
+const sessionCacheMaxEntries = 10000
+
+// SessionChecker reports whether the session an access token belongs to is still active
+type SessionChecker interface {
+	Active(ctx context.Context, sessionId string) (bool, error)
+}
+
+// sessionCache checks sessions against storage and remembers the answer for ttl, so a
+// revoked session stops working within ttl and not only when its access token expires
+type sessionCache struct {
+	storage storage.IStorage
+	ttl     time.Duration
+
+	mu      sync.Mutex
+	entries map[string]sessionCacheEntry
+}
+
+type sessionCacheEntry struct {
+	active    bool
+	checkedAt time.Time
+}
+
+func newSessionCache(storage storage.IStorage, cfg *configs.Config) *sessionCache {
+	return &sessionCache{
+		storage: storage,
+		ttl:     cfg.SessionCheckCacheTTL,
+		entries: map[string]sessionCacheEntry{},
+	}
+}
+
+// Active reports whether the session exists and is neither revoked nor ended, tokens
+// without a session are never active
+func (c *sessionCache) Active(ctx context.Context, sessionId string) (bool, error) {
+	if sessionId == "" {
+		return false, nil
+	}
+
+	c.mu.Lock()
+	entry, ok := c.entries[sessionId]
+	c.mu.Unlock()
+
+	if ok && time.Since(entry.checkedAt) < c.ttl {
+		return entry.active, nil
+	}
+
+	active, err := c.storage.Sessions().IsActive(ctx, sessionId)
+	if err != nil {
+		return false, err
+	}
+
+	if c.ttl > 0 {
+		c.mu.Lock()
+		c.store(sessionId, sessionCacheEntry{active: active, checkedAt: time.Now()})
+		c.mu.Unlock()
+	}
+
+	return active, nil
+}
+
+// store caches entry, making room first when the cache is full. Callers hold mu.
+func (c *sessionCache) store(sessionId string, entry sessionCacheEntry) {
+	if len(c.entries) >= sessionCacheMaxEntries {
+		for id, cached := range c.entries {
+			if time.Since(cached.checkedAt) >= c.ttl {
+				delete(c.entries, id)
+			}
+		}
+	}
+	if len(c.entries) >= sessionCacheMaxEntries {
+		clear(c.entries)
+	}
+
+	c.entries[sessionId] = entry
+}
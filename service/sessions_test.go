@@ -0,0 +1,59 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pb "users_service/genproto/users"
+)
+
+func TestSessionCache(t *testing.T) {
+	var (
+		ctx   = context.Background()
+		strg  = newFakeStorage(t)
+		cache = &sessionCache{storage: strg, ttl: time.Minute, entries: map[string]sessionCacheEntry{}}
+	)
+
+	session, err := strg.Sessions().Create(ctx, &pb.Session{UserId: "user-1"}, &pb.RefreshToken{ExpiresIn: time.Now().Add(time.Hour).Format(time.RFC3339)})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if active, err := cache.Active(ctx, session.GetId()); err != nil || !active {
+			t.Fatalf("Active() = %v, %v, want true", active, err)
+		}
+	}
+	if strg.sessionChecks != 1 {
+		t.Fatalf("%d session lookups, want 1 within the ttl", strg.sessionChecks)
+	}
+
+	if _, err = strg.Sessions().Revoke(ctx, &pb.RevokeSessionRequest{UserId: "user-1", SessionId: session.GetId()}); err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+	cache.entries[session.GetId()] = sessionCacheEntry{active: true, checkedAt: time.Now().Add(-time.Minute)}
+
+	if active, err := cache.Active(ctx, session.GetId()); err != nil || active {
+		t.Fatalf("Active() of a revoked session after the ttl = %v, %v, want false", active, err)
+	}
+
+	if active, _ := cache.Active(ctx, ""); active {
+		t.Fatal("a token without a session is active")
+	}
+}
+
+func TestSessionCacheDisabled(t *testing.T) {
+	var (
+		ctx   = context.Background()
+		strg  = newFakeStorage(t)
+		cache = &sessionCache{storage: strg, entries: map[string]sessionCacheEntry{}}
+	)
+
+	for i := 0; i < 2; i++ {
+		cache.Active(ctx, "session-1")
+	}
+	if strg.sessionChecks != 2 || len(cache.entries) != 0 {
+		t.Fatalf("%d session lookups and %d cached, want every call looked up", strg.sessionChecks, len(cache.entries))
+	}
+}
@@ -0,0 +1,186 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+	"users_service/configs"
+	"users_service/pkg/logger"
+	"users_service/pkg/secret"
+	"users_service/pkg/token"
+	"users_service/storage"
+
+	pb "users_service/genproto/users"
+)
+
+// keyRing keeps the token manager's keys in sync with the signing keys in storage, so a
+// rotation made through any instance reaches all of them within one reload interval.
+// Private keys are stored sealed with the configured key.
+type keyRing struct {
+	storage        storage.IStorage
+	tokens         *token.Manager
+	box            *secret.Box
+	algorithm      string
+	bootstrapPath  string
+	retiredFor     time.Duration
+	reloadInterval time.Duration
+	log            logger.ILogger
+}
+
+func newKeyRing(storage storage.IStorage, tokens *token.Manager, cfg *configs.Config, log logger.ILogger) (*keyRing, error) {
+	box, err := secret.NewBox(cfg.SigningKeyEncryptionKey)
+	if err != nil {
+		return nil, fmt.Errorf("error while creating signing key secret box: %w", err)
+	}
+
+	return &keyRing{
+		storage:       storage,
+		tokens:        tokens,
+		box:           box,
+		algorithm:     cfg.TokenSigningAlgorithm,
+		bootstrapPath: cfg.TokenSigningKeyPath,
+		// a retired key has to outlive every token signed with it
+		retiredFor:     max(cfg.AccessTokenTTL, cfg.RefreshTokenTTL, cfg.MfaChallengeTTL, cfg.PasswordlessTTL),
+		reloadInterval: cfg.SigningKeyReloadInterval,
+		log:            log,
+	}, nil
+}
+
+// load hands the stored keys to the token manager. The first instance to start against an
+// empty store creates the active key, from cfg.TokenSigningKeyPath when set, and a pending key.
+func (k *keyRing) load(ctx context.Context) error {
+	keys, err := k.reload(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys.GetKeys() {
+		if key.GetState() == token.KeyStateActive {
+			return nil
+		}
+	}
+
+	if err = k.bootstrap(ctx); err != nil {
+		return err
+	}
+
+	_, err = k.reload(ctx)
+	return err
+}
+
+// watch reloads the keys until ctx is cancelled
+func (k *keyRing) watch(ctx context.Context) {
+	ticker := time.NewTicker(k.reloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if _, err := k.reload(ctx); err != nil {
+			k.log.Error("error while reloading signing keys", logger.Error(err))
+		}
+	}
+}
+
+// rotate retires the active key, activates the pending one and creates the next pending key
+func (k *keyRing) rotate(ctx context.Context) (*pb.SigningKeys, error) {
+	next, err := token.GenerateSigningKey(k.algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, err := k.box.Seal(string(next.MarshalPEM()))
+	if err != nil {
+		return nil, fmt.Errorf("error while sealing signing key: %w", err)
+	}
+
+	if err = k.storage.SigningKeys().Rotate(ctx, signingKeyOf(next), sealed, k.retiredFor); err != nil {
+		return nil, err
+	}
+
+	return k.reload(ctx)
+}
+
+func (k *keyRing) reload(ctx context.Context) (*pb.SigningKeys, error) {
+	keys, privateKeys, err := k.storage.SigningKeys().GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ring := make([]*token.SigningKey, 0, len(keys.GetKeys()))
+	for _, stored := range keys.GetKeys() {
+		pem, err := k.box.Open(privateKeys[stored.GetId()])
+		if err != nil {
+			return nil, fmt.Errorf("error while opening signing key %s: %w", stored.GetId(), err)
+		}
+
+		key, err := token.ParseSigningKey([]byte(pem))
+		if err != nil {
+			return nil, fmt.Errorf("error while parsing signing key %s: %w", stored.GetId(), err)
+		}
+		key.State = stored.GetState()
+
+		ring = append(ring, key)
+	}
+
+	if len(ring) > 0 {
+		if err = k.tokens.SetKeys(ring); err != nil {
+			return nil, err
+		}
+	}
+
+	return keys, nil
+}
+
+func (k *keyRing) bootstrap(ctx context.Context) error {
+	var (
+		active *token.SigningKey
+		err    error
+	)
+
+	if k.bootstrapPath != "" {
+		active, err = token.LoadSigningKey(k.bootstrapPath, k.algorithm)
+	} else {
+		active, err = token.GenerateSigningKey(k.algorithm)
+	}
+	if err != nil {
+		return err
+	}
+
+	pending, err := token.GenerateSigningKey(k.algorithm)
+	if err != nil {
+		return err
+	}
+
+	activeSealed, err := k.box.Seal(string(active.MarshalPEM()))
+	if err != nil {
+		return fmt.Errorf("error while sealing signing key: %w", err)
+	}
+
+	pendingSealed, err := k.box.Seal(string(pending.MarshalPEM()))
+	if err != nil {
+		return fmt.Errorf("error while sealing signing key: %w", err)
+	}
+
+	created, err := k.storage.SigningKeys().Bootstrap(ctx, signingKeyOf(active), signingKeyOf(pending), activeSealed, pendingSealed)
+	if err != nil {
+		return err
+	}
+
+	if created {
+		k.log.Info("created signing keys", logger.String("active", active.Id), logger.String("pending", pending.Id))
+	}
+
+	return nil
+}
+
+func signingKeyOf(key *token.SigningKey) *pb.SigningKey {
+	return &pb.SigningKey{
+		Id:        key.Id,
+		Algorithm: key.Algorithm,
+	}
+}
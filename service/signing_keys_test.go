@@ -0,0 +1,124 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+	"users_service/configs"
+	"users_service/pkg/token"
+	"users_service/pkg/token/tokentest"
+
+	pb "users_service/genproto/users"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func newTestKeyRing(t *testing.T, strg *fakeStorage, tokens *token.Manager) *keyRing {
+	t.Helper()
+
+	keys, err := newKeyRing(strg, tokens, &configs.Config{
+		TokenSigningAlgorithm:   token.AlgorithmEdDSA,
+		SigningKeyEncryptionKey: "test signing key encryption key",
+		AccessTokenTTL:          time.Hour,
+		RefreshTokenTTL:         24 * time.Hour,
+	}, newTestLogger(t))
+	if err != nil {
+		t.Fatalf("newKeyRing: %v", err)
+	}
+	return keys
+}
+
+// keyStates returns the state of every stored key in creation order
+func keyStates(strg *fakeStorage) []string {
+	var states []string
+	for _, stored := range strg.signingKeys {
+		states = append(states, stored.key.State)
+	}
+	return states
+}
+
+// pemOf parses the stored private key of the i-th key as is, it fails for sealed keys
+func pemOf(strg *fakeStorage, i int) (*token.SigningKey, error) {
+	return token.ParseSigningKey([]byte(strg.signingKeys[i].privateKey))
+}
+
+func TestKeyRingLoad(t *testing.T) {
+	var (
+		ctx    = context.Background()
+		strg   = newFakeStorage(t)
+		tokens = tokentest.NewManager(t)
+	)
+
+	if err := newTestKeyRing(t, strg, tokens).load(ctx); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if states := keyStates(strg); len(states) != 2 || states[0] != token.KeyStateActive || states[1] != token.KeyStatePending {
+		t.Fatalf("stored keys %v, want an active and a pending key", states)
+	}
+	if _, err := pemOf(strg, 0); err == nil {
+		t.Fatal("the private key was stored in plain")
+	}
+
+	accessToken, _, err := tokens.GenerateAccessToken("user-1", "", "user", "", nil)
+	if err != nil {
+		t.Fatalf("GenerateAccessToken: %v", err)
+	}
+
+	// another instance starting later loads the same keys instead of creating its own
+	other := tokentest.NewManager(t)
+	if err = newTestKeyRing(t, strg, other).load(ctx); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if len(strg.signingKeys) != 2 {
+		t.Fatalf("%d keys stored after a second load, want 2", len(strg.signingKeys))
+	}
+	if _, err = other.ParseAccessToken(accessToken); err != nil {
+		t.Fatalf("the other instance does not verify the token: %v", err)
+	}
+}
+
+func TestRotateSigningKeys(t *testing.T) {
+	var (
+		ctx    = context.Background()
+		strg   = newFakeStorage(t)
+		tokens = tokentest.NewManager(t)
+		a      = newTestAuthService(t, strg)
+	)
+	a.tokens = tokens
+	a.keys = newTestKeyRing(t, strg, tokens)
+
+	if err := a.keys.load(ctx); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	accessToken, _, err := tokens.GenerateAccessToken("user-1", "", "user", "", nil)
+	if err != nil {
+		t.Fatalf("GenerateAccessToken: %v", err)
+	}
+
+	resp, err := a.RotateSigningKeys(ctx, &pb.Void{})
+	if err != nil {
+		t.Fatalf("RotateSigningKeys: %v", err)
+	}
+	want := []string{token.KeyStateRetired, token.KeyStateActive, token.KeyStatePending}
+	if len(resp.GetKeys()) != len(want) {
+		t.Fatalf("RotateSigningKeys() = %v, want keys in states %v", resp.GetKeys(), want)
+	}
+	for i, key := range resp.GetKeys() {
+		if key.GetState() != want[i] {
+			t.Fatalf("key %d is %s, want %s", i, key.GetState(), want[i])
+		}
+	}
+
+	if _, err = tokens.ParseAccessToken(accessToken); err != nil {
+		t.Fatalf("token of the retired key: %v", err)
+	}
+	if retiredUntil, _ := time.Parse(time.RFC3339, resp.GetKeys()[0].GetExpiresAt()); time.Until(retiredUntil) < 23*time.Hour {
+		t.Fatalf("retired key expires at %s, before the refresh tokens it signed", retiredUntil)
+	}
+
+	strg.signingKeys = strg.signingKeys[:2]
+	if _, err = a.RotateSigningKeys(ctx, &pb.Void{}); status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("RotateSigningKeys() without a pending key = %v, want %s", err, codes.FailedPrecondition)
+	}
+}
@@ -2,23 +2,44 @@ package service
 
 import (
 	"context"
+	"errors"
+	"slices"
+	"users_service/pkg/errs"
 	"users_service/pkg/logger"
+	"users_service/pkg/oidc"
+	"users_service/pkg/password"
 	"users_service/storage"
 
 	pb "users_service/genproto/users"
 
-	"golang.org/x/crypto/bcrypt"
+	"github.com/jackc/pgx/v5"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type userService struct {
 	storage storage.IStorage
+	hasher  password.Hasher
+	policy  *password.Policy
+	history *passwordHistory
+	mfa     *mfa
+	lockout *lockout
+	idps    *oidc.Registry
+	roles   *roleChanges
 	log     logger.ILogger
 	pb.UnimplementedUsersServiceServer
 }
 
-func NewUsersService(storage storage.IStorage, log logger.ILogger) *userService {
+func NewUsersService(storage storage.IStorage, hasher password.Hasher, policy *password.Policy, history *passwordHistory, mfa *mfa, lockout *lockout, idps *oidc.Registry, roles *roleChanges, log logger.ILogger) *userService {
 	return &userService{
 		storage: storage,
+		hasher:  hasher,
+		policy:  policy,
+		history: history,
+		mfa:     mfa,
+		lockout: lockout,
+		idps:    idps,
+		roles:   roles,
 		log:     log,
 	}
 }
@@ -47,12 +68,48 @@ func (u *userService) GetAll(ctx context.Context, request *pb.GetListRequest) (*
 
 func (u *userService) Update(ctx context.Context, request *pb.UpdateUser) (*pb.UpdatedUser, error) {
 
+	// password_hash carries the plaintext password, it is hashed here before reaching storage
+	if request.GetPasswordHash() != "" {
+		user, err := u.storage.Users().GetById(ctx, &pb.PrimaryKey{Id: request.GetId()})
+		if err != nil {
+			u.log.Error("error while getting user to check password policy in service layer", logger.Error(err))
+			return &pb.UpdatedUser{}, err
+		}
+
+		if violations := u.policy.Validate(request.GetPasswordHash(),
+			request.GetEmail(),
+			request.GetFullName(),
+			user.GetEmail(),
+			user.GetFullName(),
+		); len(violations) > 0 {
+			return &pb.UpdatedUser{}, passwordPolicyError("password_hash", violations)
+		}
+
+		violations, err := u.history.check(ctx, request.GetId(), request.GetPasswordHash())
+		if err != nil {
+			u.log.Error("error while checking password history in service layer", logger.Error(err))
+			return &pb.UpdatedUser{}, err
+		}
+		if len(violations) > 0 {
+			return &pb.UpdatedUser{}, passwordPolicyError("password_hash", violations)
+		}
+
+		hashedPassword, err := u.hasher.Hash(request.GetPasswordHash())
+		if err != nil {
+			u.log.Error("error while hashing password in service layer", logger.Error(err))
+			return &pb.UpdatedUser{}, err
+		}
+		request.PasswordHash = hashedPassword
+	}
+
 	resp, err := u.storage.Users().Update(ctx, request)
 	if err != nil {
 		u.log.Error("error while updating user info in service layer", logger.Error(err))
 		return &pb.UpdatedUser{}, err
 	}
 
+	resp.Password = ""
+
 	return resp, nil
 }
 
@@ -60,6 +117,9 @@ func (u *userService) Delete(ctx context.Context, request *pb.PrimaryKey) (*pb.V
 
 	resp, err := u.storage.Users().Delete(ctx, request)
 	if err != nil {
+		if errors.Is(err, errs.ErrLastAdmin) {
+			return &pb.Void{}, statusWithReason(codes.FailedPrecondition, "LAST_ADMIN", "the last admin can not be deleted")
+		}
 		u.log.Error("error while deleting user info in service layer", logger.Error(err))
 		return &pb.Void{}, err
 	}
@@ -67,25 +127,50 @@ func (u *userService) Delete(ctx context.Context, request *pb.PrimaryKey) (*pb.V
 	return resp, nil
 }
 
+// ChangePassword replaces the password after checking the current one, wrong current
+// passwords count towards the user's lockout like failed logins
 func (u *userService) ChangePassword(ctx context.Context, request *pb.ChangePassword) (*pb.Void, error) {
 
-	iscurrent, err := u.storage.Users().CheckPasswordExisis(ctx, request)
+	if err := u.checkLockout(ctx, request.GetUserId()); err != nil {
+		return &pb.Void{}, err
+	}
+
+	match, err := u.verifyPassword(ctx, request.GetUserId(), request.GetCurrentPassword())
 	if err != nil {
 		u.log.Error("error while checking current password is currect in service layer", logger.Error(err))
 		return &pb.Void{}, err
 	}
 
-	if !iscurrent {
-		u.log.Error("error while current password is not correct in service layer", logger.Error(err))
+	if !match {
+		return &pb.Void{}, u.attemptFailed(ctx, request.GetUserId(), status.Error(codes.PermissionDenied, "current password is not correct"))
+	}
+	u.attemptSucceeded(ctx, request.GetUserId())
+
+	user, err := u.storage.Users().GetById(ctx, &pb.PrimaryKey{Id: request.GetUserId()})
+	if err != nil {
+		u.log.Error("error while getting user to check password policy in service layer", logger.Error(err))
+		return &pb.Void{}, err
+	}
+
+	if violations := u.policy.Validate(request.GetNewPassword(), user.GetEmail(), user.GetFullName()); len(violations) > 0 {
+		return &pb.Void{}, passwordPolicyError("new_password", violations)
+	}
+
+	violations, err := u.history.check(ctx, request.GetUserId(), request.GetNewPassword())
+	if err != nil {
+		u.log.Error("error while checking password history in service layer", logger.Error(err))
 		return &pb.Void{}, err
 	}
+	if len(violations) > 0 {
+		return &pb.Void{}, passwordPolicyError("new_password", violations)
+	}
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(request.NewPassword), bcrypt.DefaultCost)
+	hashedPassword, err := u.hasher.Hash(request.GetNewPassword())
 	if err != nil {
 		u.log.Error("Error with hashing password", logger.Error(err))
 		return &pb.Void{}, err
 	}
-	request.NewPassword = string(hashedPassword)
+	request.NewPassword = hashedPassword
 
 	resp, err := u.storage.Users().ChangePassword(ctx, request)
 	if err != nil {
@@ -96,13 +181,497 @@ func (u *userService) ChangePassword(ctx context.Context, request *pb.ChangePass
 	return resp, nil
 }
 
+// ChangeUserRole changes the user's primary role, which has to be an existing role
 func (u *userService) ChangeUserRole(ctx context.Context, request *pb.ChangeUserRole) (*pb.Void, error) {
 
-	resp, err := u.storage.Users().ChangeUserRole(ctx, request)
+	_, err := u.roles.apply(ctx, roleChangeSetPrimary, request.GetId(), request.GetNewUserRole())
 	if err != nil {
+		if errors.Is(err, errs.ErrRoleNotFound) {
+			return &pb.Void{}, status.Error(codes.InvalidArgument, "unknown role")
+		}
+		if statusErr := roleError(err); statusErr != nil {
+			return &pb.Void{}, statusErr
+		}
 		u.log.Error("error while changing user role in service layer", logger.Error(err))
 		return &pb.Void{}, err
 	}
 
+	return &pb.Void{}, nil
+}
+
+// VerifyPassword checks the user's password, wrong ones count towards the user's lockout
+func (u *userService) VerifyPassword(ctx context.Context, request *pb.VerifyPassword) (*pb.Void, error) {
+
+	if err := u.checkLockout(ctx, request.GetUserId()); err != nil {
+		return &pb.Void{}, err
+	}
+
+	match, err := u.verifyPassword(ctx, request.GetUserId(), request.GetPassword())
+	if err != nil {
+		u.log.Error("error while verifying password in service layer", logger.Error(err))
+		return &pb.Void{}, err
+	}
+
+	if !match {
+		return &pb.Void{}, u.attemptFailed(ctx, request.GetUserId(), status.Error(codes.Unauthenticated, "invalid credentials"))
+	}
+	u.attemptSucceeded(ctx, request.GetUserId())
+
+	return &pb.Void{}, nil
+}
+
+func (u *userService) EnrollTotp(ctx context.Context, request *pb.PrimaryKey) (*pb.TotpEnrollment, error) {
+
+	user, err := u.storage.Users().GetById(ctx, request)
+	if err != nil {
+		u.log.Error("error while getting user to enroll totp in service layer", logger.Error(err))
+		return &pb.TotpEnrollment{}, err
+	}
+
+	if user.GetMfaEnabled() {
+		return &pb.TotpEnrollment{}, mfaError(errMfaAlreadyEnabled)
+	}
+
+	resp, err := u.mfa.enroll(ctx, user)
+	if err != nil {
+		u.log.Error("error while enrolling totp in service layer", logger.Error(err))
+		return &pb.TotpEnrollment{}, err
+	}
+
+	return resp, nil
+}
+
+// ConfirmTotp enables the enrolled TOTP secret, wrong codes count towards the user's lockout
+func (u *userService) ConfirmTotp(ctx context.Context, request *pb.TotpCodeRequest) (*pb.RecoveryCodes, error) {
+
+	if err := u.checkLockout(ctx, request.GetUserId()); err != nil {
+		return &pb.RecoveryCodes{}, err
+	}
+
+	recoveryCodes, err := u.mfa.confirm(ctx, request.GetUserId(), request.GetCode())
+	if err != nil {
+		if errors.Is(err, errMfaCodeInvalid) {
+			return &pb.RecoveryCodes{}, u.attemptFailed(ctx, request.GetUserId(), mfaError(err))
+		}
+		if statusErr := mfaError(err); statusErr != nil {
+			return &pb.RecoveryCodes{}, statusErr
+		}
+		u.log.Error("error while confirming totp in service layer", logger.Error(err))
+		return &pb.RecoveryCodes{}, err
+	}
+	u.attemptSucceeded(ctx, request.GetUserId())
+
+	return &pb.RecoveryCodes{Codes: recoveryCodes}, nil
+}
+
+func (u *userService) DisableTotp(ctx context.Context, request *pb.TotpCodeRequest) (*pb.Void, error) {
+
+	if err := u.checkMfaCode(ctx, request); err != nil {
+		return &pb.Void{}, err
+	}
+
+	if err := u.storage.Mfa().Disable(ctx, request.GetUserId()); err != nil {
+		u.log.Error("error while disabling totp in service layer", logger.Error(err))
+		return &pb.Void{}, err
+	}
+
+	return &pb.Void{}, nil
+}
+
+func (u *userService) RegenerateRecoveryCodes(ctx context.Context, request *pb.TotpCodeRequest) (*pb.RecoveryCodes, error) {
+
+	if err := u.checkMfaCode(ctx, request); err != nil {
+		return &pb.RecoveryCodes{}, err
+	}
+
+	recoveryCodes, err := u.mfa.regenerate(ctx, request.GetUserId())
+	if err != nil {
+		u.log.Error("error while regenerating recovery codes in service layer", logger.Error(err))
+		return &pb.RecoveryCodes{}, err
+	}
+
+	return &pb.RecoveryCodes{Codes: recoveryCodes}, nil
+}
+
+// UnlockUser lifts a lockout and clears the user's failed login attempts
+func (u *userService) UnlockUser(ctx context.Context, request *pb.PrimaryKey) (*pb.Void, error) {
+
+	resp, err := u.storage.Auth().ResetFailedLogins(ctx, request.GetId())
+	if err != nil {
+		u.log.Error("error while unlocking user in service layer", logger.Error(err))
+		return &pb.Void{}, err
+	}
+
+	return resp, nil
+}
+
+func (u *userService) ListLoginHistory(ctx context.Context, request *pb.LoginHistoryRequest) (*pb.LoginEvents, error) {
+
+	if request.GetPage() < 1 {
+		request.Page = 1
+	}
+	if request.GetLimit() < 1 {
+		request.Limit = 10
+	}
+
+	resp, err := u.storage.LoginEvents().GetAll(ctx, request)
+	if err != nil {
+		u.log.Error("error while getting login history in service layer", logger.Error(err))
+		return &pb.LoginEvents{}, err
+	}
+
+	return resp, nil
+}
+
+// LinkIdentity links the identity of a provider's ID token to the user
+func (u *userService) LinkIdentity(ctx context.Context, request *pb.LinkIdentityRequest) (*pb.Identity, error) {
+
+	identity, err := u.idps.Verify(ctx, request.GetProvider(), request.GetIdToken())
+	if err != nil {
+		if statusErr := identityError(err); statusErr != nil {
+			return &pb.Identity{}, statusErr
+		}
+		u.log.Error("error while verifying id token in service layer", logger.Error(err))
+		return &pb.Identity{}, err
+	}
+
+	resp, err := u.storage.Identities().Create(ctx, &pb.Identity{
+		UserId:   request.GetUserId(),
+		Provider: identity.Provider,
+		Subject:  identity.Subject,
+		Email:    identity.Email,
+	})
+	if err != nil {
+		if statusErr := identityError(err); statusErr != nil {
+			return &pb.Identity{}, statusErr
+		}
+		u.log.Error("error while linking identity in service layer", logger.Error(err))
+		return &pb.Identity{}, err
+	}
+
+	return resp, nil
+}
+
+func (u *userService) UnlinkIdentity(ctx context.Context, request *pb.UnlinkIdentityRequest) (*pb.Void, error) {
+
+	resp, err := u.storage.Identities().Delete(ctx, request)
+	if err != nil {
+		if statusErr := identityError(err); statusErr != nil {
+			return &pb.Void{}, statusErr
+		}
+		u.log.Error("error while unlinking identity in service layer", logger.Error(err))
+		return &pb.Void{}, err
+	}
+
+	return resp, nil
+}
+
+func (u *userService) ListIdentities(ctx context.Context, request *pb.PrimaryKey) (*pb.Identities, error) {
+
+	resp, err := u.storage.Identities().GetAll(ctx, request)
+	if err != nil {
+		u.log.Error("error while getting identities in service layer", logger.Error(err))
+		return &pb.Identities{}, err
+	}
+
+	return resp, nil
+}
+
+func (u *userService) CreateRole(ctx context.Context, request *pb.Role) (*pb.Role, error) {
+
+	if err := validRoleName(request.GetName()); err != nil {
+		return &pb.Role{}, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	permissions, err := rolePermissions(request.GetPermissions())
+	if err != nil {
+		return &pb.Role{}, status.Error(codes.InvalidArgument, err.Error())
+	}
+	request.Permissions = permissions
+
+	resp, err := u.storage.Roles().Create(ctx, request)
+	if err != nil {
+		if statusErr := roleError(err); statusErr != nil {
+			return &pb.Role{}, statusErr
+		}
+		u.log.Error("error while creating role in service layer", logger.Error(err))
+		return &pb.Role{}, err
+	}
+
+	return resp, nil
+}
+
+func (u *userService) ListRoles(ctx context.Context, request *pb.Void) (*pb.Roles, error) {
+
+	resp, err := u.storage.Roles().GetAll(ctx)
+	if err != nil {
+		u.log.Error("error while getting roles in service layer", logger.Error(err))
+		return &pb.Roles{}, err
+	}
+
+	return resp, nil
+}
+
+// DeleteRole deletes a role no user holds, the built-in roles can not be deleted
+func (u *userService) DeleteRole(ctx context.Context, request *pb.RoleName) (*pb.Void, error) {
+
+	if slices.Contains(builtinRoles, request.GetName()) {
+		return &pb.Void{}, statusWithReason(codes.FailedPrecondition, "BUILTIN_ROLE", "built-in roles can not be deleted")
+	}
+
+	resp, err := u.storage.Roles().Delete(ctx, request)
+	if err != nil {
+		if statusErr := roleError(err); statusErr != nil {
+			return &pb.Void{}, statusErr
+		}
+		u.log.Error("error while deleting role in service layer", logger.Error(err))
+		return &pb.Void{}, err
+	}
+
+	return resp, nil
+}
+
+// SetRolePermissions replaces the permissions a role grants
+func (u *userService) SetRolePermissions(ctx context.Context, request *pb.Role) (*pb.Role, error) {
+
+	permissions, err := rolePermissions(request.GetPermissions())
+	if err != nil {
+		return &pb.Role{}, status.Error(codes.InvalidArgument, err.Error())
+	}
+	request.Permissions = permissions
+
+	resp, err := u.storage.Roles().SetPermissions(ctx, request)
+	if err != nil {
+		if statusErr := roleError(err); statusErr != nil {
+			return &pb.Role{}, statusErr
+		}
+		u.log.Error("error while setting role permissions in service layer", logger.Error(err))
+		return &pb.Role{}, err
+	}
+
+	return resp, nil
+}
+
+func (u *userService) CreatePermission(ctx context.Context, request *pb.Permission) (*pb.Permission, error) {
+
+	if err := validPermissionName(request.GetName()); err != nil {
+		return &pb.Permission{}, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	resp, err := u.storage.Roles().CreatePermission(ctx, request)
+	if err != nil {
+		if statusErr := roleError(err); statusErr != nil {
+			return &pb.Permission{}, statusErr
+		}
+		u.log.Error("error while creating permission in service layer", logger.Error(err))
+		return &pb.Permission{}, err
+	}
+
+	return resp, nil
+}
+
+func (u *userService) ListPermissions(ctx context.Context, request *pb.Void) (*pb.Permissions, error) {
+
+	resp, err := u.storage.Roles().GetPermissions(ctx)
+	if err != nil {
+		u.log.Error("error while getting permissions in service layer", logger.Error(err))
+		return &pb.Permissions{}, err
+	}
+
+	return resp, nil
+}
+
+// AssignRole gives the user a role next to its primary role
+func (u *userService) AssignRole(ctx context.Context, request *pb.UserRoleRequest) (*pb.Void, error) {
+
+	_, err := u.roles.apply(ctx, roleChangeAssign, request.GetUserId(), request.GetRole())
+	if err != nil {
+		if statusErr := roleError(err); statusErr != nil {
+			return &pb.Void{}, statusErr
+		}
+		u.log.Error("error while assigning role in service layer", logger.Error(err))
+		return &pb.Void{}, err
+	}
+
+	return &pb.Void{}, nil
+}
+
+// UnassignRole takes an assigned role from the user, the primary role is changed with ChangeUserRole
+func (u *userService) UnassignRole(ctx context.Context, request *pb.UserRoleRequest) (*pb.Void, error) {
+
+	_, err := u.roles.apply(ctx, roleChangeUnassign, request.GetUserId(), request.GetRole())
+	if err != nil {
+		if statusErr := roleError(err); statusErr != nil {
+			return &pb.Void{}, statusErr
+		}
+		u.log.Error("error while unassigning role in service layer", logger.Error(err))
+		return &pb.Void{}, err
+	}
+
+	return &pb.Void{}, nil
+}
+
+func (u *userService) ListUserRoles(ctx context.Context, request *pb.PrimaryKey) (*pb.UserRoles, error) {
+
+	resp, err := u.storage.Roles().GetUserRoles(ctx, request)
+	if err != nil {
+		if statusErr := roleError(err); statusErr != nil {
+			return &pb.UserRoles{}, statusErr
+		}
+		u.log.Error("error while getting user roles in service layer", logger.Error(err))
+		return &pb.UserRoles{}, err
+	}
+
+	return resp, nil
+}
+
+// HasPermission reports whether any role of the user grants the permission
+func (u *userService) HasPermission(ctx context.Context, request *pb.HasPermissionRequest) (*pb.HasPermissionResponse, error) {
+
+	allowed, err := u.storage.Roles().HasPermission(ctx, request)
+	if err != nil {
+		u.log.Error("error while checking permission in service layer", logger.Error(err))
+		return &pb.HasPermissionResponse{}, err
+	}
+
+	return &pb.HasPermissionResponse{Allowed: allowed}, nil
+}
+
+// RequestRoleChange records a role change, usually granting admin, for another admin to approve
+func (u *userService) RequestRoleChange(ctx context.Context, request *pb.RoleChangeRequest) (*pb.RoleChange, error) {
+
+	resp, err := u.roles.request(ctx, request)
+	if err != nil {
+		if statusErr := roleError(err); statusErr != nil {
+			return &pb.RoleChange{}, statusErr
+		}
+		u.log.Error("error while requesting role change in service layer", logger.Error(err))
+		return &pb.RoleChange{}, err
+	}
+
+	return resp, nil
+}
+
+// ApproveRoleChange applies a role change another admin requested
+func (u *userService) ApproveRoleChange(ctx context.Context, request *pb.PrimaryKey) (*pb.RoleChange, error) {
+
+	resp, err := u.roles.approve(ctx, request)
+	if err != nil {
+		if statusErr := roleError(err); statusErr != nil {
+			return &pb.RoleChange{}, statusErr
+		}
+		u.log.Error("error while approving role change in service layer", logger.Error(err))
+		return &pb.RoleChange{}, err
+	}
+
+	return resp, nil
+}
+
+// ListRoleChanges returns applied and requested role changes, optionally of one user or in one state
+func (u *userService) ListRoleChanges(ctx context.Context, request *pb.RoleChangesRequest) (*pb.RoleChanges, error) {
+
+	if request.GetPage() < 1 {
+		request.Page = 1
+	}
+	if request.GetLimit() < 1 {
+		request.Limit = 10
+	}
+
+	resp, err := u.storage.RoleChanges().GetAll(ctx, request)
+	if err != nil {
+		u.log.Error("error while getting role changes in service layer", logger.Error(err))
+		return &pb.RoleChanges{}, err
+	}
+
 	return resp, nil
 }
+
+// checkMfaCode guards changes to an enabled second factor with a current code of it
+func (u *userService) checkMfaCode(ctx context.Context, request *pb.TotpCodeRequest) error {
+
+	if err := u.checkLockout(ctx, request.GetUserId()); err != nil {
+		return err
+	}
+
+	match, err := u.mfa.verify(ctx, request.GetUserId(), request.GetCode())
+	if err != nil {
+		if statusErr := mfaError(err); statusErr != nil {
+			return statusErr
+		}
+		u.log.Error("error while verifying mfa code in service layer", logger.Error(err))
+		return err
+	}
+
+	if !match {
+		return u.attemptFailed(ctx, request.GetUserId(), statusWithReason(codes.PermissionDenied, "MFA_CODE_INVALID", "invalid two factor code"))
+	}
+	u.attemptSucceeded(ctx, request.GetUserId())
+
+	return nil
+}
+
+// checkLockout returns an account locked status while the user is locked out
+func (u *userService) checkLockout(ctx context.Context, userId string) error {
+
+	lockedUntil, err := u.lockout.lockedUntil(ctx, userId)
+	if err != nil {
+		u.log.Error("error while checking account lockout in service layer", logger.Error(err))
+		return err
+	}
+
+	if !lockedUntil.IsZero() {
+		return accountLockedError(lockedUntil)
+	}
+
+	return nil
+}
+
+// attemptFailed counts a wrong password or code like a failed login and returns failure, or
+// an account locked status when the attempt locked the user out
+func (u *userService) attemptFailed(ctx context.Context, userId string, failure error) error {
+
+	lockedUntil, err := u.lockout.fail(ctx, userId)
+	if err != nil {
+		u.log.Error("error while recording failed attempt in service layer", logger.Error(err))
+		return failure
+	}
+
+	if !lockedUntil.IsZero() {
+		u.log.Warn("security event: account locked after failed attempts",
+			logger.String("event", "account_locked"),
+			logger.String("user_id", userId),
+		)
+		return accountLockedError(lockedUntil)
+	}
+
+	return failure
+}
+
+// attemptSucceeded clears the user's failed attempts, failing to do so must not fail the call
+func (u *userService) attemptSucceeded(ctx context.Context, userId string) {
+
+	if err := u.lockout.clear(ctx, userId); err != nil {
+		u.log.Error("error while clearing failed attempts in service layer", logger.Error(err))
+	}
+}
+
+// verifyPassword checks password against the given user's own hash only. An unknown
+// user costs as much as a wrong password and is reported the same way.
+func (u *userService) verifyPassword(ctx context.Context, userId, password string) (bool, error) {
+
+	hash, err := u.storage.Users().GetPasswordHash(ctx, &pb.PrimaryKey{Id: userId})
+	if errors.Is(err, pgx.ErrNoRows) {
+		u.hasher.VerifyNone(password)
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	match, _, err := u.hasher.Verify(hash, password)
+	if err != nil {
+		return false, err
+	}
+
+	return match, nil
+}
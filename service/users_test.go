@@ -0,0 +1,225 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"users_service/pkg/password"
+	"users_service/pkg/token/tokentest"
+
+	pb "users_service/genproto/users"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func newTestUserService(t *testing.T, strg *fakeStorage) *userService {
+	hasher := newTestHasher(t, password.SchemeArgon2id)
+
+	return &userService{
+		storage: strg,
+		hasher:  hasher,
+		policy:  newTestPolicy(t),
+		history: newPasswordHistory(strg, hasher, newTestConfig()),
+		mfa:     newTestMfa(t, strg, tokentest.NewManager(t)),
+		lockout: newLockout(strg, newTestConfig()),
+		roles:   newRoleChanges(strg, newTestConfig()),
+		log:     newTestLogger(t),
+	}
+}
+
+// The last three passwords can not be chosen again, older ones can
+func TestChangePasswordHistory(t *testing.T) {
+	var (
+		ctx     = context.Background()
+		strg    = newFakeStorage(t)
+		u       = newTestUserService(t, strg)
+		current = testPassword
+	)
+
+	change := func(newPassword string) error {
+		_, err := u.ChangePassword(ctx, &pb.ChangePassword{UserId: "user-1", CurrentPassword: current, NewPassword: newPassword})
+		if err == nil {
+			current = newPassword
+		}
+		return err
+	}
+
+	steps := []struct {
+		password string
+		wantCode codes.Code
+	}{
+		{testPassword, codes.InvalidArgument},
+		{"second password", codes.OK},
+		{"third password", codes.OK},
+		{testPassword, codes.InvalidArgument},
+		{"second password", codes.InvalidArgument},
+		{"fourth password", codes.OK},
+		{testPassword, codes.OK},
+	}
+
+	for i, step := range steps {
+		err := change(step.password)
+		if code := status.Code(err); code != step.wantCode {
+			t.Fatalf("step %d: ChangePassword(%q) = %v, want %s", i, step.password, err, step.wantCode)
+		}
+		if step.wantCode != codes.OK && errorReason(err) != "PASSWORD_POLICY_VIOLATION" {
+			t.Fatalf("step %d: reason = %q, want PASSWORD_POLICY_VIOLATION", i, errorReason(err))
+		}
+	}
+}
+
+func TestVerifyPassword(t *testing.T) {
+	var (
+		ctx = context.Background()
+		u   = newTestUserService(t, newFakeStorage(t))
+	)
+
+	tests := []struct {
+		name     string
+		userId   string
+		password string
+		wantCode codes.Code
+	}{
+		{"right password", "user-1", testPassword, codes.OK},
+		{"wrong password", "user-1", "wrong password", codes.Unauthenticated},
+		{"unknown user", "user-9", testPassword, codes.Unauthenticated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := u.VerifyPassword(ctx, &pb.VerifyPassword{UserId: tt.userId, Password: tt.password})
+			if code := status.Code(err); code != tt.wantCode {
+				t.Fatalf("VerifyPassword() = %v, want %s", err, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestChangePasswordWrongCurrent(t *testing.T) {
+	var (
+		ctx  = context.Background()
+		strg = newFakeStorage(t)
+		u    = newTestUserService(t, strg)
+		hash = strg.passwords["user-1"]
+	)
+
+	_, err := u.ChangePassword(ctx, &pb.ChangePassword{UserId: "user-1", CurrentPassword: "wrong password", NewPassword: "another password"})
+	if code := status.Code(err); code != codes.PermissionDenied {
+		t.Fatalf("ChangePassword() = %v, want %s", err, codes.PermissionDenied)
+	}
+	if strg.passwords["user-1"] != hash {
+		t.Fatal("password changed although the current password was wrong")
+	}
+}
+
+// Wrong passwords in user RPCs lock the account like failed logins do
+func TestPasswordRpcLockout(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name  string
+		check func(u *userService, password string) error
+	}{
+		{"verify password", func(u *userService, password string) error {
+			_, err := u.VerifyPassword(ctx, &pb.VerifyPassword{UserId: "user-1", Password: password})
+			return err
+		}},
+		{"change password", func(u *userService, password string) error {
+			_, err := u.ChangePassword(ctx, &pb.ChangePassword{UserId: "user-1", CurrentPassword: password, NewPassword: "another password"})
+			return err
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				strg = newFakeStorage(t)
+				u    = newTestUserService(t, strg)
+			)
+
+			for i := 1; i < u.lockout.threshold; i++ {
+				if err := tt.check(u, "wrong password"); errorReason(err) == "ACCOUNT_LOCKED" {
+					t.Fatalf("attempt %d locked the account", i)
+				}
+			}
+			if err := tt.check(u, "wrong password"); errorReason(err) != "ACCOUNT_LOCKED" {
+				t.Fatalf("last wrong attempt = %v, want ACCOUNT_LOCKED", err)
+			}
+
+			err := tt.check(u, testPassword)
+			if code := status.Code(err); code != codes.ResourceExhausted || errorReason(err) != "ACCOUNT_LOCKED" {
+				t.Fatalf("right password while locked = %v, want ACCOUNT_LOCKED", err)
+			}
+		})
+	}
+}
+
+func TestVerifyPasswordClearsFailures(t *testing.T) {
+	var (
+		ctx  = context.Background()
+		strg = newFakeStorage(t)
+		u    = newTestUserService(t, strg)
+	)
+
+	for i := 1; i < u.lockout.threshold; i++ {
+		u.VerifyPassword(ctx, &pb.VerifyPassword{UserId: "user-1", Password: "wrong password"})
+	}
+	if _, err := u.VerifyPassword(ctx, &pb.VerifyPassword{UserId: "user-1", Password: testPassword}); err != nil {
+		t.Fatalf("VerifyPassword: %v", err)
+	}
+
+	_, err := u.VerifyPassword(ctx, &pb.VerifyPassword{UserId: "user-1", Password: "wrong password"})
+	if errorReason(err) == "ACCOUNT_LOCKED" {
+		t.Fatal("failures before a right password still counted")
+	}
+}
+
+// A password set through Update is checked, hashed and kept in the history like ChangePassword does
+func TestUpdatePassword(t *testing.T) {
+	var (
+		ctx  = context.Background()
+		strg = newFakeStorage(t)
+		u    = newTestUserService(t, strg)
+	)
+
+	tests := []struct {
+		name     string
+		password string
+		wantCode codes.Code
+	}{
+		{"too short", "short", codes.InvalidArgument},
+		{"contains the email", "anna@example.com!", codes.InvalidArgument},
+		{"current password", testPassword, codes.InvalidArgument},
+		{"new password", "another password", codes.OK},
+		{"password just set", "another password", codes.InvalidArgument},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := u.Update(ctx, &pb.UpdateUser{Id: "user-1", PasswordHash: tt.password})
+			if code := status.Code(err); code != tt.wantCode {
+				t.Fatalf("Update() = %v, want %s", err, tt.wantCode)
+			}
+			if tt.wantCode != codes.OK {
+				if errorReason(err) != "PASSWORD_POLICY_VIOLATION" {
+					t.Fatalf("reason = %q, want PASSWORD_POLICY_VIOLATION", errorReason(err))
+				}
+				return
+			}
+			if resp.GetPassword() != "" {
+				t.Fatal("Update returned the password hash")
+			}
+		})
+	}
+
+	hash := strg.passwords["user-1"]
+	if hash == "another password" {
+		t.Fatal("password stored in plaintext")
+	}
+	if match, _, err := u.hasher.Verify(hash, "another password"); err != nil || !match {
+		t.Fatalf("stored hash does not match the new password: %v", err)
+	}
+	if len(strg.history["user-1"]) != 2 || strg.history["user-1"][0] != hash {
+		t.Fatalf("password history = %v, want the new hash first", strg.history["user-1"])
+	}
+}
@@ -0,0 +1,264 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"time"
+	"users_service/pkg/errs"
+	"users_service/pkg/logger"
+
+	pb "users_service/genproto/users"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// apiTokenTouchInterval limits how often validating a token writes its last use
+const apiTokenTouchInterval = time.Minute
+
+type apiTokensRepo struct {
+	db  *pgxpool.Pool
+	log logger.ILogger
+}
+
+func NewApiTokensRepo(db *pgxpool.Pool, log logger.ILogger) *apiTokensRepo {
+	return &apiTokensRepo{
+		db:  db,
+		log: log,
+	}
+}
+
+// Create stores a new api token by its digest, a zero expiresAt creates a token that never expires
+func (a *apiTokensRepo) Create(ctx context.Context, request *pb.CreateApiTokenRequest, prefix, tokenHash string, expiresAt time.Time) (*pb.ApiToken, error) {
+
+	var (
+		apiToken  = pb.ApiToken{}
+		expires   *time.Time
+		createdAt time.Time
+	)
+
+	if !expiresAt.IsZero() {
+		expires = &expiresAt
+	}
+
+	query := `insert into api_tokens (
+		user_id,
+		name,
+		token_prefix,
+		token_hash,
+		scopes,
+		expires_at
+	) values ($1, $2, $3, $4, $5, $6) returning
+		id,
+		user_id,
+		name,
+		token_prefix,
+		scopes,
+		expires_at,
+		created_at
+	`
+
+	if err := a.db.QueryRow(ctx, query,
+		request.GetUserId(),
+		request.GetName(),
+		prefix,
+		tokenHash,
+		request.GetScopes(),
+		expires,
+	).Scan(
+		&apiToken.Id,
+		&apiToken.UserId,
+		&apiToken.Name,
+		&apiToken.Prefix,
+		&apiToken.Scopes,
+		&expires,
+		&createdAt,
+	); err != nil {
+		a.log.Error("error while creating api token in storage layer", logger.Error(err))
+		return nil, err
+	}
+
+	apiToken.ExpiresAt = formatNullable(expires)
+	apiToken.CreatedAt = createdAt.Format(Layout)
+
+	return &apiToken, nil
+}
+
+// GetAll returns the user's api tokens that are not revoked, expired ones included
+func (a *apiTokensRepo) GetAll(ctx context.Context, request *pb.PrimaryKey) (*pb.ApiTokens, error) {
+
+	var (
+		apiTokens  = []*pb.ApiToken{}
+		expiresAt  *time.Time
+		lastUsedAt *time.Time
+		createdAt  time.Time
+	)
+
+	query := `
+		select
+			id,
+			user_id,
+			name,
+			token_prefix,
+			scopes,
+			expires_at,
+			last_used_at,
+			created_at
+		from
+			api_tokens
+		where
+			user_id = $1 and
+			revoked_at is null
+		order by created_at desc
+	`
+
+	rows, err := a.db.Query(ctx, query, request.GetId())
+	if err != nil {
+		a.log.Error("error while taking rows to get api tokens in storage layer", logger.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var apiToken pb.ApiToken
+		if err = rows.Scan(
+			&apiToken.Id,
+			&apiToken.UserId,
+			&apiToken.Name,
+			&apiToken.Prefix,
+			&apiToken.Scopes,
+			&expiresAt,
+			&lastUsedAt,
+			&createdAt,
+		); err != nil {
+			a.log.Error("error while scanning api token in storage layer", logger.Error(err))
+			return nil, err
+		}
+		apiToken.ExpiresAt = formatNullable(expiresAt)
+		apiToken.LastUsedAt = formatNullable(lastUsedAt)
+		apiToken.CreatedAt = createdAt.Format(Layout)
+
+		apiTokens = append(apiTokens, &apiToken)
+	}
+	if err = rows.Err(); err != nil {
+		a.log.Error("error while iterating api token rows in storage layer", logger.Error(err))
+		return nil, err
+	}
+
+	return &pb.ApiTokens{ApiTokens: apiTokens}, nil
+}
+
+// Revoke revokes one of the user's api tokens
+func (a *apiTokensRepo) Revoke(ctx context.Context, request *pb.RevokeApiTokenRequest) (*pb.Void, error) {
+
+	var pgErr *pgconn.PgError
+
+	query := `
+		update api_tokens set
+			revoked_at = now()
+		where
+			id = $1 and
+			user_id = $2 and
+			revoked_at is null
+	`
+
+	tag, err := a.db.Exec(ctx, query, request.GetTokenId(), request.GetUserId())
+	if err != nil {
+		// invalid_text_representation, the id is not a uuid
+		if errors.As(err, &pgErr) && pgErr.Code == "22P02" {
+			return nil, errs.ErrApiTokenNotFound
+		}
+		a.log.Error("error while revoking api token in storage layer", logger.Error(err))
+		return nil, err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return nil, errs.ErrApiTokenNotFound
+	}
+
+	return &pb.Void{}, nil
+}
+
+// Validate resolves a token digest to its user and scopes and records the use. Tokens of
+// deleted users are not found.
+func (a *apiTokensRepo) Validate(ctx context.Context, tokenHash string) (*pb.ApiTokenIdentity, error) {
+
+	var (
+		identity  = pb.ApiTokenIdentity{}
+		expiresAt *time.Time
+	)
+
+	query := `
+		select
+			t.id,
+			t.user_id,
+			t.scopes,
+			t.expires_at
+		from
+			api_tokens t
+		join
+			users u on u.id = t.user_id and u.deleted_at is null
+		where
+			t.token_hash = $1 and
+			t.revoked_at is null
+	`
+
+	if err := a.db.QueryRow(ctx, query, tokenHash).Scan(
+		&identity.TokenId,
+		&identity.UserId,
+		&identity.Scopes,
+		&expiresAt,
+	); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, errs.ErrApiTokenNotFound
+		}
+		a.log.Error("error while getting api token in storage layer", logger.Error(err))
+		return nil, err
+	}
+
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return nil, errs.ErrApiTokenExpired
+	}
+
+	if _, err := a.db.Exec(ctx, `
+		update api_tokens set
+			last_used_at = now()
+		where
+			id = $1 and
+			(last_used_at is null or last_used_at < $2)
+	`, identity.TokenId, time.Now().Add(-apiTokenTouchInterval)); err != nil {
+		a.log.Error("error while touching api token in storage layer", logger.Error(err))
+		return nil, err
+	}
+
+	return &identity, nil
+}
+
+// DeleteExpired removes up to limit revoked or expired api tokens
+func (a *apiTokensRepo) DeleteExpired(ctx context.Context, limit int) (int64, error) {
+
+	query := `
+		delete from
+			api_tokens
+		where
+			id in (
+				select
+					id
+				from
+					api_tokens
+				where
+					expires_at < now() or
+					revoked_at is not null
+				limit $1
+			)
+	`
+
+	tag, err := a.db.Exec(ctx, query, limit)
+	if err != nil {
+		a.log.Error("error while deleting expired api tokens in storage layer", logger.Error(err))
+		return 0, err
+	}
+
+	return tag.RowsAffected(), nil
+}
@@ -2,12 +2,15 @@ package postgres
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
+	"users_service/pkg/errs"
 	"users_service/pkg/logger"
 
 	pb "users_service/genproto/users"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -33,17 +36,33 @@ func (a *authRepo) Create(ctx context.Context, request *pb.CreateUser) (*pb.User
 		createdAt time.Time
 	)
 
-	query = `insert into users (
-		email,
-		password_hash,
-		full_name,
-		created_at
-	) values ($1, $2, $3, $4) returning 
+	query = `with created as (
+		insert into users (
+			email,
+			password_hash,
+			full_name,
+			created_at
+		) values ($1, $2, $3, $4) returning 
+			id,
+			email,
+			password_hash,
+			full_name,
+			user_role,
+			created_at
+	), history as (
+		insert into password_history (
+			user_id,
+			password_hash
+		) select id, password_hash from created
+	)
+	select
 		id,
 		email,
 		full_name,
 		user_role,
 		created_at
+	from
+		created
 	`
 
 	if err = a.db.QueryRow(ctx, query,
@@ -81,9 +100,11 @@ func (a *authRepo) GetByEmail(ctx context.Context, request *pb.Email) (*pb.UserB
 		id,
 		email,
 		full_name,
-		password_hash,
+		coalesce(password_hash, ''),
 		user_role,
-		created_at
+		created_at,
+		email_verified_at is not null,
+		mfa_enabled
 	from 
 		users 
 	where
@@ -98,6 +119,8 @@ func (a *authRepo) GetByEmail(ctx context.Context, request *pb.Email) (*pb.UserB
 		&user.Password,
 		&user.UserRole,
 		&createdAt,
+		&user.EmailVerified,
+		&user.MfaEnabled,
 	); err != nil {
 		a.log.Error("error while getting user id by username", logger.Error(err))
 		return nil, err
@@ -126,72 +149,245 @@ func (a *authRepo) DeleteRefreshTokenByUserId(ctx context.Context, request *pb.P
 		a.log.Error("error while deleting user's refresh token from toble", logger.Error(err))
 		return &pb.Void{}, err
 	}
+
+	if _, err = a.db.Exec(ctx, `update sessions set revoked_at = now() where user_id = $1 and revoked_at is null`, request.Id); err != nil {
+		a.log.Error("error while revoking user's sessions", logger.Error(err))
+		return &pb.Void{}, err
+	}
+
 	return &pb.Void{}, nil
 }
 
 func (a *authRepo) StoreRefreshToken(ctx context.Context, request *pb.RefreshToken) (*pb.Void, error) {
 
-	var (
-		query string
-		err   error
-		// expiresIn time.Time
-	)
+	expiresIn, err := refreshTokenExpiry(request)
+	if err != nil {
+		return &pb.Void{}, err
+	}
 
-	query = `
+	query := `
 	insert into refresh_tokens (
 		user_id,
-		refresh_token,
-		expires_in
-	) values ($1, $2, $3)
+		token_hash,
+		expires_in,
+		family_id
+	) values ($1, $2, $3, $4)
 	`
-	// expiresIn, err = time.Parse(time.RFC3339, request.ExpiresIn)
-	// if err != nil {
-	// 	return &pb.Void{}, err
-	// }
 
 	if _, err = a.db.Exec(ctx, query,
 		request.UserId,
 		request.RefreshToken,
-		request.ExpiresIn,
+		expiresIn,
+		request.FamilyId,
 	); err != nil {
+		a.log.Error("error while storing refresh token in storage layer", logger.Error(err))
 		return &pb.Void{}, err
 	}
 
 	return &pb.Void{}, nil
 }
 
+// refreshTokenExpiry parses the RFC3339 expiry of token, it fails with
+// ErrRefreshTokenExpiryInvalid unless the expiry lies in the future
+func refreshTokenExpiry(token *pb.RefreshToken) (time.Time, error) {
+	expiresIn, err := time.Parse(time.RFC3339, token.GetExpiresIn())
+	if err != nil || !expiresIn.After(time.Now()) {
+		return time.Time{}, errs.ErrRefreshTokenExpiryInvalid
+	}
+	return expiresIn, nil
+}
+
 func (a *authRepo) CheckRefreshTokenExists(ctx context.Context, request *pb.RequestRefreshToken) (*pb.Void, error) {
 
 	var (
-		query string
-		err   error
-		exist int
+		query     string
+		err       error
+		expiresIn time.Time
 	)
 
 	query = `
 		select
-			1
+			expires_in
 		from
 			refresh_tokens
 		where
-			refresh_token = $1
+			token_hash = $1 and
+			consumed_at is null and
+			revoked_at is null
 	`
 
-	err = a.db.QueryRow(ctx, query, request.RefreshToken).Scan(&exist)
-
-	if err != nil && err.Error() != "no rows in result set" {
+	if err = a.db.QueryRow(ctx, query, request.RefreshToken).Scan(&expiresIn); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return &pb.Void{}, errs.ErrRefreshTokenNotFound
+		}
 		a.log.Error("error while checking refresh token is exists", logger.Error(err))
 		return &pb.Void{}, err
 	}
 
-	if exist != 1 {
-		a.log.Error("error: refresh token not found in database")
-		return &pb.Void{}, fmt.Errorf("error: refresh token not found in database")
+	if !expiresIn.After(time.Now()) {
+		return &pb.Void{}, errs.ErrRefreshTokenExpired
 	}
 
 	return &pb.Void{}, nil
 }
 
+// RotateRefreshToken consumes the presented refresh token and stores its successor in the same family.
+// Presenting an already consumed token revokes the whole family and returns errs.ErrRefreshTokenReused
+// together with the presented token's owner and family.
+func (a *authRepo) RotateRefreshToken(ctx context.Context, request *pb.RequestRefreshToken, next *pb.RefreshToken) (*pb.RefreshToken, error) {
+
+	var (
+		current    = pb.RefreshToken{RefreshToken: request.GetRefreshToken()}
+		id         string
+		expiresIn  time.Time
+		consumedAt *time.Time
+		revokedAt  *time.Time
+	)
+
+	tx, err := a.db.Begin(ctx)
+	if err != nil {
+		a.log.Error("error while starting transaction to rotate refresh token", logger.Error(err))
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	query := `
+		select
+			id,
+			user_id,
+			family_id,
+			expires_in,
+			consumed_at,
+			revoked_at
+		from
+			refresh_tokens
+		where
+			token_hash = $1
+		for update
+	`
+
+	if err = tx.QueryRow(ctx, query, request.GetRefreshToken()).Scan(
+		&id,
+		&current.UserId,
+		&current.FamilyId,
+		&expiresIn,
+		&consumedAt,
+		&revokedAt,
+	); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, errs.ErrRefreshTokenNotFound
+		}
+		a.log.Error("error while getting refresh token to rotate", logger.Error(err))
+		return nil, err
+	}
+
+	if revokedAt != nil {
+		return &current, errs.ErrRefreshTokenRevoked
+	}
+
+	if consumedAt != nil {
+		if _, err = tx.Exec(ctx, `
+			update
+				refresh_tokens
+			set
+				revoked_at = now()
+			where
+				family_id = $1 and
+				revoked_at is null
+		`, current.FamilyId); err != nil {
+			a.log.Error("error while revoking refresh token family", logger.Error(err))
+			return nil, err
+		}
+
+		if _, err = tx.Exec(ctx, `update sessions set revoked_at = now() where id = $1`, current.FamilyId); err != nil {
+			a.log.Error("error while revoking session of refresh token family", logger.Error(err))
+			return nil, err
+		}
+
+		if err = tx.Commit(ctx); err != nil {
+			a.log.Error("error while committing refresh token family revocation", logger.Error(err))
+			return nil, err
+		}
+
+		return &current, errs.ErrRefreshTokenReused
+	}
+
+	if !expiresIn.After(time.Now()) {
+		return &current, errs.ErrRefreshTokenExpired
+	}
+
+	if _, err = tx.Exec(ctx, `update refresh_tokens set consumed_at = now() where id = $1`, id); err != nil {
+		a.log.Error("error while consuming refresh token", logger.Error(err))
+		return nil, err
+	}
+
+	if _, err = tx.Exec(ctx, `update sessions set last_used_at = now() where id = $1`, current.FamilyId); err != nil {
+		a.log.Error("error while updating session last used time", logger.Error(err))
+		return nil, err
+	}
+
+	query = `
+	insert into refresh_tokens (
+		user_id,
+		token_hash,
+		expires_in,
+		family_id,
+		parent_id
+	) values ($1, $2, $3, $4, $5)
+	`
+
+	if _, err = tx.Exec(ctx, query,
+		current.UserId,
+		next.GetRefreshToken(),
+		next.GetExpiresIn(),
+		current.FamilyId,
+		id,
+	); err != nil {
+		a.log.Error("error while storing rotated refresh token", logger.Error(err))
+		return nil, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		a.log.Error("error while committing refresh token rotation", logger.Error(err))
+		return nil, err
+	}
+
+	return &pb.RefreshToken{
+		UserId:       current.UserId,
+		RefreshToken: next.GetRefreshToken(),
+		ExpiresIn:    next.GetExpiresIn(),
+		FamilyId:     current.FamilyId,
+	}, nil
+}
+
+// DeleteExpiredRefreshTokens removes up to limit expired or revoked refresh tokens and returns how many were deleted
+func (a *authRepo) DeleteExpiredRefreshTokens(ctx context.Context, limit int) (int64, error) {
+
+	query := `
+		delete from
+			refresh_tokens
+		where
+			id in (
+				select
+					id
+				from
+					refresh_tokens
+				where
+					expires_in < now() or
+					revoked_at is not null
+				limit $1
+			)
+	`
+
+	tag, err := a.db.Exec(ctx, query, limit)
+	if err != nil {
+		a.log.Error("error while deleting expired refresh tokens in storage layer", logger.Error(err))
+		return 0, err
+	}
+
+	return tag.RowsAffected(), nil
+}
+
 func (a *authRepo) CheckEmailExists(ctx context.Context, request *pb.Email) (*pb.Void, error) {
 
 	var exist int
@@ -219,27 +415,83 @@ func (a *authRepo) CheckEmailExists(ctx context.Context, request *pb.Email) (*pb
 	return &pb.Void{}, nil
 }
 
-func (a *authRepo) ResetPassword(ctx context.Context, request *pb.ResetPassword) (*pb.Void, error) {
+// GetLockedUntil returns until when the user is locked out, zero time when the user is not locked
+func (a *authRepo) GetLockedUntil(ctx context.Context, userId string) (time.Time, error) {
 
-	var (
-		query string
-		err   error
-	)
+	var lockedUntil *time.Time
 
-	query = `
-		update 
+	query := `
+		select
+			locked_until
+		from
+			users
+		where
+			id = $1
+	`
+
+	if err := a.db.QueryRow(ctx, query, userId).Scan(&lockedUntil); err != nil {
+		a.log.Error("error while getting user lockout in storage layer", logger.Error(err))
+		return time.Time{}, err
+	}
+
+	if lockedUntil == nil {
+		return time.Time{}, nil
+	}
+
+	return *lockedUntil, nil
+}
+
+// RecordFailedLogin increments the user's consecutive failed login count and returns it
+func (a *authRepo) RecordFailedLogin(ctx context.Context, userId string) (int, error) {
+
+	var count int
+
+	query := `
+		update
 			users
 		set
-			password_hash = $1
+			failed_login_count = failed_login_count + 1
 		where
-			email = $2
+			id = $1
+		returning
+			failed_login_count
 	`
 
-	if _, err = a.db.Exec(ctx, query,
-		request.NewPassword,
-		request.Email,
-	); err != nil {
-		a.log.Error("error while saving new password in storage layer", logger.Error(err))
+	if err := a.db.QueryRow(ctx, query, userId).Scan(&count); err != nil {
+		a.log.Error("error while recording failed login in storage layer", logger.Error(err))
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// LockUser rejects logins of the user until the given time
+func (a *authRepo) LockUser(ctx context.Context, userId string, until time.Time) error {
+
+	if _, err := a.db.Exec(ctx, `update users set locked_until = $2 where id = $1`, userId, until); err != nil {
+		a.log.Error("error while locking user in storage layer", logger.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// ResetFailedLogins clears the user's failed login count and any lockout
+func (a *authRepo) ResetFailedLogins(ctx context.Context, userId string) (*pb.Void, error) {
+
+	query := `
+		update
+			users
+		set
+			failed_login_count = 0,
+			locked_until = null
+		where
+			id = $1 and
+			(failed_login_count > 0 or locked_until is not null)
+	`
+
+	if _, err := a.db.Exec(ctx, query, userId); err != nil {
+		a.log.Error("error while resetting failed logins in storage layer", logger.Error(err))
 		return &pb.Void{}, err
 	}
 
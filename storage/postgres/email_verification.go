@@ -0,0 +1,151 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"time"
+	"users_service/pkg/errs"
+	"users_service/pkg/logger"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type emailVerificationRepo struct {
+	db  *pgxpool.Pool
+	log logger.ILogger
+}
+
+func NewEmailVerificationRepo(db *pgxpool.Pool, log logger.ILogger) *emailVerificationRepo {
+	return &emailVerificationRepo{
+		db:  db,
+		log: log,
+	}
+}
+
+// Create stores a verification token digest for the user's email
+func (e *emailVerificationRepo) Create(ctx context.Context, userId, email, tokenHash string, expiresAt time.Time) error {
+
+	query := `
+	insert into email_verification_tokens (
+		user_id,
+		email,
+		token_hash,
+		expires_at
+	) values ($1, $2, $3, $4)
+	`
+
+	if _, err := e.db.Exec(ctx, query, userId, email, tokenHash, expiresAt); err != nil {
+		e.log.Error("error while storing email verification token in storage layer", logger.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// Consume marks the token used, verifies the owner's email and invalidates the owner's other
+// outstanding tokens. It returns the id of the verified user. Tokens mailed to an address the
+// user has changed since are not found.
+func (e *emailVerificationRepo) Consume(ctx context.Context, tokenHash string) (string, error) {
+
+	var (
+		userId    string
+		expiresAt time.Time
+	)
+
+	tx, err := e.db.Begin(ctx)
+	if err != nil {
+		e.log.Error("error while starting transaction to verify email", logger.Error(err))
+		return "", err
+	}
+	defer tx.Rollback(ctx)
+
+	query := `
+		select
+			t.user_id,
+			t.expires_at
+		from
+			email_verification_tokens t
+		join
+			users u on u.id = t.user_id
+		where
+			t.token_hash = $1 and
+			t.used_at is null and
+			t.email = u.email and
+			u.deleted_at is null
+		for update
+	`
+
+	if err = tx.QueryRow(ctx, query, tokenHash).Scan(&userId, &expiresAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", errs.ErrVerificationTokenNotFound
+		}
+		e.log.Error("error while getting email verification token in storage layer", logger.Error(err))
+		return "", err
+	}
+
+	if !expiresAt.After(time.Now()) {
+		return "", errs.ErrVerificationTokenExpired
+	}
+
+	if _, err = tx.Exec(ctx, `
+		update
+			email_verification_tokens
+		set
+			used_at = now()
+		where
+			user_id = $1 and
+			used_at is null
+	`, userId); err != nil {
+		e.log.Error("error while consuming email verification tokens in storage layer", logger.Error(err))
+		return "", err
+	}
+
+	if _, err = tx.Exec(ctx, `
+		update
+			users
+		set
+			email_verified_at = now()
+		where
+			id = $1 and
+			email_verified_at is null
+	`, userId); err != nil {
+		e.log.Error("error while marking email verified in storage layer", logger.Error(err))
+		return "", err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		e.log.Error("error while committing email verification", logger.Error(err))
+		return "", err
+	}
+
+	return userId, nil
+}
+
+// DeleteExpired removes up to limit used or expired verification tokens
+func (e *emailVerificationRepo) DeleteExpired(ctx context.Context, limit int) (int64, error) {
+
+	query := `
+		delete from
+			email_verification_tokens
+		where
+			id in (
+				select
+					id
+				from
+					email_verification_tokens
+				where
+					expires_at < now() or
+					used_at is not null
+				limit $1
+			)
+	`
+
+	tag, err := e.db.Exec(ctx, query, limit)
+	if err != nil {
+		e.log.Error("error while deleting expired email verification tokens in storage layer", logger.Error(err))
+		return 0, err
+	}
+
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,301 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"time"
+	"users_service/pkg/errs"
+	"users_service/pkg/logger"
+
+	pb "users_service/genproto/users"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type identitiesRepo struct {
+	db  *pgxpool.Pool
+	log logger.ILogger
+}
+
+func NewIdentitiesRepo(db *pgxpool.Pool, log logger.ILogger) *identitiesRepo {
+	return &identitiesRepo{
+		db:  db,
+		log: log,
+	}
+}
+
+// Create links an external identity to a user
+func (i *identitiesRepo) Create(ctx context.Context, request *pb.Identity) (*pb.Identity, error) {
+
+	var (
+		identity  = pb.Identity{}
+		createdAt time.Time
+	)
+
+	query := `insert into user_identities (
+		user_id,
+		provider,
+		subject,
+		email
+	) values ($1, $2, $3, $4) returning
+		id,
+		user_id,
+		provider,
+		subject,
+		email,
+		created_at
+	`
+
+	if err := i.db.QueryRow(ctx, query,
+		request.GetUserId(),
+		request.GetProvider(),
+		request.GetSubject(),
+		request.GetEmail(),
+	).Scan(
+		&identity.Id,
+		&identity.UserId,
+		&identity.Provider,
+		&identity.Subject,
+		&identity.Email,
+		&createdAt,
+	); err != nil {
+		if isUniqueViolation(err) {
+			return nil, errs.ErrIdentityAlreadyLinked
+		}
+		i.log.Error("error while creating identity in storage layer", logger.Error(err))
+		return nil, err
+	}
+
+	identity.CreatedAt = createdAt.Format(Layout)
+
+	return &identity, nil
+}
+
+// CreateWithUser creates a user without a password together with the identity it signed up with.
+// The email counts as verified when the provider verified it.
+func (i *identitiesRepo) CreateWithUser(ctx context.Context, request *pb.CreateUser, identity *pb.Identity, emailVerified bool) (*pb.User, error) {
+
+	var (
+		user      = pb.User{}
+		createdAt time.Time
+	)
+
+	tx, err := i.db.Begin(ctx)
+	if err != nil {
+		i.log.Error("error while starting transaction to create user with identity", logger.Error(err))
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	query := `insert into users (
+		email,
+		full_name,
+		email_verified_at
+	) values ($1, $2, case when $3 then now() end) returning
+		id,
+		email,
+		full_name,
+		user_role,
+		created_at,
+		email_verified_at is not null
+	`
+
+	if err = tx.QueryRow(ctx, query,
+		request.GetEmail(),
+		request.GetFullName(),
+		emailVerified,
+	).Scan(
+		&user.Id,
+		&user.Email,
+		&user.FullName,
+		&user.UserRole,
+		&createdAt,
+		&user.EmailVerified,
+	); err != nil {
+		if isUniqueViolation(err) {
+			return nil, errs.ErrEmailTaken
+		}
+		i.log.Error("error while creating user with identity in storage layer", logger.Error(err))
+		return nil, err
+	}
+
+	if _, err = tx.Exec(ctx, `
+		insert into user_identities (
+			user_id,
+			provider,
+			subject,
+			email
+		) values ($1, $2, $3, $4)
+	`, user.Id, identity.GetProvider(), identity.GetSubject(), identity.GetEmail()); err != nil {
+		if isUniqueViolation(err) {
+			return nil, errs.ErrIdentityAlreadyLinked
+		}
+		i.log.Error("error while creating identity of new user in storage layer", logger.Error(err))
+		return nil, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		i.log.Error("error while committing user with identity", logger.Error(err))
+		return nil, err
+	}
+
+	user.CreatedAt = createdAt.Format(Layout)
+
+	return &user, nil
+}
+
+// GetBySubject returns the identity a provider knows by subject
+func (i *identitiesRepo) GetBySubject(ctx context.Context, provider, subject string) (*pb.Identity, error) {
+
+	var (
+		identity  = pb.Identity{}
+		createdAt time.Time
+	)
+
+	query := `
+		select
+			id,
+			user_id,
+			provider,
+			subject,
+			email,
+			created_at
+		from
+			user_identities
+		where
+			provider = $1 and
+			subject = $2
+	`
+
+	if err := i.db.QueryRow(ctx, query, provider, subject).Scan(
+		&identity.Id,
+		&identity.UserId,
+		&identity.Provider,
+		&identity.Subject,
+		&identity.Email,
+		&createdAt,
+	); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, errs.ErrIdentityNotFound
+		}
+		i.log.Error("error while getting identity in storage layer", logger.Error(err))
+		return nil, err
+	}
+
+	identity.CreatedAt = createdAt.Format(Layout)
+
+	return &identity, nil
+}
+
+// GetAll returns every identity linked to the user
+func (i *identitiesRepo) GetAll(ctx context.Context, request *pb.PrimaryKey) (*pb.Identities, error) {
+
+	var (
+		identities = []*pb.Identity{}
+		createdAt  time.Time
+	)
+
+	query := `
+		select
+			id,
+			user_id,
+			provider,
+			subject,
+			email,
+			created_at
+		from
+			user_identities
+		where
+			user_id = $1
+		order by created_at
+	`
+
+	rows, err := i.db.Query(ctx, query, request.GetId())
+	if err != nil {
+		i.log.Error("error while taking rows to get identities in storage layer", logger.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var identity pb.Identity
+		if err = rows.Scan(
+			&identity.Id,
+			&identity.UserId,
+			&identity.Provider,
+			&identity.Subject,
+			&identity.Email,
+			&createdAt,
+		); err != nil {
+			i.log.Error("error while scanning identity in storage layer", logger.Error(err))
+			return nil, err
+		}
+		identity.CreatedAt = createdAt.Format(Layout)
+
+		identities = append(identities, &identity)
+	}
+	if err = rows.Err(); err != nil {
+		i.log.Error("error while iterating identity rows in storage layer", logger.Error(err))
+		return nil, err
+	}
+
+	return &pb.Identities{Identities: identities}, nil
+}
+
+// Delete unlinks the user's identity of a provider. It refuses to remove the last way
+// a user without a password can log in.
+func (i *identitiesRepo) Delete(ctx context.Context, request *pb.UnlinkIdentityRequest) (*pb.Void, error) {
+
+	var (
+		hasPassword bool
+		identities  int
+	)
+
+	tx, err := i.db.Begin(ctx)
+	if err != nil {
+		i.log.Error("error while starting transaction to unlink identity", logger.Error(err))
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	query := `
+		select
+			password_hash is not null and password_hash <> '',
+			(select count(*) from user_identities where user_id = u.id)
+		from
+			users u
+		where
+			id = $1
+		for update
+	`
+
+	if err = tx.QueryRow(ctx, query, request.GetUserId()).Scan(&hasPassword, &identities); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, errs.ErrIdentityNotFound
+		}
+		i.log.Error("error while getting login methods to unlink identity in storage layer", logger.Error(err))
+		return nil, err
+	}
+
+	if !hasPassword && identities <= 1 {
+		return nil, errs.ErrLastLoginMethod
+	}
+
+	tag, err := tx.Exec(ctx, `delete from user_identities where user_id = $1 and provider = $2`, request.GetUserId(), request.GetProvider())
+	if err != nil {
+		i.log.Error("error while unlinking identity in storage layer", logger.Error(err))
+		return nil, err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return nil, errs.ErrIdentityNotFound
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		i.log.Error("error while committing identity unlinking", logger.Error(err))
+		return nil, err
+	}
+
+	return &pb.Void{}, nil
+}
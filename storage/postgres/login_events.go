@@ -0,0 +1,155 @@
+package postgres
+
+import (
+	"context"
+	"time"
+	"users_service/pkg/logger"
+
+	pb "users_service/genproto/users"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type loginEventsRepo struct {
+	db  *pgxpool.Pool
+	log logger.ILogger
+}
+
+func NewLoginEventsRepo(db *pgxpool.Pool, log logger.ILogger) *loginEventsRepo {
+	return &loginEventsRepo{
+		db:  db,
+		log: log,
+	}
+}
+
+// Create records a login attempt, attempts for unknown emails are stored without a user
+func (l *loginEventsRepo) Create(ctx context.Context, request *pb.LoginEvent) error {
+
+	query := `
+	insert into login_events (
+		user_id,
+		email,
+		success,
+		failure_reason,
+		method,
+		ip_address,
+		user_agent
+	) values (nullif($1, '')::uuid, $2, $3, nullif($4, ''), $5, $6, $7)
+	`
+
+	if _, err := l.db.Exec(ctx, query,
+		request.GetUserId(),
+		request.GetEmail(),
+		request.GetSuccess(),
+		request.GetFailureReason(),
+		request.GetMethod(),
+		request.GetIpAddress(),
+		request.GetUserAgent(),
+	); err != nil {
+		l.log.Error("error while creating login event in storage layer", logger.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// GetAll returns a page of the user's login events, newest first
+func (l *loginEventsRepo) GetAll(ctx context.Context, request *pb.LoginHistoryRequest) (*pb.LoginEvents, error) {
+
+	var (
+		events    = []*pb.LoginEvent{}
+		offset    = int64(request.GetPage()-1) * request.GetLimit()
+		count     int
+		createdAt time.Time
+	)
+
+	if err := l.db.QueryRow(ctx, `select count(*) from login_events where user_id = $1`, request.GetUserId()).Scan(&count); err != nil {
+		l.log.Error("error while taking count of login events in storage layer", logger.Error(err))
+		return nil, err
+	}
+
+	query := `
+		select
+			id,
+			user_id,
+			email,
+			success,
+			coalesce(failure_reason, ''),
+			method,
+			ip_address,
+			user_agent,
+			created_at
+		from
+			login_events
+		where
+			user_id = $1
+		order by created_at desc
+		limit $2 offset $3
+	`
+
+	rows, err := l.db.Query(ctx, query, request.GetUserId(), request.GetLimit(), offset)
+	if err != nil {
+		l.log.Error("error while taking rows to get login events in storage layer", logger.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var event pb.LoginEvent
+		if err = rows.Scan(
+			&event.Id,
+			&event.UserId,
+			&event.Email,
+			&event.Success,
+			&event.FailureReason,
+			&event.Method,
+			&event.IpAddress,
+			&event.UserAgent,
+			&createdAt,
+		); err != nil {
+			l.log.Error("error while scanning login event in storage layer", logger.Error(err))
+			return nil, err
+		}
+		event.CreatedAt = createdAt.Format(Layout)
+
+		events = append(events, &event)
+	}
+	if err = rows.Err(); err != nil {
+		l.log.Error("error while iterating login event rows in storage layer", logger.Error(err))
+		return nil, err
+	}
+
+	return &pb.LoginEvents{
+		Events: events,
+		Page:   request.GetPage(),
+		Limit:  request.GetLimit(),
+		Count:  int32(count),
+	}, nil
+}
+
+// DeleteExpired removes up to limit login events recorded before olderThan
+func (l *loginEventsRepo) DeleteExpired(ctx context.Context, olderThan time.Time, limit int) (int64, error) {
+
+	query := `
+		delete from
+			login_events
+		where
+			id in (
+				select
+					id
+				from
+					login_events
+				where
+					created_at < $1
+				limit $2
+			)
+	`
+
+	tag, err := l.db.Exec(ctx, query, olderThan, limit)
+	if err != nil {
+		l.log.Error("error while deleting expired login events in storage layer", logger.Error(err))
+		return 0, err
+	}
+
+	return tag.RowsAffected(), nil
+}
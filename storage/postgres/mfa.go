@@ -0,0 +1,238 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"users_service/pkg/errs"
+	"users_service/pkg/logger"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type mfaRepo struct {
+	db  *pgxpool.Pool
+	log logger.ILogger
+}
+
+func NewMfaRepo(db *pgxpool.Pool, log logger.ILogger) *mfaRepo {
+	return &mfaRepo{
+		db:  db,
+		log: log,
+	}
+}
+
+// SaveSecret stores a new, not yet confirmed TOTP secret for the user replacing a previous unconfirmed one
+func (m *mfaRepo) SaveSecret(ctx context.Context, userId, encryptedSecret string) error {
+
+	query := `
+	insert into user_totp (
+		user_id,
+		secret_encrypted
+	) values ($1, $2)
+	on conflict (user_id) do update set
+		secret_encrypted = excluded.secret_encrypted,
+		last_used_step = null,
+		confirmed_at = null,
+		created_at = now()
+	`
+
+	if _, err := m.db.Exec(ctx, query, userId, encryptedSecret); err != nil {
+		m.log.Error("error while saving totp secret in storage layer", logger.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// GetSecret returns the user's encrypted TOTP secret and whether it is confirmed
+func (m *mfaRepo) GetSecret(ctx context.Context, userId string) (string, bool, error) {
+
+	var (
+		encryptedSecret string
+		confirmed       bool
+	)
+
+	query := `
+		select
+			secret_encrypted,
+			confirmed_at is not null
+		from
+			user_totp
+		where
+			user_id = $1
+	`
+
+	if err := m.db.QueryRow(ctx, query, userId).Scan(&encryptedSecret, &confirmed); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", false, errs.ErrMfaNotEnrolled
+		}
+		m.log.Error("error while getting totp secret in storage layer", logger.Error(err))
+		return "", false, err
+	}
+
+	return encryptedSecret, confirmed, nil
+}
+
+// UseStep records step as the last used TOTP time step. It reports false when a code of
+// the same or a later step was already used, so every code is accepted only once.
+func (m *mfaRepo) UseStep(ctx context.Context, userId string, step int64) (bool, error) {
+
+	query := `
+		update
+			user_totp
+		set
+			last_used_step = $2
+		where
+			user_id = $1 and
+			(last_used_step is null or last_used_step < $2)
+	`
+
+	tag, err := m.db.Exec(ctx, query, userId, step)
+	if err != nil {
+		m.log.Error("error while using totp step in storage layer", logger.Error(err))
+		return false, err
+	}
+
+	return tag.RowsAffected() == 1, nil
+}
+
+// Enable confirms the user's TOTP secret, turns on mfa and replaces the recovery codes
+func (m *mfaRepo) Enable(ctx context.Context, userId string, step int64, codeHashes []string) error {
+
+	tx, err := m.db.Begin(ctx)
+	if err != nil {
+		m.log.Error("error while starting transaction to enable mfa", logger.Error(err))
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `
+		update
+			user_totp
+		set
+			confirmed_at = now(),
+			last_used_step = $2
+		where
+			user_id = $1 and
+			confirmed_at is null
+	`, userId, step)
+	if err != nil {
+		m.log.Error("error while confirming totp secret in storage layer", logger.Error(err))
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return errs.ErrMfaNotEnrolled
+	}
+
+	if _, err = tx.Exec(ctx, `update users set mfa_enabled = true where id = $1`, userId); err != nil {
+		m.log.Error("error while enabling mfa in storage layer", logger.Error(err))
+		return err
+	}
+
+	if err = replaceRecoveryCodes(ctx, tx, userId, codeHashes); err != nil {
+		m.log.Error("error while storing recovery codes in storage layer", logger.Error(err))
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		m.log.Error("error while committing mfa enabling", logger.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// ReplaceRecoveryCodes drops every recovery code of the user and stores codeHashes instead
+func (m *mfaRepo) ReplaceRecoveryCodes(ctx context.Context, userId string, codeHashes []string) error {
+
+	tx, err := m.db.Begin(ctx)
+	if err != nil {
+		m.log.Error("error while starting transaction to replace recovery codes", logger.Error(err))
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err = replaceRecoveryCodes(ctx, tx, userId, codeHashes); err != nil {
+		m.log.Error("error while replacing recovery codes in storage layer", logger.Error(err))
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		m.log.Error("error while committing recovery codes", logger.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// UseRecoveryCode marks an unused recovery code of the user as used and reports whether there was one
+func (m *mfaRepo) UseRecoveryCode(ctx context.Context, userId, codeHash string) (bool, error) {
+
+	query := `
+		update
+			recovery_codes
+		set
+			used_at = now()
+		where
+			user_id = $1 and
+			code_hash = $2 and
+			used_at is null
+	`
+
+	tag, err := m.db.Exec(ctx, query, userId, codeHash)
+	if err != nil {
+		m.log.Error("error while using recovery code in storage layer", logger.Error(err))
+		return false, err
+	}
+
+	return tag.RowsAffected() == 1, nil
+}
+
+// Disable turns off mfa and removes the user's TOTP secret and recovery codes
+func (m *mfaRepo) Disable(ctx context.Context, userId string) error {
+
+	tx, err := m.db.Begin(ctx)
+	if err != nil {
+		m.log.Error("error while starting transaction to disable mfa", logger.Error(err))
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err = tx.Exec(ctx, `delete from recovery_codes where user_id = $1`, userId); err != nil {
+		m.log.Error("error while deleting recovery codes in storage layer", logger.Error(err))
+		return err
+	}
+
+	if _, err = tx.Exec(ctx, `delete from user_totp where user_id = $1`, userId); err != nil {
+		m.log.Error("error while deleting totp secret in storage layer", logger.Error(err))
+		return err
+	}
+
+	if _, err = tx.Exec(ctx, `update users set mfa_enabled = false where id = $1`, userId); err != nil {
+		m.log.Error("error while disabling mfa in storage layer", logger.Error(err))
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		m.log.Error("error while committing mfa disabling", logger.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx pgx.Tx, userId string, codeHashes []string) error {
+	if _, err := tx.Exec(ctx, `delete from recovery_codes where user_id = $1`, userId); err != nil {
+		return err
+	}
+
+	for _, codeHash := range codeHashes {
+		if _, err := tx.Exec(ctx, `insert into recovery_codes (user_id, code_hash) values ($1, $2)`, userId, codeHash); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,204 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"time"
+	"users_service/pkg/errs"
+	"users_service/pkg/logger"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type passwordResetRepo struct {
+	db  *pgxpool.Pool
+	log logger.ILogger
+}
+
+func NewPasswordResetRepo(db *pgxpool.Pool, log logger.ILogger) *passwordResetRepo {
+	return &passwordResetRepo{
+		db:  db,
+		log: log,
+	}
+}
+
+// Create stores a reset token digest for the user
+func (p *passwordResetRepo) Create(ctx context.Context, userId, tokenHash string, expiresAt time.Time) error {
+
+	query := `
+	insert into password_reset_tokens (
+		user_id,
+		token_hash,
+		expires_at
+	) values ($1, $2, $3)
+	`
+
+	if _, err := p.db.Exec(ctx, query, userId, tokenHash, expiresAt); err != nil {
+		p.log.Error("error while storing password reset token in storage layer", logger.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// GetUserId returns the owner of an unused and unexpired reset token without consuming it
+func (p *passwordResetRepo) GetUserId(ctx context.Context, tokenHash string) (string, error) {
+
+	var (
+		userId    string
+		expiresAt time.Time
+	)
+
+	query := `
+		select
+			user_id,
+			expires_at
+		from
+			password_reset_tokens
+		where
+			token_hash = $1 and
+			used_at is null
+	`
+
+	if err := p.db.QueryRow(ctx, query, tokenHash).Scan(&userId, &expiresAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", errs.ErrResetTokenNotFound
+		}
+		p.log.Error("error while getting password reset token in storage layer", logger.Error(err))
+		return "", err
+	}
+
+	if !expiresAt.After(time.Now()) {
+		return "", errs.ErrResetTokenExpired
+	}
+
+	return userId, nil
+}
+
+// Consume sets the new password of the token's owner, invalidates the owner's other reset tokens
+// and revokes every refresh token and session of the owner. It returns the id of the user.
+func (p *passwordResetRepo) Consume(ctx context.Context, tokenHash, passwordHash string) (string, error) {
+
+	var (
+		userId    string
+		expiresAt time.Time
+	)
+
+	tx, err := p.db.Begin(ctx)
+	if err != nil {
+		p.log.Error("error while starting transaction to reset password", logger.Error(err))
+		return "", err
+	}
+	defer tx.Rollback(ctx)
+
+	query := `
+		select
+			user_id,
+			expires_at
+		from
+			password_reset_tokens
+		where
+			token_hash = $1 and
+			used_at is null
+		for update
+	`
+
+	if err = tx.QueryRow(ctx, query, tokenHash).Scan(&userId, &expiresAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", errs.ErrResetTokenNotFound
+		}
+		p.log.Error("error while getting password reset token in storage layer", logger.Error(err))
+		return "", err
+	}
+
+	if !expiresAt.After(time.Now()) {
+		return "", errs.ErrResetTokenExpired
+	}
+
+	if _, err = tx.Exec(ctx, `
+		update
+			password_reset_tokens
+		set
+			used_at = now()
+		where
+			user_id = $1 and
+			used_at is null
+	`, userId); err != nil {
+		p.log.Error("error while consuming password reset tokens in storage layer", logger.Error(err))
+		return "", err
+	}
+
+	if _, err = tx.Exec(ctx, `
+		update
+			users
+		set
+			password_hash = $1,
+			failed_login_count = 0,
+			locked_until = null,
+			updated_at = now()
+		where
+			id = $2
+	`, passwordHash, userId); err != nil {
+		p.log.Error("error while saving new password in storage layer", logger.Error(err))
+		return "", err
+	}
+
+	if err = addPasswordHistory(ctx, tx, userId, passwordHash); err != nil {
+		p.log.Error("error while saving password history in storage layer", logger.Error(err))
+		return "", err
+	}
+
+	if _, err = tx.Exec(ctx, `
+		update
+			refresh_tokens
+		set
+			revoked_at = now()
+		where
+			user_id = $1 and
+			revoked_at is null
+	`, userId); err != nil {
+		p.log.Error("error while revoking refresh tokens after password reset", logger.Error(err))
+		return "", err
+	}
+
+	if _, err = tx.Exec(ctx, `update sessions set revoked_at = now() where user_id = $1 and revoked_at is null`, userId); err != nil {
+		p.log.Error("error while revoking sessions after password reset", logger.Error(err))
+		return "", err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		p.log.Error("error while committing password reset", logger.Error(err))
+		return "", err
+	}
+
+	return userId, nil
+}
+
+// DeleteExpired removes up to limit used or expired reset tokens
+func (p *passwordResetRepo) DeleteExpired(ctx context.Context, limit int) (int64, error) {
+
+	query := `
+		delete from
+			password_reset_tokens
+		where
+			id in (
+				select
+					id
+				from
+					password_reset_tokens
+				where
+					expires_at < now() or
+					used_at is not null
+				limit $1
+			)
+	`
+
+	tag, err := p.db.Exec(ctx, query, limit)
+	if err != nil {
+		p.log.Error("error while deleting expired password reset tokens in storage layer", logger.Error(err))
+		return 0, err
+	}
+
+	return tag.RowsAffected(), nil
+}
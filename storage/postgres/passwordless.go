@@ -0,0 +1,295 @@
+package postgres
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"time"
+	"users_service/pkg/errs"
+	"users_service/pkg/logger"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type passwordlessRepo struct {
+	db  *pgxpool.Pool
+	log logger.ILogger
+}
+
+func NewPasswordlessRepo(db *pgxpool.Pool, log logger.ILogger) *passwordlessRepo {
+	return &passwordlessRepo{
+		db:  db,
+		log: log,
+	}
+}
+
+// passwordlessLogin is a challenge row locked for update
+type passwordlessLogin struct {
+	userId    *string
+	codeHash  *string
+	attempts  int
+	expiresAt time.Time
+	usedAt    *time.Time
+}
+
+// Create opens a passwordless login challenge and returns its id. An empty userId opens a
+// challenge for an unknown email that can never be completed, an empty codeHash one that
+// is completed through a link. A new challenge replaces the user's open ones. It fails with
+// ErrPasswordlessRateLimited, before anything is stored, once emailLimit challenges were
+// opened for emailHash or ipLimit from ipAddress since since.
+func (p *passwordlessRepo) Create(ctx context.Context, userId, codeHash string, expiresAt time.Time, emailHash, ipAddress string, since time.Time, emailLimit, ipLimit int) (string, error) {
+
+	var (
+		id           string
+		emailStarted int
+		ipStarted    int
+	)
+
+	tx, err := p.db.Begin(ctx)
+	if err != nil {
+		p.log.Error("error while starting transaction to create passwordless login", logger.Error(err))
+		return "", err
+	}
+	defer tx.Rollback(ctx)
+
+	// the locks serialize concurrent starts for an email and from an ip, so they can not
+	// overrun the limits together. They are always taken in this order.
+	for _, key := range []string{"passwordless_email:" + emailHash, "passwordless_ip:" + ipAddress} {
+		if _, err = tx.Exec(ctx, `select pg_advisory_xact_lock(hashtextextended($1, 0))`, key); err != nil {
+			p.log.Error("error while locking passwordless login starts in storage layer", logger.Error(err))
+			return "", err
+		}
+	}
+
+	if err = tx.QueryRow(ctx, `
+		select
+			count(*) filter (where email_hash = $1),
+			count(*) filter (where ip_address = $2)
+		from
+			passwordless_logins
+		where
+			(email_hash = $1 or ip_address = $2) and
+			created_at >= $3
+	`, emailHash, ipAddress, since).Scan(&emailStarted, &ipStarted); err != nil {
+		p.log.Error("error while counting passwordless logins in storage layer", logger.Error(err))
+		return "", err
+	}
+
+	// calls without a known client ip are only limited per email
+	if emailStarted >= emailLimit || (ipAddress != "" && ipStarted >= ipLimit) {
+		return "", errs.ErrPasswordlessRateLimited
+	}
+
+	if userId != "" {
+		if _, err = tx.Exec(ctx, `
+			update passwordless_logins set
+				used_at = now()
+			where
+				user_id = $1 and
+				used_at is null
+		`, userId); err != nil {
+			p.log.Error("error while invalidating passwordless logins in storage layer", logger.Error(err))
+			return "", err
+		}
+	}
+
+	query := `
+	insert into passwordless_logins (
+		user_id,
+		code_hash,
+		expires_at,
+		email_hash,
+		ip_address
+	) values (nullif($1, '')::uuid, nullif($2, ''), $3, $4, $5) returning id
+	`
+
+	if err = tx.QueryRow(ctx, query, userId, codeHash, expiresAt, emailHash, ipAddress).Scan(&id); err != nil {
+		p.log.Error("error while creating passwordless login in storage layer", logger.Error(err))
+		return "", err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		p.log.Error("error while committing passwordless login", logger.Error(err))
+		return "", err
+	}
+
+	return id, nil
+}
+
+// ConsumeLink completes the link challenge with the given id and returns its user
+func (p *passwordlessRepo) ConsumeLink(ctx context.Context, id string) (string, error) {
+
+	tx, err := p.db.Begin(ctx)
+	if err != nil {
+		p.log.Error("error while starting transaction to consume passwordless link", logger.Error(err))
+		return "", err
+	}
+	defer tx.Rollback(ctx)
+
+	login, err := p.get(ctx, tx, id)
+	if err != nil {
+		return "", err
+	}
+
+	if login.userId == nil || login.codeHash != nil {
+		return "", errs.ErrPasswordlessNotFound
+	}
+
+	if err = p.use(ctx, tx, id); err != nil {
+		return "", err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		p.log.Error("error while committing passwordless link", logger.Error(err))
+		return "", err
+	}
+
+	return *login.userId, nil
+}
+
+// ConsumeCode completes the code challenge with the given id when codeHash matches and returns
+// its user. Every mismatch counts as an attempt, the challenge is burnt after maxAttempts. A
+// mismatch returns the challenge's user too, so the failed attempt can be held against it.
+func (p *passwordlessRepo) ConsumeCode(ctx context.Context, id, codeHash string, maxAttempts int) (string, error) {
+
+	tx, err := p.db.Begin(ctx)
+	if err != nil {
+		p.log.Error("error while starting transaction to consume passwordless code", logger.Error(err))
+		return "", err
+	}
+	defer tx.Rollback(ctx)
+
+	login, err := p.get(ctx, tx, id)
+	if err != nil {
+		return "", err
+	}
+
+	if login.codeHash == nil {
+		return "", errs.ErrPasswordlessNotFound
+	}
+
+	var userId string
+	if login.userId != nil {
+		userId = *login.userId
+	}
+
+	if userId == "" || subtle.ConstantTimeCompare([]byte(*login.codeHash), []byte(codeHash)) != 1 {
+		failure := errs.ErrPasswordlessCodeInvalid
+
+		query := `update passwordless_logins set attempts = attempts + 1 where id = $1`
+		if login.attempts+1 >= maxAttempts {
+			query = `update passwordless_logins set attempts = attempts + 1, used_at = now() where id = $1`
+			failure = errs.ErrPasswordlessAttemptsExceeded
+		}
+
+		if _, err = tx.Exec(ctx, query, id); err != nil {
+			p.log.Error("error while counting passwordless code attempt in storage layer", logger.Error(err))
+			return "", err
+		}
+
+		if err = tx.Commit(ctx); err != nil {
+			p.log.Error("error while committing passwordless code attempt", logger.Error(err))
+			return "", err
+		}
+
+		return userId, failure
+	}
+
+	if err = p.use(ctx, tx, id); err != nil {
+		return "", err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		p.log.Error("error while committing passwordless code", logger.Error(err))
+		return "", err
+	}
+
+	return userId, nil
+}
+
+// DeleteExpired removes up to limit used or expired passwordless challenges opened before
+// createdBefore, newer ones still count towards their user's start limit
+func (p *passwordlessRepo) DeleteExpired(ctx context.Context, createdBefore time.Time, limit int) (int64, error) {
+
+	query := `
+		delete from
+			passwordless_logins
+		where
+			id in (
+				select
+					id
+				from
+					passwordless_logins
+				where
+					(expires_at < now() or used_at is not null) and
+					created_at < $1
+				limit $2
+			)
+	`
+
+	tag, err := p.db.Exec(ctx, query, createdBefore, limit)
+	if err != nil {
+		p.log.Error("error while deleting expired passwordless logins in storage layer", logger.Error(err))
+		return 0, err
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+// get locks a usable challenge, used and malformed ids are reported as not found
+func (p *passwordlessRepo) get(ctx context.Context, tx pgx.Tx, id string) (*passwordlessLogin, error) {
+
+	var (
+		login passwordlessLogin
+		pgErr *pgconn.PgError
+	)
+
+	query := `
+		select
+			user_id,
+			code_hash,
+			attempts,
+			expires_at,
+			used_at
+		from
+			passwordless_logins
+		where
+			id = $1
+		for update
+	`
+
+	if err := tx.QueryRow(ctx, query, id).Scan(
+		&login.userId,
+		&login.codeHash,
+		&login.attempts,
+		&login.expiresAt,
+		&login.usedAt,
+	); err != nil {
+		// invalid_text_representation, the id is not a uuid
+		if errors.Is(err, pgx.ErrNoRows) || (errors.As(err, &pgErr) && pgErr.Code == "22P02") {
+			return nil, errs.ErrPasswordlessNotFound
+		}
+		p.log.Error("error while getting passwordless login in storage layer", logger.Error(err))
+		return nil, err
+	}
+
+	if login.usedAt != nil {
+		return nil, errs.ErrPasswordlessNotFound
+	}
+
+	if !login.expiresAt.After(time.Now()) {
+		return nil, errs.ErrPasswordlessExpired
+	}
+
+	return &login, nil
+}
+
+func (p *passwordlessRepo) use(ctx context.Context, tx pgx.Tx, id string) error {
+	if _, err := tx.Exec(ctx, `update passwordless_logins set used_at = now() where id = $1`, id); err != nil {
+		p.log.Error("error while consuming passwordless login in storage layer", logger.Error(err))
+		return err
+	}
+	return nil
+}
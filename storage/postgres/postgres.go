@@ -2,9 +2,12 @@ package postgres
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"users_service/configs"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -34,3 +37,24 @@ func ConnectDB(ctx context.Context, cfg configs.Config) (*pgxpool.Pool, error) {
 
 	return db, nil
 }
+
+// addPasswordHistory records a newly set password hash inside the transaction that sets it
+func addPasswordHistory(ctx context.Context, tx pgx.Tx, userId, passwordHash string) error {
+	_, err := tx.Exec(ctx, `insert into password_history (user_id, password_hash) values ($1, $2)`, userId, passwordHash)
+	return err
+}
+
+// isUniqueViolation reports whether err is a unique_violation
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
+// foreignKeyViolation returns the violated constraint when err is a foreign_key_violation
+func foreignKeyViolation(err error) (string, bool) {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+		return pgErr.ConstraintName, true
+	}
+	return "", false
+}
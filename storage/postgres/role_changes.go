@@ -0,0 +1,386 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+	"users_service/pkg/errs"
+	"users_service/pkg/logger"
+
+	pb "users_service/genproto/users"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type roleChangesRepo struct {
+	db  *pgxpool.Pool
+	log logger.ILogger
+}
+
+func NewRoleChangesRepo(db *pgxpool.Pool, log logger.ILogger) *roleChangesRepo {
+	return &roleChangesRepo{
+		db:  db,
+		log: log,
+	}
+}
+
+// roleChangeColumns are scanned by scanRoleChange, requests past expires_at read as expired
+const roleChangeColumns = `
+	id,
+	user_id,
+	action,
+	role,
+	coalesce(previous_role, ''),
+	coalesce(actor_id::text, ''),
+	coalesce(approved_by::text, ''),
+	case when state = 'pending' and expires_at < now() then 'expired' else state end,
+	created_at,
+	applied_at,
+	expires_at
+`
+
+// Apply applies a role change of request.ActorId right away and records it
+func (r *roleChangesRepo) Apply(ctx context.Context, request *pb.RoleChange) (*pb.RoleChange, error) {
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		r.log.Error("error while starting transaction to change role", logger.Error(err))
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	previousRole, err := r.apply(ctx, tx, request)
+	if err != nil {
+		return nil, err
+	}
+
+	change, err := scanRoleChange(tx.QueryRow(ctx, `
+		insert into role_changes (
+			user_id,
+			action,
+			role,
+			previous_role,
+			actor_id,
+			state,
+			applied_at
+		) values ($1, $2, $3, nullif($4, ''), nullif($5, '')::uuid, 'applied', now())
+		returning `+roleChangeColumns,
+		request.GetUserId(),
+		request.GetAction(),
+		request.GetRole(),
+		previousRole,
+		request.GetActorId(),
+	))
+	if err != nil {
+		r.log.Error("error while recording role change in storage layer", logger.Error(err))
+		return nil, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		r.log.Error("error while committing role change", logger.Error(err))
+		return nil, err
+	}
+
+	return change, nil
+}
+
+// Request records a role change of request.ActorId that waits for another admin's approval until expiresAt
+func (r *roleChangesRepo) Request(ctx context.Context, request *pb.RoleChange, expiresAt time.Time) (*pb.RoleChange, error) {
+
+	query := `
+		insert into role_changes (
+			user_id,
+			action,
+			role,
+			actor_id,
+			state,
+			expires_at
+		)
+		select $1::uuid, $2::varchar, $3::varchar, nullif($4, '')::uuid, 'pending', $5::timestamptz
+		where
+			exists (select 1 from roles where name = $3) and
+			exists (select 1 from users where id = $1 and deleted_at is null)
+		returning ` + roleChangeColumns
+
+	change, err := scanRoleChange(r.db.QueryRow(ctx, query,
+		request.GetUserId(),
+		request.GetAction(),
+		request.GetRole(),
+		request.GetActorId(),
+		expiresAt,
+	))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) || isInvalidUUID(err) {
+			return nil, r.missing(ctx, request)
+		}
+		r.log.Error("error while requesting role change in storage layer", logger.Error(err))
+		return nil, err
+	}
+
+	return change, nil
+}
+
+// Approve applies a pending role change on behalf of approverId, who has to be another admin than the requester
+func (r *roleChangesRepo) Approve(ctx context.Context, request *pb.PrimaryKey, approverId string) (*pb.RoleChange, error) {
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		r.log.Error("error while starting transaction to approve role change", logger.Error(err))
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	pending, err := scanRoleChange(tx.QueryRow(ctx, `select `+roleChangeColumns+` from role_changes where id = $1 for update`, request.GetId()))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) || isInvalidUUID(err) {
+			return nil, errs.ErrRoleChangeNotFound
+		}
+		r.log.Error("error while getting role change in storage layer", logger.Error(err))
+		return nil, err
+	}
+
+	switch {
+	case pending.State == "expired":
+		return nil, errs.ErrRoleChangeExpired
+	case pending.State != "pending":
+		return nil, errs.ErrRoleChangeNotPending
+	case pending.ActorId == approverId:
+		return nil, errs.ErrSameApprover
+	}
+
+	previousRole, err := r.apply(ctx, tx, pending)
+	if err != nil {
+		return nil, err
+	}
+
+	change, err := scanRoleChange(tx.QueryRow(ctx, `
+		update role_changes set
+			state = 'applied',
+			previous_role = nullif($2, ''),
+			approved_by = $3,
+			applied_at = now()
+		where
+			id = $1
+		returning `+roleChangeColumns,
+		pending.Id,
+		previousRole,
+		approverId,
+	))
+	if err != nil {
+		r.log.Error("error while approving role change in storage layer", logger.Error(err))
+		return nil, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		r.log.Error("error while committing role change approval", logger.Error(err))
+		return nil, err
+	}
+
+	return change, nil
+}
+
+// GetAll returns a page of role changes, newest first
+func (r *roleChangesRepo) GetAll(ctx context.Context, request *pb.RoleChangesRequest) (*pb.RoleChanges, error) {
+
+	var (
+		changes = []*pb.RoleChange{}
+		offset  = int64(request.GetPage()-1) * request.GetLimit()
+		count   int
+	)
+
+	filter := `
+		from
+			role_changes
+		where
+			($1 = '' or user_id::text = $1) and
+			($2 = '' or case when state = 'pending' and expires_at < now() then 'expired' else state end = $2)
+	`
+
+	if err := r.db.QueryRow(ctx, `select count(*) `+filter, request.GetUserId(), request.GetState()).Scan(&count); err != nil {
+		r.log.Error("error while taking count of role changes in storage layer", logger.Error(err))
+		return nil, err
+	}
+
+	query := `select ` + roleChangeColumns + filter + fmt.Sprintf(` order by created_at desc limit %d offset %d`, request.GetLimit(), offset)
+
+	rows, err := r.db.Query(ctx, query, request.GetUserId(), request.GetState())
+	if err != nil {
+		r.log.Error("error while taking rows to get role changes in storage layer", logger.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		change, err := scanRoleChange(rows)
+		if err != nil {
+			r.log.Error("error while scanning role change in storage layer", logger.Error(err))
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+	if err = rows.Err(); err != nil {
+		r.log.Error("error while iterating role change rows in storage layer", logger.Error(err))
+		return nil, err
+	}
+
+	return &pb.RoleChanges{
+		Changes: changes,
+		Page:    request.GetPage(),
+		Limit:   request.GetLimit(),
+		Count:   int32(count),
+	}, nil
+}
+
+// apply makes a role change inside tx and returns the user's primary role before it. It fails
+// with ErrLastAdmin when the change would leave no admin.
+func (r *roleChangesRepo) apply(ctx context.Context, tx pgx.Tx, change *pb.RoleChange) (string, error) {
+
+	var previousRole string
+
+	if err := lockAdmins(ctx, tx); err != nil {
+		r.log.Error("error while locking admins in storage layer", logger.Error(err))
+		return "", err
+	}
+
+	if err := tx.QueryRow(ctx, `
+		select
+			user_role
+		from
+			users
+		where
+			id = $1 and
+			deleted_at is null
+		for update
+	`, change.GetUserId()).Scan(&previousRole); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) || isInvalidUUID(err) {
+			return "", errs.ErrUserNotFound
+		}
+		r.log.Error("error while getting user role in storage layer", logger.Error(err))
+		return "", err
+	}
+
+	var (
+		tag pgconn.CommandTag
+		err error
+	)
+
+	switch change.GetAction() {
+	case "set_primary":
+		tag, err = tx.Exec(ctx, `update users set user_role = $2, updated_at = now() where id = $1`, change.GetUserId(), change.GetRole())
+	case "assign":
+		tag, err = tx.Exec(ctx, `insert into user_roles (user_id, role_name) values ($1, $2) on conflict do nothing`, change.GetUserId(), change.GetRole())
+	case "unassign":
+		tag, err = tx.Exec(ctx, `delete from user_roles where user_id = $1 and role_name = $2`, change.GetUserId(), change.GetRole())
+		if err == nil && tag.RowsAffected() == 0 {
+			return "", errs.ErrRoleNotAssigned
+		}
+	default:
+		return "", fmt.Errorf("unknown role change action %q", change.GetAction())
+	}
+	if err != nil {
+		if _, ok := foreignKeyViolation(err); ok {
+			return "", errs.ErrRoleNotFound
+		}
+		r.log.Error("error while changing role in storage layer", logger.Error(err))
+		return "", err
+	}
+
+	removesAdmin := (change.GetAction() == "set_primary" && previousRole == "admin") ||
+		(change.GetAction() == "unassign" && change.GetRole() == "admin")
+	if !removesAdmin {
+		return previousRole, nil
+	}
+
+	left, err := adminLeft(ctx, tx)
+	if err != nil {
+		r.log.Error("error while counting admins in storage layer", logger.Error(err))
+		return "", err
+	}
+
+	if !left {
+		return "", errs.ErrLastAdmin
+	}
+
+	return previousRole, nil
+}
+
+// missing tells why a role change could not be requested
+func (r *roleChangesRepo) missing(ctx context.Context, request *pb.RoleChange) error {
+
+	var roleExists bool
+
+	if err := r.db.QueryRow(ctx, `select exists (select 1 from roles where name = $1)`, request.GetRole()).Scan(&roleExists); err != nil {
+		r.log.Error("error while checking role in storage layer", logger.Error(err))
+		return err
+	}
+
+	if !roleExists {
+		return errs.ErrRoleNotFound
+	}
+	return errs.ErrUserNotFound
+}
+
+// lockAdmins serializes changes that could remove an admin, so two of them can not each leave the other as the last admin
+func lockAdmins(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx, `select 1 from roles where name = 'admin' for no key update`)
+	return err
+}
+
+// adminLeft reports whether any user that is not deleted still holds the admin role
+func adminLeft(ctx context.Context, tx pgx.Tx) (bool, error) {
+	var left bool
+	err := tx.QueryRow(ctx, `
+		select exists (
+			select
+				1
+			from
+				users u
+			where
+				u.deleted_at is null and (
+					u.user_role = 'admin' or
+					exists (select 1 from user_roles ur where ur.user_id = u.id and ur.role_name = 'admin')
+				)
+		)
+	`).Scan(&left)
+	return left, err
+}
+
+// isInvalidUUID reports whether err is an invalid_text_representation, e.g. an id that is not a uuid
+func isInvalidUUID(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "22P02"
+}
+
+func scanRoleChange(row pgx.Row) (*pb.RoleChange, error) {
+
+	var (
+		change               = pb.RoleChange{}
+		createdAt            time.Time
+		appliedAt, expiresAt *time.Time
+	)
+
+	if err := row.Scan(
+		&change.Id,
+		&change.UserId,
+		&change.Action,
+		&change.Role,
+		&change.PreviousRole,
+		&change.ActorId,
+		&change.ApprovedBy,
+		&change.State,
+		&createdAt,
+		&appliedAt,
+		&expiresAt,
+	); err != nil {
+		return nil, err
+	}
+
+	change.CreatedAt = createdAt.Format(Layout)
+	change.AppliedAt = formatNullable(appliedAt)
+	change.ExpiresAt = formatNullable(expiresAt)
+
+	return &change, nil
+}
@@ -0,0 +1,360 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"time"
+	"users_service/pkg/errs"
+	"users_service/pkg/logger"
+
+	pb "users_service/genproto/users"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type rolesRepo struct {
+	db  *pgxpool.Pool
+	log logger.ILogger
+}
+
+func NewRolesRepo(db *pgxpool.Pool, log logger.ILogger) *rolesRepo {
+	return &rolesRepo{
+		db:  db,
+		log: log,
+	}
+}
+
+// Create creates a role granting the given permissions
+func (r *rolesRepo) Create(ctx context.Context, request *pb.Role) (*pb.Role, error) {
+
+	var (
+		role      = pb.Role{Permissions: request.GetPermissions()}
+		createdAt time.Time
+	)
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		r.log.Error("error while starting transaction to create role", logger.Error(err))
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	if err = tx.QueryRow(ctx, `
+		insert into roles (
+			name,
+			description
+		) values ($1, $2) returning
+			name,
+			description,
+			created_at
+	`, request.GetName(), request.GetDescription()).Scan(
+		&role.Name,
+		&role.Description,
+		&createdAt,
+	); err != nil {
+		if isUniqueViolation(err) {
+			return nil, errs.ErrRoleExists
+		}
+		r.log.Error("error while creating role in storage layer", logger.Error(err))
+		return nil, err
+	}
+
+	if err = r.grant(ctx, tx, role.Name, role.Permissions); err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		r.log.Error("error while committing role", logger.Error(err))
+		return nil, err
+	}
+
+	role.CreatedAt = createdAt.Format(Layout)
+
+	return &role, nil
+}
+
+// GetAll returns every role with its permissions
+func (r *rolesRepo) GetAll(ctx context.Context) (*pb.Roles, error) {
+
+	var (
+		roles     = []*pb.Role{}
+		createdAt time.Time
+	)
+
+	query := `
+		select
+			r.name,
+			r.description,
+			array_remove(array_agg(rp.permission_name order by rp.permission_name), null),
+			r.created_at
+		from
+			roles r
+		left join
+			role_permissions rp on rp.role_name = r.name
+		group by r.name
+		order by r.name
+	`
+
+	rows, err := r.db.Query(ctx, query)
+	if err != nil {
+		r.log.Error("error while taking rows to get roles in storage layer", logger.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var role pb.Role
+		if err = rows.Scan(
+			&role.Name,
+			&role.Description,
+			&role.Permissions,
+			&createdAt,
+		); err != nil {
+			r.log.Error("error while scanning role in storage layer", logger.Error(err))
+			return nil, err
+		}
+		role.CreatedAt = createdAt.Format(Layout)
+
+		roles = append(roles, &role)
+	}
+	if err = rows.Err(); err != nil {
+		r.log.Error("error while iterating role rows in storage layer", logger.Error(err))
+		return nil, err
+	}
+
+	return &pb.Roles{Roles: roles}, nil
+}
+
+// Delete deletes a role no user holds
+func (r *rolesRepo) Delete(ctx context.Context, request *pb.RoleName) (*pb.Void, error) {
+
+	tag, err := r.db.Exec(ctx, `delete from roles where name = $1`, request.GetName())
+	if err != nil {
+		if _, ok := foreignKeyViolation(err); ok {
+			return nil, errs.ErrRoleInUse
+		}
+		r.log.Error("error while deleting role in storage layer", logger.Error(err))
+		return nil, err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return nil, errs.ErrRoleNotFound
+	}
+
+	return &pb.Void{}, nil
+}
+
+// SetPermissions replaces the permissions a role grants
+func (r *rolesRepo) SetPermissions(ctx context.Context, request *pb.Role) (*pb.Role, error) {
+
+	var (
+		role      = pb.Role{Permissions: request.GetPermissions()}
+		createdAt time.Time
+	)
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		r.log.Error("error while starting transaction to set role permissions", logger.Error(err))
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	if err = tx.QueryRow(ctx, `
+		select
+			name,
+			description,
+			created_at
+		from
+			roles
+		where
+			name = $1
+		for update
+	`, request.GetName()).Scan(
+		&role.Name,
+		&role.Description,
+		&createdAt,
+	); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, errs.ErrRoleNotFound
+		}
+		r.log.Error("error while getting role in storage layer", logger.Error(err))
+		return nil, err
+	}
+
+	if _, err = tx.Exec(ctx, `delete from role_permissions where role_name = $1`, role.Name); err != nil {
+		r.log.Error("error while deleting role permissions in storage layer", logger.Error(err))
+		return nil, err
+	}
+
+	if err = r.grant(ctx, tx, role.Name, role.Permissions); err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		r.log.Error("error while committing role permissions", logger.Error(err))
+		return nil, err
+	}
+
+	role.CreatedAt = createdAt.Format(Layout)
+
+	return &role, nil
+}
+
+// CreatePermission ...
+func (r *rolesRepo) CreatePermission(ctx context.Context, request *pb.Permission) (*pb.Permission, error) {
+
+	var (
+		permission = pb.Permission{}
+		createdAt  time.Time
+	)
+
+	query := `insert into permissions (
+		name,
+		description
+	) values ($1, $2) returning
+		name,
+		description,
+		created_at
+	`
+
+	if err := r.db.QueryRow(ctx, query, request.GetName(), request.GetDescription()).Scan(
+		&permission.Name,
+		&permission.Description,
+		&createdAt,
+	); err != nil {
+		if isUniqueViolation(err) {
+			return nil, errs.ErrPermissionExists
+		}
+		r.log.Error("error while creating permission in storage layer", logger.Error(err))
+		return nil, err
+	}
+
+	permission.CreatedAt = createdAt.Format(Layout)
+
+	return &permission, nil
+}
+
+// GetPermissions returns every permission
+func (r *rolesRepo) GetPermissions(ctx context.Context) (*pb.Permissions, error) {
+
+	var (
+		permissions = []*pb.Permission{}
+		createdAt   time.Time
+	)
+
+	rows, err := r.db.Query(ctx, `select name, description, created_at from permissions order by name`)
+	if err != nil {
+		r.log.Error("error while taking rows to get permissions in storage layer", logger.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var permission pb.Permission
+		if err = rows.Scan(
+			&permission.Name,
+			&permission.Description,
+			&createdAt,
+		); err != nil {
+			r.log.Error("error while scanning permission in storage layer", logger.Error(err))
+			return nil, err
+		}
+		permission.CreatedAt = createdAt.Format(Layout)
+
+		permissions = append(permissions, &permission)
+	}
+	if err = rows.Err(); err != nil {
+		r.log.Error("error while iterating permission rows in storage layer", logger.Error(err))
+		return nil, err
+	}
+
+	return &pb.Permissions{Permissions: permissions}, nil
+}
+
+// GetUserRoles returns the user's primary role and every role it holds, the primary one included
+func (r *rolesRepo) GetUserRoles(ctx context.Context, request *pb.PrimaryKey) (*pb.UserRoles, error) {
+
+	var roles = pb.UserRoles{}
+
+	query := `
+		select
+			u.user_role,
+			array(
+				select u.user_role
+				union
+				select role_name from user_roles where user_id = u.id
+				order by 1
+			)
+		from
+			users u
+		where
+			u.id = $1 and
+			u.deleted_at is null
+	`
+
+	if err := r.db.QueryRow(ctx, query, request.GetId()).Scan(&roles.PrimaryRole, &roles.Roles); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, errs.ErrUserNotFound
+		}
+		r.log.Error("error while getting user roles in storage layer", logger.Error(err))
+		return nil, err
+	}
+
+	return &roles, nil
+}
+
+// HasPermission reports whether any role of the user grants the permission
+func (r *rolesRepo) HasPermission(ctx context.Context, request *pb.HasPermissionRequest) (bool, error) {
+
+	var allowed bool
+
+	query := `
+		select exists (
+			select
+				1
+			from
+				role_permissions rp
+			where
+				rp.permission_name = $2 and
+				rp.role_name in (
+					select user_role from users where id = $1 and deleted_at is null
+					union
+					select ur.role_name from user_roles ur join users u on u.id = ur.user_id
+					where ur.user_id = $1 and u.deleted_at is null
+				)
+		)
+	`
+
+	if err := r.db.QueryRow(ctx, query, request.GetUserId(), request.GetPermission()).Scan(&allowed); err != nil {
+		r.log.Error("error while checking permission in storage layer", logger.Error(err))
+		return false, err
+	}
+
+	return allowed, nil
+}
+
+// grant adds permissions to a role inside tx
+func (r *rolesRepo) grant(ctx context.Context, tx pgx.Tx, role string, permissions []string) error {
+	if len(permissions) == 0 {
+		return nil
+	}
+
+	query := `
+		insert into role_permissions (
+			role_name,
+			permission_name
+		) select $1, unnest($2::varchar[])
+		on conflict do nothing
+	`
+
+	if _, err := tx.Exec(ctx, query, role, permissions); err != nil {
+		if _, ok := foreignKeyViolation(err); ok {
+			return errs.ErrPermissionNotFound
+		}
+		r.log.Error("error while granting role permissions in storage layer", logger.Error(err))
+		return err
+	}
+
+	return nil
+}
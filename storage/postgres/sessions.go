@@ -0,0 +1,374 @@
+package postgres
+
+import (
+	"context"
+	"time"
+	"users_service/pkg/errs"
+	"users_service/pkg/logger"
+
+	pb "users_service/genproto/users"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type sessionsRepo struct {
+	db  *pgxpool.Pool
+	log logger.ILogger
+}
+
+func NewSessionsRepo(db *pgxpool.Pool, log logger.ILogger) *sessionsRepo {
+	return &sessionsRepo{
+		db:  db,
+		log: log,
+	}
+}
+
+// Create opens a session together with the first refresh token of its family
+func (s *sessionsRepo) Create(ctx context.Context, request *pb.Session, refreshToken *pb.RefreshToken) (*pb.Session, error) {
+
+	var (
+		session    = pb.Session{}
+		createdAt  time.Time
+		lastUsedAt time.Time
+	)
+
+	expiresIn, err := refreshTokenExpiry(refreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		s.log.Error("error while starting transaction to create session", logger.Error(err))
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	query := `insert into sessions (
+		user_id,
+		device_name,
+		user_agent,
+		ip_address
+	) values ($1, $2, $3, $4) returning
+		id,
+		user_id,
+		device_name,
+		user_agent,
+		ip_address,
+		created_at,
+		last_used_at
+	`
+
+	if err = tx.QueryRow(ctx, query,
+		request.GetUserId(),
+		request.GetDeviceName(),
+		request.GetUserAgent(),
+		request.GetIpAddress(),
+	).Scan(
+		&session.Id,
+		&session.UserId,
+		&session.DeviceName,
+		&session.UserAgent,
+		&session.IpAddress,
+		&createdAt,
+		&lastUsedAt,
+	); err != nil {
+		s.log.Error("error while creating session in storage layer", logger.Error(err))
+		return nil, err
+	}
+
+	query = `
+	insert into refresh_tokens (
+		user_id,
+		token_hash,
+		expires_in,
+		family_id
+	) values ($1, $2, $3, $4)
+	`
+
+	if _, err = tx.Exec(ctx, query,
+		session.UserId,
+		refreshToken.GetRefreshToken(),
+		expiresIn,
+		session.Id,
+	); err != nil {
+		s.log.Error("error while storing session refresh token in storage layer", logger.Error(err))
+		return nil, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		s.log.Error("error while committing session creation", logger.Error(err))
+		return nil, err
+	}
+
+	session.CreatedAt = createdAt.Format(Layout)
+	session.LastUsedAt = lastUsedAt.Format(Layout)
+
+	return &session, nil
+}
+
+// CreateImpersonation opens a session of request.ImpersonatorId as request.UserId that ends at expiresAt
+func (s *sessionsRepo) CreateImpersonation(ctx context.Context, request *pb.Session, expiresAt time.Time) (*pb.Session, error) {
+
+	var (
+		session    = pb.Session{}
+		createdAt  time.Time
+		lastUsedAt time.Time
+	)
+
+	query := `insert into sessions (
+		user_id,
+		device_name,
+		user_agent,
+		ip_address,
+		impersonator_id,
+		impersonation_reason,
+		expires_at
+	) values ($1, $2, $3, $4, $5, $6, $7) returning
+		id,
+		user_id,
+		device_name,
+		user_agent,
+		ip_address,
+		created_at,
+		last_used_at,
+		impersonator_id,
+		impersonation_reason
+	`
+
+	if err := s.db.QueryRow(ctx, query,
+		request.GetUserId(),
+		request.GetDeviceName(),
+		request.GetUserAgent(),
+		request.GetIpAddress(),
+		request.GetImpersonatorId(),
+		request.GetImpersonationReason(),
+		expiresAt,
+	).Scan(
+		&session.Id,
+		&session.UserId,
+		&session.DeviceName,
+		&session.UserAgent,
+		&session.IpAddress,
+		&createdAt,
+		&lastUsedAt,
+		&session.ImpersonatorId,
+		&session.ImpersonationReason,
+	); err != nil {
+		s.log.Error("error while creating impersonation session in storage layer", logger.Error(err))
+		return nil, err
+	}
+
+	session.CreatedAt = createdAt.Format(Layout)
+	session.LastUsedAt = lastUsedAt.Format(Layout)
+	session.ExpiresAt = expiresAt.Format(Layout)
+
+	return &session, nil
+}
+
+// GetAll returns the user's sessions that still hold a live refresh token and every impersonation
+// session of the user, including ended ones, until they are purged
+func (s *sessionsRepo) GetAll(ctx context.Context, request *pb.PrimaryKey) (*pb.Sessions, error) {
+
+	var (
+		sessions   = []*pb.Session{}
+		createdAt  time.Time
+		lastUsedAt time.Time
+		expiresAt  *time.Time
+	)
+
+	query := `
+		select
+			s.id,
+			s.user_id,
+			s.device_name,
+			s.user_agent,
+			s.ip_address,
+			s.created_at,
+			s.last_used_at,
+			coalesce(s.impersonator_id::text, ''),
+			coalesce(s.impersonation_reason, ''),
+			s.expires_at
+		from
+			sessions s
+		where
+			s.user_id = $1 and (
+				s.impersonator_id is not null or (
+					s.revoked_at is null and
+					exists (
+						select
+							1
+						from
+							refresh_tokens r
+						where
+							r.family_id = s.id and
+							r.consumed_at is null and
+							r.revoked_at is null and
+							r.expires_in > now()
+					)
+				)
+			)
+		order by s.last_used_at desc
+	`
+
+	rows, err := s.db.Query(ctx, query, request.GetId())
+	if err != nil {
+		s.log.Error("error while taking rows to get sessions in storage layer", logger.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var session pb.Session
+		if err = rows.Scan(
+			&session.Id,
+			&session.UserId,
+			&session.DeviceName,
+			&session.UserAgent,
+			&session.IpAddress,
+			&createdAt,
+			&lastUsedAt,
+			&session.ImpersonatorId,
+			&session.ImpersonationReason,
+			&expiresAt,
+		); err != nil {
+			s.log.Error("error while scanning session in storage layer", logger.Error(err))
+			return nil, err
+		}
+		session.CreatedAt = createdAt.Format(Layout)
+		session.LastUsedAt = lastUsedAt.Format(Layout)
+		session.ExpiresAt = formatNullable(expiresAt)
+
+		sessions = append(sessions, &session)
+	}
+	if err = rows.Err(); err != nil {
+		s.log.Error("error while iterating session rows in storage layer", logger.Error(err))
+		return nil, err
+	}
+
+	return &pb.Sessions{Sessions: sessions}, nil
+}
+
+// Revoke ends one of the user's sessions and revokes every refresh token issued for it.
+// Impersonation sessions can not be revoked, their stateless tokens run out at expires_at.
+func (s *sessionsRepo) Revoke(ctx context.Context, request *pb.RevokeSessionRequest) (*pb.Void, error) {
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		s.log.Error("error while starting transaction to revoke session", logger.Error(err))
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	query := `
+		update
+			sessions
+		set
+			revoked_at = now()
+		where
+			id = $1 and
+			user_id = $2 and
+			impersonator_id is null and
+			revoked_at is null
+	`
+
+	tag, err := tx.Exec(ctx, query, request.GetSessionId(), request.GetUserId())
+	if err != nil {
+		s.log.Error("error while revoking session in storage layer", logger.Error(err))
+		return nil, err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return nil, errs.ErrSessionNotFound
+	}
+
+	if _, err = tx.Exec(ctx, `
+		update
+			refresh_tokens
+		set
+			revoked_at = now()
+		where
+			family_id = $1 and
+			revoked_at is null
+	`, request.GetSessionId()); err != nil {
+		s.log.Error("error while revoking session refresh tokens in storage layer", logger.Error(err))
+		return nil, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		s.log.Error("error while committing session revocation", logger.Error(err))
+		return nil, err
+	}
+
+	return &pb.Void{}, nil
+}
+
+// IsActive reports whether the session exists, is not revoked and, for impersonation
+// sessions, has not ended yet
+func (s *sessionsRepo) IsActive(ctx context.Context, id string) (bool, error) {
+
+	var active bool
+
+	query := `
+		select exists (
+			select
+				1
+			from
+				sessions
+			where
+				id = $1 and
+				revoked_at is null and
+				(expires_at is null or expires_at > now())
+		)
+	`
+
+	if err := s.db.QueryRow(ctx, query, id).Scan(&active); err != nil {
+		if isInvalidUUID(err) {
+			return false, nil
+		}
+		s.log.Error("error while checking session in storage layer", logger.Error(err))
+		return false, err
+	}
+
+	return active, nil
+}
+
+// DeleteExpired removes up to limit revoked sessions, sessions without any unexpired refresh token
+// and impersonation sessions that ended before impersonationsBefore
+func (s *sessionsRepo) DeleteExpired(ctx context.Context, impersonationsBefore time.Time, limit int) (int64, error) {
+
+	query := `
+		delete from
+			sessions
+		where
+			id in (
+				select
+					s.id
+				from
+					sessions s
+				where
+					(s.impersonator_id is not null and s.expires_at < $1) or
+					(s.impersonator_id is null and (
+						s.revoked_at is not null or
+						not exists (
+							select
+								1
+							from
+								refresh_tokens r
+							where
+								r.family_id = s.id and
+								r.expires_in > now()
+						)
+					))
+				limit $2
+			)
+	`
+
+	tag, err := s.db.Exec(ctx, query, impersonationsBefore, limit)
+	if err != nil {
+		s.log.Error("error while deleting expired sessions in storage layer", logger.Error(err))
+		return 0, err
+	}
+
+	return tag.RowsAffected(), nil
+}
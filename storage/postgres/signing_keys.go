@@ -0,0 +1,242 @@
+package postgres
+
+import (
+	"context"
+	"time"
+	"users_service/pkg/errs"
+	"users_service/pkg/logger"
+
+	pb "users_service/genproto/users"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type signingKeysRepo struct {
+	db  *pgxpool.Pool
+	log logger.ILogger
+}
+
+func NewSigningKeysRepo(db *pgxpool.Pool, log logger.ILogger) *signingKeysRepo {
+	return &signingKeysRepo{
+		db:  db,
+		log: log,
+	}
+}
+
+// GetAll returns the keys tokens may still be signed with and their encrypted private keys by id
+func (s *signingKeysRepo) GetAll(ctx context.Context) (*pb.SigningKeys, map[string]string, error) {
+
+	var (
+		keys        = []*pb.SigningKey{}
+		privateKeys = map[string]string{}
+		createdAt   time.Time
+		activatedAt *time.Time
+		retiredAt   *time.Time
+		expiresAt   *time.Time
+	)
+
+	query := `
+		select
+			id,
+			algorithm,
+			private_key_encrypted,
+			state,
+			created_at,
+			activated_at,
+			retired_at,
+			expires_at
+		from
+			signing_keys
+		where
+			expires_at is null or
+			expires_at > now()
+		order by created_at
+	`
+
+	rows, err := s.db.Query(ctx, query)
+	if err != nil {
+		s.log.Error("error while taking rows to get signing keys in storage layer", logger.Error(err))
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			key        pb.SigningKey
+			privateKey string
+		)
+		if err = rows.Scan(
+			&key.Id,
+			&key.Algorithm,
+			&privateKey,
+			&key.State,
+			&createdAt,
+			&activatedAt,
+			&retiredAt,
+			&expiresAt,
+		); err != nil {
+			s.log.Error("error while scanning signing key in storage layer", logger.Error(err))
+			return nil, nil, err
+		}
+		key.CreatedAt = createdAt.Format(Layout)
+		key.ActivatedAt = formatNullable(activatedAt)
+		key.RetiredAt = formatNullable(retiredAt)
+		key.ExpiresAt = formatNullable(expiresAt)
+
+		keys = append(keys, &key)
+		privateKeys[key.Id] = privateKey
+	}
+	if err = rows.Err(); err != nil {
+		s.log.Error("error while iterating signing key rows in storage layer", logger.Error(err))
+		return nil, nil, err
+	}
+
+	return &pb.SigningKeys{Keys: keys}, privateKeys, nil
+}
+
+// Bootstrap stores the first active key and the pending key that follows it. It reports false
+// without storing anything when another instance already created an active key.
+func (s *signingKeysRepo) Bootstrap(ctx context.Context, active, pending *pb.SigningKey, activePrivateKey, pendingPrivateKey string) (bool, error) {
+
+	var exists bool
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		s.log.Error("error while starting transaction to bootstrap signing keys", logger.Error(err))
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	if err = lockSigningKeys(ctx, tx); err != nil {
+		s.log.Error("error while locking signing keys in storage layer", logger.Error(err))
+		return false, err
+	}
+
+	if err = tx.QueryRow(ctx, `select exists (select 1 from signing_keys where state = 'active')`).Scan(&exists); err != nil {
+		s.log.Error("error while checking active signing key in storage layer", logger.Error(err))
+		return false, err
+	}
+
+	if exists {
+		return false, nil
+	}
+
+	if _, err = tx.Exec(ctx, `
+		insert into signing_keys (
+			id,
+			algorithm,
+			private_key_encrypted,
+			state,
+			activated_at
+		) values ($1, $2, $3, 'active', now()), ($4, $5, $6, 'pending', null)
+	`, active.GetId(), active.GetAlgorithm(), activePrivateKey, pending.GetId(), pending.GetAlgorithm(), pendingPrivateKey); err != nil {
+		s.log.Error("error while creating signing keys in storage layer", logger.Error(err))
+		return false, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		s.log.Error("error while committing signing keys bootstrap", logger.Error(err))
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Rotate retires the active key, activates the pending key and stores next as the new pending key.
+// The retired key keeps verifying tokens for retiredFor.
+func (s *signingKeysRepo) Rotate(ctx context.Context, next *pb.SigningKey, nextPrivateKey string, retiredFor time.Duration) error {
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		s.log.Error("error while starting transaction to rotate signing keys", logger.Error(err))
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err = lockSigningKeys(ctx, tx); err != nil {
+		s.log.Error("error while locking signing keys in storage layer", logger.Error(err))
+		return err
+	}
+
+	if _, err = tx.Exec(ctx, `
+		update signing_keys set
+			state = 'retired',
+			retired_at = now(),
+			expires_at = now() + $1 * interval '1 second'
+		where
+			state = 'active'
+	`, int64(retiredFor.Seconds())); err != nil {
+		s.log.Error("error while retiring signing key in storage layer", logger.Error(err))
+		return err
+	}
+
+	tag, err := tx.Exec(ctx, `update signing_keys set state = 'active', activated_at = now() where state = 'pending'`)
+	if err != nil {
+		s.log.Error("error while activating signing key in storage layer", logger.Error(err))
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return errs.ErrNoPendingSigningKey
+	}
+
+	if _, err = tx.Exec(ctx, `
+		insert into signing_keys (
+			id,
+			algorithm,
+			private_key_encrypted,
+			state
+		) values ($1, $2, $3, 'pending')
+	`, next.GetId(), next.GetAlgorithm(), nextPrivateKey); err != nil {
+		s.log.Error("error while creating pending signing key in storage layer", logger.Error(err))
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		s.log.Error("error while committing signing key rotation", logger.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// DeleteExpired removes up to limit retired keys no token can be verified with anymore
+func (s *signingKeysRepo) DeleteExpired(ctx context.Context, limit int) (int64, error) {
+
+	query := `
+		delete from
+			signing_keys
+		where
+			id in (
+				select
+					id
+				from
+					signing_keys
+				where
+					expires_at < now()
+				limit $1
+			)
+	`
+
+	tag, err := s.db.Exec(ctx, query, limit)
+	if err != nil {
+		s.log.Error("error while deleting expired signing keys in storage layer", logger.Error(err))
+		return 0, err
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+// lockSigningKeys serializes key changes of concurrently running instances
+func lockSigningKeys(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx, `lock table signing_keys in share row exclusive mode`)
+	return err
+}
+
+func formatNullable(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(Layout)
+}
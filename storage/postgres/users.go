@@ -2,13 +2,15 @@ package postgres
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
+	"users_service/pkg/errs"
 	"users_service/pkg/helper"
 	"users_service/pkg/logger"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
-	"golang.org/x/crypto/bcrypt"
 
 	pb "users_service/genproto/users"
 )
@@ -39,7 +41,9 @@ func (u *usersRepo) GetById(ctx context.Context, request *pb.PrimaryKey) (*pb.Us
 		email,
 		full_name,
 		user_role,
-		created_at
+		created_at,
+		email_verified_at is not null,
+		mfa_enabled
 	from
 		users
 	where
@@ -55,6 +59,8 @@ func (u *usersRepo) GetById(ctx context.Context, request *pb.PrimaryKey) (*pb.Us
 			&user.FullName,
 			&user.UserRole,
 			&createdAt,
+			&user.EmailVerified,
+			&user.MfaEnabled,
 		); err != nil {
 		u.log.Error("error while getting user info in storage layer", logger.Error(err))
 		return nil, err
@@ -109,7 +115,9 @@ func (u *usersRepo) GetAll(ctx context.Context, request *pb.GetListRequest) (*pb
 		email,
 		full_name,
 		user_role,
-		created_at
+		created_at,
+		email_verified_at is not null,
+		mfa_enabled
 	from
 		users
 	where 
@@ -136,6 +144,8 @@ func (u *usersRepo) GetAll(ctx context.Context, request *pb.GetListRequest) (*pb
 			&user.FullName,
 			&user.UserRole,
 			&createdAt,
+			&user.EmailVerified,
+			&user.MfaEnabled,
 		); err != nil {
 			u.log.Error("error while getting user info in storage layer", logger.Error(err))
 			return nil, err
@@ -176,7 +186,8 @@ func (u *usersRepo) Update(ctx context.Context, request *pb.UpdateUser) (*pb.Upd
 	}
 
 	if request.GetEmail() != "" {
-		filter += ` email = @email, `
+		// a new address has to be verified again
+		filter += ` email_verified_at = case when email = @email then email_verified_at end, email = @email, `
 		params["email"] = request.GetEmail()
 	}
 
@@ -185,16 +196,23 @@ func (u *usersRepo) Update(ctx context.Context, request *pb.UpdateUser) (*pb.Upd
 		params["password_hash"] = request.GetPasswordHash()
 	}
 
+	tx, err := u.db.Begin(ctx)
+	if err != nil {
+		u.log.Error("error while starting transaction to update user", logger.Error(err))
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
 	query += filter + ` updated_at = now() where id = @id returning 
 		id,
 		email,
-		password_hash,
+		coalesce(password_hash, ''),
 		full_name,
 		user_role,
 		updated_at
 	`
 	fullQuery, args := helper.ReplaceQueryParams(query, params)
-	if err = u.db.QueryRow(ctx, fullQuery, args...).Scan(
+	if err = tx.QueryRow(ctx, fullQuery, args...).Scan(
 		&user.Id,
 		&user.Email,
 		&user.Password,
@@ -206,53 +224,94 @@ func (u *usersRepo) Update(ctx context.Context, request *pb.UpdateUser) (*pb.Upd
 		return nil, err
 	}
 
+	// a new password is kept in the history like one set through ChangePassword
+	if request.GetPasswordHash() != "" {
+		if err = addPasswordHistory(ctx, tx, request.GetId(), request.GetPasswordHash()); err != nil {
+			u.log.Error("error while saving password history in storage layer", logger.Error(err))
+			return nil, err
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		u.log.Error("error while committing user update", logger.Error(err))
+		return nil, err
+	}
+
 	user.UpdatedAt = updatedAt.Format(Layout)
 
 	return &user, nil
 }
 
+// Delete soft deletes a user, it fails with ErrLastAdmin when that would leave no admin
 func (u *usersRepo) Delete(ctx context.Context, request *pb.PrimaryKey) (*pb.Void, error) {
 
-	_, err := u.db.Exec(ctx, ` update users set deleted_at = now() where id = $1`, request.GetId())
+	tx, err := u.db.Begin(ctx)
+	if err != nil {
+		u.log.Error("error while starting transaction to delete user", logger.Error(err))
+		return &pb.Void{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	if err = lockAdmins(ctx, tx); err != nil {
+		u.log.Error("error while locking admins in storage layer", logger.Error(err))
+		return &pb.Void{}, err
+	}
+
+	var wasAdmin bool
+	if err = tx.QueryRow(ctx, `
+		update users set
+			deleted_at = now()
+		where
+			id = $1 and
+			deleted_at is null
+		returning
+			user_role = 'admin' or
+			exists (select 1 from user_roles ur where ur.user_id = users.id and ur.role_name = 'admin')
+	`, request.GetId()).Scan(&wasAdmin); err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		u.log.Error("error while deleting user in storage layer", logger.Error(err))
+		return &pb.Void{}, err
+	}
+
+	if wasAdmin {
+		left, err := adminLeft(ctx, tx)
+		if err != nil {
+			u.log.Error("error while counting admins in storage layer", logger.Error(err))
+			return &pb.Void{}, err
+		}
+
+		if !left {
+			return &pb.Void{}, errs.ErrLastAdmin
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		u.log.Error("error while committing user deletion", logger.Error(err))
+		return &pb.Void{}, err
+	}
 
-	return &pb.Void{}, err
+	return &pb.Void{}, nil
 }
 
-func (u *usersRepo) CheckPasswordExisis(ctx context.Context, request *pb.ChangePassword) (bool, error) {
+func (u *usersRepo) GetPasswordHash(ctx context.Context, request *pb.PrimaryKey) (string, error) {
 
 	var hashedPassword string
 
 	query := `
 		select
-			password_hash
+			coalesce(password_hash, '')
 		from
 			users
+		where
+			id = $1 and
+			deleted_at is null
 	`
 
-	rows, err := u.db.Query(ctx, query)
-	if err != nil {
-		u.log.Error("error while retrieving hashed passwords from database", logger.Error(err))
-		return false, err
+	if err := u.db.QueryRow(ctx, query, request.GetId()).Scan(&hashedPassword); err != nil {
+		u.log.Error("error while getting password hash in storage layer", logger.Error(err))
+		return "", err
 	}
-	defer rows.Close()
-
-	for rows.Next() {
-		err = rows.Scan(&hashedPassword)
-		if err != nil {
-			u.log.Error("error while scanning hashed password", logger.Error(err))
-			return false, err
-		}
 
-		err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(request.CurrentPassword))
-		if err == nil {
-			return true, nil
-		} else if err != bcrypt.ErrMismatchedHashAndPassword {
-			u.log.Error("error while comparing hashed password", logger.Error(err))
-			return false, err
-		}
-	}
-
-	return false, fmt.Errorf("password does not match any stored password")
+	return hashedPassword, nil
 }
 
 func (u *usersRepo) ChangePassword(ctx context.Context, request *pb.ChangePassword) (*pb.Void, error) {
@@ -262,6 +321,13 @@ func (u *usersRepo) ChangePassword(ctx context.Context, request *pb.ChangePasswo
 		err   error
 	)
 
+	tx, err := u.db.Begin(ctx)
+	if err != nil {
+		u.log.Error("error while starting transaction to change password", logger.Error(err))
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
 	query = `
 		update 
 			users 
@@ -272,7 +338,7 @@ func (u *usersRepo) ChangePassword(ctx context.Context, request *pb.ChangePasswo
 			deleted_at is null
 	`
 
-	if _, err = u.db.Exec(ctx, query,
+	if _, err = tx.Exec(ctx, query,
 		request.GetNewPassword(),
 		request.GetUserId(),
 	); err != nil {
@@ -280,33 +346,115 @@ func (u *usersRepo) ChangePassword(ctx context.Context, request *pb.ChangePasswo
 		return nil, err
 	}
 
+	if err = addPasswordHistory(ctx, tx, request.GetUserId(), request.GetNewPassword()); err != nil {
+		u.log.Error("error while saving password history in storage layer", logger.Error(err))
+		return nil, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		u.log.Error("error while committing password change", logger.Error(err))
+		return nil, err
+	}
+
 	return &pb.Void{}, nil
 }
 
-func (u *usersRepo) ChangeUserRole(ctx context.Context, request *pb.ChangeUserRole) (*pb.Void, error) {
+// UpdatePasswordHash replaces the stored hash of the same password, e.g. after rehashing
+// with stronger parameters, so it is not recorded in the password history
+func (u *usersRepo) UpdatePasswordHash(ctx context.Context, request *pb.ChangePassword) (*pb.Void, error) {
 
-	var (
-		query string
-		err   error
-	)
-
-	query = `
-		update 
-			users 
-		set 
-			user_role = $1
+	query := `
+		update
+			users
+		set
+			password_hash = $1
 		where
-			id = $2 and 
+			id = $2 and
 			deleted_at is null
 	`
 
-	if _, err = u.db.Exec(ctx, query,
-		request.GetNewUserRole(),
-		request.GetId(),
+	if _, err := u.db.Exec(ctx, query,
+		request.GetNewPassword(),
+		request.GetUserId(),
 	); err != nil {
-		u.log.Error("error while changing user role in storage layer", logger.Error(err))
+		u.log.Error("error while updating password hash in storage layer", logger.Error(err))
 		return nil, err
 	}
 
 	return &pb.Void{}, nil
 }
+
+// GetPasswordHistory returns up to limit of the user's most recent password hashes set after since
+func (u *usersRepo) GetPasswordHistory(ctx context.Context, request *pb.PrimaryKey, limit int, since time.Time) ([]string, error) {
+
+	var hashes = []string{}
+
+	query := `
+		select
+			password_hash
+		from
+			password_history
+		where
+			user_id = $1 and
+			created_at >= $2
+		order by created_at desc
+		limit $3
+	`
+
+	rows, err := u.db.Query(ctx, query, request.GetId(), since, limit)
+	if err != nil {
+		u.log.Error("error while getting password history in storage layer", logger.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var hash string
+		if err = rows.Scan(&hash); err != nil {
+			u.log.Error("error while scanning password history in storage layer", logger.Error(err))
+			return nil, err
+		}
+		hashes = append(hashes, hash)
+	}
+	if err = rows.Err(); err != nil {
+		u.log.Error("error while iterating password history rows in storage layer", logger.Error(err))
+		return nil, err
+	}
+
+	return hashes, nil
+}
+
+// DeleteExpiredPasswordHistory removes up to limit history entries that are older than
+// olderThan or beyond the keep most recent entries of their user
+func (u *usersRepo) DeleteExpiredPasswordHistory(ctx context.Context, keep int, olderThan time.Time, limit int) (int64, error) {
+
+	query := `
+		delete from
+			password_history
+		where
+			id in (
+				select
+					h.id
+				from (
+					select
+						id,
+						created_at,
+						row_number() over (partition by user_id order by created_at desc) as position
+					from
+						password_history
+				) h
+				where
+					h.position > $1 or
+					h.created_at < $2
+				limit $3
+			)
+	`
+
+	tag, err := u.db.Exec(ctx, query, keep, olderThan, limit)
+	if err != nil {
+		u.log.Error("error while deleting expired password history in storage layer", logger.Error(err))
+		return 0, err
+	}
+
+	return tag.RowsAffected(), nil
+}
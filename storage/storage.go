@@ -2,6 +2,7 @@ package storage
 
 import (
 	"context"
+	"time"
 	"users_service/configs"
 	"users_service/pkg/logger"
 	"users_service/storage/postgres"
@@ -20,6 +21,17 @@ type IStorage interface {
 	Close()
 	Auth() IAuthStorage
 	Users() IUsersStorage
+	Sessions() ISessionsStorage
+	EmailVerification() IEmailVerificationStorage
+	PasswordReset() IPasswordResetStorage
+	Mfa() IMfaStorage
+	LoginEvents() ILoginEventsStorage
+	Passwordless() IPasswordlessStorage
+	Identities() IIdentitiesStorage
+	SigningKeys() ISigningKeysStorage
+	ApiTokens() IApiTokensStorage
+	Roles() IRolesStorage
+	RoleChanges() IRoleChangesStorage
 }
 
 type IAuthStorage interface {
@@ -28,8 +40,13 @@ type IAuthStorage interface {
 	DeleteRefreshTokenByUserId(context.Context, *pb.PrimaryKey) (*pb.Void, error)
 	StoreRefreshToken(context.Context, *pb.RefreshToken) (*pb.Void, error)
 	CheckRefreshTokenExists(context.Context, *pb.RequestRefreshToken) (*pb.Void, error)
+	RotateRefreshToken(context.Context, *pb.RequestRefreshToken, *pb.RefreshToken) (*pb.RefreshToken, error)
+	DeleteExpiredRefreshTokens(ctx context.Context, limit int) (int64, error)
 	CheckEmailExists(context.Context, *pb.Email) (*pb.Void, error)
-	ResetPassword(context.Context, *pb.ResetPassword) (*pb.Void, error)
+	GetLockedUntil(ctx context.Context, userId string) (time.Time, error)
+	RecordFailedLogin(ctx context.Context, userId string) (int, error)
+	LockUser(ctx context.Context, userId string, until time.Time) error
+	ResetFailedLogins(ctx context.Context, userId string) (*pb.Void, error)
 }
 
 type IUsersStorage interface {
@@ -37,9 +54,97 @@ type IUsersStorage interface {
 	GetAll(context.Context, *pb.GetListRequest) (*pb.Users, error)
 	Update(context.Context, *pb.UpdateUser) (*pb.UpdatedUser, error)
 	Delete(context.Context, *pb.PrimaryKey) (*pb.Void, error)
-	CheckPasswordExisis(context.Context, *pb.ChangePassword) (bool, error)
+	GetPasswordHash(context.Context, *pb.PrimaryKey) (string, error)
 	ChangePassword(context.Context, *pb.ChangePassword) (*pb.Void, error)
-	ChangeUserRole(context.Context, *pb.ChangeUserRole) (*pb.Void, error)
+	UpdatePasswordHash(context.Context, *pb.ChangePassword) (*pb.Void, error)
+	GetPasswordHistory(ctx context.Context, request *pb.PrimaryKey, limit int, since time.Time) ([]string, error)
+	DeleteExpiredPasswordHistory(ctx context.Context, keep int, olderThan time.Time, limit int) (int64, error)
+}
+
+type ISessionsStorage interface {
+	Create(context.Context, *pb.Session, *pb.RefreshToken) (*pb.Session, error)
+	CreateImpersonation(ctx context.Context, request *pb.Session, expiresAt time.Time) (*pb.Session, error)
+	GetAll(context.Context, *pb.PrimaryKey) (*pb.Sessions, error)
+	Revoke(context.Context, *pb.RevokeSessionRequest) (*pb.Void, error)
+	IsActive(ctx context.Context, id string) (bool, error)
+	DeleteExpired(ctx context.Context, impersonationsBefore time.Time, limit int) (int64, error)
+}
+
+type IEmailVerificationStorage interface {
+	Create(ctx context.Context, userId, email, tokenHash string, expiresAt time.Time) error
+	Consume(ctx context.Context, tokenHash string) (string, error)
+	DeleteExpired(ctx context.Context, limit int) (int64, error)
+}
+
+type IPasswordResetStorage interface {
+	Create(ctx context.Context, userId, tokenHash string, expiresAt time.Time) error
+	GetUserId(ctx context.Context, tokenHash string) (string, error)
+	Consume(ctx context.Context, tokenHash, passwordHash string) (string, error)
+	DeleteExpired(ctx context.Context, limit int) (int64, error)
+}
+
+type IMfaStorage interface {
+	SaveSecret(ctx context.Context, userId, encryptedSecret string) error
+	GetSecret(ctx context.Context, userId string) (string, bool, error)
+	UseStep(ctx context.Context, userId string, step int64) (bool, error)
+	Enable(ctx context.Context, userId string, step int64, codeHashes []string) error
+	ReplaceRecoveryCodes(ctx context.Context, userId string, codeHashes []string) error
+	UseRecoveryCode(ctx context.Context, userId, codeHash string) (bool, error)
+	Disable(ctx context.Context, userId string) error
+}
+
+type ILoginEventsStorage interface {
+	Create(context.Context, *pb.LoginEvent) error
+	GetAll(context.Context, *pb.LoginHistoryRequest) (*pb.LoginEvents, error)
+	DeleteExpired(ctx context.Context, olderThan time.Time, limit int) (int64, error)
+}
+
+type IPasswordlessStorage interface {
+	Create(ctx context.Context, userId, codeHash string, expiresAt time.Time, emailHash, ipAddress string, since time.Time, emailLimit, ipLimit int) (string, error)
+	ConsumeLink(ctx context.Context, id string) (string, error)
+	ConsumeCode(ctx context.Context, id, codeHash string, maxAttempts int) (string, error)
+	DeleteExpired(ctx context.Context, createdBefore time.Time, limit int) (int64, error)
+}
+
+type IIdentitiesStorage interface {
+	Create(context.Context, *pb.Identity) (*pb.Identity, error)
+	CreateWithUser(ctx context.Context, request *pb.CreateUser, identity *pb.Identity, emailVerified bool) (*pb.User, error)
+	GetBySubject(ctx context.Context, provider, subject string) (*pb.Identity, error)
+	GetAll(context.Context, *pb.PrimaryKey) (*pb.Identities, error)
+	Delete(context.Context, *pb.UnlinkIdentityRequest) (*pb.Void, error)
+}
+
+type ISigningKeysStorage interface {
+	GetAll(ctx context.Context) (*pb.SigningKeys, map[string]string, error)
+	Bootstrap(ctx context.Context, active, pending *pb.SigningKey, activePrivateKey, pendingPrivateKey string) (bool, error)
+	Rotate(ctx context.Context, next *pb.SigningKey, nextPrivateKey string, retiredFor time.Duration) error
+	DeleteExpired(ctx context.Context, limit int) (int64, error)
+}
+
+type IApiTokensStorage interface {
+	Create(ctx context.Context, request *pb.CreateApiTokenRequest, prefix, tokenHash string, expiresAt time.Time) (*pb.ApiToken, error)
+	GetAll(context.Context, *pb.PrimaryKey) (*pb.ApiTokens, error)
+	Revoke(context.Context, *pb.RevokeApiTokenRequest) (*pb.Void, error)
+	Validate(ctx context.Context, tokenHash string) (*pb.ApiTokenIdentity, error)
+	DeleteExpired(ctx context.Context, limit int) (int64, error)
+}
+
+type IRolesStorage interface {
+	Create(context.Context, *pb.Role) (*pb.Role, error)
+	GetAll(context.Context) (*pb.Roles, error)
+	Delete(context.Context, *pb.RoleName) (*pb.Void, error)
+	SetPermissions(context.Context, *pb.Role) (*pb.Role, error)
+	CreatePermission(context.Context, *pb.Permission) (*pb.Permission, error)
+	GetPermissions(context.Context) (*pb.Permissions, error)
+	GetUserRoles(context.Context, *pb.PrimaryKey) (*pb.UserRoles, error)
+	HasPermission(context.Context, *pb.HasPermissionRequest) (bool, error)
+}
+
+type IRoleChangesStorage interface {
+	Apply(context.Context, *pb.RoleChange) (*pb.RoleChange, error)
+	Request(ctx context.Context, request *pb.RoleChange, expiresAt time.Time) (*pb.RoleChange, error)
+	Approve(ctx context.Context, request *pb.PrimaryKey, approverId string) (*pb.RoleChange, error)
+	GetAll(context.Context, *pb.RoleChangesRequest) (*pb.RoleChanges, error)
 }
 
 func New(ctx context.Context, cfg *configs.Config, log *logger.ILogger) (IStorage, error) {
@@ -65,3 +170,47 @@ func (s *Storage) Auth() IAuthStorage {
 func (s *Storage) Users() IUsersStorage {
 	return postgres.NewUsersRepo(s.dbPostgres, s.log)
 }
+
+func (s *Storage) Sessions() ISessionsStorage {
+	return postgres.NewSessionsRepo(s.dbPostgres, s.log)
+}
+
+func (s *Storage) EmailVerification() IEmailVerificationStorage {
+	return postgres.NewEmailVerificationRepo(s.dbPostgres, s.log)
+}
+
+func (s *Storage) PasswordReset() IPasswordResetStorage {
+	return postgres.NewPasswordResetRepo(s.dbPostgres, s.log)
+}
+
+func (s *Storage) Mfa() IMfaStorage {
+	return postgres.NewMfaRepo(s.dbPostgres, s.log)
+}
+
+func (s *Storage) LoginEvents() ILoginEventsStorage {
+	return postgres.NewLoginEventsRepo(s.dbPostgres, s.log)
+}
+
+func (s *Storage) Passwordless() IPasswordlessStorage {
+	return postgres.NewPasswordlessRepo(s.dbPostgres, s.log)
+}
+
+func (s *Storage) Identities() IIdentitiesStorage {
+	return postgres.NewIdentitiesRepo(s.dbPostgres, s.log)
+}
+
+func (s *Storage) SigningKeys() ISigningKeysStorage {
+	return postgres.NewSigningKeysRepo(s.dbPostgres, s.log)
+}
+
+func (s *Storage) ApiTokens() IApiTokensStorage {
+	return postgres.NewApiTokensRepo(s.dbPostgres, s.log)
+}
+
+func (s *Storage) Roles() IRolesStorage {
+	return postgres.NewRolesRepo(s.dbPostgres, s.log)
+}
+
+func (s *Storage) RoleChanges() IRoleChangesStorage {
+	return postgres.NewRoleChangesRepo(s.dbPostgres, s.log)
+}